@@ -3,6 +3,7 @@
 package blocks
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -10,10 +11,24 @@ import (
 	u "github.com/ipfs/go-ipfs/util"
 )
 
+// ErrWrongHash is returned for data that does not match the hash given
+// for it.
+var ErrWrongHash = errors.New("Data did not match given hash!")
+
 // Block is a singular block of data in ipfs
 type Block struct {
 	Multihash mh.Multihash
 	Data      []byte
+
+	// where the data of the block lies on disk, for blocks that may be
+	// stored by reference to it (optionally nil)
+	PosInfo *PosInfo
+}
+
+// PosInfo is the position of the data of a block in a file.
+type PosInfo struct {
+	FullPath string // absolute
+	Offset   uint64
 }
 
 // NewBlock creates a Block object from opaque data. It will hash the data.
@@ -21,19 +36,48 @@ func NewBlock(data []byte) *Block {
 	return &Block{Data: data, Multihash: u.Hash(data)}
 }
 
+// NewBlockWithHashFunc creates a Block object from opaque data, hashing it
+// with the given multihash function, one of u.HashFuncs.
+func NewBlockWithHashFunc(data []byte, code int) (*Block, error) {
+	h, err := u.HashWith(data, code)
+	if err != nil {
+		return nil, err
+	}
+	return &Block{Data: data, Multihash: h}, nil
+}
+
 // NewBlockWithHash creates a new block when the hash of the data
 // is already known, this is used to save time in situations where
 // we are able to be confident that the data is correct
 func NewBlockWithHash(data []byte, h mh.Multihash) (*Block, error) {
 	if u.Debug {
-		chk := u.Hash(data)
-		if string(chk) != string(h) {
-			return nil, errors.New("Data did not match given hash!")
+		if err := VerifyHash(data, h); err != nil {
+			return nil, err
 		}
 	}
 	return &Block{Data: data, Multihash: h}, nil
 }
 
+// VerifyHash checks that data hashes to h, with the hash function and
+// length h was made with.
+func VerifyHash(data []byte, h mh.Multihash) error {
+	dec, err := mh.Decode(h)
+	if err != nil {
+		return err
+	}
+	if !u.HashFuncs[dec.Code] {
+		return fmt.Errorf("unsupported hash function: %s", dec.Name)
+	}
+	chk, err := mh.Sum(data, dec.Code, dec.Length)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(chk, h) {
+		return ErrWrongHash
+	}
+	return nil
+}
+
 // Key returns the block's Multihash as a Key value.
 func (b *Block) Key() u.Key {
 	return u.Key(b.Multihash)
@@ -1,6 +1,10 @@
 package blocks
 
-import "testing"
+import (
+	"testing"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+)
 
 func TestBlocksBasic(t *testing.T) {
 
@@ -14,3 +18,30 @@ func TestBlocksBasic(t *testing.T) {
 	// Test some data
 	NewBlock([]byte("Hello world!"))
 }
+
+func TestHashFuncs(t *testing.T) {
+	data := []byte("Hello world!")
+	for _, code := range []int{mh.SHA2_256, mh.SHA2_512, mh.SHA3} {
+		b, err := NewBlockWithHashFunc(data, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := mh.Decode(b.Multihash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.Code != code {
+			t.Fatalf("block hashed with %s instead of %s", dec.Name, mh.Codes[code])
+		}
+		if err := VerifyHash(data, b.Multihash); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyHash([]byte("Goodbye world!"), b.Multihash); err != ErrWrongHash {
+			t.Fatalf("expected ErrWrongHash, got %v", err)
+		}
+	}
+
+	if _, err := NewBlockWithHashFunc(data, mh.SHA1); err == nil {
+		t.Fatal("hashed a block with an unsupported function")
+	}
+}
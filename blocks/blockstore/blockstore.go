@@ -4,6 +4,8 @@ package blockstore
 
 import (
 	"errors"
+	"sync"
+	"sync/atomic"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
@@ -24,6 +26,10 @@ var ValueTypeMismatch = errors.New("The retrieved value is not a Block")
 
 var ErrNotFound = errors.New("blockstore: block not found")
 
+// ErrStorageFull is returned by Put when the storage quota is used up and
+// the block is not expected, on its way to a pin.
+var ErrStorageFull = errors.New("blockstore: storage quota exceeded")
+
 // Blockstore wraps a ThreadSafeDatastore
 type Blockstore interface {
 	DeleteBlock(u.Key) error
@@ -34,10 +40,53 @@ type Blockstore interface {
 	AllKeysChan(ctx context.Context) (<-chan u.Key, error)
 }
 
-func NewBlockstore(d ds.ThreadSafeDatastore) Blockstore {
+// GCBlockstore is a Blockstore that can be locked against concurrent
+// writers while garbage collection runs.
+type GCBlockstore interface {
+	Blockstore
+
+	// GCLock locks the blockstore for garbage collection. No operations
+	// that expect to finish with a pin should occur simultaneously.
+	// Reading during GC is safe, and requires no lock.
+	GCLock() func()
+
+	// PinLock locks the blockstore for sequences of puts expected to finish
+	// with a pin (before GC). Multiple put->pin sequences can write through
+	// at the same time, but no GC should happen simultaneously.
+	PinLock() func()
+
+	// GCRequested returns true if GCLock has been called and is waiting to
+	// take the lock.
+	GCRequested() bool
+
+	// Expect marks the block k as on its way to a pin: it is stored even
+	// past the storage quota, and garbage collection keeps it, until the
+	// returned func is called. Sessions expect the blocks written through
+	// them.
+	Expect(k u.Key) func()
+
+	// ExpectedKeys returns the keys of the blocks currently expected.
+	ExpectedKeys() []u.Key
+}
+
+func NewBlockstore(d ds.ThreadSafeDatastore) GCBlockstore {
 	dd := dsns.Wrap(d, BlockPrefix)
 	return &blockstore{
 		datastore: dd,
+		expected:  make(map[u.Key]int),
+	}
+}
+
+// NewQuotaBlockstore returns a blockstore that refuses to store new blocks
+// while full returns true. Expected blocks are always stored, since they
+// are about to be pinned; only plain caching, such as keeping blocks
+// fetched for a cat, is refused.
+func NewQuotaBlockstore(d ds.ThreadSafeDatastore, full func() bool) GCBlockstore {
+	dd := dsns.Wrap(d, BlockPrefix)
+	return &blockstore{
+		datastore: dd,
+		full:      full,
+		expected:  make(map[u.Key]int),
 	}
 }
 
@@ -45,6 +94,15 @@ type blockstore struct {
 	datastore ds.Datastore
 	// cant be ThreadSafeDatastore cause namespace.Datastore doesnt support it.
 	// we do check it on `NewBlockstore` though.
+
+	lk    sync.RWMutex
+	gcreq int32
+
+	full func() bool
+
+	// blocks on their way to a pin, with how many times each is expected
+	explk    sync.Mutex
+	expected map[u.Key]int
 }
 
 func (bs *blockstore) Get(k u.Key) (*blocks.Block, error) {
@@ -71,6 +129,9 @@ func (bs *blockstore) Put(block *blocks.Block) error {
 	if err == nil && exists {
 		return nil // already stored.
 	}
+	if bs.full != nil && !bs.isExpected(block.Key()) && bs.full() {
+		return ErrStorageFull
+	}
 	return bs.datastore.Put(k, block.Data)
 }
 
@@ -151,3 +212,52 @@ func (bs *blockstore) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
 
 	return output, nil
 }
+
+func (bs *blockstore) GCLock() func() {
+	atomic.AddInt32(&bs.gcreq, 1)
+	bs.lk.Lock()
+	atomic.AddInt32(&bs.gcreq, -1)
+	return bs.lk.Unlock
+}
+
+func (bs *blockstore) PinLock() func() {
+	bs.lk.RLock()
+	return bs.lk.RUnlock
+}
+
+func (bs *blockstore) GCRequested() bool {
+	return atomic.LoadInt32(&bs.gcreq) > 0
+}
+
+func (bs *blockstore) Expect(k u.Key) func() {
+	bs.explk.Lock()
+	bs.expected[k]++
+	bs.explk.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			bs.explk.Lock()
+			defer bs.explk.Unlock()
+			if bs.expected[k]--; bs.expected[k] <= 0 {
+				delete(bs.expected, k)
+			}
+		})
+	}
+}
+
+func (bs *blockstore) ExpectedKeys() []u.Key {
+	bs.explk.Lock()
+	defer bs.explk.Unlock()
+	out := make([]u.Key, 0, len(bs.expected))
+	for k := range bs.expected {
+		out = append(out, k)
+	}
+	return out
+}
+
+func (bs *blockstore) isExpected(k u.Key) bool {
+	bs.explk.Lock()
+	defer bs.explk.Unlock()
+	return bs.expected[k] > 0
+}
@@ -266,3 +266,50 @@ func (c *queryTestDS) Query(q dsq.Query) (dsq.Results, error) {
 	}
 	return c.ds.Query(q)
 }
+
+func TestQuotaRefusesCachingWhenFull(t *testing.T) {
+	full := false
+	bs := NewQuotaBlockstore(ds_sync.MutexWrap(ds.NewMapDatastore()), func() bool { return full })
+
+	stored := blocks.NewBlock([]byte("stored before the quota was hit"))
+	if err := bs.Put(stored); err != nil {
+		t.Fatal(err)
+	}
+
+	full = true
+	if err := bs.Put(blocks.NewBlock([]byte("cached"))); err != ErrStorageFull {
+		t.Fatalf("expected ErrStorageFull, got %v", err)
+	}
+	if err := bs.Put(stored); err != nil {
+		t.Fatal("storing a block that is already present should not fail:", err)
+	}
+
+	// holding the pin lock exempts nothing, only expected blocks are
+	unlock := bs.PinLock()
+	err := bs.Put(blocks.NewBlock([]byte("written under the pin lock")))
+	unlock()
+	if err != ErrStorageFull {
+		t.Fatalf("expected ErrStorageFull under the pin lock, got %v", err)
+	}
+
+	sess := NewSession(bs)
+	if err := sess.Put(blocks.NewBlock([]byte("about to be pinned"))); err != nil {
+		t.Fatal("blocks written through a session should be stored:", err)
+	}
+	fetched := blocks.NewBlock([]byte("fetched to be pinned"))
+	sess.Expect(fetched.Key())
+	if err := bs.Put(fetched); err != nil {
+		t.Fatal("expected blocks should be stored:", err)
+	}
+	if len(bs.ExpectedKeys()) != 2 {
+		t.Fatalf("expected 2 keys, got %v", bs.ExpectedKeys())
+	}
+	sess.Close()
+
+	if len(bs.ExpectedKeys()) != 0 {
+		t.Fatal("keys still expected after the session closed")
+	}
+	if err := bs.Put(blocks.NewBlock([]byte("cached again"))); err != ErrStorageFull {
+		t.Fatalf("expected ErrStorageFull after the session closed, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package blockstore
+
+import (
+	"sync"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Session is a Blockstore for a sequence of writes expected to finish with
+// a pin, such as an add. The blocks written through it, or expected with
+// Expect, are stored past the storage quota and kept by garbage collection
+// until the session is closed. Writes only hold the pin lock while they
+// store a block, so garbage collection may run in between; the session is
+// to be closed once the blocks are pinned, under the pin lock.
+type Session struct {
+	GCBlockstore
+
+	lk      sync.Mutex
+	release map[u.Key]func()
+}
+
+// NewSession starts a session writing to bs.
+func NewSession(bs GCBlockstore) *Session {
+	return &Session{
+		GCBlockstore: bs,
+		release:      make(map[u.Key]func()),
+	}
+}
+
+// Put stores b, which is expected until the session is closed.
+func (s *Session) Put(b *blocks.Block) error {
+	unlock := s.PinLock()
+	defer unlock()
+	s.Expect(b.Key())
+	return s.GCBlockstore.Put(b)
+}
+
+// Expect marks k as on its way to a pin, until the session is closed.
+func (s *Session) Expect(k u.Key) func() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, ok := s.release[k]; !ok {
+		s.release[k] = s.GCBlockstore.Expect(k)
+	}
+	// the blocks expected are released all together, by Close
+	return func() {}
+}
+
+// Close releases the blocks of the session.
+func (s *Session) Close() error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for k, release := range s.release {
+		release()
+		delete(s.release, k)
+	}
+	return nil
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context under which the blocks fetched, as by a
+// blockservice, are expected in s.
+func WithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// SessionFromContext returns the session of ctx, or nil.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
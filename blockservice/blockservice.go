@@ -62,6 +62,17 @@ func New(bs blockstore.Blockstore, rem exchange.Interface) (*BlockService, error
 	}, nil
 }
 
+// WithBlockstore returns a BlockService storing blocks in bs, which
+// announces them through the exchange and the provide worker of s. Only s
+// is to be closed.
+func (s *BlockService) WithBlockstore(bs blockstore.Blockstore) *BlockService {
+	return &BlockService{
+		Blockstore: bs,
+		Exchange:   s.Exchange,
+		worker:     s.worker,
+	}
+}
+
 // AddBlock adds a particular block to the service, Putting it into the datastore.
 // TODO pass a context into this if the remote.HasBlock is going to remain here.
 func (s *BlockService) AddBlock(b *blocks.Block) (u.Key, error) {
@@ -87,6 +98,7 @@ func (s *BlockService) GetBlock(ctx context.Context, k u.Key) (*blocks.Block, er
 		// implementation changes, this will break.
 	} else if err == blockstore.ErrNotFound && s.Exchange != nil {
 		log.Debug("Blockservice: Searching bitswap.")
+		expect(ctx, k)
 		blk, err := s.Exchange.GetBlock(ctx, k)
 		if err != nil {
 			return nil, err
@@ -120,6 +132,9 @@ func (s *BlockService) GetBlocks(ctx context.Context, ks []u.Key) <-chan *blocks
 			}
 		}
 
+		for _, k := range misses {
+			expect(ctx, k)
+		}
 		rblocks, err := s.Exchange.GetBlocks(ctx, misses)
 		if err != nil {
 			log.Debugf("Error with GetBlocks: %s", err)
@@ -137,6 +152,14 @@ func (s *BlockService) GetBlocks(ctx context.Context, ks []u.Key) <-chan *blocks
 	return out
 }
 
+// expect marks k as expected in the blockstore session of ctx, if any, so
+// that the block fetched is stored past the storage quota.
+func expect(ctx context.Context, k u.Key) {
+	if sess := blockstore.SessionFromContext(ctx); sess != nil {
+		sess.Expect(k)
+	}
+}
+
 // DeleteBlock deletes a block in the blockservice from the datastore
 func (s *BlockService) DeleteBlock(k u.Key) error {
 	return s.Blockstore.DeleteBlock(k)
@@ -14,6 +14,7 @@ import (
 	"github.com/ipfs/go-ipfs/core"
 	commands "github.com/ipfs/go-ipfs/core/commands"
 	corehttp "github.com/ipfs/go-ipfs/core/corehttp"
+	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
 	"github.com/ipfs/go-ipfs/core/corerouting"
 	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
@@ -29,6 +30,7 @@ const (
 	ipfsMountKwd              = "mount-ipfs"
 	ipnsMountKwd              = "mount-ipns"
 	unrestrictedApiAccess     = "unrestricted-api"
+	migrateKwd                = "migrate"
 	// apiAddrKwd    = "address-api"
 	// swarmAddrKwd  = "address-swarm"
 )
@@ -62,7 +64,16 @@ in the network, use 0.0.0.0 as the ip address:
 
 Be careful if you expose the API. It is a security risk, as anyone could use control
 your node remotely. If you need to control the node remotely, make sure to protect
-the port as you would other services or database (firewall, authenticated proxy, etc).`,
+the port as you would other services or database (firewall, authenticated proxy, etc).
+
+To bound the disk space used by blocks, set a storage quota:
+
+   ipfs config Datastore.StorageMax 10GB
+
+Once usage crosses Datastore.StorageGCWatermark percent of the quota, the
+daemon runs a garbage collection until usage drops below
+Datastore.StorageGCLowWatermark percent. A full repo still stores blocks
+that are being added or pinned, but stops caching anything else.`,
 	},
 
 	Options: []cmds.Option{
@@ -73,6 +84,7 @@ the port as you would other services or database (firewall, authenticated proxy,
 		cmds.StringOption(ipfsMountKwd, "Path to the mountpoint for IPFS (if using --mount)"),
 		cmds.StringOption(ipnsMountKwd, "Path to the mountpoint for IPNS (if using --mount)"),
 		cmds.BoolOption(unrestrictedApiAccess, "Allow API access to unlisted hashes"),
+		cmds.BoolOption(migrateKwd, "Migrate the repo to the current version before starting"),
 
 		// TODO: add way to override addresses. tricky part: updating the config if also --init.
 		// cmds.StringOption(apiAddrKwd, "Address for the daemon rpc API (overrides config)"),
@@ -129,6 +141,18 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		}
 	}
 
+	migrate, _, err := req.Option(migrateKwd).Bool()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	if migrate {
+		if err := fsrepo.Migrate(req.Context().ConfigRoot, fsrepo.RepoVersion); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	}
+
 	// acquire the repo lock _before_ constructing a node. we need to make
 	// sure we are permitted to access the resources (datastore, etc.)
 	repo, err := fsrepo.Open(req.Context().ConfigRoot)
@@ -192,6 +216,13 @@ func daemonFunc(req cmds.Request, res cmds.Response) {
 		return node, nil
 	}
 
+	// collect garbage whenever the repo crosses its storage quota
+	go func() {
+		if err := corerepo.PeriodicGC(ctx.Context, node); err != nil {
+			log.Errorf("automatic gc disabled: %s", err)
+		}
+	}()
+
 	// verify api address is valid multiaddr
 	apiMaddr, err := ma.NewMultiaddr(cfg.Addresses.API)
 	if err != nil {
@@ -143,12 +143,19 @@ func addDefaultAssets(out io.Writer, repoRoot string) error {
 		}
 
 		k := u.B58KeyDecode(s)
-		if err := dirb.AddChild(fname, k); err != nil {
+		child, err := nd.DAG.Get(ctx, k)
+		if err != nil {
+			return err
+		}
+		if err := dirb.AddChild(ctx, fname, child); err != nil {
 			return err
 		}
 	}
 
-	dir := dirb.GetNode()
+	dir, err := dirb.GetNode()
+	if err != nil {
+		return err
+	}
 	dkey, err := nd.DAG.Add(dir)
 	if err != nil {
 		return err
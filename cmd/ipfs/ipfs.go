@@ -110,4 +110,6 @@ var cmdDetailsMap = map[*cmds.Command]cmdDetails{
 	commands.UpdateCheckCmd:    {preemptsAutoUpdate: true},
 	commands.UpdateLogCmd:      {preemptsAutoUpdate: true},
 	commands.LogCmd:            {cannotRunOnClient: true},
+	commands.RepoMigrateCmd:    {cannotRunOnDaemon: true},
+	commands.RepoImportCmd:     {cannotRunOnDaemon: true, doesNotUseConfigAsInput: true},
 }
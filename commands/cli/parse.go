@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
@@ -87,7 +88,15 @@ func parseOpts(args []string, root *cmds.Command) (
 
 		if optDef.Type() == cmds.Bool {
 			if mustUse {
-				return false, fmt.Errorf("Option '%s' takes no arguments, but was passed '%s'", name, *arg)
+				// a flag on by default is turned off with --flag=false
+				if optDef.DefaultVal() != true {
+					return false, fmt.Errorf("Option '%s' takes no arguments, but was passed '%s'", name, *arg)
+				}
+				if _, err := strconv.ParseBool(*arg); err != nil {
+					return false, fmt.Errorf("Option '%s' is either true or false, but was passed '%s'", name, *arg)
+				}
+				opts[name] = *arg
+				return false, nil
 			}
 			opts[name] = ""
 			return false, nil
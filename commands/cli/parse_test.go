@@ -70,6 +70,7 @@ func TestOptionParsing(t *testing.T) {
 		Options: []commands.Option{
 			commands.StringOption("string", "s", "a string"),
 			commands.BoolOption("bool", "b", "a bool"),
+			commands.BoolOption("on", "a bool on by default").Default(true),
 		},
 		Subcommands: map[string]*commands.Command{
 			"test": subCmd,
@@ -112,6 +113,11 @@ func TestOptionParsing(t *testing.T) {
 	test("-b foo", kvs{"b": ""}, words{"foo"})
 	test("--bool foo", kvs{"bool": ""}, words{"foo"})
 	testFail("--bool=foo")
+	testFail("--bool=false")
+	test("--on foo", kvs{"on": ""}, words{"foo"})
+	test("--on=false foo", kvs{"on": "false"}, words{"foo"})
+	test("--on=true", kvs{"on": "true"}, words{})
+	testFail("--on=foo")
 	testFail("--string")
 	test("--string foo", kvs{"string": "foo"}, words{})
 	test("--string=foo", kvs{"string": "foo"}, words{})
@@ -9,6 +9,8 @@ import (
 var (
 	ErrNotDirectory = errors.New("Couln't call NextFile(), this isn't a directory")
 	ErrNotReader    = errors.New("This file is a directory, can't use Reader functions")
+
+	errSymlinkTooLong = errors.New("symlink target is too long")
 )
 
 // File is an interface that provides functionality for handling files/directories
@@ -37,6 +39,14 @@ type StatFile interface {
 	Stat() os.FileInfo
 }
 
+// PathFile is a File read from disk, which knows its path there.
+type PathFile interface {
+	File
+
+	// AbsPath returns the absolute path of the file, or "" if unknown
+	AbsPath() string
+}
+
 type PeekFile interface {
 	SizeFile
 
@@ -0,0 +1,55 @@
+package files
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Symlink is a File for a symbolic link. It is never a directory, and
+// reading it returns the link target.
+type Symlink struct {
+	name   string
+	Target string
+	stat   os.FileInfo
+
+	reader io.Reader
+}
+
+func NewLinkFile(name, target string, stat os.FileInfo) *Symlink {
+	return &Symlink{
+		name:   name,
+		Target: target,
+		stat:   stat,
+		reader: strings.NewReader(target),
+	}
+}
+
+func (lf *Symlink) IsDirectory() bool {
+	return false
+}
+
+func (lf *Symlink) NextFile() (File, error) {
+	return nil, ErrNotDirectory
+}
+
+func (lf *Symlink) FileName() string {
+	return lf.name
+}
+
+func (lf *Symlink) Read(b []byte) (int, error) {
+	return lf.reader.Read(b)
+}
+
+func (lf *Symlink) Close() error {
+	return nil
+}
+
+func (lf *Symlink) Stat() os.FileInfo {
+	return lf.stat
+}
+
+// Size returns zero: a link has no content of its own to add.
+func (lf *Symlink) Size() (int64, error) {
+	return 0, nil
+}
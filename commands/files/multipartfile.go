@@ -1,19 +1,37 @@
 package files
 
 import (
+	"io"
+	"io/ioutil"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"time"
 )
 
 const (
 	multipartFormdataType = "multipart/form-data"
 	multipartMixedType    = "multipart/mixed"
+	applicationSymlink    = "application/symlink"
 
 	contentTypeHeader = "Content-Type"
 )
 
+// Headers of a file part that carry the attributes of the file: its
+// permission bits in octal, its modification time in seconds since the
+// Unix epoch, and its absolute path on disk, escaped like the file name.
+const (
+	ModeHeader    = "X-File-Mode"
+	MtimeHeader   = "X-File-Mtime"
+	AbspathHeader = "X-File-Abspath"
+)
+
+// longest symlink target accepted in a multipart request
+const maxSymlinkTarget = 4096
+
 // MultipartFile implements File, and is created from a `multipart.Part`.
 // It can be either a directory or file (checked by calling `IsDirectory()`).
 type MultipartFile struct {
@@ -22,6 +40,10 @@ type MultipartFile struct {
 	Part      *multipart.Part
 	Reader    *multipart.Reader
 	Mediatype string
+
+	// Local is whether the file was sent from this host, whose disk its
+	// AbsPath refers to. It carries over to the files of a directory.
+	Local bool
 }
 
 func NewFileFromPart(part *multipart.Part) (File, error) {
@@ -38,6 +60,17 @@ func NewFileFromPart(part *multipart.Part) (File, error) {
 		return nil, err
 	}
 
+	if f.Mediatype == applicationSymlink {
+		target, err := ioutil.ReadAll(io.LimitReader(part, maxSymlinkTarget+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(target) > maxSymlinkTarget {
+			return nil, errSymlinkTooLong
+		}
+		return NewLinkFile(f.FileName(), string(target), f.Stat()), nil
+	}
+
 	if f.IsDirectory() {
 		boundary, found := params["boundary"]
 		if !found {
@@ -50,6 +83,39 @@ func NewFileFromPart(part *multipart.Part) (File, error) {
 	return f, nil
 }
 
+// Stat returns the attributes sent along with the part, or nil if there
+// are none.
+func (f *MultipartFile) Stat() os.FileInfo {
+	modeStr := f.Part.Header.Get(ModeHeader)
+	mtimeStr := f.Part.Header.Get(MtimeHeader)
+	if modeStr == "" && mtimeStr == "" {
+		return nil
+	}
+
+	fi := &partInfo{name: f.FileName(), dir: f.IsDirectory()}
+	if mode, err := strconv.ParseUint(modeStr, 8, 32); err == nil {
+		fi.mode = os.FileMode(mode) & os.ModePerm
+	}
+	if mtime, err := strconv.ParseInt(mtimeStr, 10, 64); err == nil {
+		fi.mtime = time.Unix(mtime, 0)
+	}
+	return fi
+}
+
+// AbsPath returns the path the file was sent from, if it was sent along
+// from this host. The paths of remote files are of no use, or refer to
+// files their sender could not read.
+func (f *MultipartFile) AbsPath() string {
+	if !f.Local {
+		return ""
+	}
+	abspath, err := url.QueryUnescape(f.Part.Header.Get(AbspathHeader))
+	if err != nil {
+		return ""
+	}
+	return abspath
+}
+
 func (f *MultipartFile) IsDirectory() bool {
 	return f.Mediatype == multipartFormdataType || f.Mediatype == multipartMixedType
 }
@@ -64,7 +130,11 @@ func (f *MultipartFile) NextFile() (File, error) {
 		return nil, err
 	}
 
-	return NewFileFromPart(part)
+	child, err := NewFileFromPart(part)
+	if mf, ok := child.(*MultipartFile); ok {
+		mf.Local = f.Local
+	}
+	return child, err
 }
 
 func (f *MultipartFile) FileName() string {
@@ -89,3 +159,25 @@ func (f *MultipartFile) Close() error {
 	}
 	return f.Part.Close()
 }
+
+// partInfo is the os.FileInfo of a multipart file, as sent in the part
+// headers.
+type partInfo struct {
+	name  string
+	mode  os.FileMode
+	mtime time.Time
+	dir   bool
+}
+
+func (fi *partInfo) Name() string       { return fi.name }
+func (fi *partInfo) Size() int64        { return 0 }
+func (fi *partInfo) ModTime() time.Time { return fi.mtime }
+func (fi *partInfo) IsDir() bool        { return fi.dir }
+func (fi *partInfo) Sys() interface{}   { return nil }
+
+func (fi *partInfo) Mode() os.FileMode {
+	if fi.dir {
+		return fi.mode | os.ModeDir
+	}
+	return fi.mode
+}
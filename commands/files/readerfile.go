@@ -10,12 +10,19 @@ import (
 // ReaderFiles are never directories, and can be read from and closed.
 type ReaderFile struct {
 	filename string
+	abspath  string
 	reader   io.ReadCloser
 	stat     os.FileInfo
 }
 
 func NewReaderFile(filename string, reader io.ReadCloser, stat os.FileInfo) *ReaderFile {
-	return &ReaderFile{filename, reader, stat}
+	return &ReaderFile{filename, "", reader, stat}
+}
+
+// NewReaderPathFile returns a ReaderFile reading the file at abspath on
+// disk.
+func NewReaderPathFile(filename, abspath string, reader io.ReadCloser, stat os.FileInfo) *ReaderFile {
+	return &ReaderFile{filename, abspath, reader, stat}
 }
 
 func (f *ReaderFile) IsDirectory() bool {
@@ -30,10 +37,23 @@ func (f *ReaderFile) FileName() string {
 	return f.filename
 }
 
+func (f *ReaderFile) AbsPath() string {
+	return f.abspath
+}
+
 func (f *ReaderFile) Read(p []byte) (int, error) {
 	return f.reader.Read(p)
 }
 
+// Seek seeks the underlying reader, if it can seek.
+func (f *ReaderFile) Seek(offset int64, whence int) (int64, error) {
+	s, ok := f.reader.(io.Seeker)
+	if !ok {
+		return 0, errors.New("file is not seekable")
+	}
+	return s.Seek(offset, whence)
+}
+
 func (f *ReaderFile) Close() error {
 	return f.reader.Close()
 }
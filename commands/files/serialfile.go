@@ -4,6 +4,7 @@ import (
 	"io"
 	"os"
 	fp "path"
+	"path/filepath"
 	"sort"
 	"syscall"
 )
@@ -36,7 +37,11 @@ func NewSerialFile(path string, file *os.File) (File, error) {
 func newSerialFile(path string, file *os.File, stat os.FileInfo) (File, error) {
 	// for non-directories, return a ReaderFile
 	if !stat.IsDir() {
-		return &ReaderFile{path, file, stat}, nil
+		abspath, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		return NewReaderPathFile(path, abspath, file, stat), nil
 	}
 
 	// for directories, stat all of the contents first, so we know what files to
@@ -71,6 +76,7 @@ func (f *serialFile) NextFile() (File, error) {
 	if err != nil {
 		return nil, err
 	}
+	f.current = nil
 
 	// if there aren't any files left in the root directory, we're done
 	if len(f.files) == 0 {
@@ -80,8 +86,17 @@ func (f *serialFile) NextFile() (File, error) {
 	stat := f.files[0]
 	f.files = f.files[1:]
 
-	// open the next file
+	// links are added as links, not as the file they point to
 	filePath := fp.Join(f.path, stat.Name())
+	if stat.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return nil, err
+		}
+		return NewLinkFile(filePath, target, stat), nil
+	}
+
+	// open the next file
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
@@ -124,6 +139,9 @@ func (f *serialFile) Size() (int64, error) {
 }
 
 func size(stat os.FileInfo, filename string) (int64, error) {
+	if stat.Mode()&os.ModeSymlink != 0 {
+		return 0, nil
+	}
 	if !stat.IsDir() {
 		return stat.Size(), nil
 	}
@@ -69,3 +69,19 @@ func TestAllowedMethod(t *testing.T) {
 		"Access-Control-Expose-Headers":    "",
 	})
 }
+
+func TestIsLocal(t *testing.T) {
+	for addr, local := range map[string]bool{
+		"127.0.0.1:5001":   true,
+		"[::1]:5001":       true,
+		"10.0.0.1:5001":    false,
+		"[2001:db8::1]:80": false,
+		"garbage":          false,
+	} {
+		req, _ := http.NewRequest("POST", "http://example.com/api/v0/add", nil)
+		req.RemoteAddr = addr
+		if isLocal(req) != local {
+			t.Errorf("expected isLocal(%s) to be %t", addr, local)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"mime/multipart"
 	"net/textproto"
 	"net/url"
+	"strconv"
 	"sync"
 
 	files "github.com/ipfs/go-ipfs/commands/files"
@@ -83,13 +84,24 @@ func (mfr *MultiFileReader) Read(buf []byte) (written int, err error) {
 				header.Set("Content-Disposition", fmt.Sprintf("file; filename=\"%s\"", filename))
 			}
 
-			if file.IsDirectory() {
+			if _, ok := file.(*files.Symlink); ok {
+				header.Set("Content-Type", "application/symlink")
+			} else if file.IsDirectory() {
 				boundary := mfr.currentFile.(*MultiFileReader).Boundary()
 				header.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", boundary))
 			} else {
 				header.Set("Content-Type", "application/octet-stream")
 			}
 
+			if sf, ok := file.(files.StatFile); ok && sf.Stat() != nil {
+				stat := sf.Stat()
+				header.Set(files.ModeHeader, strconv.FormatUint(uint64(stat.Mode().Perm()), 8))
+				header.Set(files.MtimeHeader, strconv.FormatInt(stat.ModTime().Unix(), 10))
+			}
+			if pf, ok := file.(files.PathFile); ok && pf.AbsPath() != "" {
+				header.Set(files.AbspathHeader, url.QueryEscape(pf.AbsPath()))
+			}
+
 			_, err := mfr.mpWriter.CreatePart(header)
 			if err != nil {
 				return 0, err
@@ -4,8 +4,11 @@ import (
 	"io"
 	"io/ioutil"
 	"mime/multipart"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	files "github.com/ipfs/go-ipfs/commands/files"
 )
@@ -104,3 +107,70 @@ func TestOutput(t *testing.T) {
 		t.Error("Expected to get (nil, io.EOF)")
 	}
 }
+
+func TestOutputLinksAndAttrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multifilereader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.sh"), []byte("#!/bin/sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1234567890, 0)
+	if err := os.Chtimes(filepath.Join(dir, "a.sh"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.sh", filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := files.NewSerialFile(dir, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfr := NewMultiFileReader(files.NewSliceFile("", []files.File{sf}), true)
+	mpReader := multipart.NewReader(mfr, mfr.Boundary())
+
+	part, err := mpReader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpdir, err := files.NewFileFromPart(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpdir.(*files.MultipartFile).Local = true
+
+	script, err := mpdir.NextFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := script.(files.StatFile).Stat()
+	if stat == nil || stat.Mode().Perm() != 0750 || !stat.ModTime().Equal(mtime) {
+		t.Fatalf("attributes of a.sh were not sent: %v", stat)
+	}
+	if p := script.(files.PathFile).AbsPath(); p != filepath.Join(dir, "a.sh") {
+		t.Fatalf("wrong path sent for a.sh: %q", p)
+	}
+	if _, err := ioutil.ReadAll(script); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := mpdir.NextFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl, ok := link.(*files.Symlink)
+	if !ok {
+		t.Fatalf("expected a symlink, got %T", link)
+	}
+	if sl.Target != "a.sh" {
+		t.Fatalf("expected the link to point to a.sh, got %q", sl.Target)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"mime"
+	"net"
 	"net/http"
 	"strings"
 
@@ -97,7 +98,7 @@ func Parse(r *http.Request, root *cmds.Command) (cmds.Request, error) {
 
 	var f *files.MultipartFile
 	if mediatype == "multipart/form-data" {
-		f = &files.MultipartFile{Mediatype: mediatype}
+		f = &files.MultipartFile{Mediatype: mediatype, Local: isLocal(r)}
 		f.Reader, err = r.MultipartReader()
 		if err != nil {
 			return nil, err
@@ -144,3 +145,13 @@ func parseOptions(r *http.Request) (map[string]interface{}, []string) {
 
 	return opts, args
 }
+
+// isLocal returns whether r was sent from this host.
+func isLocal(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -21,12 +21,19 @@ type Option interface {
 	Names() []string     // a list of unique names matched with user-provided flags
 	Type() reflect.Kind  // value must be this type
 	Description() string // a short string that describes this option
+
+	// Default records that the option is v when not given, and returns
+	// the option. Only a Bool option defaulting to true can be given a
+	// value on the command line, to turn it off with --name=false.
+	Default(v interface{}) Option
+	DefaultVal() interface{} // the value recorded with Default, or nil
 }
 
 type option struct {
 	names       []string
 	kind        reflect.Kind
 	description string
+	defaultVal  interface{}
 }
 
 func (o *option) Names() []string {
@@ -41,6 +48,15 @@ func (o *option) Description() string {
 	return o.description
 }
 
+func (o *option) Default(v interface{}) Option {
+	o.defaultVal = v
+	return o
+}
+
+func (o *option) DefaultVal() interface{} {
+	return o.defaultVal
+}
+
 // constructor helper functions
 func NewOption(kind reflect.Kind, names ...string) Option {
 	if len(names) < 2 {
@@ -6,6 +6,7 @@ import (
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	dsync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	repo "github.com/ipfs/go-ipfs/repo"
 )
 
@@ -31,6 +32,7 @@ func NewNodeBuilder() *NodeBuilder {
 func defaultRepo() repo.Repo {
 	return &repo.Mock{
 		D: dsync.MutexWrap(ds.NewMapDatastore()),
+		K: keystore.NewMemKeystore(),
 	}
 }
 
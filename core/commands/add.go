@@ -1,22 +1,34 @@
 package commands
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path"
+	"strconv"
 	"strings"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/cheggaaa/pb"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	bsrv "github.com/ipfs/go-ipfs/blockservice"
 	cmds "github.com/ipfs/go-ipfs/commands"
 	files "github.com/ipfs/go-ipfs/commands/files"
 	core "github.com/ipfs/go-ipfs/core"
-	coreunix "github.com/ipfs/go-ipfs/core/coreunix"
-	importer "github.com/ipfs/go-ipfs/importer"
+	"github.com/ipfs/go-ipfs/core/coreunix"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
 	"github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -27,14 +39,34 @@ var ErrDepthLimitExceeded = fmt.Errorf("depth limit exceeded")
 const progressReaderIncrement = 1024 * 256
 
 const (
-	progressOptionName = "progress"
-	wrapOptionName     = "wrap-with-directory"
+	progressOptionName    = "progress"
+	wrapOptionName        = "wrap-with-directory"
+	chunkerOptionName     = "chunker"
+	modeOptionName        = "preserve-mode"
+	mtimeOptionName       = "preserve-mtime"
+	rawLeavesOptionName   = "raw-leaves"
+	inlineOptionName      = "inline"
+	inlineLimitOptionName = "inline-limit"
+	hashOptionName        = "hash"
+	resumeOptionName      = "resume"
+	nocopyOptionName      = "nocopy"
 )
 
+// files of at most this many encoded bytes are inlined by --inline
+const defaultInlineLimit = 32
+
+// AddedObject is an event of an add: the progress of a file, with the bytes
+// of it read so far and its size if known, or the hash of a file once it is
+// added, with its size for files.
 type AddedObject struct {
 	Name  string
 	Hash  string `json:",omitempty"`
 	Bytes int64  `json:",omitempty"`
+	Size  int64  `json:",omitempty"`
+
+	// the bytes of the file imported by an earlier add, which this one
+	// resumes from
+	Resumed int64 `json:",omitempty"`
 }
 
 var AddCmd = &cmds.Command{
@@ -45,6 +77,41 @@ Adds contents of <path> to ipfs. Use -r to add directories.
 Note that directories are added recursively, to form the ipfs
 MerkleDAG. A smarter partial add with a staging area (like git)
 remains to be implemented.
+
+The --chunker option selects how files are cut into blocks:
+'size-<bytes>' cuts blocks of a fixed size, 262144 bytes by default.
+'rabin-<min>-<avg>-<max>' cuts blocks where the content allows, so that
+an edit that shifts the rest of a file only changes the blocks around
+it. 'rabin-<avg>' and 'rabin' pick the bounds from the average size.
+
+Symbolic links are added as links. With --preserve-mode and
+--preserve-mtime, the permissions and modification times of files and
+directories are recorded too, and restored by 'ipfs get'. Recording them
+changes the hashes of the added objects.
+
+With --raw-leaves, the blocks of file data are stored as they are,
+without a unixfs wrapper, so that each one hashes like the bytes it
+holds. With --inline, files small enough to fit in --inline-limit bytes
+(32 by default) are stored inside the directory that holds them rather
+than in blocks of their own.
+
+Objects are hashed with sha2-256, unless --hash names another of
+sha2-512 or sha3.
+
+Adds of large files record their progress as they go. If one is
+interrupted, adding the same file again with --resume picks up where it
+stopped, rather than chunking and storing the whole file again. A file
+counts as the same when it has the same name, size and modification
+time, and is added with the same options. When adding through the
+daemon, the part of the file already stored is still sent to it, and
+skipped there; only adds run without a daemon seek past it.
+
+With --nocopy, the blocks of file data are not copied into the repo:
+the repo records where they lie in the added files instead, and reads
+them from there when they are needed. The files must then stay where
+they are, unchanged, and be readable by the daemon; blocks of files that
+changed can no longer be read. 'ipfs filestore' lists and checks the
+blocks stored this way. --nocopy implies --raw-leaves.
 `,
 	},
 
@@ -57,6 +124,15 @@ remains to be implemented.
 		cmds.BoolOption(progressOptionName, "p", "Stream progress data"),
 		cmds.BoolOption(wrapOptionName, "w", "Wrap files with a directory object"),
 		cmds.BoolOption("t", "trickle", "Use trickle-dag format for dag generation"),
+		cmds.StringOption(chunkerOptionName, "s", "Chunking algorithm: size-<bytes> or rabin-<min>-<avg>-<max>"),
+		cmds.BoolOption(modeOptionName, "Record the permissions of files and directories"),
+		cmds.BoolOption(mtimeOptionName, "Record the modification times of files and directories"),
+		cmds.BoolOption(rawLeavesOptionName, "Store the blocks of file data as raw blocks"),
+		cmds.BoolOption(inlineOptionName, "Store small files inside their directory"),
+		cmds.IntOption(inlineLimitOptionName, "The largest size in bytes of an inlined file"),
+		cmds.StringOption(hashOptionName, "The hash function to use: sha2-256, sha2-512 or sha3"),
+		cmds.BoolOption(resumeOptionName, "Resume interrupted adds of the same files"),
+		cmds.BoolOption(nocopyOptionName, "Store references to the files instead of copying their data (implies --raw-leaves)"),
 	},
 	PreRun: func(req cmds.Request) error {
 		if quiet, _, _ := req.Option("quiet").Bool(); quiet {
@@ -88,15 +164,73 @@ remains to be implemented.
 			return
 		}
 
-		progress, _, _ := req.Option(progressOptionName).Bool()
-		wrap, _, _ := req.Option(wrapOptionName).Bool()
+		chunker, _, _ := req.Option(chunkerOptionName).String()
+		spl, err := chunk.FromString(chunker)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		inlineLimit, found, _ := req.Option(inlineLimitOptionName).Int()
+		if !found {
+			inlineLimit = defaultInlineLimit
+		}
+		if inlineLimit < 0 {
+			res.SetError(fmt.Errorf("invalid inline limit: %d", inlineLimit), cmds.ErrClient)
+			return
+		}
+
+		hashFn, err := hashFuncOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
 
 		outChan := make(chan interface{}, 8)
 		res.SetOutput((<-chan interface{})(outChan))
 
+		a := &adder{
+			node:     n,
+			out:      outChan,
+			splitter: spl,
+			chunker:  chunker,
+			hashFn:   hashFn,
+		}
+		a.progress, _, _ = req.Option(progressOptionName).Bool()
+		a.wrap, _, _ = req.Option(wrapOptionName).Bool()
+		a.preserveMode, _, _ = req.Option(modeOptionName).Bool()
+		a.preserveMtime, _, _ = req.Option(mtimeOptionName).Bool()
+		a.rawLeaves, _, _ = req.Option(rawLeavesOptionName).Bool()
+		a.resume, _, _ = req.Option(resumeOptionName).Bool()
+		a.nocopy, _, _ = req.Option(nocopyOptionName).Bool()
+		if a.nocopy {
+			// only raw leaves hold data exactly as it lies in the file
+			a.rawLeaves = true
+		}
+		if inline, _, _ := req.Option(inlineOptionName).Bool(); inline {
+			a.inlineLimit = inlineLimit
+		}
+
 		go func() {
 			defer close(outChan)
 
+			// the blocks added are kept from garbage collection by the
+			// session until they are pinned, so the pin lock is only held
+			// to pin them, not while the client sends the files
+			sess, ds := n.PinSession()
+			defer sess.Close()
+			a.sess, a.dag = sess, ds
+
+			if a.inlineLimit > 0 {
+				scratch, closer, err := newScratchDAG()
+				if err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+				defer closer.Close()
+				a.scratch = scratch
+			}
+
 			for {
 				file, err := req.Files().NextFile()
 				if err != nil && err != io.EOF {
@@ -107,20 +241,13 @@ remains to be implemented.
 					return
 				}
 
-				rootnd, err := addFile(n, file, outChan, progress, wrap)
+				rootnd, err := a.addFile(file)
 				if err != nil {
 					res.SetError(err, cmds.ErrNormal)
 					return
 				}
 
-				err = n.Pinning.Pin(context.Background(), rootnd, true)
-				if err != nil {
-					res.SetError(err, cmds.ErrNormal)
-					return
-				}
-
-				err = n.Pinning.Flush()
-				if err != nil {
+				if err := a.pin(rootnd); err != nil {
 					res.SetError(err, cmds.ErrNormal)
 					return
 				}
@@ -213,55 +340,278 @@ remains to be implemented.
 	Type: AddedObject{},
 }
 
-func add(n *core.IpfsNode, reader io.Reader) (*dag.Node, error) {
-	node, err := importer.BuildDagFromReader(reader, n.DAG, nil, chunk.DefaultSplitter)
+// adder adds files to a node, as set up by the options of the add command.
+type adder struct {
+	node     *core.IpfsNode
+	sess     *bstore.Session // the blocks added are written through
+	dag      dag.DAGService  // writes through sess
+	out      chan interface{}
+	progress bool
+	wrap     bool
+	splitter chunk.BlockSplitter
+	chunker  string
+	resume   bool
+	nocopy   bool
+
+	// which attributes of the files to record
+	preserveMode  bool
+	preserveMtime bool
+
+	// how to lay out the added data
+	rawLeaves   bool
+	inlineLimit int
+	hashFn      int
+
+	// whether the files added go in a directory, where they may be
+	// inlined
+	inDir bool
+	// keeps the files that may be inlined until they are, so that inlined
+	// files aren't stored in blocks of their own too
+	scratch dag.DAGService
+}
+
+// pin pins the root of an added file, under the pin lock.
+func (a *adder) pin(root *dag.Node) error {
+	defer a.node.Blockstore.PinLock()()
+
+	if err := a.node.Pinning.Pin(context.Background(), root, true); err != nil {
+		return err
+	}
+	return a.node.Pinning.Flush()
+}
+
+// builderParams returns the params to build the dag of a file with.
+func (a *adder) builderParams() h.DagBuilderParams {
+	return h.DagBuilderParams{
+		Dagserv:   a.dag,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		RawLeaves: a.rawLeaves,
+		HashFunc:  a.hashFn,
+	}
+}
+
+// checkpointer returns the checkpointer of the add of file, or nil if the
+// file cannot be told apart from others with the same name, as when it is
+// read from stdin.
+func (a *adder) checkpointer(file files.File) *coreunix.Checkpointer {
+	sf, ok := file.(files.StatFile)
+	if !ok || sf.Stat() == nil || sf.Stat().ModTime().IsZero() {
+		return nil
+	}
+	st := sf.Stat()
+	id := coreunix.CheckpointID(
+		file.FileName(),
+		strconv.FormatInt(st.Size(), 10),
+		strconv.FormatInt(st.ModTime().UnixNano(), 10),
+		a.chunker,
+		strconv.FormatBool(a.rawLeaves),
+		strconv.Itoa(a.hashFn),
+	)
+	return coreunix.NewCheckpointer(a.node.Repo.Datastore(), id)
+}
+
+// skipInput skips the first n bytes of file, seeking past them if it can.
+// Files sent by a client over HTTP can't seek, so the skipped bytes are
+// still read from the request: the client has no access to the checkpoint
+// and sends whole files.
+func skipInput(file files.File, n int64) error {
+	if n == 0 {
+		return nil
+	}
+	if s, ok := file.(io.Seeker); ok {
+		if _, err := s.Seek(n, os.SEEK_CUR); err == nil {
+			return nil
+		}
+	}
+	_, err := io.CopyN(ioutil.Discard, file, n)
+	return err
+}
+
+// newScratchDAG returns a DAGService keeping nodes in memory, offline,
+// along with the service to close once done with it.
+func newScratchDAG() (dag.DAGService, io.Closer, error) {
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	bserv, err := bsrv.New(bs, offline.Exchange(bs))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	return dag.NewDAGService(bserv), bserv, nil
+}
 
-	return node, nil
+// copyDAG stores nd and the nodes under it, found in from, in to.
+func copyDAG(ctx context.Context, nd *dag.Node, from, to dag.DAGService) error {
+	for _, lnk := range nd.Links {
+		child, err := lnk.GetNode(ctx, from)
+		if err != nil {
+			return err
+		}
+		if err := copyDAG(ctx, child, from, to); err != nil {
+			return err
+		}
+	}
+	_, err := to.Add(nd)
+	return err
 }
 
-func addFile(n *core.IpfsNode, file files.File, out chan interface{}, progress bool, wrap bool) (*dag.Node, error) {
+// newDirectory returns an empty directory for the adder to fill.
+func (a *adder) newDirectory() (*uio.Directory, error) {
+	dir := uio.NewDirectory(a.dag)
+	dir.SetInlineLimit(a.inlineLimit)
+	if err := dir.SetHashFunc(a.hashFn); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+func (a *adder) addFile(file files.File) (*dag.Node, error) {
 	if file.IsDirectory() {
-		return addDir(n, file, out, progress)
+		return a.addDir(file)
+	}
+
+	if link, ok := file.(*files.Symlink); ok {
+		return a.addSymlink(link)
 	}
 
 	// if the progress flag was specified, wrap the file so that we can send
 	// progress updates to the client (over the output channel)
 	var reader io.Reader = file
-	if progress {
-		reader = &progressReader{file: file, out: out}
+	var progress *progressReader
+	if a.progress {
+		progress = &progressReader{file: file, out: a.out}
+		if sf, ok := file.(files.StatFile); ok && sf.Stat() != nil {
+			progress.size = sf.Stat().Size()
+		}
+		reader = progress
 	}
 
-	if wrap {
-		p, dagnode, err := coreunix.AddWrapped(n, reader, path.Base(file.FileName()))
+	dbp := a.builderParams()
+	if a.nocopy {
+		pf, ok := file.(files.PathFile)
+		if !ok || pf.AbsPath() == "" {
+			return nil, fmt.Errorf("cannot add %s without copying it: its path on disk is unknown", file.FileName())
+		}
+		dbp.FullPath = pf.AbsPath()
+	}
+
+	cp := a.checkpointer(file)
+	if cp != nil {
+		dbp.LeafAdded = cp.LeafAdded
+	}
+	if cp != nil && a.resume {
+		// the leaves stored by the previous add are not pinned, keep
+		// them from garbage collection from when they are found
+		unlock := a.node.Blockstore.PinLock()
+		var err error
+		dbp.Resume, err = cp.Load(a.node.Blockstore)
+		for _, l := range dbp.Resume {
+			a.sess.Expect(u.Key(l.Hash))
+		}
+		unlock()
 		if err != nil {
 			return nil, err
 		}
-		out <- &AddedObject{
-			Hash: p,
-			Name: file.FileName(),
+		var skip int64
+		for _, l := range dbp.Resume {
+			skip += int64(l.DataSize)
+		}
+		if err := skipInput(file, skip); err != nil {
+			return nil, err
+		}
+		if progress != nil && skip > 0 {
+			progress.skip(skip)
 		}
-		return dagnode, nil
 	}
 
-	dagnode, err := add(n, reader)
+	// a file small enough to be inlined is built without storing it, and
+	// only stored if it turns out not to be inlined
+	scratched := false
+	if a.scratch != nil && (a.inDir || a.wrap) && len(dbp.Resume) == 0 {
+		head := make([]byte, a.inlineLimit+1)
+		n, err := io.ReadFull(reader, head)
+		switch err {
+		case io.EOF, io.ErrUnexpectedEOF:
+			reader = bytes.NewReader(head[:n])
+			dbp.Dagserv = a.scratch
+			scratched = true
+		case nil:
+			reader = io.MultiReader(bytes.NewReader(head), reader)
+		default:
+			return nil, err
+		}
+	}
+
+	dagnode, err := bal.BalancedLayout(dbp.New(a.splitter.Split(reader)))
 	if err != nil {
 		return nil, err
 	}
+	if cp != nil {
+		if err := cp.Remove(); err != nil {
+			return nil, err
+		}
+	}
+	if scratched && len(dagnode.Links) > 0 {
+		// split in several blocks, it can't be inlined after all
+		if err := copyDAG(a.node.Context(), dagnode, a.scratch, a.dag); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := a.setAttrs(dagnode, file); err != nil {
+		return nil, err
+	}
+	if a.wrap {
+		if err := a.store(dagnode, true); err != nil {
+			return nil, err
+		}
+		return a.addWrapped(dagnode, file.FileName())
+	}
+	if err := a.store(dagnode, a.inDir); err != nil {
+		return nil, err
+	}
 
 	log.Infof("adding file: %s", file.FileName())
-	if err := outputDagnode(out, file.FileName(), dagnode); err != nil {
+	o, err := getOutput(dagnode)
+	if err != nil {
+		return nil, err
+	}
+	size, err := ft.DataSize(dagnode.Data)
+	if err != nil {
 		return nil, err
 	}
+	a.out <- &AddedObject{
+		Name: file.FileName(),
+		Hash: o.Hash,
+		Size: int64(size),
+	}
 	return dagnode, nil
 }
 
-func addDir(n *core.IpfsNode, dir files.File, out chan interface{}, progress bool) (*dag.Node, error) {
+func (a *adder) addSymlink(link *files.Symlink) (*dag.Node, error) {
+	log.Infof("adding symlink: %s", link.FileName())
+
+	dagnode := &dag.Node{Data: ft.SymlinkData(link.Target)}
+	if err := dagnode.SetHashFunc(a.hashFn); err != nil {
+		return nil, err
+	}
+	if err := a.setAttrs(dagnode, link); err != nil {
+		return nil, err
+	}
+	if err := a.store(dagnode, a.inDir); err != nil {
+		return nil, err
+	}
+	if err := outputDagnode(a.out, link.FileName(), dagnode); err != nil {
+		return nil, err
+	}
+	return dagnode, nil
+}
+
+func (a *adder) addDir(dir files.File) (*dag.Node, error) {
 	log.Infof("adding directory: %s", dir.FileName())
 
-	tree := &dag.Node{Data: ft.FolderPBData()}
+	tree, err := a.newDirectory()
+	if err != nil {
+		return nil, err
+	}
 
 	for {
 		file, err := dir.NextFile()
@@ -272,30 +622,122 @@ func addDir(n *core.IpfsNode, dir files.File, out chan interface{}, progress boo
 			break
 		}
 
-		node, err := addFile(n, file, out, progress, false)
+		child := *a
+		child.wrap = false
+		child.inDir = true
+		node, err := child.addFile(file)
 		if err != nil {
 			return nil, err
 		}
 
 		_, name := path.Split(file.FileName())
 
-		err = tree.AddNodeLink(name, node)
+		err = tree.AddChild(a.node.Context(), name, node)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := outputDagnode(out, dir.FileName(), tree)
+	dirnode, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.setAttrs(dirnode, dir); err != nil {
+		return nil, err
+	}
+
+	err = outputDagnode(a.out, dir.FileName(), dirnode)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = n.DAG.Add(tree)
+	if err := a.store(dirnode, a.inDir); err != nil {
+		return nil, err
+	}
+
+	return dirnode, nil
+}
+
+// addWrapped stores dagnode in a directory of its own, under the base of
+// filename, and returns the directory.
+func (a *adder) addWrapped(dagnode *dag.Node, filename string) (*dag.Node, error) {
+	name := path.Base(filename)
+	dir, err := a.newDirectory()
+	if err != nil {
+		return nil, err
+	}
+	if err := dir.AddChild(a.node.Context(), name, dagnode); err != nil {
+		return nil, err
+	}
+	dirnode, err := dir.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	k, err := a.dag.Add(dirnode)
 	if err != nil {
 		return nil, err
 	}
 
-	return tree, nil
+	a.out <- &AddedObject{
+		Hash: path.Join(k.String(), name),
+		Name: filename,
+	}
+	return dirnode, nil
+}
+
+// setAttrs records the attributes of file that the adder preserves in
+// dagnode.
+func (a *adder) setAttrs(dagnode *dag.Node, file files.File) error {
+	sf, ok := file.(files.StatFile)
+	if !ok || sf.Stat() == nil || !(a.preserveMode || a.preserveMtime) {
+		return nil
+	}
+
+	var attrs ft.Attrs
+	if a.preserveMode {
+		attrs.Mode = sf.Stat().Mode().Perm()
+	}
+	if a.preserveMtime {
+		attrs.ModTime = sf.Stat().ModTime()
+	}
+	data, err := ft.SetAttrs(dagnode.Data, attrs)
+	if err != nil {
+		return err
+	}
+	dagnode.Data = data
+	// the node may have been encoded already, with its old data
+	_, err = dagnode.Encoded(true)
+	return err
+}
+
+// store stores dagnode, unless it goes in a directory that inlines it.
+func (a *adder) store(dagnode *dag.Node, inDir bool) error {
+	if inDir {
+		inline, err := uio.Inlines(dagnode, a.inlineLimit)
+		if err != nil || inline {
+			return err
+		}
+	}
+	_, err := a.dag.Add(dagnode)
+	return err
+}
+
+// hashFuncOption returns the multihash function named by the hash option,
+// sha2-256 if it is not given.
+func hashFuncOption(req cmds.Request) (int, error) {
+	name, found, err := req.Option(hashOptionName).String()
+	if err != nil {
+		return 0, err
+	}
+	if !found || name == "" {
+		return mh.SHA2_256, nil
+	}
+	code, ok := mh.Names[strings.ToLower(name)]
+	if !ok || !u.HashFuncs[code] {
+		return 0, fmt.Errorf("unsupported hash function: %q", name)
+	}
+	return code, nil
 }
 
 // outputDagnode sends dagnode info over the output channel
@@ -316,6 +758,7 @@ func outputDagnode(out chan interface{}, name string, dn *dag.Node) error {
 type progressReader struct {
 	file         files.File
 	out          chan interface{}
+	size         int64 // the size of the file, if known
 	bytes        int64
 	lastProgress int64
 }
@@ -329,8 +772,22 @@ func (i *progressReader) Read(p []byte) (int, error) {
 		i.out <- &AddedObject{
 			Name:  i.file.FileName(),
 			Bytes: i.bytes,
+			Size:  i.size,
 		}
 	}
 
 	return n, err
 }
+
+// skip accounts for the first n bytes of the file, skipped as an earlier
+// add imported them.
+func (i *progressReader) skip(n int64) {
+	i.bytes += n
+	i.lastProgress = i.bytes
+	i.out <- &AddedObject{
+		Name:    i.file.FileName(),
+		Bytes:   i.bytes,
+		Size:    i.size,
+		Resumed: n,
+	}
+}
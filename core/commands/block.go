@@ -107,12 +107,17 @@ var blockPutCmd = &cmds.Command{
 		ShortDescription: `
 ipfs block put is a plumbing command for storing raw ipfs blocks.
 It reads from stdin, and <key> is a base58 encoded multihash.
+The block is hashed with sha2-256, unless --hash names another of
+sha2-512 or sha3.
 `,
 	},
 
 	Arguments: []cmds.Argument{
 		cmds.FileArg("data", true, false, "The data to be stored as an IPFS block").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.StringOption(hashOptionName, "The hash function to use: sha2-256, sha2-512 or sha3"),
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.Context().GetNode()
 		if err != nil {
@@ -120,6 +125,12 @@ It reads from stdin, and <key> is a base58 encoded multihash.
 			return
 		}
 
+		hashFn, err := hashFuncOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
 		file, err := req.Files().NextFile()
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
@@ -138,7 +149,11 @@ It reads from stdin, and <key> is a base58 encoded multihash.
 			return
 		}
 
-		b := blocks.NewBlock(data)
+		b, err := blocks.NewBlockWithHashFunc(data, hashFn)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
 		log.Debugf("BlockPut key: '%q'", b.Key())
 
 		k, err := n.Blocks.AddBlock(b)
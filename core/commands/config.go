@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 
@@ -14,6 +13,7 @@ import (
 	repo "github.com/ipfs/go-ipfs/repo"
 	config "github.com/ipfs/go-ipfs/repo/config"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -136,7 +136,8 @@ var configShowCmd = &cmds.Command{
 		Tagline: "Outputs the content of the config file",
 		ShortDescription: `
 WARNING: Your private key is stored in the config file, and it will be
-included in the output of this command.
+included in the output of this command. The secret keys of datastore
+mounts are left out.
 `,
 	},
 
@@ -236,12 +237,17 @@ func setConfig(r repo.Repo, key string, value interface{}) (*ConfigField, error)
 func showConfig(filename string) (io.Reader, error) {
 	// TODO maybe we should omit privkey so we don't accidentally leak it?
 
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
+	var cfg map[string]interface{}
+	if err := serialize.ReadConfigFile(filename, &cfg); err != nil {
 		return nil, err
 	}
+	fsrepo.RedactSecrets(cfg)
 
-	return bytes.NewReader(data), nil
+	data, err := config.HumanOutput(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(append(data, '\n')), nil
 }
 
 func editConfig(filename string) error {
@@ -0,0 +1,128 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	cmds "github.com/ipfs/go-ipfs/commands"
+	filestore "github.com/ipfs/go-ipfs/filestore"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var FilestoreCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Manage the blocks stored by reference to files",
+		ShortDescription: `
+Blocks added with 'ipfs add --nocopy' are not copied into the repo: the
+repo records where they lie in the added files instead. These commands
+list those blocks, and check that their files still hold them.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"ls":     filestoreLsCmd,
+		"verify": filestoreVerifyCmd,
+	},
+}
+
+var filestoreLsCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the blocks stored by reference to files",
+		ShortDescription: `
+'ipfs filestore ls' lists every block stored by reference, with the file,
+offset and size of its data:
+
+  <hash> <file> <offset> <size>
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		runFilestoreList(req, res, (*filestore.Filestore).List)
+	},
+	Type: filestore.ListRes{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: filestoreMarshaler(func(r *filestore.ListRes) string {
+			return fmt.Sprintf("%s %s %d %d\n", r.Key, r.FilePath, r.Offset, r.Size)
+		}),
+	},
+}
+
+var filestoreVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check the blocks stored by reference to files",
+		ShortDescription: `
+'ipfs filestore verify' reads back every block stored by reference and
+checks that its data still hashes to its key. Each block is listed with
+its status: 'ok', 'changed' when its file holds other data there now,
+'missing' when its file is gone or too short, or 'error' when its file
+cannot be read.
+
+  <status> <hash> <file> <offset>
+
+Blocks that are not 'ok' can no longer be read, until their files are
+restored or added again.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		runFilestoreList(req, res, (*filestore.Filestore).Verify)
+	},
+	Type: filestore.ListRes{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: filestoreMarshaler(func(r *filestore.ListRes) string {
+			return fmt.Sprintf("%s %s %s %d\n", r.Status, r.Key, r.FilePath, r.Offset)
+		}),
+	},
+}
+
+func runFilestoreList(req cmds.Request, res cmds.Response, list func(*filestore.Filestore, context.Context) (<-chan *filestore.ListRes, error)) {
+	n, err := req.Context().GetNode()
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	if n.Filestore == nil {
+		res.SetError(fmt.Errorf("this node has no filestore"), cmds.ErrNormal)
+		return
+	}
+
+	refs, err := list(n.Filestore, req.Context().Context)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
+	outChan := make(chan interface{})
+	res.SetOutput((<-chan interface{})(outChan))
+
+	go func() {
+		defer close(outChan)
+		for r := range refs {
+			outChan <- r
+		}
+	}()
+}
+
+func filestoreMarshaler(format func(*filestore.ListRes) string) cmds.Marshaler {
+	return func(res cmds.Response) (io.Reader, error) {
+		outChan, ok := res.Output().(<-chan interface{})
+		if !ok {
+			return nil, u.ErrCast()
+		}
+
+		marshal := func(v interface{}) (io.Reader, error) {
+			r, ok := v.(*filestore.ListRes)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			return bytes.NewBufferString(format(r)), nil
+		}
+
+		return &cmds.ChannelMarshaler{
+			Channel:   outChan,
+			Marshaler: marshal,
+		}, nil
+	}
+}
@@ -0,0 +1,518 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// selfKeyName names the identity key of the node, which is not kept in
+// the keystore.
+const selfKeyName = "self"
+
+// The sizes in bits of the keys key gen creates. Smaller RSA keys are
+// weak, larger ones take too long to generate.
+const (
+	defaultKeySize = 2048
+	minKeySize     = 2048
+	maxKeySize     = 8192
+)
+
+var errNoKeystore = errors.New("this repo has no keystore")
+
+type KeyOutput struct {
+	Name string
+	Id   string
+}
+
+type KeyOutputList struct {
+	Keys []KeyOutput
+}
+
+type KeyRenameOutput struct {
+	Was string
+	Now string
+	Id  string
+}
+
+var KeyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create and manage the keys IPNS names are published with",
+		Synopsis: `
+ipfs key gen <name>               - Create a new key
+ipfs key list                     - List the keys
+ipfs key rm <name>...             - Remove keys
+ipfs key rename <name> <new-name> - Rename a key
+ipfs key export <name>            - Write a key to stdout
+ipfs key import <name> <file>     - Store a key written by export
+`,
+		ShortDescription: `
+Besides its identity key, named 'self', a node can keep any number of
+named keys in the keystore of its repo, and publish an IPNS name with
+each: 'ipfs name publish --key=<name>'. The keys are stored encrypted
+with a passphrase, read from the IPFS_KEYSTORE_PASSPHRASE environment
+variable; it is needed to create, import, export and publish with keys.
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"gen":    keyGenCmd,
+		"list":   keyListCmd,
+		"rm":     keyRmCmd,
+		"rename": keyRenameCmd,
+		"export": keyExportCmd,
+		"import": keyImportCmd,
+	},
+}
+
+var keyGenCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a new key",
+		ShortDescription: `
+'ipfs key gen' creates a new RSA key, stores it as <name>, and outputs
+the IPNS name it publishes to.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "The name of the key to create"),
+	},
+	Options: []cmds.Option{
+		cmds.StringOption("type", "t", "The type of the key to create: rsa (the default)"),
+		cmds.IntOption("size", "s", fmt.Sprintf("The size in bits of the key to create, from %d to %d (default: %d)", minKeySize, maxKeySize, defaultKeySize)),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		ks, err := nodeKeystore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		name := req.Arguments()[0]
+		if err := validateKeyName(name); err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		typ, _, _ := req.Option("type").String()
+		if typ != "" && typ != "rsa" {
+			res.SetError(fmt.Errorf("unknown key type: %s", typ), cmds.ErrClient)
+			return
+		}
+		size, found, err := req.Option("size").Int()
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		if !found {
+			size = defaultKeySize
+		}
+		if size < minKeySize || size > maxKeySize {
+			res.SetError(fmt.Errorf("key size must be from %d to %d bits, got %d", minKeySize, maxKeySize, size), cmds.ErrClient)
+			return
+		}
+
+		sk, _, err := ci.GenerateKeyPair(ci.RSA, size)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if err := ks.Put(name, sk); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out, err := keyOutput(name, sk)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: keyOutputMarshaler,
+	},
+	Type: KeyOutput{},
+}
+
+var keyListCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "List the keys",
+		ShortDescription: `
+'ipfs key list' lists the names of the keys, the identity key 'self'
+first. With -l, each name is preceded by the IPNS name of its key.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("l", "Show the IPNS name of each key"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		ks, err := nodeKeystore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		names, err := ks.List()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		// the keys are only read, and so need the passphrase, with -l
+		long, _, _ := req.Option("l").Bool()
+
+		list := &KeyOutputList{}
+		for _, name := range append([]string{selfKeyName}, names...) {
+			if !long {
+				list.Keys = append(list.Keys, KeyOutput{Name: name})
+				continue
+			}
+			sk, err := keyByName(n, name)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			out, err := keyOutput(name, sk)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			list.Keys = append(list.Keys, *out)
+		}
+		res.SetOutput(list)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			list, ok := res.Output().(*KeyOutputList)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			long, _, _ := res.Request().Option("l").Bool()
+
+			var buf bytes.Buffer
+			for _, k := range list.Keys {
+				if long {
+					fmt.Fprintf(&buf, "%s %s\n", k.Id, k.Name)
+				} else {
+					fmt.Fprintln(&buf, k.Name)
+				}
+			}
+			return &buf, nil
+		},
+	},
+	Type: KeyOutputList{},
+}
+
+var keyRmCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove keys",
+		ShortDescription: `
+'ipfs key rm' removes the named keys from the keystore. The names they
+published to can no longer be updated, unless the keys were exported, and
+are no longer republished.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, true, "The names of the keys to remove"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		ks, err := nodeKeystore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		list := &KeyOutputList{}
+		for _, name := range req.Arguments() {
+			if err := validateKeyName(name); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+			sk, err := keyByName(n, name)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			out, err := keyOutput(name, sk)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			if err := ks.Delete(name); err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			if err := n.Republisher.Forget(out.Id); err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+			list.Keys = append(list.Keys, *out)
+		}
+		res.SetOutput(list)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			list, ok := res.Output().(*KeyOutputList)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			var buf bytes.Buffer
+			for _, k := range list.Keys {
+				fmt.Fprintf(&buf, "removed key %s %s\n", k.Name, k.Id)
+			}
+			return &buf, nil
+		},
+	},
+	Type: KeyOutputList{},
+}
+
+var keyRenameCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Rename a key",
+		ShortDescription: `
+'ipfs key rename' gives the key <name> the name <new-name>. The IPNS name
+it publishes to stays the same.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "The name of the key to rename"),
+		cmds.StringArg("new-name", true, false, "The new name of the key"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		ks, err := nodeKeystore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		was, now := req.Arguments()[0], req.Arguments()[1]
+		for _, name := range []string{was, now} {
+			if err := validateKeyName(name); err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		sk, err := ks.Get(was)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if err := ks.Rename(was, now); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out, err := keyOutput(now, sk)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&KeyRenameOutput{Was: was, Now: now, Id: out.Id})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*KeyRenameOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			s := fmt.Sprintf("renamed key %s to %s (%s)\n", out.Was, out.Now, out.Id)
+			return strings.NewReader(s), nil
+		},
+	},
+	Type: KeyRenameOutput{},
+}
+
+var keyExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write a key to stdout",
+		ShortDescription: `
+'ipfs key export' writes the key <name> to stdout, unencrypted, in the
+format 'ipfs key import' reads. Anyone who gets hold of it can publish
+to its name: keep it safe.
+
+The identity key of the node, 'self', is not exported: it also proves who
+the node is to its peers, and is only kept in the config of the repo.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "The name of the key to export"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		name := req.Arguments()[0]
+		if name == selfKeyName {
+			res.SetError(fmt.Errorf("the identity key '%s' cannot be exported", selfKeyName), cmds.ErrClient)
+			return
+		}
+		sk, err := keyByName(n, name)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		b, err := ci.MarshalPrivateKey(sk)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(bytes.NewReader(b))
+	},
+}
+
+var keyImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Store a key written by export",
+		ShortDescription: `
+'ipfs key import' reads a key written by 'ipfs key export', stores it as
+<name>, and outputs the IPNS name it publishes to.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", true, false, "The name to store the key as"),
+		cmds.FileArg("key", true, false, "The file holding the key").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		ks, err := nodeKeystore(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		name := req.Arguments()[0]
+		if err := validateKeyName(name); err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		file, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		sk, err := ci.UnmarshalPrivateKey(data)
+		if err != nil {
+			res.SetError(fmt.Errorf("not a key written by 'ipfs key export': %s", err), cmds.ErrClient)
+			return
+		}
+		if err := ks.Put(name, sk); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out, err := keyOutput(name, sk)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: keyOutputMarshaler,
+	},
+	Type: KeyOutput{},
+}
+
+func nodeKeystore(req cmds.Request) (keystore.Keystore, error) {
+	n, err := req.Context().GetNode()
+	if err != nil {
+		return nil, err
+	}
+	ks := n.Repo.Keystore()
+	if ks == nil {
+		return nil, errNoKeystore
+	}
+	return ks, nil
+}
+
+// validateKeyName checks that name can name a key of the keystore.
+func validateKeyName(name string) error {
+	if name == selfKeyName {
+		return fmt.Errorf("the key name '%s' is reserved for the identity key", selfKeyName)
+	}
+	return keystore.ValidateName(name)
+}
+
+// keyByName returns the key named name: the identity key for 'self', and
+// a key of the keystore otherwise.
+func keyByName(n *core.IpfsNode, name string) (ci.PrivKey, error) {
+	if name == selfKeyName {
+		if n.PrivateKey == nil {
+			if err := n.LoadPrivateKey(); err != nil {
+				return nil, err
+			}
+		}
+		return n.PrivateKey, nil
+	}
+
+	ks := n.Repo.Keystore()
+	if ks == nil {
+		return nil, errNoKeystore
+	}
+	sk, err := ks.Get(name)
+	if err == keystore.ErrNoSuchKey {
+		return nil, fmt.Errorf("no key named %s", name)
+	}
+	return sk, err
+}
+
+func keyOutput(name string, sk ci.PrivKey) (*KeyOutput, error) {
+	id, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyOutput{Name: name, Id: id.Pretty()}, nil
+}
+
+func keyOutputMarshaler(res cmds.Response) (io.Reader, error) {
+	out, ok := res.Output().(*KeyOutput)
+	if !ok {
+		return nil, u.ErrCast()
+	}
+	return strings.NewReader(out.Id + "\n"), nil
+}
@@ -14,6 +14,7 @@ import (
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	unixfspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -76,11 +77,16 @@ it contains, with the following format:
 
 		output := make([]LsObject, len(req.Arguments()))
 		for i, dagnode := range dagnodes {
+			links, err := listLinks(req.Context().Context, node.DAG, dagnode)
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
 			output[i] = LsObject{
 				Hash:  paths[i],
-				Links: make([]LsLink, len(dagnode.Links)),
+				Links: make([]LsLink, len(links)),
 			}
-			for j, link := range dagnode.Links {
+			for j, link := range links {
 				ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
 				defer cancel()
 				link.Node, err = link.GetNode(ctx, node.DAG)
@@ -119,7 +125,7 @@ it contains, with the following format:
 					fmt.Fprintln(w, "Hash\tSize\tName\t")
 				}
 				for _, link := range object.Links {
-					if link.Type == unixfspb.Data_Directory {
+					if link.Type == unixfspb.Data_Directory || link.Type == unixfspb.Data_HAMTShard {
 						link.Name += "/"
 					}
 					fmt.Fprintf(w, "%s\t%v\t%s\t\n", link.Hash, link.Size, link.Name)
@@ -135,3 +141,16 @@ it contains, with the following format:
 	},
 	Type: LsOutput{},
 }
+
+// listLinks returns the links of dagnode, or the entries of the directory
+// if it is sharded.
+func listLinks(ctx context.Context, ds merkledag.DAGService, dagnode *merkledag.Node) ([]*merkledag.Link, error) {
+	if d, err := unixfs.FromBytes(dagnode.Data); err != nil || d.GetType() != unixfs.THAMTShard {
+		return dagnode.Links, nil
+	}
+	dir, err := uio.NewDirectoryFromNode(ds, dagnode)
+	if err != nil {
+		return nil, err
+	}
+	return dir.Links(ctx)
+}
@@ -0,0 +1,115 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	repub "github.com/ipfs/go-ipfs/namesys/republisher"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+type NameStatusOutput struct {
+	Republishing    bool   // whether the republisher runs: the daemon is online
+	RepublishPeriod string // how often the names are republished
+	RecordLifetime  string // how long the records republished stay valid
+	Names           []*repub.Entry
+}
+
+var nameStatusCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show the names this node published",
+		ShortDescription: `
+IPNS records expire, so the daemon publishes again every name the node
+published, every Ipns.RepublishPeriod (12h by default), with records
+valid for Ipns.RecordLifetime (24h by default).
+
+'ipfs name status' lists these names, with the path published at each,
+when it was last published, and when its record expires. Names whose
+last republish failed are listed with the error.
+
+With --forget, the names given are no longer republished, and are
+dropped from the list.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("name", false, true, "The names to forget, with --forget"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("forget", "Stop republishing the names given"),
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		forget, _, _ := req.Option("forget").Bool()
+		if forget {
+			if len(req.Arguments()) == 0 {
+				res.SetError(errors.New("no names to forget given"), cmds.ErrClient)
+				return
+			}
+			for _, name := range req.Arguments() {
+				if err := n.Republisher.Forget(name); err != nil {
+					res.SetError(err, cmds.ErrNormal)
+					return
+				}
+			}
+		} else if len(req.Arguments()) > 0 {
+			res.SetError(errors.New("names are only given with --forget"), cmds.ErrClient)
+			return
+		}
+
+		entries, err := n.Republisher.Entries()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&NameStatusOutput{
+			Republishing:    n.OnlineMode(),
+			RepublishPeriod: n.Republisher.Interval.String(),
+			RecordLifetime:  n.Republisher.RecordLifetime.String(),
+			Names:           entries,
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*NameStatusOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			var buf bytes.Buffer
+			if out.Republishing {
+				fmt.Fprintf(&buf, "Republishing every %s, with records valid for %s\n", out.RepublishPeriod, out.RecordLifetime)
+			} else {
+				fmt.Fprintln(&buf, "Not republishing: the daemon is not running")
+			}
+			for _, e := range out.Names {
+				fmt.Fprintf(&buf, "%s %s published %s, expires %s\n", e.Name, e.Value,
+					e.Published.Format(time.RFC3339), e.EOL.Format(time.RFC3339))
+				if e.Error != "" {
+					fmt.Fprintf(&buf, "  last republish failed: %s\n", e.Error)
+				}
+			}
+			return &buf, nil
+		},
+	},
+	Type: NameStatusOutput{},
+}
@@ -11,11 +11,16 @@ import (
 	"text/tabwriter"
 
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	core "github.com/ipfs/go-ipfs/core"
 	dag "github.com/ipfs/go-ipfs/merkledag"
+	dagutils "github.com/ipfs/go-ipfs/merkledag/utils"
 	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+	u "github.com/ipfs/go-ipfs/util"
 )
 
 // ErrObjectTooLarge is returned when too much data was read from stdin. current limit 512k
@@ -26,6 +31,8 @@ const inputLimit = 512 * 1024
 type Node struct {
 	Links []Link
 	Data  string
+	// Raw is set for raw blocks, which hold nothing but their data.
+	Raw bool `json:",omitempty"`
 }
 
 type Link struct {
@@ -45,11 +52,13 @@ var ObjectCmd = &cmds.Command{
 'ipfs object' is a plumbing command used to manipulate DAG objects
 directly.`,
 		Synopsis: `
-ipfs object get <key>   - Get the DAG node named by <key>
-ipfs object put <data>  - Stores input, outputs its key
-ipfs object data <key>  - Outputs raw bytes in an object
-ipfs object links <key> - Outputs links pointed to by object
-ipfs object stat <key>  - Outputs statistics of object
+ipfs object get <key>          - Get the DAG node named by <key>
+ipfs object put <data>         - Stores input, outputs its key
+ipfs object data <key>         - Outputs raw bytes in an object
+ipfs object links <key>        - Outputs links pointed to by object
+ipfs object stat <key>         - Outputs statistics of object
+ipfs object new <template>     - Create new ipfs objects
+ipfs object patch <cmd> <args> - Create new objects from old ones
 `,
 	},
 
@@ -59,6 +68,8 @@ ipfs object stat <key>  - Outputs statistics of object
 		"get":   objectGetCmd,
 		"put":   objectPutCmd,
 		"stat":  objectStatCmd,
+		"new":   objectNewCmd,
+		"patch": objectPatchCmd,
 	},
 }
 
@@ -91,7 +102,7 @@ output is the raw data of the object.
 		}
 
 		fpath := path.Path(req.Arguments()[0])
-		node, err := core.Resolve(req.Context().Context, n, fpath)
+		node, err := resolveObject(req.Context().Context, n, fpath)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -121,7 +132,7 @@ multihash.
 		}
 
 		fpath := path.Path(req.Arguments()[0])
-		node, err := core.Resolve(req.Context().Context, n, fpath)
+		node, err := resolveObject(req.Context().Context, n, fpath)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -181,7 +192,7 @@ This command outputs data in the following encodings:
 
 		fpath := path.Path(req.Arguments()[0])
 
-		object, err := core.Resolve(req.Context().Context, n, fpath)
+		object, err := resolveObject(req.Context().Context, n, fpath)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -190,6 +201,7 @@ This command outputs data in the following encodings:
 		node := &Node{
 			Links: make([]Link, len(object.Links)),
 			Data:  string(object.Data),
+			Raw:   object.IsRaw(),
 		}
 
 		for i, link := range object.Links {
@@ -206,6 +218,9 @@ This command outputs data in the following encodings:
 	Marshalers: cmds.MarshalerMap{
 		cmds.EncodingType("protobuf"): func(res cmds.Response) (io.Reader, error) {
 			node := res.Output().(*Node)
+			if node.Raw {
+				return strings.NewReader(node.Data), nil
+			}
 			object, err := deserializeNode(node)
 			if err != nil {
 				return nil, err
@@ -247,7 +262,7 @@ var objectStatCmd = &cmds.Command{
 
 		fpath := path.Path(req.Arguments()[0])
 
-		object, err := core.Resolve(req.Context().Context, n, fpath)
+		object, err := resolveObject(req.Context().Context, n, fpath)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -348,6 +363,328 @@ Data should be in the format specified by the --inputenc flag.
 	Type: Object{},
 }
 
+var objectNewCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Creates a new object from an ipfs template",
+		ShortDescription: `
+'ipfs object new' is a plumbing command for creating new DAG nodes.
+By default it creates and returns a new empty node, but you may pass an
+optional template argument to create a preformatted node.
+
+Available templates:
+	* unixfs-dir
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("template", false, false, "Template to use"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		node := new(dag.Node)
+		if len(req.Arguments()) == 1 {
+			node, err = nodeFromTemplate(req.Arguments()[0])
+			if err != nil {
+				res.SetError(err, cmds.ErrClient)
+				return
+			}
+		}
+
+		if _, err := n.DAG.Add(node); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		output, err := getOutput(node)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(output)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: objectHashMarshaler,
+	},
+	Type: Object{},
+}
+
+// ErrUnknownTemplate is returned for templates 'ipfs object new' does not
+// know
+var ErrUnknownTemplate = errors.New("unknown object template")
+
+func nodeFromTemplate(template string) (*dag.Node, error) {
+	switch template {
+	case "unixfs-dir":
+		return &dag.Node{Data: ft.FolderPBData()}, nil
+	default:
+		return nil, ErrUnknownTemplate
+	}
+}
+
+var objectPatchCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Create a new object from an existing one",
+		ShortDescription: `
+'ipfs object patch' is a plumbing command for editing DAG nodes. As
+nodes cannot change, each edit creates a new node, along with new
+copies of the nodes on the path to it, and outputs the hash of the new
+root. Links are edited by name; the entries of sharded directories are
+edited in the trie of the directory. The data of a sharded directory
+holds its trie, and cannot be edited.
+`,
+		Synopsis: `
+ipfs object patch add-link <root> <path> <ref> - Link <ref> at <path>
+ipfs object patch rm-link <root> <path>        - Remove the link at <path>
+ipfs object patch set-data <root> <data>       - Set the data of <root>
+ipfs object patch append-data <root> <data>    - Append to the data of <root>
+`,
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add-link":    objectPatchAddLinkCmd,
+		"rm-link":     objectPatchRmLinkCmd,
+		"set-data":    objectPatchSetDataCmd,
+		"append-data": objectPatchAppendDataCmd,
+	},
+}
+
+var objectPatchAddLinkCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Add a link to a given object",
+		ShortDescription: `
+'ipfs object patch add-link' links <ref> at <path> below <root>, where
+<path> is made of link names separated by slashes, and outputs the hash
+of the new root. A link already at <path> is replaced.
+
+With --create, the nodes missing on the way to <path> are created as
+empty unixfs directories. For example, to build a directory tree:
+
+    EMPTY=$(ipfs object new unixfs-dir)
+    ipfs object patch add-link --create $EMPTY a/b/file $FILE
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "The object to add a link to"),
+		cmds.StringArg("path", true, false, "The link names leading to the new link"),
+		cmds.StringArg("ref", true, false, "The object to link to"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("create", "p", "Create the missing nodes on the way"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := patchRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		names, err := linkPath(req.Arguments()[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		child, err := resolveObject(req.Context().Context, n, path.Path(req.Arguments()[2]))
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		var create func() *dag.Node
+		if c, _, _ := req.Option("create").Bool(); c {
+			create = func() *dag.Node {
+				return &dag.Node{Data: ft.FolderPBData()}
+			}
+		}
+
+		nd, err := dagutils.InsertNodeAtPath(req.Context().Context, n.DAG, root, names, child, create)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		setPatchOutput(res, nd)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: objectHashMarshaler,
+	},
+	Type: Object{},
+}
+
+var objectPatchRmLinkCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Remove a link from an object",
+		ShortDescription: `
+'ipfs object patch rm-link' removes the link at <path> below <root>,
+where <path> is made of link names separated by slashes, and outputs the
+hash of the new root.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "The object to remove a link from"),
+		cmds.StringArg("path", true, false, "The link names leading to the link to remove"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, root, err := patchRoot(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		names, err := linkPath(req.Arguments()[1])
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+
+		nd, err := dagutils.RemoveLinkAtPath(req.Context().Context, n.DAG, root, names)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		setPatchOutput(res, nd)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: objectHashMarshaler,
+	},
+	Type: Object{},
+}
+
+var objectPatchSetDataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Set the data field of an object",
+		ShortDescription: `
+'ipfs object patch set-data' replaces the data of <root> with the bytes
+read from <data>, and outputs the hash of the new object.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "The object to set the data of"),
+		cmds.FileArg("data", true, false, "The data to set").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		runPatchData(req, res, func(old, data []byte) []byte {
+			return data
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: objectHashMarshaler,
+	},
+	Type: Object{},
+}
+
+var objectPatchAppendDataCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Append data to the data field of an object",
+		ShortDescription: `
+'ipfs object patch append-data' appends the bytes read from <data> to
+the data of <root>, and outputs the hash of the new object.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("root", true, false, "The object to append data to"),
+		cmds.FileArg("data", true, false, "The data to append").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		runPatchData(req, res, func(old, data []byte) []byte {
+			return append(old, data...)
+		})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: objectHashMarshaler,
+	},
+	Type: Object{},
+}
+
+// patchRoot resolves the root argument of a patch command.
+func patchRoot(req cmds.Request) (*core.IpfsNode, *dag.Node, error) {
+	n, err := req.Context().GetNode()
+	if err != nil {
+		return nil, nil, err
+	}
+	root, err := core.Resolve(req.Context().Context, n, path.Path(req.Arguments()[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	return n, root, nil
+}
+
+// linkPath splits a path of link names, as given to the patch commands.
+func linkPath(p string) ([]string, error) {
+	names := strings.Split(strings.Trim(p, "/"), "/")
+	for _, name := range names {
+		if name == "" || name == "." || name == ".." {
+			return nil, fmt.Errorf("invalid link path: %q", p)
+		}
+	}
+	return names, nil
+}
+
+// runPatchData stores a copy of the root argument with its data replaced
+// by what patch makes of the old data and the data argument.
+func runPatchData(req cmds.Request, res cmds.Response, patch func(old, data []byte) []byte) {
+	n, root, err := patchRoot(req)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+
+	if hamt.IsShard(root) {
+		res.SetError(errors.New("the data of a sharded directory cannot be edited"), cmds.ErrNormal)
+		return
+	}
+
+	var data []byte
+	input, err := req.Files().NextFile()
+	switch err {
+	case io.EOF:
+		// no data given, as with an empty file
+	case nil:
+		data, err = ioutil.ReadAll(io.LimitReader(input, inputLimit+10))
+		input.Close()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+	default:
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	if len(data) >= inputLimit {
+		res.SetError(ErrObjectTooLarge, cmds.ErrNormal)
+		return
+	}
+
+	nd := root.Copy()
+	nd.Data = patch(nd.Data, data)
+	if _, err := n.DAG.Add(nd); err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	setPatchOutput(res, nd)
+}
+
+func setPatchOutput(res cmds.Response, nd *dag.Node) {
+	output, err := getOutput(nd)
+	if err != nil {
+		res.SetError(err, cmds.ErrNormal)
+		return
+	}
+	res.SetOutput(output)
+}
+
+func objectHashMarshaler(res cmds.Response) (io.Reader, error) {
+	object := res.Output().(*Object)
+	return strings.NewReader(object.Hash + "\n"), nil
+}
+
 // ErrEmptyNode is returned when the input to 'ipfs object put' contains no data
 var ErrEmptyNode = errors.New("no data or links in this node")
 
@@ -423,6 +760,31 @@ func getObjectEnc(o interface{}) objectEncoding {
 	return objectEncoding(v)
 }
 
+// resolveObject resolves fpath to a node. A path that is only a key may
+// name a raw block, such as a leaf added with --raw-leaves, which is read
+// as a raw node rather than decoded.
+func resolveObject(ctx context.Context, n *core.IpfsNode, fpath path.Path) (*dag.Node, error) {
+	segs := fpath.Segments()
+	if len(segs) == 2 && segs[0] == "ipfs" {
+		segs = segs[1:]
+	}
+	if len(segs) != 1 {
+		return core.Resolve(ctx, n, fpath)
+	}
+	h, err := mh.FromB58String(segs[0])
+	if err != nil {
+		return core.Resolve(ctx, n, fpath)
+	}
+
+	b, err := n.Blocks.GetBlock(ctx, u.Key(h))
+	if err != nil {
+		return nil, err
+	}
+	_, err = dag.Decoded(b.Data)
+	lnk := &dag.Link{Hash: h, Raw: err != nil}
+	return lnk.GetNode(ctx, n.DAG)
+}
+
 func getOutput(dagnode *dag.Node) (*Object, error) {
 	key, err := dagnode.Key()
 	if err != nil {
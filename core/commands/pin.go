@@ -2,11 +2,15 @@ package commands
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	pin "github.com/ipfs/go-ipfs/pin"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -16,9 +20,11 @@ var PinCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"add": addPinCmd,
-		"rm":  rmPinCmd,
-		"ls":  listPinCmd,
+		"add":    addPinCmd,
+		"rm":     rmPinCmd,
+		"ls":     listPinCmd,
+		"update": updatePinCmd,
+		"verify": verifyPinCmd,
 	},
 }
 
@@ -32,6 +38,15 @@ var addPinCmd = &cmds.Command{
 		ShortDescription: `
 Retrieves the object named by <ipfs-path> and stores it locally
 on disk.
+`,
+		LongDescription: `
+Retrieves the object named by <ipfs-path> and stores it locally
+on disk.
+
+Pins can be labelled to record why they exist. --name, --owner and
+--tags (a comma separated list) are stored with the pin, together with
+its creation time, and can be used to filter 'ipfs pin ls' and to
+remove pins with 'ipfs pin rm --name'.
 `,
 	},
 
@@ -40,6 +55,9 @@ on disk.
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption("recursive", "r", "Recursively pin the object linked to by the specified object(s)"),
+		cmds.StringOption("name", "Name to label the pin with"),
+		cmds.StringOption("owner", "Owner to record for the pin"),
+		cmds.StringOption("tags", "Comma separated tags to attach to the pin"),
 	},
 	Type: PinOutput{},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -59,7 +77,13 @@ on disk.
 			recursive = false
 		}
 
-		added, err := corerepo.Pin(n, req.Arguments(), recursive)
+		meta, err := pinMetadataOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		added, err := corerepo.PinWithMetadata(n, req.Arguments(), recursive, meta)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -97,14 +121,17 @@ var rmPinCmd = &cmds.Command{
 		ShortDescription: `
 Removes the pin from the given object allowing it to be garbage
 collected if needed.
+
+With --name, every pin labelled with that name is removed instead.
 `,
 	},
 
 	Arguments: []cmds.Argument{
-		cmds.StringArg("ipfs-path", true, true, "Path to object(s) to be unpinned").EnableStdin(),
+		cmds.StringArg("ipfs-path", false, true, "Path to object(s) to be unpinned").EnableStdin(),
 	},
 	Options: []cmds.Option{
 		cmds.BoolOption("recursive", "r", "Recursively unpin the object linked to by the specified object(s)"),
+		cmds.StringOption("name", "Remove all pins labelled with this name"),
 	},
 	Type: PinOutput{},
 	Run: func(req cmds.Request, res cmds.Response) {
@@ -124,7 +151,27 @@ collected if needed.
 			recursive = false // default
 		}
 
-		removed, err := corerepo.Unpin(n, req.Arguments(), recursive)
+		name, nameFound, err := req.Option("name").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		var removed []u.Key
+		switch {
+		case nameFound && len(req.Arguments()) > 0:
+			err = errors.New("cannot combine --name with paths to unpin")
+			res.SetError(err, cmds.ErrClient)
+			return
+		case nameFound:
+			removed, err = corerepo.UnpinByName(n, name)
+		case len(req.Arguments()) > 0:
+			removed, err = corerepo.Unpin(n, req.Arguments(), recursive)
+		default:
+			err = errors.New("no paths to unpin given")
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -148,6 +195,167 @@ collected if needed.
 	},
 }
 
+var updatePinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Move a recursive pin from an old root to a new one",
+		ShortDescription: `
+Updates the recursive pin of <from-path> to <to-path> in one step. Only
+the parts of the two objects that differ are walked, so subtrees shared
+between the old and the new version are never fetched or recounted.
+The labels of the old pin move to the new one.
+
+Use --unpin=false to keep the old root pinned as well.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("from-path", true, false, "Path to the old, recursively pinned object"),
+		cmds.StringArg("to-path", true, false, "Path to the new object to be pinned"),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("unpin", "Remove the old pin (default: true)").Default(true),
+	},
+	Type: PinOutput{},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		unpin, found, err := req.Option("unpin").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if !found {
+			unpin = true // default
+		}
+
+		args := req.Arguments()
+		from, to, err := corerepo.Update(n, args[0], args[1], unpin)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&PinOutput{[]u.Key{from, to}})
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			updated, ok := res.Output().(*PinOutput)
+			if !ok || len(updated.Pinned) != 2 {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "updated %s to %s\n", updated.Pinned[0], updated.Pinned[1])
+			return buf, nil
+		},
+	},
+}
+
+var verifyPinCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Verify that pinned objects are complete and uncorrupted",
+		ShortDescription: `
+Walks every recursive pin, and checks every direct pin, using only the
+local blockstore. Each block is read back and rehashed; blocks that are
+missing or whose contents no longer match their hash are reported per
+pin root.
+
+Use --refetch to replace bad blocks with fresh copies from the network.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("refetch", "Fetch missing or corrupt blocks again from the network"),
+		cmds.BoolOption("verbose", "v", "Also report pins that are intact"),
+		cmds.BoolOption("quiet", "q", "Write just hashes of broken pins"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		refetch, _, err := req.Option("refetch").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		statusChan, err := corerepo.VerifyPins(n, req.Context().Context, refetch)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for st := range statusChan {
+				outChan <- st
+			}
+		}()
+	},
+	Type: corerepo.PinStatus{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			verbose, _, err := res.Request().Option("verbose").Bool()
+			if err != nil {
+				return nil, err
+			}
+			quiet, _, err := res.Request().Option("quiet").Bool()
+			if err != nil {
+				return nil, err
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				st, ok := v.(*corerepo.PinStatus)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+
+				buf := new(bytes.Buffer)
+				switch {
+				case st.Ok:
+					if verbose && !quiet {
+						fmt.Fprintf(buf, "%s ok\n", st.Key)
+					}
+				case quiet:
+					fmt.Fprintf(buf, "%s\n", st.Key)
+				default:
+					fmt.Fprintf(buf, "%s broken: %d missing, %d corrupt\n", st.Key, len(st.Missing), len(st.Corrupt))
+					for _, k := range st.Missing {
+						fmt.Fprintf(buf, "  missing %s\n", k)
+					}
+					for _, k := range st.Corrupt {
+						fmt.Fprintf(buf, "  corrupt %s\n", k)
+					}
+					for _, k := range st.Refetched {
+						fmt.Fprintf(buf, "  refetched %s\n", k)
+					}
+				}
+				return buf, nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+			}, nil
+		},
+	},
+}
+
 var listPinCmd = &cmds.Command{
 	Helptext: cmds.HelpText{
 		Tagline: "List objects pinned to local storage",
@@ -166,7 +374,11 @@ Use --type=<type> to specify the type of pinned keys to list. Valid values are:
     * "all"
 
 To see the ref count on indirect pins, pass the -count option flag.
-Defaults to "direct".
+Defaults to "direct", or to "all" when filtering by label.
+
+Pins labelled with 'ipfs pin add --name/--owner/--tags' are shown with
+their labels. Use --name, --owner or --tag to list only the pins carrying
+that label; indirect pins never match a label.
 `,
 	},
 
@@ -174,6 +386,9 @@ Defaults to "direct".
 		cmds.StringOption("type", "t", "The type of pinned keys to list. Can be \"direct\", \"indirect\", \"recursive\", or \"all\". Defaults to \"direct\""),
 		cmds.BoolOption("count", "n", "Show refcount when listing indirect pins"),
 		cmds.BoolOption("quiet", "q", "Write just hashes of objects"),
+		cmds.StringOption("name", "Only list pins with this name"),
+		cmds.StringOption("owner", "Only list pins with this owner"),
+		cmds.StringOption("tag", "Only list pins carrying this tag"),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.Context().GetNode()
@@ -182,6 +397,12 @@ Defaults to "direct".
 			return
 		}
 
+		filter, err := pinFilterOption(req)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
 		typeStr, found, err := req.Option("type").String()
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
@@ -189,6 +410,10 @@ Defaults to "direct".
 		}
 		if !found {
 			typeStr = "direct"
+			if filter != nil {
+				// labelled pins may be direct or recursive
+				typeStr = "all"
+			}
 		}
 
 		switch typeStr {
@@ -198,29 +423,33 @@ Defaults to "direct".
 			res.SetError(err, cmds.ErrClient)
 		}
 
+		annotations := n.Pinning.Annotations()
 		keys := make(map[string]RefKeyObject)
+		addKey := func(k u.Key, typ string, count int) {
+			meta := annotations[k]
+			if filter != nil && !filter.matches(meta) {
+				return
+			}
+			keys[k.B58String()] = RefKeyObject{
+				Type:     typ,
+				Count:    count,
+				Metadata: meta,
+			}
+		}
+
 		if typeStr == "direct" || typeStr == "all" {
 			for _, k := range n.Pinning.DirectKeys() {
-				keys[k.B58String()] = RefKeyObject{
-					Type:  "direct",
-					Count: 1,
-				}
+				addKey(k, "direct", 1)
 			}
 		}
 		if typeStr == "indirect" || typeStr == "all" {
 			for k, v := range n.Pinning.IndirectKeys() {
-				keys[k.B58String()] = RefKeyObject{
-					Type:  "indirect",
-					Count: v,
-				}
+				addKey(k, "indirect", v)
 			}
 		}
 		if typeStr == "recursive" || typeStr == "all" {
 			for _, k := range n.Pinning.RecursiveKeys() {
-				keys[k.B58String()] = RefKeyObject{
-					Type:  "recursive",
-					Count: 1,
-				}
+				addKey(k, "recursive", 1)
 			}
 		}
 
@@ -261,6 +490,8 @@ Defaults to "direct".
 				for k, v := range keys.Keys {
 					if quiet {
 						fmt.Fprintf(out, "%s\n", k)
+					} else if v.Metadata != nil {
+						fmt.Fprintf(out, "%s %s%s\n", k, v.Type, formatPinMetadata(v.Metadata))
 					} else {
 						fmt.Fprintf(out, "%s %s\n", k, v.Type)
 					}
@@ -272,10 +503,104 @@ Defaults to "direct".
 }
 
 type RefKeyObject struct {
-	Type  string
-	Count int
+	Type     string
+	Count    int
+	Metadata *pin.Metadata `json:",omitempty"`
 }
 
 type RefKeyList struct {
 	Keys map[string]RefKeyObject
 }
+
+// pinMetadataOption builds the metadata given to 'ipfs pin add', or nil if
+// no label was given.
+func pinMetadataOption(req cmds.Request) (*pin.Metadata, error) {
+	meta := &pin.Metadata{}
+	var found bool
+
+	for opt, dst := range map[string]*string{"name": &meta.Name, "owner": &meta.Owner} {
+		v, ok, err := req.Option(opt).String()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			*dst = v
+			found = true
+		}
+	}
+
+	tags, ok, err := req.Option("tags").String()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		for _, t := range strings.Split(tags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				meta.Tags = append(meta.Tags, t)
+			}
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	meta.Created = time.Now()
+	return meta, nil
+}
+
+type pinFilter struct {
+	name, owner, tag string
+}
+
+func (f *pinFilter) matches(m *pin.Metadata) bool {
+	switch {
+	case m == nil:
+		return false
+	case f.name != "" && m.Name != f.name:
+		return false
+	case f.owner != "" && m.Owner != f.owner:
+		return false
+	case f.tag != "" && !m.HasTag(f.tag):
+		return false
+	}
+	return true
+}
+
+// pinFilterOption returns the label filter given to 'ipfs pin ls', or nil
+// if no label was given.
+func pinFilterOption(req cmds.Request) (*pinFilter, error) {
+	f := &pinFilter{}
+	var found bool
+	for opt, dst := range map[string]*string{"name": &f.name, "owner": &f.owner, "tag": &f.tag} {
+		v, ok, err := req.Option(opt).String()
+		if err != nil {
+			return nil, err
+		}
+		if ok && v != "" {
+			*dst = v
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return f, nil
+}
+
+func formatPinMetadata(m *pin.Metadata) string {
+	var out string
+	if m.Name != "" {
+		out += fmt.Sprintf(" name=%q", m.Name)
+	}
+	if m.Owner != "" {
+		out += fmt.Sprintf(" owner=%q", m.Owner)
+	}
+	if len(m.Tags) > 0 {
+		out += fmt.Sprintf(" tags=%q", strings.Join(m.Tags, ","))
+	}
+	if !m.Created.IsZero() {
+		out += fmt.Sprintf(" created=%s", m.Created.UTC().Format(time.RFC3339))
+	}
+	return out
+}
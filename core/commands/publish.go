@@ -23,12 +23,14 @@ var publishCmd = &cmds.Command{
 		ShortDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In publish, the
-default value of <name> is your own identity public key.
+default value of <name> is your own identity public key, or the public
+key of the key named with --key.
 `,
 		LongDescription: `
 IPNS is a PKI namespace, where names are the hashes of public keys, and
 the private key enables publishing new (signed) values. In publish, the
-default value of <name> is your own identity public key.
+default value of <name> is your own identity public key, or the public
+key of the key named with --key.
 
 Examples:
 
@@ -37,6 +39,11 @@ Publish an <ipfs-path> to your identity name:
   > ipfs name publish /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
   published name QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n to QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
 
+Publish an <ipfs-path> to the name of a key created with 'ipfs key gen':
+
+  > ipfs name publish --key=mysite /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  published name QmSiTko9JZyabH56y2fussEt1A5oDqsFXB3CkvAqraFryz to QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+
 Publish an <ipfs-path> to another public key (not implemented):
 
   > ipfs name publish QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
@@ -48,6 +55,9 @@ Publish an <ipfs-path> to another public key (not implemented):
 		cmds.StringArg("name", false, false, "The IPNS name to publish to. Defaults to your node's peerID"),
 		cmds.StringArg("ipfs-path", true, false, "IPFS path of the obejct to be published at <name>").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.StringOption("key", "k", "The name of the key to publish with, from 'ipfs key list' (default: self)"),
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		log.Debug("Begin Publish")
 		n, err := req.Context().GetNode()
@@ -90,9 +100,18 @@ Publish an <ipfs-path> to another public key (not implemented):
 			return
 		}
 
-		// TODO n.Keychain.Get(name).PrivKey
+		kname, _, _ := req.Option("key").String()
+		if kname == "" {
+			kname = selfKeyName
+		}
+		k, err := keyByName(n, kname)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
 		// TODO(cryptix): is req.Context().Context a child of n.Context()?
-		output, err := publish(req.Context().Context, n, n.PrivateKey, p)
+		output, err := publish(req.Context().Context, n, k, p)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -1,12 +1,19 @@
 package commands
 
 import (
+	"archive/tar"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	cmds "github.com/ipfs/go-ipfs/commands"
 	corerepo "github.com/ipfs/go-ipfs/core/corerepo"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -19,7 +26,12 @@ var RepoCmd = &cmds.Command{
 	},
 
 	Subcommands: map[string]*cmds.Command{
-		"gc": repoGcCmd,
+		"gc":      repoGcCmd,
+		"stat":    repoStatCmd,
+		"verify":  repoVerifyCmd,
+		"migrate": RepoMigrateCmd,
+		"export":  repoExportCmd,
+		"import":  RepoImportCmd,
 	},
 }
 
@@ -30,11 +42,20 @@ var repoGcCmd = &cmds.Command{
 'ipfs repo gc' is a plumbing command that will sweep the local
 set of stored objects and remove ones that are not pinned in
 order to reclaim hard disk space.
+
+The sweep first marks every block reachable from a pin, then removes
+everything else. While it runs, 'ipfs add' and 'ipfs pin add' wait for
+it to finish so freshly imported blocks are not collected.
+
+Use --dry-run to list the blocks that would be removed without deleting
+anything.
 `,
 	},
 
 	Options: []cmds.Option{
 		cmds.BoolOption("quiet", "q", "Write minimal output"),
+		cmds.BoolOption("dry-run", "n", "List the blocks that would be removed, but don't remove them"),
+		cmds.BoolOption("progress", "p", "Show a running count of removed blocks"),
 	},
 	Run: func(req cmds.Request, res cmds.Response) {
 		n, err := req.Context().GetNode()
@@ -43,7 +64,13 @@ order to reclaim hard disk space.
 			return
 		}
 
-		gcOutChan, err := corerepo.GarbageCollectAsync(n, req.Context().Context)
+		dryRun, _, err := req.Option("dry-run").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		gcOutChan, err := corerepo.GarbageCollectAsync(n, req.Context().Context, dryRun)
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -59,6 +86,48 @@ order to reclaim hard disk space.
 			}
 		}()
 	},
+	PostRun: func(req cmds.Request, res cmds.Response) {
+		if res.Error() != nil {
+			return
+		}
+		progress, _, _ := req.Option("progress").Bool()
+		if !progress {
+			return
+		}
+		outChan, ok := res.Output().(<-chan interface{})
+		if !ok {
+			res.SetError(u.ErrCast(), cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(nil)
+
+		quiet, _, _ := req.Option("quiet").Bool()
+		dryRun, _, _ := req.Option("dry-run").Bool()
+
+		var count int
+		var status string
+		for out := range outChan {
+			obj, ok := out.(*corerepo.KeyRemoved)
+			if !ok {
+				res.SetError(u.ErrCast(), cmds.ErrNormal)
+				return
+			}
+			count++
+
+			// clear the status line before printing the key
+			fmt.Fprintf(res.Stderr(), "\r%s\r", strings.Repeat(" ", len(status)))
+			fmt.Fprint(res.Stdout(), gcOutputLine(obj, quiet, dryRun))
+
+			status = fmt.Sprintf("%d blocks removed", count)
+			if dryRun {
+				status = fmt.Sprintf("%d blocks would be removed", count)
+			}
+			fmt.Fprint(res.Stderr(), status)
+		}
+		if len(status) > 0 {
+			fmt.Fprintln(res.Stderr())
+		}
+	},
 	Type: corerepo.KeyRemoved{},
 	Marshalers: cmds.MarshalerMap{
 		cmds.Text: func(res cmds.Response) (io.Reader, error) {
@@ -72,19 +141,153 @@ order to reclaim hard disk space.
 				return nil, err
 			}
 
+			dryRun, _, err := res.Request().Option("dry-run").Bool()
+			if err != nil {
+				return nil, err
+			}
+
 			marshal := func(v interface{}) (io.Reader, error) {
 				obj, ok := v.(*corerepo.KeyRemoved)
 				if !ok {
 					return nil, u.ErrCast()
 				}
 
-				var buf *bytes.Buffer
-				if quiet {
-					buf = bytes.NewBufferString(string(obj.Key) + "\n")
-				} else {
-					buf = bytes.NewBufferString(fmt.Sprintf("removed %s\n", obj.Key))
+				return bytes.NewBufferString(gcOutputLine(obj, quiet, dryRun)), nil
+			}
+
+			return &cmds.ChannelMarshaler{
+				Channel:   outChan,
+				Marshaler: marshal,
+			}, nil
+		},
+	},
+}
+
+func gcOutputLine(obj *corerepo.KeyRemoved, quiet, dryRun bool) string {
+	switch {
+	case quiet:
+		return string(obj.Key) + "\n"
+	case dryRun:
+		return fmt.Sprintf("would remove %s\n", obj.Key)
+	default:
+		return fmt.Sprintf("removed %s\n", obj.Key)
+	}
+}
+
+var repoStatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Show statistics about the repo",
+		ShortDescription: `
+'ipfs repo stat' shows the number of blocks in the repo, how many bytes
+they take up, how that compares to the storage quota set in
+Datastore.StorageMax, the size on disk of the leveldb datastores and of
+every datastore mount, and the path and version of the repo.
+`,
+	},
+
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		stat, err := corerepo.RepoStat(n, req.Context().Context, req.Context().ConfigRoot)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(stat)
+	},
+	Type: corerepo.Stat{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			stat, ok := res.Output().(*corerepo.Stat)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			buf := new(bytes.Buffer)
+			fmt.Fprintf(buf, "NumObjects:  %d\n", stat.NumObjects)
+			fmt.Fprintf(buf, "RepoSize:    %s\n", humanize.Bytes(stat.RepoSize))
+			if stat.StorageMax == 0 {
+				fmt.Fprintf(buf, "StorageMax:  unlimited\n")
+			} else {
+				fmt.Fprintf(buf, "StorageMax:  %s (%.1f%% used)\n", humanize.Bytes(stat.StorageMax),
+					float64(stat.RepoSize)*100/float64(stat.StorageMax))
+			}
+			fmt.Fprintf(buf, "LevelDBSize: %s\n", humanize.Bytes(stat.LevelDBSize))
+			for _, m := range stat.Mounts {
+				fmt.Fprintf(buf, "Mount %s:  %s (%s at %s)\n", m.Prefix, humanize.Bytes(m.Size), m.Type, m.Path)
+			}
+			fmt.Fprintf(buf, "RepoPath:    %s\n", stat.RepoPath)
+			fmt.Fprintf(buf, "Version:     %s\n", stat.Version)
+			return buf, nil
+		},
+	},
+}
+
+var repoVerifyCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Check every block in the repo for corruption",
+		ShortDescription: `
+'ipfs repo verify' reads every block stored in the repo and checks that
+its data still hashes to its key, listing the blocks that don't.
+
+With --remove, corrupt blocks are deleted, so that they are fetched again
+from the network the next time they are needed.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("remove", "Delete corrupt blocks"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		remove, _, err := req.Option("remove").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		bad, err := corerepo.VerifyBlocks(n, req.Context().Context, remove)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		outChan := make(chan interface{})
+		res.SetOutput((<-chan interface{})(outChan))
+
+		go func() {
+			defer close(outChan)
+			for cb := range bad {
+				outChan <- cb
+			}
+		}()
+	},
+	Type: corerepo.CorruptBlock{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			outChan, ok := res.Output().(<-chan interface{})
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			marshal := func(v interface{}) (io.Reader, error) {
+				cb, ok := v.(*corerepo.CorruptBlock)
+				if !ok {
+					return nil, u.ErrCast()
+				}
+				if cb.Removed {
+					return bytes.NewBufferString(fmt.Sprintf("removed corrupt block %s\n", cb.Key)), nil
 				}
-				return buf, nil
+				return bytes.NewBufferString(fmt.Sprintf("corrupt block %s\n", cb.Key)), nil
 			}
 
 			return &cmds.ChannelMarshaler{
@@ -94,3 +297,204 @@ order to reclaim hard disk space.
 		},
 	},
 }
+
+type MigrateOutput struct {
+	From, To string
+}
+
+var RepoMigrateCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Upgrade the repo to the version this program uses",
+		ShortDescription: `
+'ipfs repo migrate' runs the migrations that bring the repo to the
+version expected by this version of ipfs. Use --to to move to another
+version, including back to an older one.
+
+The version file, the config and the directories of the datastore
+mounts are backed up before every step. If the step fails they are
+restored, and anything else the step added to the repo is removed.
+Remote datastore mounts are not backed up. The daemon must not be
+running.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.StringOption("to", "The repo version to migrate to (default: the current version)"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		to, found, err := req.Option("to").String()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if !found {
+			to = fsrepo.RepoVersion
+		}
+
+		root := req.Context().ConfigRoot
+		from, err := mfsr.RepoPath(root).Version()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		if err := fsrepo.Migrate(root, to); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(&MigrateOutput{From: from, To: to})
+	},
+	Type: MigrateOutput{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*MigrateOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			if out.From == out.To {
+				return bytes.NewBufferString(fmt.Sprintf("repo is already at version %s\n", out.To)), nil
+			}
+			return bytes.NewBufferString(fmt.Sprintf("migrated repo from version %s to %s\n", out.From, out.To)), nil
+		},
+	},
+}
+
+var repoExportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Write the repo config, pins and pinned blocks as a tar archive",
+		ShortDescription: `
+'ipfs repo export' writes a tar archive to stdout holding the config, the
+named keys of the keystore, the pin state and every pinned block of the
+repo. Blocks that are not pinned are left out. The archive can be
+restored with 'ipfs repo import'.
+
+With --redact-keys, the private key and any datastore credentials are
+removed from the archived config, and the named keys are left out: the
+names published with them can't be published from the restored repo
+until the keys are imported with 'ipfs key import'.
+`,
+	},
+
+	Options: []cmds.Option{
+		cmds.BoolOption("redact-keys", "Leave the private keys and datastore credentials out of the archive"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		redact, _, err := req.Option("redact-keys").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		piper, pipew := io.Pipe()
+		go func() {
+			err := corerepo.Export(n, req.Context().Context, pipew, redact)
+			pipew.CloseWithError(err)
+		}()
+		res.SetOutput(piper)
+	},
+}
+
+var RepoImportCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Restore a repo archive written by 'ipfs repo export'",
+		ShortDescription: `
+'ipfs repo import' stores the blocks of an archive written by 'ipfs repo
+export' and restores its pins. Every block is checked against its hash
+as it is read; the import stops at the first block that doesn't match.
+
+If there is no repo yet, one is created from the archived config. This
+needs an archive exported without --redact-keys. An existing repo keeps
+its own config unless --config is given; the local identity is kept if
+the archive has no private key. The archived named keys are added to the
+keystore; the import stops at a key named like another key of the repo.
+The daemon must not be running.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("archive", true, false, "The archive to import").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.BoolOption("config", "Replace the config of an existing repo with the archived one"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		replaceConfig, _, err := req.Option("config").Bool()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		file, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		defer file.Close()
+
+		tr := tar.NewReader(file)
+		cfg, err := corerepo.ReadArchiveConfig(tr)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		root := req.Context().ConfigRoot
+		if err := importConfig(root, cfg, replaceConfig); err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		stats, err := corerepo.ImportArchive(n, req.Context().Context, tr)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		res.SetOutput(stats)
+	},
+	Type: corerepo.ImportStats{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			stats, ok := res.Output().(*corerepo.ImportStats)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+			return bytes.NewBufferString(fmt.Sprintf("imported %d keys, %d blocks and %d pins\n", stats.Keys, stats.Blocks, stats.Pins)), nil
+		},
+	},
+}
+
+// importConfig creates the repo at root from the archived config cfg if
+// there is none, or replaces the config of the existing repo with cfg if
+// replace is set.
+func importConfig(root string, cfg *config.Config, replace bool) error {
+	if !fsrepo.IsInitialized(root) {
+		if cfg.Identity.PrivKey == "" {
+			return errors.New("the archive has no private key, it was exported with --redact-keys; run 'ipfs init' first")
+		}
+		return fsrepo.Init(root, cfg)
+	}
+	if !replace {
+		return nil
+	}
+
+	r, err := fsrepo.Open(root)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if cfg.Identity.PrivKey == "" {
+		cfg.Identity = r.Config().Identity
+	}
+	return r.SetConfig(cfg)
+}
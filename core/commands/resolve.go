@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	cmds "github.com/ipfs/go-ipfs/commands"
+	namesys "github.com/ipfs/go-ipfs/namesys"
 	path "github.com/ipfs/go-ipfs/path"
 	u "github.com/ipfs/go-ipfs/util"
 )
@@ -40,12 +41,27 @@ Resolve te value of another name:
   > ipfs name resolve QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n
   QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
 
+A name may be published with another name as its value, such as
+/ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n. Without --recursive,
+resolve stops there; with it, resolve follows the names until it reaches
+a path which is not a name:
+
+  > ipfs name resolve --recursive QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ
+  /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+
+Resolved names are cached for the TTL of their records; --nocache
+resolves them anew.
+
 `,
 	},
 
 	Arguments: []cmds.Argument{
 		cmds.StringArg("name", false, false, "The IPNS name to resolve. Defaults to your node's peerID.").EnableStdin(),
 	},
+	Options: []cmds.Option{
+		cmds.BoolOption("recursive", "r", "Resolve until the result is not an IPNS name"),
+		cmds.BoolOption("nocache", "n", "Do not use cached entries"),
+	},
 	Run: func(req cmds.Request, res cmds.Response) {
 
 		n, err := req.Context().GetNode()
@@ -75,7 +91,18 @@ Resolve te value of another name:
 			name = req.Arguments()[0]
 		}
 
-		output, err := n.Namesys.Resolve(n.Context(), name)
+		recursive, _, _ := req.Option("recursive").Bool()
+		nocache, _, _ := req.Option("nocache").Bool()
+		depth := 1
+		if recursive {
+			depth = namesys.DefaultDepthLimit
+		}
+
+		_, output, err := n.Namesys.ResolveN(n.Context(), name, depth, nocache)
+		if err == namesys.ErrResolveRecursion && !recursive {
+			// the value is another name, which was not asked for
+			err = nil
+		}
 		if err != nil {
 			res.SetError(err, cmds.ErrNormal)
 			return
@@ -0,0 +1,143 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	path "github.com/ipfs/go-ipfs/path"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+type ResolveHop struct {
+	Name  string
+	Value path.Path
+}
+
+type ResolveOutput struct {
+	Hops []ResolveHop
+	Path path.Path
+}
+
+var ResolveCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Resolve the names of a path",
+		ShortDescription: `
+'ipfs resolve' resolves an /ipns/ path, following the names it resolves
+to, whether IPNS names, DNSLink domains or proquints, until it reaches a
+path which is not a name. It prints each name resolved, with its value,
+then the path reached.
+`,
+		LongDescription: `
+'ipfs resolve' resolves an /ipns/ path, following the names it resolves
+to, whether IPNS names, DNSLink domains or proquints, until it reaches a
+path which is not a name. It prints each name resolved, with its value,
+then the path reached.
+
+The path may also be given as a bare name. Segments following the name
+are kept at the end of the path reached.
+
+At most --depth names are resolved (32 by default, 0 for no limit);
+resolve fails if a name resolves to another name past that, or back to
+itself.
+
+Resolved names are cached for the TTL of their records, so a name
+published anew elsewhere may resolve to its previous value for a while;
+--nocache resolves every name anew.
+
+Examples:
+
+  > ipfs resolve /ipns/example.com/docs
+  /ipns/example.com -> /ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n
+  /ipns/QmbCMUZw6JFeZ7Wp9jkzbye3Fzp2GGcPgC3nmeUjfVF87n -> /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy
+  /ipfs/QmatmE9msSfkKxoffpHwNLNKgwZG8eT9Bud6YoPab52vpy/docs
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "The path to resolve").EnableStdin(),
+	},
+	Options: []cmds.Option{
+		cmds.IntOption("depth", "d", "The most names to resolve (default: 32, 0 for no limit)"),
+		cmds.BoolOption("nocache", "n", "Do not use cached entries"),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		if !n.OnlineMode() {
+			err := n.SetupOfflineRouting()
+			if err != nil {
+				res.SetError(err, cmds.ErrNormal)
+				return
+			}
+		}
+
+		depth, found, err := req.Option("depth").Int()
+		if err != nil {
+			res.SetError(err, cmds.ErrClient)
+			return
+		}
+		if !found {
+			depth = namesys.DefaultDepthLimit
+		}
+		if depth < 0 {
+			res.SetError(fmt.Errorf("depth must not be negative"), cmds.ErrClient)
+			return
+		}
+
+		nocache, _, _ := req.Option("nocache").Bool()
+
+		p := req.Arguments()[0]
+		if strings.HasPrefix(p, "/ipfs/") {
+			// nothing to resolve
+			res.SetOutput(&ResolveOutput{Path: path.Path(p)})
+			return
+		}
+
+		seg := strings.Split(strings.Trim(strings.TrimPrefix(p, "/ipns/"), "/"), "/")
+		if seg[0] == "" {
+			res.SetError(fmt.Errorf("invalid path: %s", p), cmds.ErrClient)
+			return
+		}
+
+		hops, resolved, err := n.Namesys.ResolveN(n.Context(), seg[0], depth, nocache)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+		resolved, err = path.FromSegments(append(resolved.Segments(), seg[1:]...)...)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		out := &ResolveOutput{Path: resolved}
+		for _, h := range hops {
+			out.Hops = append(out.Hops, ResolveHop{Name: h.Name, Value: h.Value})
+		}
+		res.SetOutput(out)
+	},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			out, ok := res.Output().(*ResolveOutput)
+			if !ok {
+				return nil, u.ErrCast()
+			}
+
+			var buf bytes.Buffer
+			for _, h := range out.Hops {
+				fmt.Fprintf(&buf, "/ipns/%s -> %s\n", h.Name, h.Value)
+			}
+			fmt.Fprintln(&buf, out.Path)
+			return &buf, nil
+		},
+	},
+	Type: ResolveOutput{},
+}
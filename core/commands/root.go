@@ -34,13 +34,17 @@ BASIC COMMANDS
 DATA STRUCTURE COMMANDS
 
     block         Interact with raw blocks in the datastore
+    filestore     Manage blocks stored by reference to files
     object        Interact with raw dag nodes
+    tar           Store tar archives as browsable dag nodes
 
 ADVANCED COMMANDS
 
     daemon        Start a long-running daemon process
     mount         Mount an ipfs read-only mountpoint
     name          Publish or resolve IPNS names
+    resolve       Resolve the names of a path
+    key           Create and manage IPNS keys
     pin           Pin objects to local storage
     repo gc       Garbage collect unpinned objects
 
@@ -84,8 +88,10 @@ var rootSubcommands = map[string]*cmds.Command{
 	"config":    ConfigCmd,
 	"dht":       DhtCmd,
 	"diag":      DiagCmd,
+	"filestore": FilestoreCmd,
 	"get":       GetCmd,
 	"id":        IDCmd,
+	"key":       KeyCmd,
 	"log":       LogCmd,
 	"ls":        LsCmd,
 	"mount":     MountCmd,
@@ -95,8 +101,10 @@ var rootSubcommands = map[string]*cmds.Command{
 	"ping":      PingCmd,
 	"refs":      RefsCmd,
 	"repo":      RepoCmd,
+	"resolve":   ResolveCmd,
 	"stats":     StatsCmd,
 	"swarm":     SwarmCmd,
+	"tar":       TarCmd,
 	"update":    UpdateCmd,
 	"version":   VersionCmd,
 	"bitswap":   BitswapCmd,
@@ -0,0 +1,133 @@
+package commands
+
+import (
+	"io"
+	"strings"
+
+	cmds "github.com/ipfs/go-ipfs/commands"
+	core "github.com/ipfs/go-ipfs/core"
+	path "github.com/ipfs/go-ipfs/path"
+	tar "github.com/ipfs/go-ipfs/tar"
+)
+
+var TarCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Utility functions for tar files in ipfs",
+	},
+
+	Subcommands: map[string]*cmds.Command{
+		"add": tarAddCmd,
+		"cat": tarCatCmd,
+	},
+}
+
+var tarAddCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Import a tar file into ipfs",
+		ShortDescription: `
+'ipfs tar add' will parse a tar file and create a merkledag structure to
+represent it. The entries of the archive can be browsed under the hash it
+prints: every path element is prefixed with '_', and the body of an entry
+is linked as 'data', so that 'ipfs cat <hash>/_dir/_file/data' prints
+dir/file. The bodies are stored as 'ipfs add' stores files.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.FileArg("file", true, false, "tar file to add").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		fi, err := req.Files().NextFile()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		// the session keeps the blocks of the archive from garbage
+		// collection until it is pinned
+		sess, ds := n.PinSession()
+		defer sess.Close()
+
+		node, err := tar.ImportTar(fi, ds)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		k, err := node.Key()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		unlock := n.Blockstore.PinLock()
+		err = n.Pinning.Pin(req.Context().Context, node, true)
+		if err == nil {
+			err = n.Pinning.Flush()
+		}
+		unlock()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(&AddedObject{
+			Name: fi.FileName(),
+			Hash: k.B58String(),
+		})
+	},
+	Type: AddedObject{},
+	Marshalers: cmds.MarshalerMap{
+		cmds.Text: func(res cmds.Response) (io.Reader, error) {
+			o := res.Output().(*AddedObject)
+			return strings.NewReader(o.Hash + "\n"), nil
+		},
+	},
+}
+
+var tarCatCmd = &cmds.Command{
+	Helptext: cmds.HelpText{
+		Tagline: "Export a tar file from ipfs",
+		ShortDescription: `
+'ipfs tar cat' will export a tar file from a previously imported one in
+ipfs, byte for byte.
+`,
+	},
+
+	Arguments: []cmds.Argument{
+		cmds.StringArg("path", true, false, "ipfs path of archive to export").EnableStdin(),
+	},
+	Run: func(req cmds.Request, res cmds.Response) {
+		n, err := req.Context().GetNode()
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		p, err := path.ParsePath(req.Arguments()[0])
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		root, err := core.Resolve(req.Context().Context, n, p)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		r, err := tar.ExportTar(req.Context().Context, root, n.DAG)
+		if err != nil {
+			res.SetError(err, cmds.ErrNormal)
+			return
+		}
+
+		res.SetOutput(r)
+	},
+}
@@ -45,11 +45,13 @@ import (
 	bsnet "github.com/ipfs/go-ipfs/exchange/bitswap/network"
 	offline "github.com/ipfs/go-ipfs/exchange/offline"
 	rp "github.com/ipfs/go-ipfs/exchange/reprovide"
+	filestore "github.com/ipfs/go-ipfs/filestore"
 
 	mount "github.com/ipfs/go-ipfs/fuse/mount"
 	ipnsfs "github.com/ipfs/go-ipfs/ipnsfs"
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
 	namesys "github.com/ipfs/go-ipfs/namesys"
+	repub "github.com/ipfs/go-ipfs/namesys/republisher"
 	path "github.com/ipfs/go-ipfs/path"
 	pin "github.com/ipfs/go-ipfs/pin"
 	repo "github.com/ipfs/go-ipfs/repo"
@@ -86,7 +88,8 @@ type IpfsNode struct {
 
 	// Services
 	Peerstore  peer.Peerstore       // storage for other Peer instances
-	Blockstore bstore.Blockstore    // the block store (lower level)
+	Blockstore bstore.GCBlockstore  // the block store (lower level)
+	Filestore  *filestore.Filestore // the blocks stored by reference to files
 	Blocks     *bserv.BlockService  // the block service, get/add blocks.
 	DAG        merkledag.DAGService // the merkle dag service, get/add objects.
 	Resolver   *path.Resolver       // the path resolution system
@@ -99,6 +102,7 @@ type IpfsNode struct {
 	Routing      routing.IpfsRouting // the routing system. recommend ipfs-dht
 	Exchange     exchange.Interface  // the block exchange + strategy (bitswap)
 	Namesys      namesys.NameSystem  // the name system, resolves paths to hashes
+	Republisher  *repub.Republisher  // republishes the names published
 	Diagnostics  *diag.Diagnostics   // the diagnostics service
 	Reprovider   *rp.Reprovider      // the value reprovider system
 
@@ -107,6 +111,9 @@ type IpfsNode struct {
 	ctxgroup.ContextGroup
 
 	mode mode
+
+	// Datastore.StorageMax in bytes, 0 for no quota
+	storageMax uint64
 }
 
 // Mounts defines what the node's mount state is. This should
@@ -221,7 +228,15 @@ func standardWithRouting(r repo.Repo, online bool, routingOption RoutingOption,
 			return nil, err
 		}
 
-		n.Blockstore, err = bstore.WriteCached(bstore.NewBlockstore(n.Repo.Datastore()), kSizeBlockstoreWriteCache)
+		// the quota is read once; a change takes a restart
+		if max, _, _, err := n.Repo.Config().Datastore.StorageLimits(); err != nil {
+			log.Errorf("ignoring storage quota: %s", err)
+		} else {
+			n.storageMax = max
+		}
+		bs := bstore.NewQuotaBlockstore(n.Repo.Datastore(), n.StorageFull)
+		n.Filestore = filestore.New(bs, n.Repo.Datastore())
+		n.Blockstore, err = bstore.WriteCached(n.Filestore, kSizeBlockstoreWriteCache)
 		if err != nil {
 			return nil, err
 		}
@@ -271,6 +286,8 @@ func (n *IpfsNode) startOnlineServices(ctx context.Context, routingOption Routin
 	n.Reprovider = rp.NewReprovider(n.Routing, n.Blockstore)
 	go n.Reprovider.ProvideEvery(ctx, kReprovideFrequency)
 
+	go n.Republisher.Run(ctx)
+
 	// setup local discovery
 	if do != nil {
 		service, err := do(n.PeerHost)
@@ -328,9 +345,7 @@ func (n *IpfsNode) startOnlineServicesWithHost(ctx context.Context, host p2phost
 	n.Exchange = bitswap.New(ctx, n.Identity, bitswapNetwork, n.Blockstore, alwaysSendToPeer)
 
 	// setup name system
-	n.Namesys = namesys.NewNameSystem(n.Routing)
-
-	return nil
+	return n.setupNamesys()
 }
 
 // teardown closes owned children. If any errors occur, this function returns
@@ -387,6 +402,31 @@ func (n *IpfsNode) OnlineMode() bool {
 	}
 }
 
+// StorageFull returns whether the repo holds as many bytes of blocks as
+// Datastore.StorageMax allowed when the node was built. It is called on
+// every block written, so it only reads the running total of the repo.
+func (n *IpfsNode) StorageFull() bool {
+	if n.storageMax == 0 {
+		return false
+	}
+	used, err := n.Repo.StorageUsage()
+	if err != nil {
+		return false
+	}
+	return used >= n.storageMax
+}
+
+// PinSession starts a blockstore session for a sequence of writes which
+// is to finish with a pin, such as an add, and returns it with a
+// DAGService writing through it. The blocks written are stored past the
+// storage quota, and kept by garbage collection until the session is
+// closed, without the pin lock being held in between writes. The session
+// is to be closed once the blocks are pinned.
+func (n *IpfsNode) PinSession() (*bstore.Session, merkledag.DAGService) {
+	sess := bstore.NewSession(n.Blockstore)
+	return sess, merkledag.NewDAGService(n.Blocks.WithBlockstore(sess))
+}
+
 func (n *IpfsNode) Bootstrap(cfg BootstrapConfig) error {
 
 	// TODO what should return value be when in offlineMode?
@@ -472,8 +512,23 @@ func (n *IpfsNode) SetupOfflineRouting() error {
 
 	n.Routing = offroute.NewOfflineRouter(n.Repo.Datastore(), n.PrivateKey)
 
-	n.Namesys = namesys.NewNameSystem(n.Routing)
+	return n.setupNamesys()
+}
+
+// setupNamesys sets up the name system over the routing system. The names
+// published through it are tracked by the republisher, which the online
+// node runs.
+func (n *IpfsNode) setupNamesys() error {
+	period, lifetime, err := n.Repo.Config().Ipns.RepublisherTimes()
+	if err != nil {
+		return err
+	}
 
+	ns := namesys.NewNameSystem(n.Routing, n.Repo.Datastore(), n.Repo.Config().Ipns.CacheSize())
+	n.Republisher = repub.NewRepublisher(ns, n.Routing, n.Repo.Datastore(), n.PrivateKey, n.Repo.Keystore())
+	n.Republisher.Interval = period
+	n.Republisher.RecordLifetime = lifetime
+	n.Namesys = n.Republisher.NameSystem()
 	return nil
 }
 
@@ -557,7 +612,8 @@ func startListening(ctx context.Context, host p2phost.Host, cfg *config.Config)
 
 func constructDHTRouting(ctx context.Context, host p2phost.Host, dstore ds.ThreadSafeDatastore) (routing.IpfsRouting, error) {
 	dhtRouting := dht.NewDHT(ctx, host, dstore)
-	dhtRouting.Validator[IpnsValidatorTag] = namesys.IpnsRecordValidator
+	dhtRouting.Validator[IpnsValidatorTag] = namesys.NewIpnsRecordValidator(host.Peerstore())
+	dhtRouting.Selector[IpnsValidatorTag] = namesys.NewIpnsSelectorFunc(host.Peerstore())
 	return dhtRouting, nil
 }
 
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	gopath "path"
+	"sort"
 	"strings"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	"github.com/ipfs/go-ipfs/routing"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	u "github.com/ipfs/go-ipfs/util"
 )
@@ -71,8 +73,12 @@ func (i *gatewayHandler) loadTemplate() error {
 func (i *gatewayHandler) newDagFromReader(r io.Reader) (*dag.Node, error) {
 	// TODO(cryptix): change and remove this helper once PR1136 is merged
 	// return ufs.AddFromReader(i.node, r.Body)
+	// the session keeps the blocks from garbage collection until the
+	// pinner holds them
+	sess, ds := i.node.PinSession()
+	defer sess.Close()
 	return importer.BuildDagFromReader(
-		r, i.node.DAG, i.node.Pinning.GetManual(), chunk.DefaultSplitter)
+		r, ds, i.node.Pinning.GetManual(), chunk.DefaultSplitter)
 }
 
 // TODO(btc): break this apart into separate handlers using a more expressive muxer
@@ -159,6 +165,13 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		// set modtime to a really long time ago, since files are immutable and should stay cached
 		modtime = time.Unix(1, 0)
 	}
+	// unless the file recorded when it was last modified. A raw block
+	// has no room for it.
+	if pbdata, err := ft.FromBytes(nd.Data); err == nil && !nd.IsRaw() {
+		if mt := ft.AttrsOf(pbdata).ModTime; !mt.IsZero() {
+			modtime = mt
+		}
+	}
 
 	if err == nil {
 		defer dr.Close()
@@ -167,11 +180,24 @@ func (i *gatewayHandler) getOrHeadHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	dir, err := uio.NewDirectoryFromNode(i.node.DAG, nd)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	links, err := dir.Links(ctx)
+	if err != nil {
+		internalWebError(w, err)
+		return
+	}
+	// the entries of a sharded directory are not sorted
+	sort.Stable(dag.LinkSlice(links))
+
 	// storage for directory listing
 	var dirListing []directoryItem
 	// loop through files
 	foundIndex := false
-	for _, link := range nd.Links {
+	for _, link := range links {
 		if link.Name == "index.html" {
 			if urlPath[len(urlPath)-1] != '/' {
 				http.Redirect(w, r, urlPath+"/", 302)
@@ -316,7 +342,7 @@ func (i *gatewayHandler) putHandler(w http.ResponseWriter, r *http.Request) {
 	if _, ok := err.(path.ErrNoLink); ok {
 		// Create empty directories, links will be made further down the code
 		for len(pathNodes) < len(components) {
-			pathNodes = append(pathNodes, uio.NewDirectory(i.node.DAG).GetNode())
+			pathNodes = append(pathNodes, uio.NewEmptyDirectory())
 		}
 	} else if err != nil {
 		webError(w, "Could not resolve parent object", err, http.StatusBadRequest)
@@ -7,6 +7,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	core "github.com/ipfs/go-ipfs/core"
@@ -29,6 +30,14 @@ func (m mockNamesys) Resolve(ctx context.Context, name string) (value path.Path,
 	return p, nil
 }
 
+func (m mockNamesys) ResolveN(ctx context.Context, name string, depth int, nocache bool) ([]namesys.Hop, path.Path, error) {
+	p, err := m.Resolve(ctx, name)
+	if err != nil {
+		return nil, "", err
+	}
+	return []namesys.Hop{{Name: name, Value: p}}, p, nil
+}
+
 func (m mockNamesys) CanResolve(name string) bool {
 	_, ok := m[name]
 	return ok
@@ -38,6 +47,10 @@ func (m mockNamesys) Publish(ctx context.Context, name ci.PrivKey, value path.Pa
 	return errors.New("not implemented for mockNamesys")
 }
 
+func (m mockNamesys) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error {
+	return errors.New("not implemented for mockNamesys")
+}
+
 func newNodeWithMockNamesys(t *testing.T, ns mockNamesys) *core.IpfsNode {
 	c := config.Config{
 		Identity: config.Identity{
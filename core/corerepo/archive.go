@@ -0,0 +1,357 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/core"
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	"github.com/ipfs/go-ipfs/merkledag"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	"github.com/ipfs/go-ipfs/pin"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// Names of the entries of a repo archive. The config comes first, so that
+// a repo can be created from it before any block is read, then the named
+// keys of the keystore, and the pins come last, once every block they need
+// is stored.
+const (
+	archiveConfig = "config"
+	archiveKeys   = "keys"
+	archiveBlocks = "blocks"
+	archivePins   = "pins"
+)
+
+// archivedPins is the pin state of a repo archive. Keys are base58.
+type archivedPins struct {
+	Recursive []string
+	Direct    []string
+	Metadata  map[string]*pin.Metadata `json:",omitempty"`
+}
+
+// ImportStats counts what an archive import restored.
+type ImportStats struct {
+	Keys   int
+	Blocks int
+	Pins   int
+}
+
+// Export writes the config, the keystore, the pin state and every pinned
+// block of n to w as a tar stream. With redactKeys set, the private key and
+// datastore credentials are left out of the config, and the keystore is
+// left out.
+func Export(n *core.IpfsNode, ctx context.Context, w io.Writer, redactKeys bool) error {
+	tw := tar.NewWriter(w)
+
+	cfgData, err := exportConfig(n.Repo.Config(), redactKeys)
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, archiveConfig, cfgData); err != nil {
+		return err
+	}
+	if ks := n.Repo.Keystore(); ks != nil && !redactKeys {
+		if err := exportKeys(ks, tw); err != nil {
+			return err
+		}
+	}
+
+	// the pinned blocks must not be collected half way through
+	defer n.Blockstore.PinLock()()
+
+	pins := &archivedPins{Metadata: make(map[string]*pin.Metadata)}
+	seen := make(map[u.Key]struct{})
+	for _, k := range n.Pinning.RecursiveKeys() {
+		pins.Recursive = append(pins.Recursive, k.B58String())
+		if err := exportTree(ctx, n, tw, k, false, seen); err != nil {
+			return err
+		}
+	}
+	for _, k := range n.Pinning.DirectKeys() {
+		pins.Direct = append(pins.Direct, k.B58String())
+		if err := exportBlock(n, tw, k, seen); err != nil {
+			return err
+		}
+	}
+	for k, m := range n.Pinning.Annotations() {
+		pins.Metadata[k.B58String()] = m
+	}
+
+	pinData, err := json.Marshal(pins)
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, archivePins, pinData); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// exportConfig returns cfg as archived. With redact set, the private key is
+// left out and the datastore secrets are redacted.
+func exportConfig(cfg *config.Config, redact bool) ([]byte, error) {
+	if !redact {
+		return config.Marshal(cfg)
+	}
+	m, err := config.ToMap(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := m["Identity"].(map[string]interface{}); ok {
+		delete(id, "PrivKey")
+	}
+	fsrepo.RedactSecrets(m)
+	return config.Marshal(m)
+}
+
+// exportKeys writes the keys of ks, in the format of ipfs key export.
+func exportKeys(ks keystore.Keystore, tw *tar.Writer) error {
+	names, err := ks.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		sk, err := ks.Get(name)
+		if err != nil {
+			return fmt.Errorf("key %s: %s", name, err)
+		}
+		data, err := ci.MarshalPrivateKey(sk)
+		if err != nil {
+			return err
+		}
+		if err := writeEntry(tw, path.Join(archiveKeys, name), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportTree writes the blocks of the dag under k. raw tells whether k is a
+// raw block, which has no links.
+func exportTree(ctx context.Context, n *core.IpfsNode, tw *tar.Writer, k u.Key, raw bool, seen map[u.Key]struct{}) error {
+	if _, ok := seen[k]; ok {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := n.Blockstore.Get(k)
+	if err != nil {
+		return fmt.Errorf("pinned block %s: %s", k, err)
+	}
+	seen[k] = struct{}{}
+	if err := writeEntry(tw, path.Join(archiveBlocks, k.B58String()), b.Data); err != nil {
+		return err
+	}
+
+	if raw {
+		return nil
+	}
+	nd, err := merkledag.Decoded(b.Data)
+	if err != nil {
+		return err
+	}
+	for _, l := range nd.Links {
+		if l.Inline != nil {
+			// exported along with this block
+			continue
+		}
+		if err := exportTree(ctx, n, tw, u.Key(l.Hash), l.Raw, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportBlock(n *core.IpfsNode, tw *tar.Writer, k u.Key, seen map[u.Key]struct{}) error {
+	if _, ok := seen[k]; ok {
+		return nil
+	}
+	b, err := n.Blockstore.Get(k)
+	if err != nil {
+		return fmt.Errorf("pinned block %s: %s", k, err)
+	}
+	seen[k] = struct{}{}
+	return writeEntry(tw, path.Join(archiveBlocks, k.B58String()), b.Data)
+}
+
+func writeEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// ReadArchiveConfig reads the config at the start of an archive written by
+// Export. The rest of the archive is left to ImportArchive.
+func ReadArchiveConfig(tr *tar.Reader) (*config.Config, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("reading archive: %s", err)
+	}
+	if hdr.Name != archiveConfig {
+		return nil, errors.New("not a repo archive: it does not start with a config")
+	}
+	var cfg config.Config
+	if err := json.NewDecoder(tr).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("archived config: %s", err)
+	}
+	// redacted secrets are left unset, to be found as when not configured
+	for _, m := range cfg.Datastore.Mounts {
+		for name, v := range m.Params {
+			if v == fsrepo.Redacted {
+				delete(m.Params, name)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+// ImportArchive stores the blocks of an archive into n and restores its
+// pins. Every block is checked against its key before it is stored.
+func ImportArchive(n *core.IpfsNode, ctx context.Context, tr *tar.Reader) (*ImportStats, error) {
+	// the session keeps the blocks imported from garbage collection until
+	// their pins are restored
+	sess := bstore.NewSession(n.Blockstore)
+	defer sess.Close()
+
+	stats := new(ImportStats)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, errors.New("archive ended before the pin state")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading archive: %s", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		switch dir, name := path.Split(hdr.Name); {
+		case path.Clean(dir) == archiveKeys:
+			if err := importKey(n.Repo.Keystore(), name, tr); err != nil {
+				return nil, err
+			}
+			stats.Keys++
+		case path.Clean(dir) == archiveBlocks:
+			if err := importBlock(sess, name, tr); err != nil {
+				return nil, err
+			}
+			stats.Blocks++
+		case hdr.Name == archivePins:
+			pins, err := importPins(n, ctx, tr)
+			if err != nil {
+				return nil, err
+			}
+			stats.Pins = pins
+			return stats, nil
+		default:
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+	}
+}
+
+// importKey stores the key read from r in ks as name. A key already stored
+// as name is kept, if it is the same.
+func importKey(ks keystore.Keystore, name string, r io.Reader) error {
+	if ks == nil {
+		return errors.New("the archive has keys, but the repo has no keystore")
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	sk, err := ci.UnmarshalPrivateKey(data)
+	if err != nil {
+		return fmt.Errorf("key %s: %s", name, err)
+	}
+	err = ks.Put(name, sk)
+	if err == keystore.ErrKeyExists {
+		have, err := ks.Get(name)
+		if err != nil {
+			return err
+		}
+		if !ci.KeyEqual(have, sk) {
+			return fmt.Errorf("key %s: the repo has another key by that name", name)
+		}
+		return nil
+	}
+	return err
+}
+
+func importBlock(bs bstore.Blockstore, name string, r io.Reader) error {
+	k := u.B58KeyDecode(name)
+	if k == "" {
+		return fmt.Errorf("invalid block name %q", name)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !hashMatches(k, data) {
+		return fmt.Errorf("block %s does not match its hash", name)
+	}
+	b, err := blocks.NewBlockWithHash(data, k.ToMultihash())
+	if err != nil {
+		return err
+	}
+	return bs.Put(b)
+}
+
+func importPins(n *core.IpfsNode, ctx context.Context, r io.Reader) (int, error) {
+	defer n.Blockstore.PinLock()()
+
+	var pins archivedPins
+	if err := json.NewDecoder(r).Decode(&pins); err != nil {
+		return 0, fmt.Errorf("archived pins: %s", err)
+	}
+
+	count := 0
+	add := func(keys []string, recursive bool) error {
+		for _, s := range keys {
+			k := u.B58KeyDecode(s)
+			nd, err := n.DAG.Get(ctx, k)
+			if err != nil {
+				return fmt.Errorf("pin %s: %s", s, err)
+			}
+			if err := n.Pinning.Pin(ctx, nd, recursive); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	}
+	if err := add(pins.Recursive, true); err != nil {
+		return 0, err
+	}
+	if err := add(pins.Direct, false); err != nil {
+		return 0, err
+	}
+	for s, m := range pins.Metadata {
+		if err := n.Pinning.Annotate(u.B58KeyDecode(s), m); err != nil {
+			return 0, err
+		}
+	}
+	return count, n.Pinning.Flush()
+}
@@ -0,0 +1,208 @@
+package corerepo
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/core"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	testutil "github.com/ipfs/go-ipfs/util/testutil"
+)
+
+func TestArchiveRoundtrip(t *testing.T) {
+	ctx := context.Background()
+	src, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child := &mdag.Node{Data: []byte("child")}
+	root := &mdag.Node{Data: []byte("root")}
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	direct := &mdag.Node{Data: []byte("direct")}
+	unpinned := &mdag.Node{Data: []byte("unpinned")}
+	for _, nd := range []*mdag.Node{root, direct, unpinned} {
+		if err := src.DAG.AddRecursive(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Pinning.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Pinning.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+	src.Repo.Config().Identity.PrivKey = "secret"
+	src.Repo.Config().Datastore.Mounts = []config.DatastoreMount{{
+		Prefix: "/blocks",
+		Type:   "s3",
+		Params: map[string]interface{}{"bucket": "b", "secretKey": "s3secret"},
+	}}
+	sk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Repo.Keystore().Put("site", sk); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(src, ctx, &buf, true); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	dst, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	cfg, err := ReadArchiveConfig(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Identity.PrivKey != "" {
+		t.Fatal("private key should be redacted")
+	}
+	if _, ok := cfg.Datastore.Mounts[0].Params["secretKey"]; ok || cfg.Datastore.Mounts[0].Params["bucket"] != "b" {
+		t.Fatalf("only the datastore secrets should be redacted: %v", cfg.Datastore.Mounts[0].Params)
+	}
+	if bytes.Contains(archive, []byte("s3secret")) {
+		t.Fatal("datastore secret found in the archive")
+	}
+	if src.Repo.Config().Identity.PrivKey != "secret" || src.Repo.Config().Datastore.Mounts[0].Params["secretKey"] != "s3secret" {
+		t.Fatal("redacting should not change the exported repo")
+	}
+
+	stats, err := ImportArchive(dst, ctx, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Keys != 0 || stats.Blocks != 3 || stats.Pins != 2 {
+		t.Fatalf("expected no keys, 3 blocks and 2 pins, got %+v", stats)
+	}
+	if has, _ := dst.Repo.Keystore().Has("site"); has {
+		t.Fatal("named key should be redacted")
+	}
+	for _, nd := range []*mdag.Node{root, child, direct} {
+		k, _ := nd.Key()
+		if has, _ := dst.Blockstore.Has(k); !has {
+			t.Fatalf("block %s was not imported", k)
+		}
+	}
+	rk, _ := root.Key()
+	dk, _ := direct.Key()
+	uk, _ := unpinned.Key()
+	if !dst.Pinning.IsPinned(rk) || !dst.Pinning.IsPinned(dk) {
+		t.Fatal("pins were not restored")
+	}
+	if has, _ := dst.Blockstore.Has(uk); has {
+		t.Fatal("unpinned block should not be exported")
+	}
+}
+
+func TestArchiveRejectsCorruptBlock(t *testing.T) {
+	ctx := context.Background()
+	src, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd := &mdag.Node{Data: []byte("pinned data")}
+	if err := src.DAG.AddRecursive(nd); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Pinning.Pin(ctx, nd, true); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(src, ctx, &buf, false); err != nil {
+		t.Fatal(err)
+	}
+	// flip the block data without changing its length
+	archive := bytes.Replace(buf.Bytes(), []byte("pinned data"), []byte("pinned DATA"), 1)
+
+	dst, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(archive))
+	if _, err := ReadArchiveConfig(tr); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ImportArchive(dst, ctx, tr)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected a hash mismatch, got %v", err)
+	}
+	k, _ := nd.Key()
+	if has, _ := dst.Blockstore.Has(k); has {
+		t.Fatal("corrupt block should not be stored")
+	}
+}
+
+func TestArchiveKeys(t *testing.T) {
+	ctx := context.Background()
+	src, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Repo.Keystore().Put("site", sk); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Export(src, ctx, &buf, false); err != nil {
+		t.Fatal(err)
+	}
+
+	importInto := func(n *core.IpfsNode) (*ImportStats, error) {
+		tr := tar.NewReader(bytes.NewReader(buf.Bytes()))
+		if _, err := ReadArchiveConfig(tr); err != nil {
+			t.Fatal(err)
+		}
+		return ImportArchive(n, ctx, tr)
+	}
+
+	dst, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a second import finds the same key
+	for i := 0; i < 2; i++ {
+		stats, err := importInto(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Keys != 1 {
+			t.Fatalf("expected 1 key, got %+v", stats)
+		}
+	}
+	if k, err := dst.Repo.Keystore().Get("site"); err != nil || !ci.KeyEqual(k, sk) {
+		t.Fatalf("named key not restored: %v", err)
+	}
+
+	other, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Repo.Keystore().Put("site", otherKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importInto(other); err == nil {
+		t.Fatal("imported over another key by the same name")
+	}
+}
@@ -1,8 +1,13 @@
 package corerepo
 
 import (
+	"errors"
+	"time"
+
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/ipfs/go-ipfs/core"
+	gc "github.com/ipfs/go-ipfs/pin/gc"
 	u "github.com/ipfs/go-ipfs/util"
 
 	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
@@ -10,59 +15,110 @@ import (
 
 var log = eventlog.Logger("corerepo")
 
+// how often PeriodicGC compares the repo size against the watermarks
+const gcCheckInterval = time.Minute
+
+// ErrNoQuota is returned by ConditionalGC when Datastore.StorageMax is
+// not set.
+var ErrNoQuota = errors.New("no storage quota configured")
+
 type KeyRemoved struct {
 	Key u.Key
 }
 
+// GarbageCollect removes every block that is not reachable from a pin.
 func GarbageCollect(n *core.IpfsNode, ctx context.Context) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel() // in case error occurs during operation
-	keychan, err := n.Blockstore.AllKeysChan(ctx)
+	rmed, err := gc.GC(ctx, n.Blockstore, n.Pinning, false)
 	if err != nil {
 		return err
 	}
-	for k := range keychan { // rely on AllKeysChan to close chan
-		if !n.Pinning.IsPinned(k) {
-			err := n.Blockstore.DeleteBlock(k)
-			if err != nil {
-				return err
-			}
-		}
+
+	for _ = range rmed {
+		// drain the channel, the sweep runs until it is closed
 	}
 	return nil
 }
 
-func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context) (<-chan *KeyRemoved, error) {
-
-	keychan, err := n.Blockstore.AllKeysChan(ctx)
+// GarbageCollectAsync runs a garbage collection and streams the removed
+// keys. With dryRun set nothing is deleted, and the keys that would have
+// been removed are reported instead.
+func GarbageCollectAsync(n *core.IpfsNode, ctx context.Context, dryRun bool) (<-chan *KeyRemoved, error) {
+	rmed, err := gc.GC(ctx, n.Blockstore, n.Pinning, dryRun)
 	if err != nil {
 		return nil, err
 	}
 
-	output := make(chan *KeyRemoved)
+	out := make(chan *KeyRemoved)
 	go func() {
-		defer close(output)
-		for {
+		defer close(out)
+		for k := range rmed {
 			select {
-			case k, ok := <-keychan:
-				if !ok {
-					return
-				}
-				if !n.Pinning.IsPinned(k) {
-					err := n.Blockstore.DeleteBlock(k)
-					if err != nil {
-						log.Debugf("Error removing key from blockstore: %s", err)
-						continue
-					}
-					select {
-					case output <- &KeyRemoved{k}:
-					case <-ctx.Done():
-					}
-				}
+			case out <- &KeyRemoved{k}:
 			case <-ctx.Done():
 				return
 			}
 		}
 	}()
-	return output, nil
+	return out, nil
+}
+
+// PeriodicGC checks the repo size every minute and runs ConditionalGC when
+// the high watermark is crossed. It returns when ctx is done, or right away
+// when no storage quota is configured.
+func PeriodicGC(ctx context.Context, n *core.IpfsNode) error {
+	if max, _, _, err := n.Repo.Config().Datastore.StorageLimits(); err != nil || max == 0 {
+		return err
+	}
+
+	ticker := time.NewTicker(gcCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ConditionalGC(ctx, n); err != nil && err != ErrNoQuota {
+				log.Errorf("automatic gc: %s", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// ConditionalGC runs a garbage collection if the blocks stored exceed the
+// high watermark of the storage quota. The sweep stops early once usage
+// drops below the low watermark, so the rest of the cache survives.
+func ConditionalGC(ctx context.Context, n *core.IpfsNode) error {
+	max, high, low, err := n.Repo.Config().Datastore.StorageLimits()
+	if err != nil {
+		return err
+	}
+	if max == 0 {
+		return ErrNoQuota
+	}
+	used, err := n.Repo.StorageUsage()
+	if err != nil {
+		return err
+	}
+	if used < high {
+		return nil
+	}
+	log.Infof("repo holds %s of %s, starting garbage collection",
+		humanize.Bytes(used), humanize.Bytes(max))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rmed, err := gc.GC(ctx, n.Blockstore, n.Pinning, false)
+	if err != nil {
+		return err
+	}
+	for _ = range rmed {
+		used, err := n.Repo.StorageUsage()
+		if err == nil && used <= low {
+			// enough space was freed; the sweep stops at the cancel
+			cancel()
+		}
+	}
+	return nil
 }
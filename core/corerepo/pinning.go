@@ -18,17 +18,32 @@ import (
 	"time"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 
 	"github.com/ipfs/go-ipfs/core"
 	"github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
+	pin "github.com/ipfs/go-ipfs/pin"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
 func Pin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
+	return PinWithMetadata(n, paths, recursive, nil)
+}
+
+// PinWithMetadata pins the given paths and attaches meta to every new pin.
+// A nil meta leaves existing metadata untouched.
+func PinWithMetadata(n *core.IpfsNode, paths []string, recursive bool, meta *pin.Metadata) ([]u.Key, error) {
 	// TODO(cryptix): do we want a ctx as first param for (Un)Pin() as well, just like core.Resolve?
 	ctx := n.Context()
 
+	// fetched blocks must not be collected before they are pinned, and
+	// are stored past the storage quota
+	defer n.Blockstore.PinLock()()
+	sess := bstore.NewSession(n.Blockstore)
+	defer sess.Close()
+	ctx = bstore.WithSession(ctx, sess)
+
 	dagnodes := make([]*merkledag.Node, 0)
 	for _, fpath := range paths {
 		dagnode, err := core.Resolve(ctx, n, path.Path(fpath))
@@ -51,6 +66,11 @@ func Pin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 		if err != nil {
 			return nil, fmt.Errorf("pin: %s", err)
 		}
+		if meta != nil {
+			if err := n.Pinning.Annotate(k, meta); err != nil {
+				return nil, fmt.Errorf("pin: %s", err)
+			}
+		}
 		out = append(out, k)
 	}
 
@@ -66,6 +86,9 @@ func Unpin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 	// TODO(cryptix): do we want a ctx as first param for (Un)Pin() as well, just like core.Resolve?
 	ctx := n.Context()
 
+	// the flushed pin state must not be collected before it is recorded
+	defer n.Blockstore.PinLock()()
+
 	dagnodes := make([]*merkledag.Node, 0)
 	for _, fpath := range paths {
 		dagnode, err := core.Resolve(ctx, n, path.Path(fpath))
@@ -94,3 +117,83 @@ func Unpin(n *core.IpfsNode, paths []string, recursive bool) ([]u.Key, error) {
 	}
 	return unpinned, nil
 }
+
+// UnpinByName removes every direct or recursive pin whose metadata carries
+// the given name.
+func UnpinByName(n *core.IpfsNode, name string) ([]u.Key, error) {
+	ctx := n.Context()
+
+	// the flushed pin state must not be collected before it is recorded
+	defer n.Blockstore.PinLock()()
+
+	recursive := make(map[u.Key]bool)
+	for _, k := range n.Pinning.RecursiveKeys() {
+		recursive[k] = true
+	}
+
+	var unpinned []u.Key
+	for k, meta := range n.Pinning.Annotations() {
+		if meta.Name != name {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		err := n.Pinning.Unpin(ctx, k, recursive[k])
+		if err != nil {
+			return nil, err
+		}
+		unpinned = append(unpinned, k)
+	}
+
+	if len(unpinned) == 0 {
+		return nil, fmt.Errorf("no pins named %q", name)
+	}
+
+	err := n.Pinning.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return unpinned, nil
+}
+
+// Update moves the recursive pin of fromPath to toPath, returning the keys
+// of both roots. With unpin false the old root stays pinned.
+func Update(n *core.IpfsNode, fromPath, toPath string, unpin bool) (from, to u.Key, err error) {
+	ctx := n.Context()
+
+	// the new dag must not be collected before it is pinned, and is
+	// stored past the storage quota
+	defer n.Blockstore.PinLock()()
+	sess := bstore.NewSession(n.Blockstore)
+	defer sess.Close()
+	ctx = bstore.WithSession(ctx, sess)
+
+	fromNode, err := core.Resolve(ctx, n, path.Path(fromPath))
+	if err != nil {
+		return "", "", err
+	}
+	toNode, err := core.Resolve(ctx, n, path.Path(toPath))
+	if err != nil {
+		return "", "", err
+	}
+	from, err = fromNode.Key()
+	if err != nil {
+		return "", "", err
+	}
+	to, err = toNode.Key()
+	if err != nil {
+		return "", "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if err := n.Pinning.Update(ctx, from, to, unpin); err != nil {
+		return "", "", fmt.Errorf("pin: %s", err)
+	}
+
+	if err := n.Pinning.Flush(); err != nil {
+		return "", "", err
+	}
+	return from, to, nil
+}
@@ -0,0 +1,75 @@
+package corerepo
+
+import (
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/core"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// Stat describes the contents of the repo, and how much it stores against
+// its quota.
+type Stat struct {
+	// NumObjects is the number of blocks stored.
+	NumObjects uint64
+	// RepoSize is the number of bytes of blocks stored.
+	RepoSize uint64
+	// StorageMax is the storage quota in bytes, or 0 when unlimited.
+	StorageMax uint64
+	// LevelDBSize is the size on disk of the leveldb datastore mounts.
+	LevelDBSize uint64
+	// Mounts are the sizes on disk of the datastore mounts.
+	Mounts   []fsrepo.MountUsage
+	RepoPath string
+	Version  string
+}
+
+// RepoStat reports the contents of the repo of n, which is stored at
+// repoPath.
+func RepoStat(n *core.IpfsNode, ctx context.Context, repoPath string) (*Stat, error) {
+	used, err := n.Repo.StorageUsage()
+	if err != nil {
+		return nil, err
+	}
+	max, _, _, err := n.Repo.Config().Datastore.StorageLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var count uint64
+	for _ = range keys {
+		count++
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mounts, err := fsrepo.DatastoreUsage(repoPath, n.Repo.Config())
+	if err != nil {
+		return nil, err
+	}
+	var ldbSize uint64
+	for _, m := range mounts {
+		if m.Type == "leveldb" {
+			ldbSize += m.Size
+		}
+	}
+	version, err := mfsr.RepoPath(repoPath).Version()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stat{
+		NumObjects:  count,
+		RepoSize:    used,
+		StorageMax:  max,
+		LevelDBSize: ldbSize,
+		Mounts:      mounts,
+		RepoPath:    repoPath,
+		Version:     "fs-repo@" + version,
+	}, nil
+}
@@ -0,0 +1,276 @@
+package corerepo
+
+import (
+	"fmt"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/core"
+	"github.com/ipfs/go-ipfs/merkledag"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// PinStatus is the result of verifying a single pin root.
+type PinStatus struct {
+	Key       u.Key
+	Ok        bool
+	Missing   []u.Key `json:",omitempty"`
+	Corrupt   []u.Key `json:",omitempty"`
+	Refetched []u.Key `json:",omitempty"`
+}
+
+// blockState is the result of checking a single block.
+type blockState int
+
+const (
+	blockOk blockState = iota
+	blockMissing
+	blockCorrupt
+)
+
+type pinVerifier struct {
+	n       *core.IpfsNode
+	refetch bool
+
+	// links of every good block, and the state of every bad one
+	links map[u.Key][]u.Key
+	bad   map[u.Key]blockState
+	// blocks linked to as raw blocks, which have no links
+	raw map[u.Key]bool
+	// blocks that were successfully fetched again
+	fixed map[u.Key]bool
+}
+
+// VerifyPins checks that every pinned dag is fully present in the local
+// blockstore and that every block still hashes to its key. The network is
+// never consulted, unless refetch is set, in which case missing and
+// corrupt blocks are fetched again through the exchange.
+func VerifyPins(n *core.IpfsNode, ctx context.Context, refetch bool) (<-chan *PinStatus, error) {
+	v := &pinVerifier{
+		n:       n,
+		refetch: refetch,
+		links:   make(map[u.Key][]u.Key),
+		bad:     make(map[u.Key]blockState),
+		raw:     make(map[u.Key]bool),
+		fixed:   make(map[u.Key]bool),
+	}
+
+	recursive := n.Pinning.RecursiveKeys()
+	direct := n.Pinning.DirectKeys()
+
+	out := make(chan *PinStatus)
+	go func() {
+		defer close(out)
+		if refetch {
+			// refetched blocks must not be collected before we are done,
+			// and are stored past the storage quota
+			defer n.Blockstore.PinLock()()
+			sess := bstore.NewSession(n.Blockstore)
+			defer sess.Close()
+			ctx = bstore.WithSession(ctx, sess)
+		}
+
+		send := func(st *PinStatus) bool {
+			select {
+			case out <- st:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for _, k := range recursive {
+			st := &PinStatus{Key: k}
+			v.walk(ctx, k, st, make(map[u.Key]struct{}))
+			st.Ok = len(st.Missing) == 0 && len(st.Corrupt) == 0
+			if !send(st) {
+				return
+			}
+		}
+		for _, k := range direct {
+			st := &PinStatus{Key: k}
+			v.checkInto(ctx, k, st)
+			st.Ok = len(st.Missing) == 0 && len(st.Corrupt) == 0
+			if !send(st) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (v *pinVerifier) walk(ctx context.Context, k u.Key, st *PinStatus, seen map[u.Key]struct{}) {
+	if _, ok := seen[k]; ok {
+		return
+	}
+	seen[k] = struct{}{}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if !v.checkInto(ctx, k, st) {
+		// without the block, its children can't be found
+		return
+	}
+	for _, child := range v.links[k] {
+		v.walk(ctx, child, st, seen)
+	}
+}
+
+// checkInto checks k and records a failure in st. It returns whether k is
+// usable, either because it was good or because it was refetched.
+func (v *pinVerifier) checkInto(ctx context.Context, k u.Key, st *PinStatus) bool {
+	state := v.check(ctx, k)
+	switch state {
+	case blockMissing:
+		st.Missing = append(st.Missing, k)
+	case blockCorrupt:
+		st.Corrupt = append(st.Corrupt, k)
+	}
+	if v.fixed[k] {
+		st.Refetched = append(st.Refetched, k)
+		return true
+	}
+	return state == blockOk
+}
+
+func (v *pinVerifier) check(ctx context.Context, k u.Key) blockState {
+	if _, ok := v.links[k]; ok {
+		if state, bad := v.bad[k]; bad {
+			return state
+		}
+		return blockOk
+	}
+	if state, ok := v.bad[k]; ok {
+		return state
+	}
+
+	state, data := readLocal(v.n.Blockstore, k)
+	if state != blockOk {
+		v.bad[k] = state
+		if !v.refetch {
+			return state
+		}
+		fetched, err := v.fetch(ctx, k, state)
+		if err != nil {
+			log.Debugf("pin verify: failed to refetch %s: %s", k, err)
+			return state
+		}
+		v.fixed[k] = true
+		data = fetched
+	}
+
+	if v.raw[k] {
+		v.links[k] = nil
+		return state
+	}
+	nd, err := merkledag.Decoded(data)
+	if err != nil {
+		// a block with the right hash that is not a dag node has no
+		// links to follow
+		v.links[k] = nil
+		return state
+	}
+	var links []u.Key
+	for _, l := range nd.Links {
+		if l.Inline != nil {
+			// stored in this block, there is nothing else to check
+			continue
+		}
+		if l.Raw {
+			v.raw[u.Key(l.Hash)] = true
+		}
+		links = append(links, u.Key(l.Hash))
+	}
+	v.links[k] = links
+	return state
+}
+
+// readLocal reads k from bs only, and rehashes its data.
+func readLocal(bs bstore.Blockstore, k u.Key) (blockState, []byte) {
+	b, err := bs.Get(k)
+	if err == bstore.ErrNotFound {
+		return blockMissing, nil
+	}
+	if err != nil {
+		return blockCorrupt, nil
+	}
+	if !hashMatches(k, b.Data) {
+		return blockCorrupt, nil
+	}
+	return blockOk, b.Data
+}
+
+// fetch replaces a bad block with a copy from the exchange.
+func (v *pinVerifier) fetch(ctx context.Context, k u.Key, state blockState) ([]byte, error) {
+	if v.n.Exchange == nil {
+		return nil, fmt.Errorf("no exchange to fetch %s from", k)
+	}
+	b, err := v.n.Exchange.GetBlock(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if !hashMatches(k, b.Data) {
+		return nil, fmt.Errorf("fetched block does not match %s", k)
+	}
+	if state == blockCorrupt {
+		// Put skips keys that are already stored
+		if err := v.n.Blockstore.DeleteBlock(k); err != nil {
+			return nil, err
+		}
+	}
+	if sess := bstore.SessionFromContext(ctx); sess != nil {
+		sess.Expect(k)
+	}
+	if err := v.n.Blockstore.Put(b); err != nil {
+		return nil, err
+	}
+	return b.Data, nil
+}
+
+// hashMatches rehashes data with the hash function named by k.
+func hashMatches(k u.Key, data []byte) bool {
+	return blocks.VerifyHash(data, k.ToMultihash()) == nil
+}
+
+// CorruptBlock is a stored block whose data no longer hashes to its key.
+type CorruptBlock struct {
+	Key     u.Key
+	Removed bool `json:",omitempty"`
+}
+
+// VerifyBlocks rehashes every block in the blockstore of n and reports the
+// ones that are corrupt. With remove set, corrupt blocks are also deleted,
+// so that they can be fetched again.
+func VerifyBlocks(n *core.IpfsNode, ctx context.Context, remove bool) (<-chan *CorruptBlock, error) {
+	keys, err := n.Blockstore.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *CorruptBlock)
+	go func() {
+		defer close(out)
+		for k := range keys {
+			if state, _ := readLocal(n.Blockstore, k); state != blockCorrupt {
+				// blocks removed since the listing started are fine too
+				continue
+			}
+			cb := &CorruptBlock{Key: k}
+			if remove {
+				if err := n.Blockstore.DeleteBlock(k); err != nil {
+					log.Debugf("repo verify: failed to remove %s: %s", k, err)
+				} else {
+					cb.Removed = true
+				}
+			}
+			select {
+			case out <- cb:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,143 @@
+package corerepo
+
+import (
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/core"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func collectStatus(t *testing.T, n *core.IpfsNode, refetch bool) map[u.Key]*PinStatus {
+	out, err := VerifyPins(n, context.Background(), refetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[u.Key]*PinStatus)
+	for st := range out {
+		res[st.Key] = st
+	}
+	return res
+}
+
+func TestVerifyPins(t *testing.T) {
+	ctx := context.Background()
+	n, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := &mdag.Node{Data: []byte("good")}
+	missing := &mdag.Node{Data: []byte("missing")}
+	corrupt := &mdag.Node{Data: []byte("corrupt")}
+	root := &mdag.Node{Data: []byte("root")}
+	for name, child := range map[string]*mdag.Node{"good": good, "missing": missing, "corrupt": corrupt} {
+		if err := root.AddNodeLink(name, child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := n.DAG.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Pinning.Pin(ctx, good, false); err != nil {
+		t.Fatal(err)
+	}
+
+	rk, _ := root.Key()
+	gk, _ := good.Key()
+	mk, _ := missing.Key()
+	ck, _ := corrupt.Key()
+
+	if err := n.Blockstore.DeleteBlock(mk); err != nil {
+		t.Fatal(err)
+	}
+	// overwrite the block under its key with other data
+	if err := n.Blockstore.DeleteBlock(ck); err != nil {
+		t.Fatal(err)
+	}
+	bad, err := blocks.NewBlockWithHash([]byte("bit rot"), ck.ToMultihash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockstore.Put(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	res := collectStatus(t, n, false)
+	if st := res[gk]; st == nil || !st.Ok {
+		t.Fatal("direct pin should verify")
+	}
+	st := res[rk]
+	if st == nil || st.Ok {
+		t.Fatal("broken recursive pin should be reported")
+	}
+	if len(st.Missing) != 1 || st.Missing[0] != mk {
+		t.Fatalf("expected %s missing, got %v", mk, st.Missing)
+	}
+	if len(st.Corrupt) != 1 || st.Corrupt[0] != ck {
+		t.Fatalf("expected %s corrupt, got %v", ck, st.Corrupt)
+	}
+
+	// the offline exchange can't repair anything
+	res = collectStatus(t, n, true)
+	if st := res[rk]; st.Ok || len(st.Refetched) != 0 {
+		t.Fatal("nothing should have been refetched offline")
+	}
+}
+
+func TestVerifyBlocks(t *testing.T) {
+	ctx := context.Background()
+	n, err := core.NewMockNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := blocks.NewBlock([]byte("good"))
+	if err := n.Blockstore.Put(good); err != nil {
+		t.Fatal(err)
+	}
+	ck := blocks.NewBlock([]byte("corrupt")).Key()
+	bad, err := blocks.NewBlockWithHash([]byte("bit rot"), ck.ToMultihash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Blockstore.Put(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	collect := func(remove bool) []*CorruptBlock {
+		out, err := VerifyBlocks(n, ctx, remove)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []*CorruptBlock
+		for cb := range out {
+			res = append(res, cb)
+		}
+		return res
+	}
+
+	res := collect(false)
+	if len(res) != 1 || res[0].Key != ck || res[0].Removed {
+		t.Fatalf("expected only %s to be reported, got %v", ck, res)
+	}
+
+	res = collect(true)
+	if len(res) != 1 || !res[0].Removed {
+		t.Fatal("corrupt block should have been removed")
+	}
+	if has, _ := n.Blockstore.Has(ck); has {
+		t.Fatal("corrupt block is still stored")
+	}
+	if has, _ := n.Blockstore.Has(good.Key()); !has {
+		t.Fatal("good block should be kept")
+	}
+	if res := collect(false); len(res) != 0 {
+		t.Fatalf("nothing should be corrupt anymore, got %v", res)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"github.com/ipfs/go-ipfs/pin"
 	"github.com/ipfs/go-ipfs/thirdparty/eventlog"
 	unixfs "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 )
 
 var log = eventlog.Logger("coreunix")
@@ -25,6 +26,8 @@ var log = eventlog.Logger("coreunix")
 // Add builds a merkledag from the a reader, pinning all objects to the local
 // datastore. Returns a key representing the root node.
 func Add(n *core.IpfsNode, r io.Reader) (string, error) {
+	defer n.Blockstore.PinLock()()
+
 	// TODO more attractive function signature importer.BuildDagFromReader
 	dagNode, err := importer.BuildDagFromReader(
 		r,
@@ -48,6 +51,8 @@ func Add(n *core.IpfsNode, r io.Reader) (string, error) {
 
 // AddR recursively adds files in |path|.
 func AddR(n *core.IpfsNode, root string) (key string, err error) {
+	defer n.Blockstore.PinLock()()
+
 	f, err := os.Open(root)
 	if err != nil {
 		return "", err
@@ -57,7 +62,7 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 	if err != nil {
 		return "", err
 	}
-	dagnode, err := addFile(n, ff)
+	dagnode, err := addFile(n, ff, chunk.DefaultSplitter)
 	if err != nil {
 		return "", err
 	}
@@ -68,14 +73,16 @@ func AddR(n *core.IpfsNode, root string) (key string, err error) {
 	return k.String(), nil
 }
 
-// AddWrapped adds data from a reader, and wraps it with a directory object
-// to preserve the filename.
+// AddWrapped adds data from a reader, cut into blocks by spl, and wraps it
+// with a directory object to preserve the filename.
 // Returns the path of the added file ("<dir hash>/filename"), the DAG node of
 // the directory, and and error if any.
-func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkledag.Node, error) {
+// AddWrapped does not take the blockstore pin lock; callers must hold it
+// until the returned node has been pinned.
+func AddWrapped(n *core.IpfsNode, r io.Reader, filename string, spl chunk.BlockSplitter) (string, *merkledag.Node, error) {
 	file := files.NewReaderFile(filename, ioutil.NopCloser(r), nil)
 	dir := files.NewSliceFile("", []files.File{file})
-	dagnode, err := addDir(n, dir)
+	dagnode, err := addDir(n, dir, spl)
 	if err != nil {
 		return "", nil, err
 	}
@@ -86,13 +93,13 @@ func AddWrapped(n *core.IpfsNode, r io.Reader, filename string) (string, *merkle
 	return gopath.Join(k.String(), filename), dagnode, nil
 }
 
-func add(n *core.IpfsNode, reader io.Reader) (*merkledag.Node, error) {
+func add(n *core.IpfsNode, reader io.Reader, spl chunk.BlockSplitter) (*merkledag.Node, error) {
 	mp, ok := n.Pinning.(pin.ManualPinner)
 	if !ok {
 		return nil, errors.New("invalid pinner type! expected manual pinner")
 	}
 
-	node, err := importer.BuildDagFromReader(reader, n.DAG, mp, chunk.DefaultSplitter)
+	node, err := importer.BuildDagFromReader(reader, n.DAG, mp, spl)
 	if err != nil {
 		return nil, err
 	}
@@ -119,12 +126,15 @@ func addNode(n *core.IpfsNode, node *merkledag.Node) error {
 	return nil
 }
 
-func addFile(n *core.IpfsNode, file files.File) (*merkledag.Node, error) {
+func addFile(n *core.IpfsNode, file files.File, spl chunk.BlockSplitter) (*merkledag.Node, error) {
 	if file.IsDirectory() {
-		return addDir(n, file)
+		return addDir(n, file, spl)
+	}
+	if link, ok := file.(*files.Symlink); ok {
+		return addSymlink(n, link.Target)
 	}
 
-	dagnode, err := add(n, file)
+	dagnode, err := add(n, file, spl)
 	if err != nil {
 		return nil, err
 	}
@@ -132,9 +142,17 @@ func addFile(n *core.IpfsNode, file files.File) (*merkledag.Node, error) {
 	return dagnode, nil
 }
 
-func addDir(n *core.IpfsNode, dir files.File) (*merkledag.Node, error) {
+func addSymlink(n *core.IpfsNode, target string) (*merkledag.Node, error) {
+	dagnode := &merkledag.Node{Data: unixfs.SymlinkData(target)}
+	if _, err := n.DAG.Add(dagnode); err != nil {
+		return nil, err
+	}
+	return dagnode, nil
+}
+
+func addDir(n *core.IpfsNode, dir files.File, spl chunk.BlockSplitter) (*merkledag.Node, error) {
 
-	tree := &merkledag.Node{Data: unixfs.FolderPBData()}
+	tree := uio.NewDirectory(n.DAG)
 
 Loop:
 	for {
@@ -146,22 +164,26 @@ Loop:
 			break Loop
 		}
 
-		node, err := addFile(n, file)
+		node, err := addFile(n, file, spl)
 		if err != nil {
 			return nil, err
 		}
 
 		_, name := gopath.Split(file.FileName())
 
-		err = tree.AddNodeLink(name, node)
+		err = tree.AddChild(n.Context(), name, node)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	err := addNode(n, tree)
+	dirnode, err := tree.GetNode()
+	if err != nil {
+		return nil, err
+	}
+	err = addNode(n, dirnode)
 	if err != nil {
 		return nil, err
 	}
-	return tree, nil
+	return dirnode, nil
 }
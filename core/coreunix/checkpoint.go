@@ -0,0 +1,138 @@
+package coreunix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var checkpointsKey = ds.NewKey("/local/addcheckpoints")
+
+// LeavesPerCheckpoint is how many leaves an import stores between two
+// checkpoints, 64MB of file data with the default chunker.
+var LeavesPerCheckpoint = 256
+
+var errBadCheckpoint = errors.New("invalid import checkpoint")
+
+// Checkpointer records the leaves an import of a file has stored, so that
+// an interrupted import of the same file can be resumed rather than
+// started over. The leaves are saved in batches of LeavesPerCheckpoint,
+// under /local/addcheckpoints/<id>/<batch>.
+//
+// Batches left over by earlier attempts may remain past the last one an
+// import saves; as all attempts with an id import the same data the same
+// way, they hold the same leaves, and are simply picked up again.
+type Checkpointer struct {
+	dstore  ds.Datastore
+	key     ds.Key
+	batches int
+	skip    int // leaves loaded, which the import reports again
+	pending []h.Leaf
+}
+
+// CheckpointID identifies the imports of a file by desc, which must tell
+// apart all files and all ways of importing them.
+func CheckpointID(desc ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(desc, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewCheckpointer(d ds.Datastore, id string) *Checkpointer {
+	return &Checkpointer{
+		dstore: d,
+		key:    checkpointsKey.ChildString(id),
+	}
+}
+
+func (c *Checkpointer) batchKey(i int) ds.Key {
+	return c.key.ChildString(strconv.Itoa(i))
+}
+
+// Load returns the leaves saved by earlier imports, to resume from. It
+// returns none when any of their blocks is gone from bs.
+func (c *Checkpointer) Load(bs bstore.Blockstore) ([]h.Leaf, error) {
+	var leaves []h.Leaf
+	for {
+		v, err := c.dstore.Get(c.batchKey(c.batches))
+		if err == ds.ErrNotFound {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, errBadCheckpoint
+		}
+		var batch []h.Leaf
+		if err := json.Unmarshal(b, &batch); err != nil {
+			return nil, errBadCheckpoint
+		}
+		leaves = append(leaves, batch...)
+		c.batches++
+	}
+
+	for _, l := range leaves {
+		has, err := bs.Has(u.Key(l.Hash))
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			log.Infof("blocks of checkpoint %s are gone, starting over", c.key)
+			c.batches = 0
+			return nil, nil
+		}
+	}
+	c.skip = len(leaves)
+	return leaves, nil
+}
+
+// LeafAdded records l, saving a batch of leaves once it is complete. It is
+// meant as the LeafAdded callback of the importer.
+func (c *Checkpointer) LeafAdded(l h.Leaf) error {
+	if c.skip > 0 {
+		c.skip--
+		return nil
+	}
+
+	c.pending = append(c.pending, l)
+	if len(c.pending) < LeavesPerCheckpoint {
+		return nil
+	}
+
+	b, err := json.Marshal(c.pending)
+	if err != nil {
+		return err
+	}
+	if err := c.dstore.Put(c.batchKey(c.batches), b); err != nil {
+		return err
+	}
+	c.batches++
+	c.pending = nil
+	return nil
+}
+
+// Remove deletes all saved leaves, once the import is done.
+func (c *Checkpointer) Remove() error {
+	for i := 0; ; i++ {
+		has, err := c.dstore.Has(c.batchKey(i))
+		if err != nil {
+			return err
+		}
+		if !has {
+			return nil
+		}
+		if err := c.dstore.Delete(c.batchKey(i)); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package coreunix
+
+import (
+	"bytes"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+)
+
+func TestCheckpointer(t *testing.T) {
+	defer func(n int) { LeavesPerCheckpoint = n }(LeavesPerCheckpoint)
+	LeavesPerCheckpoint = 2
+
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	bs := bstore.NewBlockstore(dssync.MutexWrap(ds.NewMapDatastore()))
+	var leaves []h.Leaf
+	for i := 0; i < 5; i++ {
+		b := blocks.NewBlock([]byte{byte(i)})
+		if err := bs.Put(b); err != nil {
+			t.Fatal(err)
+		}
+		leaves = append(leaves, h.Leaf{Hash: b.Multihash, Size: 1, DataSize: 1, Raw: true})
+	}
+
+	id := CheckpointID("file", "5")
+	cp := NewCheckpointer(d, id)
+	for _, l := range leaves {
+		if err := cp.LeafAdded(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// only whole batches are saved
+	cp = NewCheckpointer(d, id)
+	loaded, err := cp.Load(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 4 {
+		t.Fatalf("expected 4 leaves, loaded %d", len(loaded))
+	}
+	for i, l := range loaded {
+		if !bytes.Equal(l.Hash, leaves[i].Hash) {
+			t.Fatalf("leaf %d differs", i)
+		}
+	}
+
+	// a resumed import reports the loaded leaves again, which are not
+	// saved twice
+	for _, l := range leaves {
+		if err := cp.LeafAdded(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if loaded, err = NewCheckpointer(d, id).Load(bs); err != nil || len(loaded) != 4 {
+		t.Fatalf("expected 4 leaves, loaded %d (%v)", len(loaded), err)
+	}
+
+	if loaded, err = NewCheckpointer(d, CheckpointID("other")).Load(bs); err != nil || len(loaded) != 0 {
+		t.Fatal("loaded leaves of another import")
+	}
+
+	if err := bs.DeleteBlock(blocks.NewBlock([]byte{3}).Key()); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = NewCheckpointer(d, id).Load(bs); err != nil || len(loaded) != 0 {
+		t.Fatal("loaded leaves whose blocks are gone")
+	}
+
+	if err := cp.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := d.Has(cp.batchKey(0)); has {
+		t.Fatal("checkpoint not removed")
+	}
+}
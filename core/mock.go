@@ -8,6 +8,8 @@ import (
 	"github.com/ipfs/go-ipfs/blocks/blockstore"
 	blockservice "github.com/ipfs/go-ipfs/blockservice"
 	"github.com/ipfs/go-ipfs/exchange/offline"
+	filestore "github.com/ipfs/go-ipfs/filestore"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	nsys "github.com/ipfs/go-ipfs/namesys"
 	mocknet "github.com/ipfs/go-ipfs/p2p/net/mock"
@@ -52,24 +54,28 @@ func NewMockNode() (*IpfsNode, error) {
 	nd.Repo = &repo.Mock{
 		// TODO C: conf,
 		D: ds2.CloserWrap(syncds.MutexWrap(datastore.NewMapDatastore())),
+		K: keystore.NewMemKeystore(),
 	}
 
 	// Routing
 	nd.Routing = offrt.NewOfflineRouter(nd.Repo.Datastore(), nd.PrivateKey)
 
 	// Bitswap
-	bstore := blockstore.NewBlockstore(nd.Repo.Datastore())
-	bserv, err := blockservice.New(bstore, offline.Exchange(bstore))
+	nd.Filestore = filestore.New(blockstore.NewBlockstore(nd.Repo.Datastore()), nd.Repo.Datastore())
+	nd.Blockstore = nd.Filestore
+	nd.Exchange = offline.Exchange(nd.Blockstore)
+	bserv, err := blockservice.New(nd.Blockstore, nd.Exchange)
 	if err != nil {
 		return nil, err
 	}
 
+	nd.Blocks = bserv
 	nd.DAG = mdag.NewDAGService(bserv)
 
 	nd.Pinning = pin.NewPinner(nd.Repo.Datastore(), nd.DAG)
 
 	// Namespace resolver
-	nd.Namesys = nsys.NewNameSystem(nd.Routing)
+	nd.Namesys = nsys.NewNameSystem(nd.Routing, nd.Repo.Datastore(), 0)
 
 	// Path resolver
 	nd.Resolver = &path.Resolver{DAG: nd.DAG}
@@ -215,7 +215,8 @@ func (bs *Bitswap) GetBlocks(ctx context.Context, keys []u.Key) (<-chan *blocks.
 }
 
 // HasBlock announces the existance of a block to this bitswap service. The
-// service will potentially notify its peers.
+// service will potentially notify its peers. A block the blockstore has
+// no room to cache is still delivered to the requests waiting for it.
 func (bs *Bitswap) HasBlock(ctx context.Context, blk *blocks.Block) error {
 	log.Event(ctx, "hasBlock", blk)
 	select {
@@ -224,7 +225,11 @@ func (bs *Bitswap) HasBlock(ctx context.Context, blk *blocks.Block) error {
 	default:
 	}
 
-	if err := bs.blockstore.Put(blk); err != nil {
+	switch err := bs.blockstore.Put(blk); err {
+	case nil:
+	case blockstore.ErrStorageFull:
+		log.Debugf("not caching %s: %s", blk.Key(), err)
+	default:
 		return err
 	}
 	bs.wantlist.Remove(blk.Key())
@@ -6,11 +6,13 @@ import (
 	"testing"
 	"time"
 
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	detectrace "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-detect-race"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	travis "github.com/ipfs/go-ipfs/util/testutil/ci/travis"
 
 	blocks "github.com/ipfs/go-ipfs/blocks"
+	blockstore "github.com/ipfs/go-ipfs/blocks/blockstore"
 	blocksutil "github.com/ipfs/go-ipfs/blocks/blocksutil"
 	tn "github.com/ipfs/go-ipfs/exchange/bitswap/testnet"
 	p2ptestutil "github.com/ipfs/go-ipfs/p2p/test/util"
@@ -89,6 +91,39 @@ func TestGetBlockFromPeerAfterPeerAnnounces(t *testing.T) {
 	}
 }
 
+func TestGetBlockWithFullBlockstore(t *testing.T) {
+	net := tn.VirtualNetwork(mockrouting.NewServer(), delay.Fixed(kNetworkDelay))
+	block := blocks.NewBlock([]byte("block"))
+	g := NewTestSessionGenerator(net)
+	defer g.Close()
+
+	hasBlock := g.Next()
+	defer hasBlock.Exchange.Close()
+	if err := hasBlock.Exchange.HasBlock(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+
+	full := g.NextWithBlockstore(func(d ds.ThreadSafeDatastore) blockstore.GCBlockstore {
+		return blockstore.NewQuotaBlockstore(d, func() bool { return true })
+	})
+	defer full.Exchange.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	received, err := full.Exchange.GetBlock(ctx, block.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(block.Data, received.Data) {
+		t.Fatal("Data doesn't match")
+	}
+
+	// the block was delivered, but not cached
+	if has, err := full.Blockstore().Has(block.Key()); err != nil || has {
+		t.Fatal("a full blockstore cached the block")
+	}
+}
+
 func TestLargeSwarm(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
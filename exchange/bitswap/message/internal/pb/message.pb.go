@@ -23,6 +23,7 @@ var _ = math.Inf
 type Message struct {
 	Wantlist         *Message_Wantlist `protobuf:"bytes,1,opt,name=wantlist" json:"wantlist,omitempty"`
 	Blocks           [][]byte          `protobuf:"bytes,2,rep,name=blocks" json:"blocks,omitempty"`
+	Keys             [][]byte          `protobuf:"bytes,3,rep,name=keys" json:"keys,omitempty"`
 	XXX_unrecognized []byte            `json:"-"`
 }
 
@@ -44,6 +45,13 @@ func (m *Message) GetBlocks() [][]byte {
 	return nil
 }
 
+func (m *Message) GetKeys() [][]byte {
+	if m != nil {
+		return m.Keys
+	}
+	return nil
+}
+
 type Message_Wantlist struct {
 	Entries          []*Message_Wantlist_Entry `protobuf:"bytes,1,rep,name=entries" json:"entries,omitempty"`
 	Full             *bool                     `protobuf:"varint,2,opt,name=full" json:"full,omitempty"`
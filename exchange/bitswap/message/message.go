@@ -71,17 +71,25 @@ type Entry struct {
 	Cancel bool
 }
 
-func newMessageFromProto(pbm pb.Message) BitSwapMessage {
+func newMessageFromProto(pbm pb.Message) (BitSwapMessage, error) {
 	m := newMsg()
 	m.SetFull(pbm.GetWantlist().GetFull())
 	for _, e := range pbm.GetWantlist().GetEntries() {
 		m.addEntry(u.Key(e.GetBlock()), int(e.GetPriority()), e.GetCancel())
 	}
-	for _, d := range pbm.GetBlocks() {
-		b := blocks.NewBlock(d)
-		m.AddBlock(b)
+	keys := pbm.GetKeys()
+	for i, d := range pbm.GetBlocks() {
+		if i >= len(keys) {
+			// peers that do not send keys only have sha2-256 blocks
+			m.AddBlock(blocks.NewBlock(d))
+			continue
+		}
+		if err := blocks.VerifyHash(d, keys[i]); err != nil {
+			return nil, err
+		}
+		m.AddBlock(&blocks.Block{Data: d, Multihash: keys[i]})
 	}
-	return m
+	return m, nil
 }
 
 func (m *impl) SetFull(full bool) {
@@ -144,8 +152,7 @@ func FromNet(r io.Reader) (BitSwapMessage, error) {
 		return nil, err
 	}
 
-	m := newMessageFromProto(*pb)
-	return m, nil
+	return newMessageFromProto(*pb)
 }
 
 func (m *impl) ToProto() *pb.Message {
@@ -160,6 +167,7 @@ func (m *impl) ToProto() *pb.Message {
 	}
 	for _, b := range m.Blocks() {
 		pbm.Blocks = append(pbm.Blocks, b.Data)
+		pbm.Keys = append(pbm.Keys, []byte(b.Multihash))
 	}
 	return pbm
 }
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 
 	blocks "github.com/ipfs/go-ipfs/blocks"
 	pb "github.com/ipfs/go-ipfs/exchange/bitswap/message/internal/pb"
@@ -32,7 +33,10 @@ func TestNewMessageFromProto(t *testing.T) {
 	if !wantlistContains(protoMessage.Wantlist, str) {
 		t.Fail()
 	}
-	m := newMessageFromProto(*protoMessage)
+	m, err := newMessageFromProto(*protoMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !wantlistContains(m.ToProto().GetWantlist(), str) {
 		t.Fail()
 	}
@@ -186,3 +190,26 @@ func TestDuplicates(t *testing.T) {
 		t.Fatal("Duplicate in BitSwapMessage")
 	}
 }
+
+func TestBlockKeys(t *testing.T) {
+	b, err := blocks.NewBlockWithHashFunc([]byte("sha3 block"), mh.SHA3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	m.AddBlock(b)
+
+	pbm := m.ToProto()
+	out, err := newMessageFromProto(*pbm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Blocks()) != 1 || out.Blocks()[0].Key() != b.Key() {
+		t.Fatal("a block not hashed with sha2-256 lost its key")
+	}
+
+	pbm.Blocks[0] = []byte("another block")
+	if _, err := newMessageFromProto(*pbm); err == nil {
+		t.Fatal("accepted a block that does not match its key")
+	}
+}
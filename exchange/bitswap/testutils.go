@@ -50,6 +50,16 @@ func (g *SessionGenerator) Next() Instance {
 	return session(g.ctx, g.net, p)
 }
 
+// NextWithBlockstore is Next, over the blockstore newBS returns.
+func (g *SessionGenerator) NextWithBlockstore(newBS func(ds.ThreadSafeDatastore) blockstore.GCBlockstore) Instance {
+	g.seq++
+	p, err := p2ptestutil.RandTestBogusIdentity()
+	if err != nil {
+		panic("FIXME") // TODO change signature
+	}
+	return sessionWithBlockstore(g.ctx, g.net, p, newBS)
+}
+
 func (g *SessionGenerator) Instances(n int) []Instance {
 	instances := make([]Instance, 0)
 	for j := 0; j < n; j++ {
@@ -81,13 +91,19 @@ func (i *Instance) SetBlockstoreLatency(t time.Duration) time.Duration {
 // sessions. To safeguard, use the SessionGenerator to generate sessions. It's
 // just a much better idea.
 func session(ctx context.Context, net tn.Network, p testutil.Identity) Instance {
+	return sessionWithBlockstore(ctx, net, p, blockstore.NewBlockstore)
+}
+
+// sessionWithBlockstore creates a test bitswap session over the blockstore
+// newBS returns.
+func sessionWithBlockstore(ctx context.Context, net tn.Network, p testutil.Identity, newBS func(ds.ThreadSafeDatastore) blockstore.GCBlockstore) Instance {
 	bsdelay := delay.Fixed(0)
 	const kWriteCacheElems = 100
 
 	adapter := net.Adapter(p)
 	dstore := ds_sync.MutexWrap(datastore2.WithDelay(ds.NewMapDatastore(), bsdelay))
 
-	bstore, err := blockstore.WriteCached(blockstore.NewBlockstore(ds_sync.MutexWrap(dstore)), kWriteCacheElems)
+	bstore, err := blockstore.WriteCached(newBS(ds_sync.MutexWrap(dstore)), kWriteCacheElems)
 	if err != nil {
 		panic(err.Error()) // FIXME perhaps change signature and return error.
 	}
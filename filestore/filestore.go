@@ -0,0 +1,298 @@
+// Package filestore implements a blockstore that keeps the blocks added
+// from files on disk as references to those files, rather than copying
+// their data into the repo.
+package filestore
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var log = eventlog.Logger("filestore")
+
+// FilestorePrefix namespaces the references in the datastore
+var FilestorePrefix = ds.NewKey("filestore")
+
+// ErrChanged is returned by Get for a block whose file no longer holds its
+// data.
+var ErrChanged = errors.New("filestore: referenced file changed")
+
+// DataObj is where the data of a block lies on disk.
+type DataObj struct {
+	FilePath string
+	Offset   uint64
+	Size     uint64
+}
+
+// Status is the state of the data of a referenced block.
+type Status string
+
+const (
+	StatusOk      Status = "ok"
+	StatusChanged Status = "changed" // the file holds other data there
+	StatusMissing Status = "missing" // the file is gone, or too short
+	StatusError   Status = "error"   // the file cannot be read
+)
+
+// Filestore is a Blockstore that stores blocks which come with a PosInfo
+// as references to their file, and all others in an underlying
+// blockstore. Referenced blocks are read back from their file, and
+// verified, on every Get.
+type Filestore struct {
+	bs   bstore.GCBlockstore
+	refs ds.Datastore
+}
+
+// New returns a Filestore over bs, which keeps its references in d.
+func New(bs bstore.GCBlockstore, d ds.ThreadSafeDatastore) *Filestore {
+	return &Filestore{
+		bs:   bs,
+		refs: dsns.Wrap(d, FilestorePrefix),
+	}
+}
+
+func (f *Filestore) Get(k u.Key) (*blocks.Block, error) {
+	b, err := f.bs.Get(k)
+	if err != bstore.ErrNotFound {
+		return b, err
+	}
+
+	obj, err := f.getRef(k)
+	if err == ds.ErrNotFound {
+		return nil, bstore.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, st := readRef(k, obj)
+	if st != StatusOk {
+		log.Warningf("block %s: %s is %s", k, obj.FilePath, st)
+		return nil, ErrChanged
+	}
+	return &blocks.Block{Multihash: mh.Multihash(k), Data: data}, nil
+}
+
+// Put stores b as a reference to its file if it has a PosInfo, and in the
+// underlying blockstore otherwise. The data of b is read back from the file
+// first: a block its file does not hold, as its PosInfo came from a client
+// which is wrong or lying, is copied instead.
+func (f *Filestore) Put(b *blocks.Block) error {
+	if b.PosInfo == nil {
+		return f.bs.Put(b)
+	}
+
+	// a copy is as good as a reference
+	if has, err := f.bs.Has(b.Key()); err != nil || has {
+		return err
+	}
+	obj := &DataObj{
+		FilePath: b.PosInfo.FullPath,
+		Offset:   b.PosInfo.Offset,
+		Size:     uint64(len(b.Data)),
+	}
+	if _, st := readRef(b.Key(), obj); st != StatusOk {
+		log.Warningf("block %s: %s is %s, copying it", b.Key(), obj.FilePath, st)
+		return f.bs.Put(b)
+	}
+	v, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return f.refs.Put(refKey(b.Key()), v)
+}
+
+func (f *Filestore) Has(k u.Key) (bool, error) {
+	has, err := f.bs.Has(k)
+	if err != nil || has {
+		return has, err
+	}
+	return f.refs.Has(refKey(k))
+}
+
+func (f *Filestore) DeleteBlock(k u.Key) error {
+	err := f.bs.DeleteBlock(k)
+	rerr := f.refs.Delete(refKey(k))
+	if rerr == ds.ErrNotFound {
+		return err
+	}
+	return rerr
+}
+
+// AllKeysChan returns the keys of the underlying blockstore, then those of
+// the referenced blocks.
+func (f *Filestore) AllKeysChan(ctx context.Context) (<-chan u.Key, error) {
+	keys, err := f.bs.AllKeysChan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := f.list(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan u.Key)
+	go func() {
+		defer close(out)
+		for k := range keys {
+			select {
+			case out <- k:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for r := range refs {
+			select {
+			case out <- r.Key:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (f *Filestore) GCLock() func() {
+	return f.bs.GCLock()
+}
+
+func (f *Filestore) PinLock() func() {
+	return f.bs.PinLock()
+}
+
+func (f *Filestore) GCRequested() bool {
+	return f.bs.GCRequested()
+}
+
+func (f *Filestore) Expect(k u.Key) func() {
+	return f.bs.Expect(k)
+}
+
+func (f *Filestore) ExpectedKeys() []u.Key {
+	return f.bs.ExpectedKeys()
+}
+
+// refKey is the key of the reference of block k. Binary keys do not
+// survive ds.NewKey, which cleans them as paths, so keys are stored in
+// base58.
+func refKey(k u.Key) ds.Key {
+	return ds.NewKey(k.B58String())
+}
+
+func (f *Filestore) getRef(k u.Key) (*DataObj, error) {
+	v, err := f.refs.Get(refKey(k))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRef(v)
+}
+
+func decodeRef(v interface{}) (*DataObj, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, bstore.ValueTypeMismatch
+	}
+	obj := new(DataObj)
+	if err := json.Unmarshal(b, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// readRef reads the data of block k from its file, and checks that it
+// still hashes to k.
+func readRef(k u.Key, obj *DataObj) ([]byte, Status) {
+	fi, err := os.Open(obj.FilePath)
+	if os.IsNotExist(err) {
+		return nil, StatusMissing
+	}
+	if err != nil {
+		return nil, StatusError
+	}
+	defer fi.Close()
+
+	data := make([]byte, obj.Size)
+	_, err = fi.ReadAt(data, int64(obj.Offset))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, StatusMissing
+	}
+	if err != nil {
+		return nil, StatusError
+	}
+	if blocks.VerifyHash(data, mh.Multihash(k)) != nil {
+		return nil, StatusChanged
+	}
+	return data, StatusOk
+}
+
+// ListRes is a referenced block, with the status of its data if it was
+// verified.
+type ListRes struct {
+	Key u.Key
+	DataObj
+	Status Status `json:",omitempty"`
+}
+
+// List returns all the blocks stored by reference.
+func (f *Filestore) List(ctx context.Context) (<-chan *ListRes, error) {
+	return f.list(ctx, false)
+}
+
+// Verify returns all the blocks stored by reference, with the status of
+// their data, read back from their file.
+func (f *Filestore) Verify(ctx context.Context) (<-chan *ListRes, error) {
+	return f.list(ctx, true)
+}
+
+func (f *Filestore) list(ctx context.Context, verify bool) (<-chan *ListRes, error) {
+	// datastore/namespace does *NOT* fix up Query.Prefix
+	res, err := f.refs.Query(dsq.Query{Prefix: FilestorePrefix.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *ListRes)
+	go func() {
+		defer close(out)
+		defer res.Close()
+
+		for e := range res.Next() {
+			if e.Error != nil {
+				log.Debug("filestore: query failed: ", e.Error)
+				return
+			}
+			k := u.B58KeyDecode(ds.NewKey(e.Key).BaseNamespace())
+			if k == "" {
+				log.Debugf("filestore: bad reference key %s", e.Key)
+				continue
+			}
+			obj, err := decodeRef(e.Value)
+			if err != nil {
+				log.Debugf("filestore: bad reference for %s: %s", k, err)
+				continue
+			}
+			r := &ListRes{Key: k, DataObj: *obj}
+			if verify {
+				_, r.Status = readRef(k, obj)
+			}
+
+			select {
+			case out <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
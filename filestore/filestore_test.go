@@ -0,0 +1,260 @@
+package filestore
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func newFilestore(t *testing.T) (*Filestore, string) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+	return New(bstore.NewBlockstore(d), d), dir
+}
+
+func listAll(t *testing.T, list func(context.Context) (<-chan *ListRes, error)) []*ListRes {
+	refs, err := list(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*ListRes
+	for r := range refs {
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestReferencedBlocks(t *testing.T) {
+	fs, dir := newFilestore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	data := make([]byte, 1000)
+	u.NewTimeSeededRand().Read(data)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ref := blocks.NewBlock(data[100:300])
+	ref.PosInfo = &blocks.PosInfo{FullPath: path, Offset: 100}
+	copied := blocks.NewBlock([]byte("copied"))
+	for _, b := range []*blocks.Block{ref, copied} {
+		if err := fs.Put(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, err := fs.Get(ref.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Data, data[100:300]) {
+		t.Fatal("read back wrong data")
+	}
+	if has, err := fs.Has(ref.Key()); err != nil || !has {
+		t.Fatal("referenced block not found")
+	}
+
+	refs := listAll(t, fs.List)
+	if len(refs) != 1 || refs[0].Key != ref.Key() || refs[0].FilePath != path || refs[0].Offset != 100 || refs[0].Size != 200 {
+		t.Fatalf("wrong references listed: %v", refs)
+	}
+	var keys []u.Key
+	ch, err := fs.AllKeysChan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range ch {
+		keys = append(keys, k)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	// the block is gone once its file changes
+	data[150]++
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Get(ref.Key()); err != ErrChanged {
+		t.Fatalf("expected ErrChanged, got %v", err)
+	}
+	if refs := listAll(t, fs.Verify); len(refs) != 1 || refs[0].Status != StatusChanged {
+		t.Fatalf("changed file not reported: %v", refs)
+	}
+
+	if err := ioutil.WriteFile(path, data[:200], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if refs := listAll(t, fs.Verify); len(refs) != 1 || refs[0].Status != StatusMissing {
+		t.Fatalf("truncated file not reported: %v", refs)
+	}
+
+	if err := fs.DeleteBlock(ref.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := fs.Has(ref.Key()); has {
+		t.Fatal("deleted block still stored")
+	}
+	if _, err := fs.Get(copied.Key()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutWrongReference(t *testing.T) {
+	fs, dir := newFilestore(t)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("what the file holds"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// claimed to be in a file which holds other data, or none
+	for _, pi := range []*blocks.PosInfo{
+		{FullPath: path},
+		{FullPath: path, Offset: 1000},
+		{FullPath: filepath.Join(dir, "nothing")},
+	} {
+		b := blocks.NewBlock([]byte("what the client sent"))
+		b.PosInfo = pi
+		if err := fs.Put(b); err != nil {
+			t.Fatal(err)
+		}
+		got, err := fs.Get(b.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data, b.Data) {
+			t.Fatal("read back wrong data")
+		}
+		if refs := listAll(t, fs.List); len(refs) != 0 {
+			t.Fatalf("block claimed at %v stored by reference", pi)
+		}
+		if err := fs.DeleteBlock(b.Key()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddNoCopy(t *testing.T) {
+	fs, dir := newFilestore(t)
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	data := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(data)
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := bserv.New(fs, offline.Exchange(fs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dserv := dag.NewDAGService(bs)
+	dbp := h.DagBuilderParams{
+		Dagserv:   dserv,
+		Maxlinks:  4,
+		RawLeaves: true,
+		FullPath:  path,
+	}
+	spl := &chunk.SizeSplitter{Size: 1000}
+	nd, err := bal.BalancedLayout(dbp.New(spl.Split(bytes.NewReader(data))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := listAll(t, fs.Verify)
+	if len(refs) != 10 {
+		t.Fatalf("expected the 10 leaves to be referenced, got %d", len(refs))
+	}
+	for _, r := range refs {
+		if r.Status != StatusOk || r.Offset%1000 != 0 {
+			t.Fatalf("bad reference: %v", r)
+		}
+	}
+
+	dr, err := uio.NewDagReader(ctx, nd, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("read back wrong data")
+	}
+}
+
+func TestKeyNotAPath(t *testing.T) {
+	fs, dir := newFilestore(t)
+	defer os.RemoveAll(dir)
+
+	// a block whose binary key is changed by cleaning it as a path, such
+	// as one ending with '/' or holding "//"
+	var data []byte
+	for i := 0; ; i++ {
+		data = []byte(fmt.Sprintf("block %d", i))
+		k := blocks.NewBlock(data).Key()
+		if u.KeyFromDsKey(k.DsKey()) != k {
+			break
+		}
+	}
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := blocks.NewBlock(data)
+	b.PosInfo = &blocks.PosInfo{FullPath: path}
+	if err := fs.Put(b); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := listAll(t, fs.Verify)
+	if len(refs) != 1 || refs[0].Key != b.Key() || refs[0].Status != StatusOk {
+		t.Fatalf("wrong references listed: %v", refs)
+	}
+	ch, err := fs.AllKeysChan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k := range ch {
+		if k != b.Key() {
+			t.Fatalf("listed key %s instead of %s", k, b.Key())
+		}
+	}
+	if _, err := fs.Get(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.DeleteBlock(b.Key()); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := fs.Has(b.Key()); has {
+		t.Fatal("deleted block still stored")
+	}
+}
@@ -35,7 +35,7 @@ func InitializeKeyspace(n *core.IpfsNode, key ci.PrivKey) error {
 		return err
 	}
 
-	pub := nsys.NewRoutingPublisher(n.Routing)
+	pub := nsys.NewRoutingPublisher(n.Routing, n.Repo.Datastore())
 	err = pub.Publish(n.Context(), key, path.FromKey(nodek))
 	if err != nil {
 		return err
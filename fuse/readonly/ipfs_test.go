@@ -112,6 +112,7 @@ func TestIpfsStressRead(t *testing.T) {
 	defer mnt.Close()
 
 	var ks []u.Key
+	var nds []*dag.Node
 	var paths []string
 
 	nobj := 50
@@ -126,6 +127,7 @@ func TestIpfsStressRead(t *testing.T) {
 		}
 
 		ks = append(ks, k)
+		nds = append(nds, fi)
 		paths = append(paths, k.String())
 	}
 
@@ -134,18 +136,22 @@ func TestIpfsStressRead(t *testing.T) {
 		db := uio.NewDirectory(nd.DAG)
 		for j := 0; j < 1+rand.Intn(10); j++ {
 			name := fmt.Sprintf("child%d", j)
-			err := db.AddChild(name, ks[rand.Intn(len(ks))])
+			err := db.AddChild(nd.Context(), name, nds[rand.Intn(len(nds))])
 			if err != nil {
 				t.Fatal(err)
 			}
 		}
-		newdir := db.GetNode()
+		newdir, err := db.GetNode()
+		if err != nil {
+			t.Fatal(err)
+		}
 		k, err := nd.DAG.Add(newdir)
 		if err != nil {
 			t.Fatal(err)
 		}
 
 		ks = append(ks, k)
+		nds = append(nds, newdir)
 		npaths := getPaths(t, nd, k.String(), newdir)
 		paths = append(paths, npaths...)
 	}
@@ -195,19 +201,18 @@ func TestIpfsBasicDirRead(t *testing.T) {
 
 	// Make a 'file'
 	fi, data := randObj(t, nd, 10000)
-	k, err := fi.Key()
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	// Make a directory and put that file in it
 	db := uio.NewDirectory(nd.DAG)
-	err = db.AddChild("actual", k)
+	err := db.AddChild(nd.Context(), "actual", fi)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	d1nd := db.GetNode()
+	d1nd, err := db.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
 	d1ndk, err := nd.DAG.Add(d1nd)
 	if err != nil {
 		t.Fatal(err)
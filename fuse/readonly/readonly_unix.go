@@ -6,6 +6,7 @@ package readonly
 import (
 	"io"
 	"os"
+	"syscall"
 
 	fuse "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse"
 	fs "github.com/ipfs/go-ipfs/Godeps/_workspace/src/bazil.org/fuse/fs"
@@ -15,6 +16,7 @@ import (
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
 	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	ftpb "github.com/ipfs/go-ipfs/unixfs/pb"
 	lgbl "github.com/ipfs/go-ipfs/util/eventlog/loggables"
@@ -90,19 +92,32 @@ func (s *Node) Attr() fuse.Attr {
 	if s.cached == nil {
 		s.loadData()
 	}
+
+	// the recorded permissions, without write access since the mount is
+	// read only
+	attrs := ft.AttrsOf(s.cached)
+	perm := func(def os.FileMode) os.FileMode {
+		if attrs.Mode != 0 {
+			return attrs.Mode &^ 0222
+		}
+		return def
+	}
+
 	switch s.cached.GetType() {
-	case ftpb.Data_Directory:
+	case ftpb.Data_Directory, ftpb.Data_HAMTShard:
 		return fuse.Attr{
-			Mode: os.ModeDir | 0555,
-			Uid:  uint32(os.Getuid()),
-			Gid:  uint32(os.Getgid()),
+			Mode:  os.ModeDir | perm(0555),
+			Mtime: attrs.ModTime,
+			Uid:   uint32(os.Getuid()),
+			Gid:   uint32(os.Getgid()),
 		}
 	case ftpb.Data_File:
 		size := s.cached.GetFilesize()
 		return fuse.Attr{
-			Mode:   0444,
+			Mode:   perm(0444),
 			Size:   uint64(size),
 			Blocks: uint64(len(s.Nd.Links)),
+			Mtime:  attrs.ModTime,
 			Uid:    uint32(os.Getuid()),
 			Gid:    uint32(os.Getgid()),
 		}
@@ -114,6 +129,14 @@ func (s *Node) Attr() fuse.Attr {
 			Uid:    uint32(os.Getuid()),
 			Gid:    uint32(os.Getgid()),
 		}
+	case ftpb.Data_Symlink:
+		return fuse.Attr{
+			Mode:  os.ModeSymlink | 0555,
+			Size:  uint64(len(s.cached.GetData())),
+			Mtime: attrs.ModTime,
+			Uid:   uint32(os.Getuid()),
+			Gid:   uint32(os.Getgid()),
+		}
 
 	default:
 		log.Debug("Invalid data type.")
@@ -136,8 +159,15 @@ func (s *Node) Lookup(ctx context.Context, name string) (fs.Node, error) {
 // ReadDirAll reads the link structure as directory entries
 func (s *Node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	log.Debug("Node ReadDir")
-	entries := make([]fuse.Dirent, len(s.Nd.Links))
-	for i, link := range s.Nd.Links {
+	links := s.Nd.Links
+	if dir, err := uio.NewDirectoryFromNode(s.Ipfs.DAG, s.Nd); err == nil {
+		links, err = dir.Links(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entries := make([]fuse.Dirent, len(links))
+	for i, link := range links {
 		n := link.Name
 		if len(n) == 0 {
 			n = link.Hash.B58String()
@@ -151,6 +181,19 @@ func (s *Node) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	return nil, fuse.ENOENT
 }
 
+// Readlink returns the target of a symlink node.
+func (s *Node) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	if s.cached == nil {
+		if err := s.loadData(); err != nil {
+			return "", err
+		}
+	}
+	if s.cached.GetType() != ftpb.Data_Symlink {
+		return "", fuse.Errno(syscall.EINVAL)
+	}
+	return string(s.cached.GetData()), nil
+}
+
 func (s *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
 
 	k, err := s.Nd.Key()
@@ -200,6 +243,7 @@ type roNode interface {
 	fs.HandleReader
 	fs.Node
 	fs.NodeStringLookuper
+	fs.NodeReadlinker
 }
 
 var _ roNode = (*Node)(nil)
@@ -0,0 +1,62 @@
+package chunk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FromString returns the splitter described by spec:
+//
+//	size-<bytes>            fixed size chunks
+//	rabin                   rabin chunks of DefaultBlockSize on average
+//	rabin-<avg>             rabin chunks of avg bytes on average
+//	rabin-<min>-<avg>-<max> rabin chunks with explicit bounds
+//
+// An empty spec or "default" returns DefaultSplitter.
+func FromString(spec string) (BlockSplitter, error) {
+	switch {
+	case spec == "" || spec == "default":
+		return DefaultSplitter, nil
+	case strings.HasPrefix(spec, "size-"):
+		size, err := strconv.Atoi(spec[len("size-"):])
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid chunker %q: size must be a positive number of bytes", spec)
+		}
+		return &SizeSplitter{Size: size}, nil
+	case spec == "rabin" || strings.HasPrefix(spec, "rabin-"):
+		return parseRabin(spec)
+	default:
+		return nil, fmt.Errorf("unknown chunker %q, expected size-<bytes> or rabin-<min>-<avg>-<max>", spec)
+	}
+}
+
+func parseRabin(spec string) (BlockSplitter, error) {
+	parts := strings.Split(spec, "-")[1:]
+	sizes := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid chunker %q: sizes must be positive numbers of bytes", spec)
+		}
+		sizes[i] = n
+	}
+
+	var rb *Rabin
+	var err error
+	switch len(sizes) {
+	case 0:
+		rb = NewRabin(DefaultBlockSize)
+	case 1:
+		avg := sizes[0]
+		rb, err = NewRabinMinMax(avg/3, avg, avg+avg/2)
+	case 3:
+		rb, err = NewRabinMinMax(sizes[0], sizes[1], sizes[2])
+	default:
+		return nil, fmt.Errorf("invalid chunker %q, expected rabin-<avg> or rabin-<min>-<avg>-<max>", spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunker %q: %s", spec, err)
+	}
+	return rb, nil
+}
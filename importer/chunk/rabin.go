@@ -2,93 +2,160 @@ package chunk
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"math"
 )
 
-type MaybeRabin struct {
-	mask         int
-	windowSize   int
-	MinBlockSize int
-	MaxBlockSize int
+// Pol is the irreducible polynomial of degree 53 over GF(2) used to compute
+// Rabin fingerprints. Changing it changes where every file is cut.
+const Pol = 0x3DA3358B4DC173
+
+// windowSize is the number of bytes the fingerprint is computed over.
+const windowSize = 64
+
+// tables precompute the effect of a byte leaving the window (out) and of
+// reducing the fingerprint after a byte is shifted in (mod).
+type tables struct {
+	out [256]uint64
+	mod [256]uint64
+}
+
+var polTables = newTables(Pol)
+
+// polShift is how far the top byte of a fingerprint is from bit zero.
+var polShift = uint(deg(Pol) - 8)
+
+func newTables(pol uint64) *tables {
+	t := new(tables)
+	k := uint(deg(pol))
+	for b := 0; b < 256; b++ {
+		// the fingerprint of b followed by windowSize-1 zeros, which is
+		// what b contributes just before it leaves the window
+		h := appendByte(0, byte(b), pol)
+		for i := 0; i < windowSize-1; i++ {
+			h = appendByte(h, 0, pol)
+		}
+		t.out[b] = h
+
+		// xoring mod[b] both clears the top byte b and adds its remainder
+		t.mod[b] = polMod(uint64(b)<<k, pol) | uint64(b)<<k
+	}
+	return t
+}
+
+// deg returns the degree of the polynomial p, or -1 for zero.
+func deg(p uint64) int {
+	d := -1
+	for ; p != 0; p >>= 1 {
+		d++
+	}
+	return d
+}
+
+// polMod returns x modulo p.
+func polMod(x, p uint64) uint64 {
+	dp := deg(p)
+	for dx := deg(x); dx >= dp; dx = deg(x) {
+		x ^= p << uint(dx-dp)
+	}
+	return x
+}
+
+func appendByte(h uint64, b byte, pol uint64) uint64 {
+	return polMod(h<<8|uint64(b), pol)
+}
+
+// fingerprint is a Rabin fingerprint of the last windowSize bytes.
+type fingerprint struct {
+	window [windowSize]byte
+	pos    int
+	digest uint64
 }
 
-func NewMaybeRabin(avgBlkSize int) *MaybeRabin {
-	blkbits := uint(math.Log2(float64(avgBlkSize)))
-	rb := new(MaybeRabin)
-	rb.mask = (1 << blkbits) - 1
-	rb.windowSize = 16 // probably a good number...
-	rb.MinBlockSize = avgBlkSize / 2
-	rb.MaxBlockSize = (avgBlkSize / 2) * 3
+func (f *fingerprint) reset() {
+	*f = fingerprint{}
+}
+
+func (f *fingerprint) slide(b byte) {
+	out := f.window[f.pos]
+	f.window[f.pos] = b
+	f.pos = (f.pos + 1) % windowSize
+
+	f.digest ^= polTables.out[out]
+	index := f.digest >> polShift
+	f.digest = (f.digest<<8 | uint64(b)) ^ polTables.mod[index]
+}
+
+// Rabin splits a stream at content defined boundaries: a chunk ends where
+// the fingerprint of its last bytes has its low bits clear. Since the cut
+// points only depend on nearby data, an insertion or a deletion only
+// changes the chunks around it, and the rest of the file deduplicates.
+type Rabin struct {
+	min, max int
+	mask     uint64
+}
+
+// NewRabin returns a Rabin splitter producing chunks of avgBlkSize bytes on
+// average, between a third and one and a half times that size. Sizes too
+// small to fill the fingerprint window are raised.
+func NewRabin(avgBlkSize int) *Rabin {
+	if avgBlkSize < 3*windowSize {
+		avgBlkSize = 3 * windowSize
+	}
+	rb, _ := NewRabinMinMax(avgBlkSize/3, avgBlkSize, avgBlkSize+avgBlkSize/2)
 	return rb
 }
 
-func (mr *MaybeRabin) Split(r io.Reader) chan []byte {
-	out := make(chan []byte, 16)
-	go func() {
-		inbuf := bufio.NewReader(r)
-		blkbuf := new(bytes.Buffer)
-
-		// some bullshit numbers i made up
-		a := 10         // honestly, no idea what this is
-		MOD := 33554383 // randomly chosen (seriously)
-		an := 1
-		rollingHash := 0
-
-		// Window is a circular buffer
-		window := make([]byte, mr.windowSize)
-		push := func(i int, val byte) (outval int) {
-			outval = int(window[i%len(window)])
-			window[i%len(window)] = val
-			return
-		}
+// NewRabinMinMax returns a Rabin splitter producing chunks of avg bytes on
+// average, and never smaller than min or larger than max bytes, except for
+// the last chunk. avg is rounded to a power of two.
+func NewRabinMinMax(min, avg, max int) (*Rabin, error) {
+	if min < windowSize {
+		return nil, fmt.Errorf("rabin min size must be at least %d bytes", windowSize)
+	}
+	if avg < min || max < avg {
+		return nil, fmt.Errorf("rabin sizes must satisfy min <= avg <= max, got %d, %d, %d", min, avg, max)
+	}
+	bits := uint(math.Floor(math.Log2(float64(avg)) + 0.5))
+	return &Rabin{
+		min:  min,
+		max:  max,
+		mask: 1<<bits - 1,
+	}, nil
+}
 
-		// Duplicate byte slice
-		dup := func(b []byte) []byte {
-			d := make([]byte, len(b))
-			copy(d, b)
-			return d
-		}
+func (rb *Rabin) Split(r io.Reader) chan []byte {
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
 
-		// Fill up the window
-		i := 0
-		for ; i < mr.windowSize; i++ {
-			b, err := inbuf.ReadByte()
+		in := bufio.NewReader(r)
+		var fp fingerprint
+		buf := make([]byte, 0, rb.max)
+		for {
+			b, err := in.ReadByte()
 			if err != nil {
-				fmt.Println(err)
+				if err != io.EOF {
+					log.Debugf("Block split error: %s", err)
+				}
+				if len(buf) > 0 {
+					out <- buf
+				}
 				return
 			}
-			blkbuf.WriteByte(b)
-			push(i, b)
-			rollingHash = (rollingHash*a + int(b)) % MOD
-			an = (an * a) % MOD
-		}
+			buf = append(buf, b)
 
-		for ; true; i++ {
-			b, err := inbuf.ReadByte()
-			if err != nil {
-				break
+			// the window only has to be full by the time a cut is allowed
+			if len(buf) > rb.min-windowSize {
+				fp.slide(b)
 			}
-			outval := push(i, b)
-			blkbuf.WriteByte(b)
-			rollingHash = (rollingHash*a + int(b) - an*outval) % MOD
-			if (rollingHash&mr.mask == mr.mask && blkbuf.Len() > mr.MinBlockSize) ||
-				blkbuf.Len() >= mr.MaxBlockSize {
-				out <- dup(blkbuf.Bytes())
-				blkbuf.Reset()
-			}
-
-			// Check if there are enough remaining
-			peek, err := inbuf.Peek(mr.windowSize)
-			if err != nil || len(peek) != mr.windowSize {
-				break
+			if (len(buf) >= rb.min && fp.digest&rb.mask == 0) || len(buf) >= rb.max {
+				out <- buf
+				buf = make([]byte, 0, rb.max)
+				fp.reset()
 			}
 		}
-		io.Copy(blkbuf, inbuf)
-		out <- blkbuf.Bytes()
-		close(out)
 	}()
 	return out
 }
@@ -0,0 +1,100 @@
+package chunk
+
+import (
+	"bytes"
+	"testing"
+)
+
+func chunkSet(t *testing.T, s BlockSplitter, data []byte) map[string]bool {
+	set := make(map[string]bool)
+	var whole []byte
+	for c := range s.Split(bytes.NewReader(data)) {
+		set[string(c)] = true
+		whole = append(whole, c...)
+	}
+	if !bytes.Equal(whole, data) {
+		t.Fatal("chunks do not add up to the input")
+	}
+	return set
+}
+
+func TestRabinSizes(t *testing.T) {
+	rb, err := NewRabinMinMax(1024, 4096, 8192)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := randBuf(t, 1<<20)
+	chunks := rb.Split(&clipReader{r: bytes.NewReader(data), size: 1000})
+
+	var sizes []int
+	for c := range chunks {
+		sizes = append(sizes, len(c))
+	}
+	for i, size := range sizes {
+		if size > 8192 || (size < 1024 && i != len(sizes)-1) {
+			t.Fatalf("chunk %d has size %d", i, size)
+		}
+	}
+	// (1<<20)/4096 = 256 chunks are expected, give or take
+	if len(sizes) < 128 || len(sizes) > 512 {
+		t.Fatalf("got %d chunks, expected about 256", len(sizes))
+	}
+}
+
+func TestRabinResistsShifts(t *testing.T) {
+	rb := NewRabin(4096)
+	data := randBuf(t, 1<<20)
+	shifted := append(append(randBuf(t, 100), data[:1<<19]...), data[1<<19+7:]...)
+
+	before := chunkSet(t, rb, data)
+	after := chunkSet(t, rb, shifted)
+	common := 0
+	for c := range after {
+		if before[c] {
+			common++
+		}
+	}
+	if common < len(before)*9/10 {
+		t.Fatalf("only %d of %d chunks survived an insertion and a deletion", common, len(before))
+	}
+}
+
+func TestRabinShortInput(t *testing.T) {
+	rb := NewRabin(4096)
+	chunkSet(t, rb, []byte("short"))
+	if len(chunkSet(t, rb, nil)) != 0 {
+		t.Fatal("empty input should produce no chunks")
+	}
+}
+
+func TestFromString(t *testing.T) {
+	good := map[string]BlockSplitter{
+		"":                    DefaultSplitter,
+		"size-1024":           &SizeSplitter{Size: 1024},
+		"rabin-128-512-1024":  &Rabin{min: 128, max: 1024, mask: 511},
+		"rabin-3000":          &Rabin{min: 1000, max: 4500, mask: 4095},
+		"rabin-100-4000-5000": &Rabin{min: 100, max: 5000, mask: 4095},
+	}
+	for spec, want := range good {
+		s, err := FromString(spec)
+		if err != nil {
+			t.Fatalf("%s: %s", spec, err)
+		}
+		switch w := want.(type) {
+		case *SizeSplitter:
+			if ss, ok := s.(*SizeSplitter); !ok || *ss != *w {
+				t.Fatalf("%s: got %#v", spec, s)
+			}
+		case *Rabin:
+			if rb, ok := s.(*Rabin); !ok || *rb != *w {
+				t.Fatalf("%s: got %#v", spec, s)
+			}
+		}
+	}
+
+	for _, spec := range []string{"size-", "size-0", "size-x", "rabin-1-2", "rabin-100", "rabin-10-20-30", "rabin-512-256-1024", "buzhash"} {
+		if _, err := FromString(spec); err == nil {
+			t.Fatalf("%s should be rejected", spec)
+		}
+	}
+}
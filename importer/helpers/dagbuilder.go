@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	blocks "github.com/ipfs/go-ipfs/blocks"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
 )
@@ -13,6 +15,24 @@ type DagBuilderHelper struct {
 	in       <-chan []byte
 	nextData []byte // the next item to return.
 	maxlinks int
+
+	rawLeaves bool
+	hashFn    int
+
+	resume    []Leaf
+	leafAdded func(Leaf) error
+
+	fullPath string
+	offset   uint64 // of the next chunk in the file
+}
+
+// Leaf describes a stored leaf of a file dag, enough to link to it again
+// without its data.
+type Leaf struct {
+	Hash     mh.Multihash
+	Size     uint64 // the size of the leaf, as recorded in links to it
+	DataSize uint64 // the size of the file data it holds
+	Raw      bool
 }
 
 type DagBuilderParams struct {
@@ -24,16 +44,49 @@ type DagBuilderParams struct {
 
 	// Pinner to use for pinning files (optionally nil)
 	Pinner pin.ManualPinner
+
+	// Store the leaves as raw blocks of file data, rather than as unixfs
+	// nodes
+	RawLeaves bool
+
+	// The multihash function to hash the nodes with, one of u.HashFuncs.
+	// Zero is the default, SHA2_256.
+	HashFunc int
+
+	// Leaves stored by an interrupted import of the same data, built with
+	// the same params. They are linked in place of the first chunks, and
+	// the input must start after the data they hold.
+	Resume []Leaf
+
+	// Called with every leaf of the file, in order, once it is stored
+	// (optionally nil)
+	LeafAdded func(Leaf) error
+
+	// The absolute path of the file the input is read from, to let the
+	// blockstore keep raw leaves by reference to it rather than copying
+	// their data (optionally empty)
+	FullPath string
 }
 
 // Generate a new DagBuilderHelper from the given params, using 'in' as a
 // data source
 func (dbp *DagBuilderParams) New(in <-chan []byte) *DagBuilderHelper {
+	var offset uint64
+	for _, l := range dbp.Resume {
+		offset += l.DataSize
+	}
+
 	return &DagBuilderHelper{
-		dserv:    dbp.Dagserv,
-		mp:       dbp.Pinner,
-		in:       in,
-		maxlinks: dbp.Maxlinks,
+		dserv:     dbp.Dagserv,
+		mp:        dbp.Pinner,
+		in:        in,
+		maxlinks:  dbp.Maxlinks,
+		rawLeaves: dbp.RawLeaves,
+		hashFn:    dbp.HashFunc,
+		resume:    dbp.Resume,
+		leafAdded: dbp.LeafAdded,
+		fullPath:  dbp.FullPath,
+		offset:    offset,
 	}
 }
 
@@ -64,6 +117,9 @@ func (db *DagBuilderHelper) prepareNext() {
 func (db *DagBuilderHelper) Done() bool {
 	// ensure we have an accurate perspective on data
 	// as `done` this may be called before `next`.
+	if len(db.resume) > 0 {
+		return false
+	}
 	db.prepareNext() // idempotent
 	return db.nextData == nil
 }
@@ -106,6 +162,12 @@ func (db *DagBuilderHelper) FillNodeLayer(node *UnixfsNode) error {
 }
 
 func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
+	if len(db.resume) > 0 {
+		node.stored = &db.resume[0]
+		db.resume = db.resume[1:]
+		return nil
+	}
+
 	data := db.Next()
 	if data == nil { // we're done!
 		return nil
@@ -116,14 +178,25 @@ func (db *DagBuilderHelper) FillNodeWithData(node *UnixfsNode) error {
 	}
 
 	node.SetData(data)
+	if db.fullPath != "" {
+		node.posInfo = &blocks.PosInfo{FullPath: db.fullPath, Offset: db.offset}
+	}
+	db.offset += uint64(len(data))
 	return nil
 }
 
 func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
+	if node.stored != nil {
+		return node.getStored(db.dserv)
+	}
+
 	dn, err := node.GetDagNode()
 	if err != nil {
 		return nil, err
 	}
+	if err := db.setHashFunc(dn); err != nil {
+		return nil, err
+	}
 
 	key, err := db.dserv.Add(dn)
 	if err != nil {
@@ -141,6 +214,15 @@ func (db *DagBuilderHelper) Add(node *UnixfsNode) (*dag.Node, error) {
 	return dn, nil
 }
 
+// setHashFunc makes nd hashed with the function of the builder, if it has
+// one.
+func (db *DagBuilderHelper) setHashFunc(nd *dag.Node) error {
+	if db.hashFn == 0 {
+		return nil
+	}
+	return nd.SetHashFunc(db.hashFn)
+}
+
 func (db *DagBuilderHelper) Maxlinks() int {
 	return db.maxlinks
 }
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	"github.com/ipfs/go-ipfs/pin"
@@ -42,6 +43,15 @@ var ErrSizeLimitExceeded = fmt.Errorf("object size limit exceeded")
 type UnixfsNode struct {
 	node *dag.Node
 	ufmt *ft.FSNode
+
+	// whether the node is stored as a raw block, while it has no children
+	raw bool
+
+	// a leaf already stored, which the node stands for
+	stored *Leaf
+
+	// where the data of the node lies in the imported file, if known
+	posInfo *blocks.PosInfo
 }
 
 // NewUnixfsNode creates a new Unixfs node to represent a file
@@ -62,6 +72,14 @@ func NewUnixfsBlock() *UnixfsNode {
 
 // NewUnixfsNodeFromDag reconstructs a Unixfs node from a given dag node
 func NewUnixfsNodeFromDag(nd *dag.Node) (*UnixfsNode, error) {
+	if nd.IsRaw() {
+		return &UnixfsNode{
+			node: new(dag.Node),
+			ufmt: &ft.FSNode{Type: ft.TRaw, Data: nd.Data},
+			raw:  true,
+		}, nil
+	}
+
 	mb, err := ft.FSNodeFromBytes(nd.Data)
 	if err != nil {
 		return nil, err
@@ -93,12 +111,23 @@ func (n *UnixfsNode) GetChild(i int, ds dag.DAGService) (*UnixfsNode, error) {
 // the passed in DagBuilderHelper is used to store the child node an
 // pin it locally so it doesnt get lost
 func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
+	if child.stored != nil {
+		return n.addStoredChild(*child.stored, db)
+	}
+
 	n.ufmt.AddBlockSize(child.ufmt.FileSize())
 
+	if db.rawLeaves && child.NumChildren() == 0 {
+		child.raw = true
+	}
+
 	childnode, err := child.GetDagNode()
 	if err != nil {
 		return err
 	}
+	if err := db.setHashFunc(childnode); err != nil {
+		return err
+	}
 
 	// Add a link to this node without storing a reference to the memory
 	// This way, we avoid nodes building up and consuming all of our RAM
@@ -117,9 +146,47 @@ func (n *UnixfsNode) AddChild(child *UnixfsNode, db *DagBuilderHelper) error {
 		db.mp.PinWithMode(childkey, pin.Indirect)
 	}
 
+	if db.leafAdded != nil && child.NumChildren() == 0 {
+		lnk := n.node.Links[len(n.node.Links)-1]
+		return db.leafAdded(Leaf{
+			Hash:     lnk.Hash,
+			Size:     lnk.Size,
+			DataSize: child.ufmt.FileSize(),
+			Raw:      lnk.Raw,
+		})
+	}
 	return nil
 }
 
+// addStoredChild links the stored leaf l as a child of the receiver.
+func (n *UnixfsNode) addStoredChild(l Leaf, db *DagBuilderHelper) error {
+	n.ufmt.AddBlockSize(l.DataSize)
+	n.node.AddRawLink("", &dag.Link{
+		Hash: l.Hash,
+		Size: l.Size,
+		Raw:  l.Raw,
+	})
+
+	if db.mp != nil {
+		db.mp.PinWithMode(u.Key(l.Hash), pin.Indirect)
+	}
+
+	if db.leafAdded != nil {
+		return db.leafAdded(l)
+	}
+	return nil
+}
+
+// getStored fetches the stored leaf the node stands for.
+func (n *UnixfsNode) getStored(ds dag.DAGService) (*dag.Node, error) {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	// the link knows whether the leaf is a raw block
+	lnk := &dag.Link{Hash: n.stored.Hash, Size: n.stored.Size, Raw: n.stored.Raw}
+	return lnk.GetNode(ctx, ds)
+}
+
 // Removes the child node at the given index
 func (n *UnixfsNode) RemoveChild(index int, dbh *DagBuilderHelper) {
 	k := u.Key(n.node.Links[index].Hash)
@@ -137,6 +204,12 @@ func (n *UnixfsNode) SetData(data []byte) {
 // getDagNode fills out the proper formatting for the unixfs node
 // inside of a DAG node and returns the dag node
 func (n *UnixfsNode) GetDagNode() (*dag.Node, error) {
+	if n.raw && n.NumChildren() == 0 {
+		nd := dag.NewRawNode(n.ufmt.Data)
+		nd.PosInfo = n.posInfo
+		return nd, nil
+	}
+
 	data, err := n.ufmt.GetBytes()
 	if err != nil {
 		return nil, err
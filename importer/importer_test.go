@@ -6,8 +6,12 @@ import (
 	"io/ioutil"
 	"testing"
 
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bal "github.com/ipfs/go-ipfs/importer/balanced"
 	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	h "github.com/ipfs/go-ipfs/importer/helpers"
+	trickle "github.com/ipfs/go-ipfs/importer/trickle"
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
@@ -60,6 +64,172 @@ func TestBalancedDag(t *testing.T) {
 	}
 }
 
+func TestRawLeaves(t *testing.T) {
+	ds := mdtest.Mock(t)
+	buf := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(buf)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:   ds,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		RawLeaves: true,
+	}
+	spl := &chunk.SizeSplitter{Size: 1000}
+	nd, err := bal.BalancedLayout(dbp.New(spl.Split(bytes.NewReader(buf))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every leaf hashes like the data it holds
+	for i, lnk := range nd.Links {
+		if !lnk.Raw {
+			t.Fatalf("leaf %d is not raw", i)
+		}
+		if !bytes.Equal(lnk.Hash, u.Hash(buf[i*1000:(i+1)*1000])) {
+			t.Fatalf("leaf %d does not hash like its data", i)
+		}
+	}
+
+	dr, err := uio.NewDagReader(context.TODO(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	ds := mdtest.Mock(t)
+	buf := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(buf)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:  ds,
+		Maxlinks: 4,
+		HashFunc: mh.SHA2_512,
+	}
+	spl := &chunk.SizeSplitter{Size: 500}
+	nd, err := bal.BalancedLayout(dbp.New(spl.Split(bytes.NewReader(buf))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every node of the dag, as read back, is hashed with sha2-512
+	var check func(*dag.Node)
+	check = func(nd *dag.Node) {
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := mh.Decode([]byte(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dec.Code != mh.SHA2_512 {
+			t.Fatalf("node hashed with %s", dec.Name)
+		}
+		for _, lnk := range nd.Links {
+			child, err := lnk.GetNode(context.TODO(), ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check(child)
+		}
+	}
+	check(nd)
+
+	dr, err := uio.NewDagReader(context.TODO(), nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, buf) {
+		t.Fatal("bad read")
+	}
+}
+
+func TestResume(t *testing.T) {
+	buf := make([]byte, 10000)
+	u.NewTimeSeededRand().Read(buf)
+
+	for _, layout := range []func(*h.DagBuilderHelper) (*dag.Node, error){bal.BalancedLayout, trickle.TrickleLayout} {
+		ds := mdtest.Mock(t)
+		var leaves []h.Leaf
+		dbp := h.DagBuilderParams{
+			Dagserv:   ds,
+			Maxlinks:  4,
+			RawLeaves: true,
+			LeafAdded: func(l h.Leaf) error {
+				leaves = append(leaves, l)
+				return nil
+			},
+		}
+		spl := &chunk.SizeSplitter{Size: 500}
+		full, err := layout(dbp.New(spl.Split(bytes.NewReader(buf))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leaves) != 20 {
+			t.Fatalf("expected 20 leaves, got %d", len(leaves))
+		}
+
+		// resume after the first 7 leaves, as if interrupted there
+		var resumed []h.Leaf
+		dbp.Resume = leaves[:7]
+		dbp.LeafAdded = func(l h.Leaf) error {
+			resumed = append(resumed, l)
+			return nil
+		}
+		nd, err := layout(dbp.New(spl.Split(bytes.NewReader(buf[7*500:]))))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fullk, err := full.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k != fullk {
+			t.Fatal("resumed import differs from the full one")
+		}
+		if len(resumed) != len(leaves) {
+			t.Fatalf("resumed import reported %d leaves, expected %d", len(resumed), len(leaves))
+		}
+
+		// a file of a single raw leaf, found whole
+		dbp.Resume = leaves[:1]
+		dbp.LeafAdded = nil
+		in := make(chan []byte)
+		close(in)
+		nd, err = layout(dbp.New(in))
+		if err != nil {
+			t.Fatal("resuming a file of a single raw leaf:", err)
+		}
+		if len(nd.Links) == 1 {
+			// the trickle layout puts even a single leaf under a root
+			nd, err = nd.Links[0].GetNode(context.Background(), ds)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if k, err := nd.Key(); err != nil || k != u.Key(leaves[0].Hash) || !nd.IsRaw() {
+			t.Fatalf("resumed single leaf differs from the stored one: %s %v", k, err)
+		}
+	}
+}
+
 func BenchmarkBalancedReadSmallBlock(b *testing.B) {
 	b.StopTimer()
 	nbytes := int64(10000000)
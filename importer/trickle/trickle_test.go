@@ -123,11 +123,11 @@ func arrComp(a, b []byte) error {
 	return nil
 }
 
-func TestMaybeRabinConsistency(t *testing.T) {
+func TestRabinConsistency(t *testing.T) {
 	if testing.Short() {
 		t.SkipNow()
 	}
-	testFileConsistency(t, chunk.NewMaybeRabin(4096), 256*4096)
+	testFileConsistency(t, chunk.NewRabin(4096), 256*4096)
 }
 
 func TestRabinBlockSize(t *testing.T) {
@@ -137,7 +137,7 @@ func TestRabinBlockSize(t *testing.T) {
 	buf := new(bytes.Buffer)
 	nbytes := 1024 * 1024
 	io.CopyN(buf, rand.Reader, int64(nbytes))
-	rab := chunk.NewMaybeRabin(4096)
+	rab := chunk.NewRabin(4096)
 	blkch := rab.Split(buf)
 
 	var blocks [][]byte
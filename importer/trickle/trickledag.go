@@ -236,6 +236,9 @@ func verifyTDagRec(nd *dag.Node, depth, direct, layerRepeat int, ds dag.DAGServi
 		if len(nd.Links) > 0 {
 			return errors.New("expected direct block")
 		}
+		if nd.IsRaw() {
+			return nil
+		}
 
 		pbn, err := ft.FromBytes(nd.Data)
 		if err != nil {
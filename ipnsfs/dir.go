@@ -11,6 +11,7 @@ import (
 
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	ufspb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
 
@@ -24,21 +25,25 @@ type Directory struct {
 	childDirs map[string]*Directory
 	files     map[string]*File
 
-	lock sync.Mutex
-	node *dag.Node
+	lock       sync.Mutex
+	dirbuilder *uio.Directory
 
 	name string
 }
 
-func NewDirectory(name string, node *dag.Node, parent childCloser, fs *Filesystem) *Directory {
-	return &Directory{
-		fs:        fs,
-		name:      name,
-		node:      node,
-		parent:    parent,
-		childDirs: make(map[string]*Directory),
-		files:     make(map[string]*File),
+func NewDirectory(name string, node *dag.Node, parent childCloser, fs *Filesystem) (*Directory, error) {
+	db, err := uio.NewDirectoryFromNode(fs.dserv, node)
+	if err != nil {
+		return nil, err
 	}
+	return &Directory{
+		fs:         fs,
+		name:       name,
+		dirbuilder: db,
+		parent:     parent,
+		childDirs:  make(map[string]*Directory),
+		files:      make(map[string]*File),
+	}, nil
 }
 
 // closeChild updates the child by the given name to the dag node 'nd'
@@ -51,17 +56,24 @@ func (d *Directory) closeChild(name string, nd *dag.Node) error {
 
 	d.lock.Lock()
 	defer d.lock.Unlock()
-	err = d.node.RemoveNodeLink(name)
-	if err != nil && err != dag.ErrNotFound {
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	err = d.dirbuilder.AddChild(ctx, name, nd)
+	if err != nil {
 		return err
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	return d.closeSelf()
+}
+
+// closeSelf propagates the current node of this directory to its parent
+func (d *Directory) closeSelf() error {
+	nd, err := d.dirbuilder.GetNode()
 	if err != nil {
 		return err
 	}
-
-	return d.parent.closeChild(d.name, d.node)
+	return d.parent.closeChild(d.name, nd)
 }
 
 func (d *Directory) Type() NodeType {
@@ -85,7 +97,7 @@ func (d *Directory) childFile(name string) (*File, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
 		return nil, ErrIsDirectory
 	case ufspb.Data_File:
 		nfi, err := NewFile(name, nd, d, d.fs)
@@ -120,8 +132,11 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 	}
 
 	switch i.GetType() {
-	case ufspb.Data_Directory:
-		ndir := NewDirectory(name, nd, d, d.fs)
+	case ufspb.Data_Directory, ufspb.Data_HAMTShard:
+		ndir, err := NewDirectory(name, nd, d, d.fs)
+		if err != nil {
+			return nil, err
+		}
 		d.childDirs[name] = ndir
 		return ndir, nil
 	case ufspb.Data_File:
@@ -136,16 +151,10 @@ func (d *Directory) childDir(name string) (*Directory, error) {
 // childFromDag searches through this directories dag node for a child link
 // with the given name
 func (d *Directory) childFromDag(name string) (*dag.Node, error) {
-	for _, lnk := range d.node.Links {
-		if lnk.Name == name {
-			ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
-			defer cancel()
-
-			return lnk.GetNode(ctx, d.fs.dserv)
-		}
-	}
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
 
-	return nil, os.ErrNotExist
+	return d.dirbuilder.Find(ctx, name)
 }
 
 // Child returns the child of this directory by the given name
@@ -174,8 +183,17 @@ func (d *Directory) List() []string {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	links, err := d.dirbuilder.Links(ctx)
+	if err != nil {
+		log.Errorf("listing %s: %s", d.name, err)
+		return nil
+	}
+
 	var out []string
-	for _, lnk := range d.node.Links {
+	for _, lnk := range links {
 		out = append(out, lnk.Name)
 	}
 	return out
@@ -194,13 +212,20 @@ func (d *Directory) Mkdir(name string) (*Directory, error) {
 		return nil, os.ErrExist
 	}
 
-	ndir := &dag.Node{Data: ft.FolderPBData()}
-	err = d.node.AddNodeLinkClean(name, ndir)
+	ndir := uio.NewEmptyDirectory()
+	if _, err := d.fs.dserv.Add(ndir); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	err = d.dirbuilder.AddChild(ctx, name, ndir)
 	if err != nil {
 		return nil, err
 	}
 
-	err = d.parent.closeChild(d.name, d.node)
+	err = d.closeSelf()
 	if err != nil {
 		return nil, err
 	}
@@ -215,12 +240,15 @@ func (d *Directory) Unlink(name string) error {
 	delete(d.childDirs, name)
 	delete(d.files, name)
 
-	err := d.node.RemoveNodeLink(name)
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	err := d.dirbuilder.RemoveChild(ctx, name)
 	if err != nil {
 		return err
 	}
 
-	return d.parent.closeChild(d.name, d.node)
+	return d.closeSelf()
 }
 
 // AddChild adds the node 'nd' under this directory giving it the name 'name'
@@ -237,14 +265,21 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 		return errors.New("directory already has entry by that name")
 	}
 
-	err = d.node.AddNodeLinkClean(name, nd)
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
+	defer cancel()
+
+	err = d.dirbuilder.AddChild(ctx, name, nd)
 	if err != nil {
 		return err
 	}
 
 	switch pbn.GetType() {
-	case ft.TDirectory:
-		d.childDirs[name] = NewDirectory(name, nd, d, d.fs)
+	case ft.TDirectory, ft.THAMTShard:
+		ndir, err := NewDirectory(name, nd, d, d.fs)
+		if err != nil {
+			return err
+		}
+		d.childDirs[name] = ndir
 	case ft.TFile, ft.TMetadata, ft.TRaw:
 		nfi, err := NewFile(name, nd, d, d.fs)
 		if err != nil {
@@ -254,11 +289,11 @@ func (d *Directory) AddChild(name string, nd *dag.Node) error {
 	default:
 		return ErrInvalidChild
 	}
-	return d.parent.closeChild(d.name, d.node)
+	return d.closeSelf()
 }
 
 func (d *Directory) GetNode() (*dag.Node, error) {
-	return d.node, nil
+	return d.dirbuilder.GetNode()
 }
 
 func (d *Directory) Lock() {
@@ -0,0 +1,398 @@
+// Package keystore keeps the named private keys of a node, which it can
+// publish IPNS names with besides its identity key.
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/crypto/scrypt"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+)
+
+// EnvPassphrase names the environment variable holding the passphrase the
+// keys of a repo keystore are encrypted with.
+const EnvPassphrase = "IPFS_KEYSTORE_PASSPHRASE"
+
+// ErrNoSuchKey is returned for a name with no key.
+var ErrNoSuchKey = errors.New("no key by the given name was found")
+
+// ErrKeyExists is returned when a key is stored under a name already
+// taken.
+var ErrKeyExists = errors.New("key by that name already exists, refusing to overwrite")
+
+// ErrBadKeyData is returned for a key file which does not hold a key, as
+// it has been tampered with or was encrypted with another passphrase.
+var ErrBadKeyData = errors.New("key data cannot be read, wrong passphrase?")
+
+// ErrNoPassphrase is returned when reading or storing a key of a keystore
+// which was given no passphrase.
+var ErrNoPassphrase = fmt.Errorf("the keystore needs a passphrase, set %s", EnvPassphrase)
+
+// Keystore stores private keys by name.
+type Keystore interface {
+	// Has returns whether there is a key named name.
+	Has(name string) (bool, error)
+	// Put stores k as name, unless there is a key by that name already.
+	Put(name string, k ci.PrivKey) error
+	// Get returns the key named name, or ErrNoSuchKey.
+	Get(name string) (ci.PrivKey, error)
+	// Delete removes the key named name.
+	Delete(name string) error
+	// Rename gives the key named from the name to.
+	Rename(from, to string) error
+	// List returns the names of all stored keys, sorted.
+	List() ([]string, error)
+}
+
+// ValidateName checks that name can name a key: it must be a single path
+// element, not hidden.
+func ValidateName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("key names must not be empty")
+	case strings.ContainsAny(name, `/\`):
+		return fmt.Errorf("key names must not contain slashes")
+	case strings.HasPrefix(name, "."):
+		return fmt.Errorf("key names must not start with a dot")
+	}
+	return nil
+}
+
+// FSKeystore is a Keystore that keeps each key in a file of a directory
+// only its owner can read.
+//
+// The keys are encrypted with AES-256-GCM, under a key derived with scrypt
+// from the passphrase of the user and a salt of the file. A file holds a
+// header (format version, scrypt parameters, salt), the nonce and the
+// sealed key; the header is authenticated along with the key.
+type FSKeystore struct {
+	dir        string
+	passphrase []byte
+
+	// aeads caches the ciphers derived from the passphrase, by salt, as
+	// scrypt is slow on purpose.
+	aeads map[string]cipher.AEAD
+	salt  []byte // salt of the keys Put
+	lk    sync.Mutex
+}
+
+const (
+	keyFileVersion = 1
+	saltLen        = 16
+	headerLen      = 4 + saltLen
+)
+
+// scrypt parameters of the keys Put, tests lower scryptLogN.
+var (
+	scryptLogN byte = 15
+	scryptR    byte = 8
+	scryptP    byte = 1
+)
+
+// NewFSKeystore returns a keystore in dir, creating it if need be, which
+// encrypts the keys with passphrase. Without a passphrase the keys can be
+// listed, renamed and removed, but not read or stored.
+func NewFSKeystore(dir string, passphrase []byte) (*FSKeystore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FSKeystore{
+		dir:        dir,
+		passphrase: passphrase,
+		aeads:      make(map[string]cipher.AEAD),
+	}, nil
+}
+
+// aead returns the cipher of the files with header hdr.
+func (ks *FSKeystore) aead(hdr []byte) (cipher.AEAD, error) {
+	if len(ks.passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+	if len(hdr) != headerLen || hdr[0] != keyFileVersion {
+		return nil, ErrBadKeyData
+	}
+	logN, r, p, salt := hdr[1], int(hdr[2]), int(hdr[3]), hdr[4:]
+
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	if aead, ok := ks.aeads[string(salt)]; ok {
+		return aead, nil
+	}
+	if logN == 0 || logN > 30 {
+		return nil, ErrBadKeyData
+	}
+	key, err := scrypt.Key(ks.passphrase, salt, 1<<logN, r, p, 32)
+	if err != nil {
+		return nil, ErrBadKeyData
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	ks.aeads[string(salt)] = aead
+	return aead, nil
+}
+
+// header returns the header of the files Put, drawing the salt on first
+// use.
+func (ks *FSKeystore) header() ([]byte, error) {
+	ks.lk.Lock()
+	if ks.salt == nil {
+		salt := make([]byte, saltLen)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			ks.lk.Unlock()
+			return nil, err
+		}
+		ks.salt = salt
+	}
+	salt := ks.salt
+	ks.lk.Unlock()
+
+	hdr := []byte{keyFileVersion, scryptLogN, scryptR, scryptP}
+	return append(hdr, salt...), nil
+}
+
+func (ks *FSKeystore) seal(data []byte) ([]byte, error) {
+	hdr, err := ks.header()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := ks.aead(hdr)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(append([]byte{}, hdr...), nonce...)
+	return aead.Seal(out, nonce, data, hdr), nil
+}
+
+func (ks *FSKeystore) open(data []byte) ([]byte, error) {
+	if len(data) < headerLen {
+		return nil, ErrBadKeyData
+	}
+	hdr, data := data[:headerLen], data[headerLen:]
+	aead, err := ks.aead(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrBadKeyData
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	out, err := aead.Open(nil, nonce, data, hdr)
+	if err != nil {
+		return nil, ErrBadKeyData
+	}
+	return out, nil
+}
+
+func (ks *FSKeystore) Has(name string) (bool, error) {
+	if err := ValidateName(name); err != nil {
+		return false, err
+	}
+	_, err := os.Stat(filepath.Join(ks.dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ks *FSKeystore) Put(name string, k ci.PrivKey) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	data, err := ci.MarshalPrivateKey(k)
+	if err != nil {
+		return err
+	}
+	data, err = ks.seal(data)
+	if err != nil {
+		return err
+	}
+
+	fi, err := os.OpenFile(filepath.Join(ks.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if os.IsExist(err) {
+		return ErrKeyExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fi.Write(data); err != nil {
+		fi.Close()
+		return err
+	}
+	return fi.Close()
+}
+
+func (ks *FSKeystore) Get(name string) (ci.PrivKey, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(ks.dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = ks.open(data)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ci.UnmarshalPrivateKey(data)
+	if err != nil {
+		return nil, ErrBadKeyData
+	}
+	return k, nil
+}
+
+func (ks *FSKeystore) Delete(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(ks.dir, name))
+	if os.IsNotExist(err) {
+		return ErrNoSuchKey
+	}
+	return err
+}
+
+func (ks *FSKeystore) Rename(from, to string) error {
+	if err := ValidateName(from); err != nil {
+		return err
+	}
+	if err := ValidateName(to); err != nil {
+		return err
+	}
+	if has, err := ks.Has(to); err != nil || has {
+		if err == nil {
+			err = ErrKeyExists
+		}
+		return err
+	}
+	err := os.Rename(filepath.Join(ks.dir, from), filepath.Join(ks.dir, to))
+	if os.IsNotExist(err) {
+		return ErrNoSuchKey
+	}
+	return err
+}
+
+func (ks *FSKeystore) List() ([]string, error) {
+	dir, err := os.Open(ks.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(0)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, name := range names {
+		if ValidateName(name) == nil {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// MemKeystore is a Keystore in memory, for tests and mock nodes.
+type MemKeystore struct {
+	keys map[string]ci.PrivKey
+	lk   sync.Mutex
+}
+
+// NewMemKeystore returns an empty MemKeystore.
+func NewMemKeystore() *MemKeystore {
+	return &MemKeystore{keys: make(map[string]ci.PrivKey)}
+}
+
+func (ks *MemKeystore) Has(name string) (bool, error) {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	_, ok := ks.keys[name]
+	return ok, nil
+}
+
+func (ks *MemKeystore) Put(name string, k ci.PrivKey) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	if _, ok := ks.keys[name]; ok {
+		return ErrKeyExists
+	}
+	ks.keys[name] = k
+	return nil
+}
+
+func (ks *MemKeystore) Get(name string) (ci.PrivKey, error) {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	k, ok := ks.keys[name]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return k, nil
+}
+
+func (ks *MemKeystore) Delete(name string) error {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	if _, ok := ks.keys[name]; !ok {
+		return ErrNoSuchKey
+	}
+	delete(ks.keys, name)
+	return nil
+}
+
+func (ks *MemKeystore) Rename(from, to string) error {
+	if err := ValidateName(to); err != nil {
+		return err
+	}
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	k, ok := ks.keys[from]
+	if !ok {
+		return ErrNoSuchKey
+	}
+	if _, ok := ks.keys[to]; ok {
+		return ErrKeyExists
+	}
+	delete(ks.keys, from)
+	ks.keys[to] = k
+	return nil
+}
+
+func (ks *MemKeystore) List() ([]string, error) {
+	ks.lk.Lock()
+	defer ks.lk.Unlock()
+	var out []string
+	for name := range ks.keys {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
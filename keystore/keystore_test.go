@@ -0,0 +1,166 @@
+package keystore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	testutil "github.com/ipfs/go-ipfs/util/testutil"
+)
+
+func testKeystore(t *testing.T, ks Keystore) {
+	k1, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Put("foo", k1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("bar", k2); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("foo", k2); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	for _, name := range []string{"", "a/b", ".hidden"} {
+		if err := ks.Put(name, k1); err == nil {
+			t.Fatalf("stored a key named %q", name)
+		}
+	}
+
+	k, err := ks.Get("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ci.KeyEqual(k, k1) {
+		t.Fatal("got back the wrong key")
+	}
+	if _, err := ks.Get("baz"); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+
+	if err := ks.Rename("foo", "bar"); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+	if err := ks.Rename("foo", "baz"); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := ks.Has("foo"); err != nil || has {
+		t.Fatal("renamed key still stored under its old name")
+	}
+	if k, err := ks.Get("baz"); err != nil || !ci.KeyEqual(k, k1) {
+		t.Fatal("renamed key not found under its new name")
+	}
+
+	if err := ks.Delete("bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Delete("bar"); err != ErrNoSuchKey {
+		t.Fatalf("expected ErrNoSuchKey, got %v", err)
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"baz"}) {
+		t.Fatalf("wrong keys listed: %v", names)
+	}
+}
+
+func TestMemKeystore(t *testing.T) {
+	testKeystore(t, NewMemKeystore())
+}
+
+func TestFSKeystore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	scryptLogN = 10 // keep the test fast
+	defer func() { scryptLogN = 15 }()
+
+	ks, err := NewFSKeystore(dir, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testKeystore(t, ks)
+
+	// only the owner can read the keys
+	fi, err := os.Stat(filepath.Join(dir, "baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		t.Fatalf("key readable by others: %s", fi.Mode())
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad"), []byte("not a key"), 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("bad"); err != ErrBadKeyData {
+		t.Fatalf("expected ErrBadKeyData, got %v", err)
+	}
+
+	// the key is stored encrypted
+	data, err := ioutil.ReadFile(filepath.Join(dir, "baz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ci.UnmarshalPrivateKey(data); err == nil {
+		t.Fatal("key stored in the clear")
+	}
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(filepath.Join(dir, "tampered"), data, 0400); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("tampered"); err != ErrBadKeyData {
+		t.Fatalf("expected ErrBadKeyData, got %v", err)
+	}
+
+	// another keystore on the directory reads the key with the same
+	// passphrase only
+	other, err := NewFSKeystore(dir, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("baz"); err != nil {
+		t.Fatal(err)
+	}
+	wrong, err := NewFSKeystore(dir, []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Get("baz"); err != ErrBadKeyData {
+		t.Fatalf("expected ErrBadKeyData, got %v", err)
+	}
+
+	// without a passphrase keys are listed but not read or stored
+	none, err := NewFSKeystore(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := none.Get("baz"); err != ErrNoPassphrase {
+		t.Fatalf("expected ErrNoPassphrase, got %v", err)
+	}
+	k, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := none.Put("new", k); err != ErrNoPassphrase {
+		t.Fatalf("expected ErrNoPassphrase, got %v", err)
+	}
+	if has, err := none.Has("baz"); err != nil || !has {
+		t.Fatal("key not found without a passphrase")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 
 	pb "github.com/ipfs/go-ipfs/merkledag/internal/pb"
@@ -24,7 +25,7 @@ func (n *Node) Unmarshal(encoded []byte) error {
 	pbnl := pbn.GetLinks()
 	n.Links = make([]*Link, len(pbnl))
 	for i, l := range pbnl {
-		n.Links[i] = &Link{Name: l.GetName(), Size: l.GetTsize()}
+		n.Links[i] = &Link{Name: l.GetName(), Size: l.GetTsize(), Raw: l.GetRaw(), Inline: l.GetInline()}
 		h, err := mh.Cast(l.GetHash())
 		if err != nil {
 			return fmt.Errorf("Link hash is not valid multihash. %v", err)
@@ -48,8 +49,12 @@ func (n *Node) MarshalTo(encoded []byte) error {
 }
 
 // Marshal encodes a *Node instance into a new byte slice.
-// The conversion uses an intermediate PBNode.
+// The conversion uses an intermediate PBNode, except for raw nodes which
+// are their data.
 func (n *Node) Marshal() ([]byte, error) {
+	if n.raw {
+		return n.Data, nil
+	}
 	pbn := n.getPBNode()
 	data, err := pbn.Marshal()
 	if err != nil {
@@ -68,6 +73,10 @@ func (n *Node) getPBNode() *pb.PBNode {
 		pbn.Links[i].Name = &l.Name
 		pbn.Links[i].Tsize = &l.Size
 		pbn.Links[i].Hash = []byte(l.Hash)
+		if l.Raw {
+			pbn.Links[i].Raw = proto.Bool(true)
+		}
+		pbn.Links[i].Inline = l.Inline
 	}
 
 	pbn.Data = n.Data
@@ -84,12 +93,25 @@ func (n *Node) Encoded(force bool) ([]byte, error) {
 		if err != nil {
 			return []byte{}, err
 		}
-		n.cached = u.Hash(n.encoded)
+		n.cached, err = u.HashWith(n.encoded, n.HashFunc())
+		if err != nil {
+			n.encoded = nil
+			return []byte{}, err
+		}
 	}
 
 	return n.encoded, nil
 }
 
+// decodeBlock returns the node stored in a block, which is a raw node if
+// the link to it says so.
+func decodeBlock(data []byte, raw bool) (*Node, error) {
+	if raw {
+		return NewRawNode(data), nil
+	}
+	return Decoded(data)
+}
+
 // Decoded decodes raw data and returns a new Node instance.
 func Decoded(encoded []byte) (*Node, error) {
 	n := new(Node)
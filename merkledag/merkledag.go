@@ -25,6 +25,10 @@ type DAGService interface {
 	// nodes of the passed in node.
 	GetDAG(context.Context, *Node) []NodeGetter
 	GetNodes(context.Context, []u.Key) []NodeGetter
+
+	// GetLinks returns, in order, the nodes the given links point to. It
+	// reads the nodes stored in the links, and the raw blocks.
+	GetLinks(context.Context, []*Link) []NodeGetter
 }
 
 func NewDAGService(bs *bserv.BlockService) DAGService {
@@ -57,6 +61,9 @@ func (n *dagService) Add(nd *Node) (u.Key, error) {
 	if err != nil {
 		return "", err
 	}
+	if nd.raw {
+		b.PosInfo = nd.PosInfo
+	}
 
 	return n.Blocks.AddBlock(b)
 }
@@ -92,7 +99,12 @@ func (n *dagService) Get(ctx context.Context, k u.Key) (*Node, error) {
 		return nil, err
 	}
 
-	return Decoded(b.Data)
+	nd, err := Decoded(b.Data)
+	if err != nil {
+		return nil, err
+	}
+	nd.hashFn = hashFuncOf(b.Multihash)
+	return nd, nil
 }
 
 // Remove deletes the given node and all of its children from the BlockService
@@ -162,17 +174,41 @@ func FindLinks(links []u.Key, k u.Key, start int) []int {
 // It returns a channel of nodes, which the caller can receive
 // all the child nodes of 'root' on, in proper order.
 func (ds *dagService) GetDAG(ctx context.Context, root *Node) []NodeGetter {
-	var keys []u.Key
-	for _, lnk := range root.Links {
-		keys = append(keys, u.Key(lnk.Hash))
-	}
-
-	return ds.GetNodes(ctx, keys)
+	return ds.GetLinks(ctx, root.Links)
 }
 
 // GetNodes returns an array of 'NodeGetter' promises, with each corresponding
 // to the key with the same index as the passed in keys
 func (ds *dagService) GetNodes(ctx context.Context, keys []u.Key) []NodeGetter {
+	return ds.getNodes(ctx, keys, nil)
+}
+
+// GetLinks returns an array of 'NodeGetter' promises, with each corresponding
+// to the link with the same index as the passed in links
+func (ds *dagService) GetLinks(ctx context.Context, links []*Link) []NodeGetter {
+	promises := make([]NodeGetter, len(links))
+	var keys []u.Key
+	var raw []bool
+	var fetched []int
+	for i, l := range links {
+		if l.Inline != nil {
+			promises[i] = inlinePromise{l}
+			continue
+		}
+		keys = append(keys, u.Key(l.Hash))
+		raw = append(raw, l.Raw)
+		fetched = append(fetched, i)
+	}
+
+	for j, p := range ds.getNodes(ctx, keys, raw) {
+		promises[fetched[j]] = p
+	}
+	return promises
+}
+
+// getNodes fetches the nodes of the given keys. The blocks of the keys
+// marked in raw are raw nodes.
+func (ds *dagService) getNodes(ctx context.Context, keys []u.Key, raw []bool) []NodeGetter {
 
 	// Early out if no work to do
 	if len(keys) == 0 {
@@ -199,14 +235,15 @@ func (ds *dagService) GetNodes(ctx context.Context, keys []u.Key) []NodeGetter {
 					return
 				}
 
-				nd, err := Decoded(blk.Data)
-				if err != nil {
-					// NB: can happen with improperly formatted input data
-					log.Debug("Got back bad block!")
-					return
-				}
 				is := FindLinks(keys, blk.Key(), 0)
 				for _, i := range is {
+					nd, err := decodeBlock(blk.Data, raw != nil && raw[i])
+					if err != nil {
+						// NB: can happen with improperly formatted input data
+						log.Debug("Got back bad block!")
+						return
+					}
+					nd.hashFn = hashFuncOf(blk.Multihash)
 					count++
 					sendChans[i] <- nd
 				}
@@ -231,6 +268,15 @@ func dedupeKeys(ks []u.Key) []u.Key {
 	return out
 }
 
+// inlinePromise is the promise of the node stored in a link
+type inlinePromise struct {
+	link *Link
+}
+
+func (p inlinePromise) Get(context.Context) (*Node, error) {
+	return p.link.inlineNode()
+}
+
 func newNodePromise(ctx context.Context) (NodeGetter, chan<- *Node) {
 	ch := make(chan *Node, 1)
 	return &nodePromise{
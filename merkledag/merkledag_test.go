@@ -213,3 +213,73 @@ func runBatchFetchTest(t *testing.T, read io.Reader) {
 
 	wg.Wait()
 }
+
+func TestRawAndInlineLinks(t *testing.T) {
+	dsp := getDagservAndPinner(t)
+	ctx := context.Background()
+
+	raw := NewRawNode([]byte("some file data"))
+	rawk, err := dsp.ds.Add(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(rawk), u.Hash(raw.Data)) {
+		t.Fatal("a raw node does not hash like its data")
+	}
+
+	small := &Node{Data: []byte("small")}
+	inline, err := MakeInlineLink(small)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeInlineLink(&Node{Links: []*Link{inline}}); err != ErrInlineLinks {
+		t.Fatalf("expected ErrInlineLinks, got %v", err)
+	}
+
+	parent := new(Node)
+	if err := parent.AddNodeLinkClean("raw", raw); err != nil {
+		t.Fatal(err)
+	}
+	parent.AddRawLink("small", inline)
+	enc, err := parent.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decoded(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rawlnk, err := decoded.GetNodeLink("raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rawlnk.Raw {
+		t.Fatal("the raw flag of a link was lost")
+	}
+	got, err := rawlnk.GetNode(ctx, dsp.ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsRaw() || !bytes.Equal(got.Data, raw.Data) {
+		t.Fatal("a raw link did not resolve to its raw node")
+	}
+
+	// the inline node was never stored, it is read from the link
+	inlnk, err := decoded.GetNodeLink("small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = inlnk.GetNode(ctx, dsp.ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Data, small.Data) {
+		t.Fatal("an inline link did not resolve to its node")
+	}
+
+	inlnk.Inline = []byte("tampered")
+	if _, err := inlnk.GetNode(ctx, dsp.ds); err == nil {
+		t.Fatal("an inline node that does not match its hash was accepted")
+	}
+}
@@ -1,14 +1,20 @@
 package merkledag
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	blocks "github.com/ipfs/go-ipfs/blocks"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
+// ErrInlineLinks is returned when inlining a node that has links.
+var ErrInlineLinks = errors.New("merkledag: cannot inline a node with links")
+
 // NodeMap maps u.Keys to Nodes.
 // We cannot use []byte/Multihash for keys :(
 // so have to convert Multihash bytes to string (u.Key)
@@ -24,6 +30,59 @@ type Node struct {
 	encoded []byte
 
 	cached mh.Multihash
+
+	// raw nodes are blocks of Data alone, with no encoding
+	raw bool
+
+	// the multihash function of the node, SHA2_256 if zero
+	hashFn int
+
+	// where the data of a raw node lies on disk, to store the node by
+	// reference to it rather than by copying it (optionally nil)
+	PosInfo *blocks.PosInfo
+}
+
+// NewRawNode returns a node that is stored as a block of data alone, with
+// no links nor encoding, so that the block hashes like the data itself.
+// Links to a raw node are marked as such, since the block does not tell.
+func NewRawNode(data []byte) *Node {
+	return &Node{Data: data, raw: true}
+}
+
+// IsRaw returns whether the node is stored as a raw block.
+func (n *Node) IsRaw() bool {
+	return n.raw
+}
+
+// SetHashFunc sets the multihash function the node is hashed with, one of
+// u.HashFuncs. Nodes are hashed with SHA2_256 unless set otherwise; nodes
+// read from a DAGService keep the function of their key.
+func (n *Node) SetHashFunc(code int) error {
+	if !u.HashFuncs[code] {
+		return fmt.Errorf("merkledag: unsupported hash function %d", code)
+	}
+	if code != n.HashFunc() {
+		n.hashFn = code
+		n.encoded = nil
+	}
+	return nil
+}
+
+// HashFunc returns the multihash function the node is hashed with.
+func (n *Node) HashFunc() int {
+	if n.hashFn == 0 {
+		return mh.SHA2_256
+	}
+	return n.hashFn
+}
+
+// hashFuncOf returns the multihash function of h, or zero for the default.
+func hashFuncOf(h mh.Multihash) int {
+	dec, err := mh.Decode(h)
+	if err != nil {
+		return 0
+	}
+	return dec.Code
 }
 
 // NodeStat is a statistics object for a Node. Mostly sizes.
@@ -51,6 +110,13 @@ type Link struct {
 	// multihash of the target object
 	Hash mh.Multihash
 
+	// whether the target object is a raw block, see NewRawNode
+	Raw bool
+
+	// the encoded target object, when it is small enough to be stored in
+	// the link itself
+	Inline []byte
+
 	// a ptr to the actual node for graph manipulation
 	Node *Node
 }
@@ -75,16 +141,56 @@ func MakeLink(n *Node) (*Link, error) {
 	return &Link{
 		Size: s,
 		Hash: h,
+		Raw:  n.raw,
 	}, nil
 }
 
+// MakeInlineLink creates a link to the given node that holds the encoded
+// node, so that it needs not be fetched. The node must not have links.
+func MakeInlineLink(n *Node) (*Link, error) {
+	if len(n.Links) != 0 {
+		return nil, ErrInlineLinks
+	}
+	lnk, err := MakeLink(n)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := n.Encoded(false)
+	if err != nil {
+		return nil, err
+	}
+	lnk.Inline = append([]byte(nil), enc...)
+	return lnk, nil
+}
+
 // GetNode returns the MDAG Node that this link points to
 func (l *Link) GetNode(ctx context.Context, serv DAGService) (*Node, error) {
 	if l.Node != nil {
 		return l.Node, nil
 	}
+	if l.Inline != nil {
+		return l.inlineNode()
+	}
+
+	return serv.GetLinks(ctx, []*Link{l})[0].Get(ctx)
+}
 
-	return serv.Get(ctx, u.Key(l.Hash))
+// inlineNode decodes the node stored in the link, making sure it is the
+// one the link points to.
+func (l *Link) inlineNode() (*Node, error) {
+	nd, err := decodeBlock(l.Inline, l.Raw)
+	if err != nil {
+		return nil, err
+	}
+	nd.hashFn = hashFuncOf(l.Hash)
+	h, err := nd.Multihash()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h, l.Hash) {
+		return nil, fmt.Errorf("merkledag: inline node does not match its hash %s", l.Hash.B58String())
+	}
+	return nd, nil
 }
 
 // AddNodeLink adds a link to another node.
@@ -121,10 +227,12 @@ func (n *Node) AddNodeLinkClean(name string, that *Node) error {
 func (n *Node) AddRawLink(name string, l *Link) error {
 	n.encoded = nil
 	n.Links = append(n.Links, &Link{
-		Name: name,
-		Size: l.Size,
-		Hash: l.Hash,
-		Node: l.Node,
+		Name:   name,
+		Size:   l.Size,
+		Hash:   l.Hash,
+		Raw:    l.Raw,
+		Inline: l.Inline,
+		Node:   l.Node,
 	})
 
 	return nil
@@ -147,10 +255,12 @@ func (n *Node) GetNodeLink(name string) (*Link, error) {
 	for _, l := range n.Links {
 		if l.Name == name {
 			return &Link{
-				Name: l.Name,
-				Size: l.Size,
-				Hash: l.Hash,
-				Node: l.Node,
+				Name:   l.Name,
+				Size:   l.Size,
+				Hash:   l.Hash,
+				Raw:    l.Raw,
+				Inline: l.Inline,
+				Node:   l.Node,
 			}, nil
 		}
 	}
@@ -160,7 +270,7 @@ func (n *Node) GetNodeLink(name string) (*Link, error) {
 // Copy returns a copy of the node.
 // NOTE: does not make copies of Node objects in the links.
 func (n *Node) Copy() *Node {
-	nnode := new(Node)
+	nnode := &Node{raw: n.raw, hashFn: n.hashFn}
 	nnode.Data = make([]byte, len(n.Data))
 	copy(nnode.Data, n.Data)
 
@@ -0,0 +1,158 @@
+// Package dagutils edits merkledag nodes. As nodes are immutable once
+// stored, every edit returns a new root, made of copies of the nodes on
+// the path to the change, which are stored along the way.
+//
+// Links are edited by name. The entries of HAMT shards are edited in their
+// trie, as the links of a shard are named after its slots.
+package dagutils
+
+import (
+	"errors"
+	"os"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+)
+
+// ErrEmptyPath is returned for edits of the root itself, which have no
+// link to edit.
+var ErrEmptyPath = errors.New("dagutils: empty path")
+
+// AddLink returns a copy of root with a link named name to child, in place
+// of any link root had by that name.
+func AddLink(ctx context.Context, ds dag.DAGService, root *dag.Node, name string, child *dag.Node) (*dag.Node, error) {
+	if name == "" {
+		return nil, errors.New("dagutils: cannot add a link with no name")
+	}
+
+	if hamt.IsShard(root) {
+		return editShard(ds, root, func(s *hamt.Shard) error {
+			return s.Set(ctx, name, child)
+		})
+	}
+	nd := root.Copy()
+	// there may be no link by that name yet
+	_ = nd.RemoveNodeLink(name)
+	if err := nd.AddNodeLinkClean(name, child); err != nil {
+		return nil, err
+	}
+	if _, err := ds.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// InsertNodeAtPath returns a copy of root with a link to insert at the
+// path made of the link names in p. Nodes missing on the way are made with
+// create, or are an error if create is nil.
+func InsertNodeAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, p []string, insert *dag.Node, create func() *dag.Node) (*dag.Node, error) {
+	if len(p) == 0 {
+		return nil, ErrEmptyPath
+	}
+	if len(p) == 1 {
+		return AddLink(ctx, ds, root, p[0], insert)
+	}
+
+	var nd *dag.Node
+	lnk, err := getLink(ctx, ds, root, p[0])
+	switch {
+	case err == dag.ErrNotFound && create != nil:
+		nd = create()
+	case err != nil:
+		return nil, err
+	default:
+		nd, err = lnk.GetNode(ctx, ds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nd, err = InsertNodeAtPath(ctx, ds, nd, p[1:], insert, create)
+	if err != nil {
+		return nil, err
+	}
+	return AddLink(ctx, ds, root, p[0], nd)
+}
+
+// RemoveLinkAtPath returns a copy of root without the link at the path
+// made of the link names in p.
+func RemoveLinkAtPath(ctx context.Context, ds dag.DAGService, root *dag.Node, p []string) (*dag.Node, error) {
+	if len(p) == 0 {
+		return nil, ErrEmptyPath
+	}
+	if len(p) == 1 {
+		return removeLink(ctx, ds, root, p[0])
+	}
+
+	lnk, err := getLink(ctx, ds, root, p[0])
+	if err != nil {
+		return nil, err
+	}
+	nd, err := lnk.GetNode(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+	nd, err = RemoveLinkAtPath(ctx, ds, nd, p[1:])
+	if err != nil {
+		return nil, err
+	}
+	return AddLink(ctx, ds, root, p[0], nd)
+}
+
+// getLink returns the link named name of nd.
+func getLink(ctx context.Context, ds dag.DAGService, nd *dag.Node, name string) (*dag.Link, error) {
+	if !hamt.IsShard(nd) {
+		return nd.GetNodeLink(name)
+	}
+	s, err := hamt.NewHamtFromDag(ds, nd)
+	if err != nil {
+		return nil, err
+	}
+	lnk, err := s.Find(ctx, name)
+	if err == os.ErrNotExist {
+		return nil, dag.ErrNotFound
+	}
+	return lnk, err
+}
+
+// removeLink returns a copy of root without the link named name.
+func removeLink(ctx context.Context, ds dag.DAGService, root *dag.Node, name string) (*dag.Node, error) {
+	if hamt.IsShard(root) {
+		return editShard(ds, root, func(s *hamt.Shard) error {
+			err := s.Remove(ctx, name)
+			if err == os.ErrNotExist {
+				return dag.ErrNotFound
+			}
+			return err
+		})
+	}
+	nd := root.Copy()
+	if err := nd.RemoveNodeLink(name); err != nil {
+		return nil, err
+	}
+	if _, err := ds.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+// editShard returns the shard root as edited by edit.
+func editShard(ds dag.DAGService, root *dag.Node, edit func(*hamt.Shard) error) (*dag.Node, error) {
+	s, err := hamt.NewHamtFromDag(ds, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := edit(s); err != nil {
+		return nil, err
+	}
+	nd, err := s.Node()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ds.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
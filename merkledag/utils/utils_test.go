@@ -0,0 +1,165 @@
+package dagutils
+
+import (
+	"fmt"
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+func TestInsertAndRemove(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	root := new(dag.Node)
+	if _, err := ds.Add(root); err != nil {
+		t.Fatal(err)
+	}
+	child := &dag.Node{Data: []byte("child")}
+	if _, err := ds.Add(child); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := InsertNodeAtPath(ctx, ds, root, []string{"a", "b"}, child, nil); err != dag.ErrNotFound {
+		t.Fatalf("expected ErrNotFound without create, got %v", err)
+	}
+
+	create := func() *dag.Node { return &dag.Node{Data: []byte("dir")} }
+	nroot, err := InsertNodeAtPath(ctx, ds, root, []string{"a", "b", "c"}, child, create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Links) != 0 {
+		t.Fatal("the original root was modified")
+	}
+	nroot, err = InsertNodeAtPath(ctx, ds, nroot, []string{"a", "d"}, child, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolve := func(nd *dag.Node, p string) (*dag.Node, error) {
+		k, err := nd.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &path.Resolver{DAG: ds}
+		return r.ResolvePath(ctx, path.Path(k.B58String()+"/"+p))
+	}
+	for _, p := range []string{"a/b/c", "a/d"} {
+		nd, err := resolve(nroot, p)
+		if err != nil {
+			t.Fatalf("resolving %s: %s", p, err)
+		}
+		if string(nd.Data) != "child" {
+			t.Fatalf("wrong node at %s", p)
+		}
+	}
+
+	nroot, err = RemoveLinkAtPath(ctx, ds, nroot, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolve(nroot, "a/b/c"); err == nil {
+		t.Fatal("removed link still resolves")
+	}
+	if _, err := resolve(nroot, "a/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RemoveLinkAtPath(ctx, ds, nroot, []string{"a", "x"}); err != dag.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := InsertNodeAtPath(ctx, ds, nroot, nil, child, create); err != ErrEmptyPath {
+		t.Fatalf("expected ErrEmptyPath, got %v", err)
+	}
+}
+
+func TestEditShard(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+
+	s, err := hamt.NewShard(ds, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &dag.Node{Data: ft.FolderPBData()}
+	if _, err := ds.Add(sub); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := s.Set(ctx, fmt.Sprintf("entry%d", i), sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Add(root); err != nil {
+		t.Fatal(err)
+	}
+
+	child := &dag.Node{Data: []byte("child")}
+	if _, err := ds.Add(child); err != nil {
+		t.Fatal(err)
+	}
+	ck, _ := child.Key()
+	// an entry of the shard, then a link below an entry
+	nroot, err := InsertNodeAtPath(ctx, ds, root, []string{"new"}, child, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nroot, err = InsertNodeAtPath(ctx, ds, nroot, []string{"entry7", "below"}, child, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hamt.IsShard(nroot) {
+		t.Fatal("the edited root is no longer a shard")
+	}
+
+	ns, err := hamt.NewHamtFromDag(ds, nroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnk, err := ns.Find(ctx, "new")
+	if err != nil || u.Key(lnk.Hash) != ck {
+		t.Fatalf("entry not added to the shard: %v", err)
+	}
+	lnk, err = ns.Find(ctx, "entry7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nd, err := lnk.GetNode(ctx, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nd.GetNodeLink("below"); err != nil {
+		t.Fatal("link not added below the entry")
+	}
+	links, err := ns.Links(ctx)
+	if err != nil || len(links) != 21 {
+		t.Fatalf("expected 21 entries, got %d, %v", len(links), err)
+	}
+
+	nroot, err = RemoveLinkAtPath(ctx, ds, nroot, []string{"new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RemoveLinkAtPath(ctx, ds, nroot, []string{"new"}); err != dag.ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	ns, err = hamt.NewHamtFromDag(ds, nroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ns.Find(ctx, "new"); err == nil {
+		t.Fatal("entry not removed from the shard")
+	}
+}
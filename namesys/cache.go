@@ -0,0 +1,82 @@
+package namesys
+
+import (
+	"time"
+
+	lru "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/hashicorp/golang-lru"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	path "github.com/ipfs/go-ipfs/path"
+)
+
+// ttlResolver is a Resolver which also tells how long the values it
+// resolves may be cached.
+type ttlResolver interface {
+	Resolver
+	resolveTTL(ctx context.Context, name string) (path.Path, time.Duration, error)
+}
+
+// DefaultResolverTTL is how long the values of resolvers which do not
+// tell their TTL are cached.
+const DefaultResolverTTL = time.Minute
+
+// resolveTTL resolves name with r, and returns how long the value may be
+// cached.
+func resolveTTL(ctx context.Context, r Resolver, name string) (path.Path, time.Duration, error) {
+	if tr, ok := r.(ttlResolver); ok {
+		return tr.resolveTTL(ctx, name)
+	}
+	p, err := r.Resolve(ctx, name)
+	return p, DefaultResolverTTL, err
+}
+
+type cacheEntry struct {
+	val path.Path
+	eol time.Time
+}
+
+// resolveCache caches resolved names, each until its TTL passes. The
+// least recently used names are evicted first.
+type resolveCache struct {
+	cache *lru.Cache // pointer b/c Cache contains a Mutex as value
+}
+
+// newResolveCache returns a cache of size names, or nil when size is not
+// positive. A nil cache caches nothing.
+func newResolveCache(size int) *resolveCache {
+	if size <= 0 {
+		return nil
+	}
+	c, err := lru.New(size)
+	if err != nil {
+		panic(err) // only on a non-positive size
+	}
+	return &resolveCache{cache: c}
+}
+
+func (c *resolveCache) get(name string) (path.Path, bool) {
+	if c == nil {
+		return "", false
+	}
+	v, ok := c.cache.Get(name)
+	if !ok {
+		return "", false
+	}
+	e := v.(cacheEntry)
+	if !time.Now().Before(e.eol) {
+		c.cache.Remove(name)
+		return "", false
+	}
+	return e.val, true
+}
+
+func (c *resolveCache) set(name string, val path.Path, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	if ttl <= 0 {
+		c.cache.Remove(name)
+		return
+	}
+	c.cache.Add(name, cacheEntry{val: val, eol: time.Now().Add(ttl)})
+}
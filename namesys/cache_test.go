@@ -0,0 +1,156 @@
+package namesys
+
+import (
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	testutil "github.com/ipfs/go-ipfs/util/testutil"
+)
+
+// countingResolver resolves every name to val, for ttl, and counts the
+// names resolved.
+type countingResolver struct {
+	val   path.Path
+	ttl   time.Duration
+	count int
+}
+
+func (r *countingResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	p, _, err := r.resolveTTL(ctx, name)
+	return p, err
+}
+
+func (r *countingResolver) resolveTTL(ctx context.Context, name string) (path.Path, time.Duration, error) {
+	r.count++
+	return r.val, r.ttl, nil
+}
+
+func (r *countingResolver) CanResolve(name string) bool {
+	return true
+}
+
+// nameOf returns the IPNS name published with k.
+func nameOf(k ci.PrivKey) (string, error) {
+	id, err := peer.IDFromPrivateKey(k)
+	return id.Pretty(), err
+}
+
+func TestResolveCache(t *testing.T) {
+	ctx := context.Background()
+	r := &countingResolver{val: "/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN", ttl: time.Hour}
+	ns := &ipns{resolvers: []Resolver{r}, cache: newResolveCache(2)}
+
+	for i := 0; i < 2; i++ {
+		if p, err := ns.Resolve(ctx, "a"); err != nil || p != r.val {
+			t.Fatalf("resolved to %s, %v", p, err)
+		}
+	}
+	if r.count != 1 {
+		t.Fatalf("cached name resolved %d times", r.count)
+	}
+
+	if _, _, err := ns.ResolveN(ctx, "a", DefaultDepthLimit, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.count != 2 {
+		t.Fatal("name resolved from the cache despite nocache")
+	}
+
+	// the least recently used name is evicted
+	ns.Resolve(ctx, "b")
+	ns.Resolve(ctx, "c")
+	ns.Resolve(ctx, "a")
+	if r.count != 5 {
+		t.Fatalf("expected 5 names resolved, got %d", r.count)
+	}
+
+	// names expire with their TTL
+	r.ttl = time.Millisecond * 10
+	ns.ResolveN(ctx, "a", DefaultDepthLimit, true)
+	time.Sleep(time.Millisecond * 20)
+	ns.Resolve(ctx, "a")
+	if r.count != 7 {
+		t.Fatal("expired name resolved from the cache")
+	}
+
+	// no cache at all
+	ns.cache = newResolveCache(0)
+	ns.Resolve(ctx, "a")
+	ns.Resolve(ctx, "a")
+	if r.count != 9 {
+		t.Fatal("name cached without a cache")
+	}
+}
+
+func TestPublishUpdatesCache(t *testing.T) {
+	ctx := context.Background()
+	d := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	ns := NewNameSystem(d, dssync.MutexWrap(ds.NewMapDatastore()), 16)
+
+	privk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1 := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	p2 := path.FromString("/ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	if err := ns.Publish(ctx, privk, p1); err != nil {
+		t.Fatal(err)
+	}
+	name, err := nameOf(privk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ns.Resolve(ctx, name); err != nil || p != p1 {
+		t.Fatalf("resolved to %s, %v", p, err)
+	}
+	if err := ns.Publish(ctx, privk, p2); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := ns.Resolve(ctx, name); err != nil || p != p2 {
+		t.Fatalf("resolved to %s after publishing %s", p, p2)
+	}
+}
+
+func TestResolverTTL(t *testing.T) {
+	ctx := context.Background()
+	d := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	privk, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := nameOf(privk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// records are cached for their TTL, but not past their EOL
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	if err := NewRoutingPublisher(d, dssync.MutexWrap(ds.NewMapDatastore())).PublishWithEOL(ctx, privk, p, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRoutingResolver(d).(*routingResolver)
+	if _, ttl, err := r.resolveTTL(ctx, name); err != nil || ttl != DefaultRecordTTL {
+		t.Fatalf("got TTL %s, %v", ttl, err)
+	}
+	if err := NewRoutingPublisher(d, dssync.MutexWrap(ds.NewMapDatastore())).PublishWithEOL(ctx, privk, p, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ttl, err := r.resolveTTL(ctx, name); err != nil || ttl > time.Second {
+		t.Fatalf("got TTL %s past the EOL, %v", ttl, err)
+	}
+
+	dr := &DNSResolver{lookupTXT: func(ctx context.Context, name string) ([]string, time.Duration, error) {
+		return []string{"dnslink=" + p.String()}, time.Minute * 5, nil
+	}}
+	if _, ttl, err := dr.resolveTTL(ctx, "example.com"); err != nil || ttl != time.Minute*5 {
+		t.Fatalf("got TTL %s, %v", ttl, err)
+	}
+}
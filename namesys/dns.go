@@ -2,19 +2,28 @@ package namesys
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	isd "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-is-domain"
+	dns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/miekg/dns"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	path "github.com/ipfs/go-ipfs/path"
 )
 
+// DefaultDNSTTL is how long the entries of a domain are cached when the
+// TTL of its records is unknown.
+const DefaultDNSTTL = time.Minute
+
 // DNSResolver implements a Resolver on DNS domains
 type DNSResolver struct {
-	// TODO: maybe some sort of caching?
-	// cache would need a timeout
+	// lookupTXT returns the TXT records of a domain, and how long they
+	// may be cached. nil means lookupTXT.
+	lookupTXT func(ctx context.Context, name string) ([]string, time.Duration, error)
 }
 
 // CanResolve implements Resolver
@@ -26,20 +35,158 @@ func (r *DNSResolver) CanResolve(name string) bool {
 // TXT records for a given domain name should contain a b58
 // encoded multihash.
 func (r *DNSResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	p, _, err := r.resolveTTL(ctx, name)
+	return p, err
+}
+
+// resolveTTL implements ttlResolver. Entries are cached for the TTL of
+// the TXT records.
+func (r *DNSResolver) resolveTTL(ctx context.Context, name string) (path.Path, time.Duration, error) {
 	log.Info("DNSResolver resolving %v", name)
-	txt, err := net.LookupTXT(name)
+	lookup := r.lookupTXT
+	if lookup == nil {
+		lookup = lookupTXT
+	}
+	txt, ttl, err := lookup(ctx, name)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	for _, t := range txt {
 		p, err := parseEntry(t)
 		if err == nil {
-			return p, nil
+			return p, ttl, nil
 		}
 	}
 
-	return "", ErrResolveFailed
+	return "", 0, ErrResolveFailed
+}
+
+var (
+	resolvConfOnce sync.Once
+	resolvConf     *dns.ClientConfig
+)
+
+// loadResolvConf returns the configuration of /etc/resolv.conf, read the
+// first time it is needed, or nil if it lists no name server.
+func loadResolvConf() *dns.ClientConfig {
+	resolvConfOnce.Do(func() {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			log.Debugf("DNSResolver: reading resolv.conf: %s", err)
+			return
+		}
+		if len(conf.Servers) > 0 {
+			resolvConf = conf
+		}
+	})
+	return resolvConf
+}
+
+// dnsAnswer is the answer of a name server to a lookup.
+type dnsAnswer struct {
+	server string
+	msg    *dns.Msg
+	err    error
+}
+
+// lookupTXT returns the TXT records of name, with the smallest TTL of the
+// records answered. The system resolver does not tell TTLs, so the name
+// servers of resolv.conf are asked directly, all at once, and the first
+// answer is used; without them, the system resolver is used, and the
+// records cached for DefaultDNSTTL.
+func lookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	return lookupTXTFrom(ctx, loadResolvConf(), name)
+}
+
+// lookupTXTFrom is lookupTXT with the name servers of conf, or the system
+// resolver if conf is nil.
+func lookupTXTFrom(ctx context.Context, conf *dns.ClientConfig, name string) ([]string, time.Duration, error) {
+	if conf == nil {
+		return systemLookupTXT(ctx, name)
+	}
+
+	timeout := time.Duration(conf.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second * 5 // the default of resolv.conf
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(time.Now()) < timeout {
+		timeout = deadline.Sub(time.Now())
+	}
+	if timeout <= 0 {
+		return nil, 0, context.DeadlineExceeded
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	udp := &dns.Client{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	tcp := &dns.Client{Net: "tcp", DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+
+	// buffered, so that the exchanges left over when returning finish
+	answers := make(chan dnsAnswer, len(conf.Servers))
+	for _, s := range conf.Servers {
+		go func(s string) {
+			addr := net.JoinHostPort(s, conf.Port)
+			in, _, err := udp.Exchange(m.Copy(), addr)
+			if err == nil && in.Truncated {
+				// the records do not fit in a datagram
+				in, _, err = tcp.Exchange(m.Copy(), addr)
+			}
+			answers <- dnsAnswer{server: s, msg: in, err: err}
+		}(s)
+	}
+
+	for range conf.Servers {
+		var a dnsAnswer
+		select {
+		case a = <-answers:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+		if a.err != nil {
+			log.Debugf("DNSResolver: %s failed: %s", a.server, a.err)
+			continue
+		}
+		if a.msg.Rcode != dns.RcodeSuccess {
+			return nil, 0, fmt.Errorf("lookup %s: %s", name, dns.RcodeToString[a.msg.Rcode])
+		}
+
+		var txt []string
+		ttl := DefaultDNSTTL
+		for i, rr := range a.msg.Answer {
+			if i == 0 || time.Duration(rr.Header().Ttl)*time.Second < ttl {
+				ttl = time.Duration(rr.Header().Ttl) * time.Second
+			}
+			if t, ok := rr.(*dns.TXT); ok {
+				txt = append(txt, strings.Join(t.Txt, ""))
+			}
+		}
+		return txt, ttl, nil
+	}
+
+	// no name server answered
+	return systemLookupTXT(ctx, name)
+}
+
+// systemLookupTXT returns the TXT records of name from the system
+// resolver, to be cached for DefaultDNSTTL. It gives up when ctx is done.
+func systemLookupTXT(ctx context.Context, name string) ([]string, time.Duration, error) {
+	type result struct {
+		txt []string
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		txt, err := net.LookupTXT(name)
+		done <- result{txt, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.txt, DefaultDNSTTL, r.err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
 }
 
 func parseEntry(txt string) (path.Path, error) {
@@ -1,7 +1,12 @@
 package namesys
 
 import (
+	"net"
 	"testing"
+	"time"
+
+	dns "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/miekg/dns"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 )
 
 func TestDnsEntryParsing(t *testing.T) {
@@ -40,3 +45,81 @@ func TestDnsEntryParsing(t *testing.T) {
 		}
 	}
 }
+
+// drain reads the DNS queries of pc, and never answers them.
+func drain(pc net.PacketConn) {
+	buf := make([]byte, 512)
+	for {
+		if _, _, err := pc.ReadFrom(buf); err != nil {
+			return
+		}
+	}
+}
+
+func TestLookupTXTFirstAnswer(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, err := net.SplitHostPort(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	silent, err := net.ListenPacket("udp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		pc.Close()
+		t.Skipf("cannot listen on a second loopback address: %s", err)
+	}
+	defer silent.Close()
+	go drain(silent)
+
+	srv := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+			Txt: []string{"dnslink=/ipfs/QmY3hE8xgFCjGcz6PHgnvJz5HZi1BaKRfPkn1ghZUcYMjD"},
+		})
+		w.WriteMsg(m)
+	})}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	// the silent server comes first, but is not waited for
+	conf := &dns.ClientConfig{Servers: []string{"127.0.0.2", "127.0.0.1"}, Port: port, Timeout: 5}
+	start := time.Now()
+	txt, ttl, err := lookupTXTFrom(context.Background(), conf, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) >= time.Second*5 {
+		t.Fatal("waited for the silent name server")
+	}
+	if len(txt) != 1 || ttl != time.Second*300 {
+		t.Fatalf("wrong answer: %v, ttl %s", txt, ttl)
+	}
+}
+
+func TestLookupTXTContext(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go drain(silent)
+	_, port, err := net.SplitHostPort(silent.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &dns.ClientConfig{Servers: []string{"127.0.0.1"}, Port: port, Timeout: 5}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := lookupTXTFrom(ctx, conf, "example.com"); err == nil {
+		t.Fatal("expected the lookup to time out")
+	}
+	if time.Since(start) >= time.Second*5 {
+		t.Fatal("the lookup outlived its context")
+	}
+}
@@ -3,6 +3,7 @@ package namesys
 
 import (
 	"errors"
+	"time"
 
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	ci "github.com/ipfs/go-ipfs/p2p/crypto"
@@ -15,6 +16,29 @@ var ErrResolveFailed = errors.New("could not resolve name.")
 // ErrPublishFailed signals an error when attempting to publish.
 var ErrPublishFailed = errors.New("could not publish name.")
 
+// ErrResolveRecursion signals that a name still resolves to another name
+// after the most names allowed were resolved.
+var ErrResolveRecursion = errors.New("could not resolve name (recursion limit exceeded).")
+
+// ErrResolveCycle signals that a name resolves, through other names, to
+// itself.
+var ErrResolveCycle = errors.New("could not resolve name (cycle detected).")
+
+const (
+	// DefaultDepthLimit is the most names Resolve follows a name through.
+	DefaultDepthLimit = 32
+
+	// UnlimitedDepth lets ResolveN follow any number of names; cycles are
+	// still detected.
+	UnlimitedDepth = 0
+)
+
+// Hop is a step of a recursive resolution: Name resolved to Value.
+type Hop struct {
+	Name  string
+	Value path.Path
+}
+
 // Namesys represents a cohesive name publishing and resolving system.
 //
 // Publishing a name is the process of establishing a mapping, a key-value
@@ -25,6 +49,14 @@ var ErrPublishFailed = errors.New("could not publish name.")
 type NameSystem interface {
 	Resolver
 	Publisher
+
+	// ResolveN resolves name, then the names under /ipns/ it resolves
+	// to, until it resolves to a path that is not a name, or depth names
+	// were resolved. It returns each hop and the path resolved to; when
+	// the depth limit is reached, that path is the last name reached, and
+	// the error is ErrResolveRecursion. With nocache, the names are
+	// resolved anew rather than from the cache.
+	ResolveN(ctx context.Context, name string, depth int, nocache bool) ([]Hop, path.Path, error)
 }
 
 // Resolver is an object capable of resolving names.
@@ -43,4 +75,8 @@ type Publisher interface {
 	// Publish establishes a name-value mapping.
 	// TODO make this not PrivKey specific.
 	Publish(ctx context.Context, name ci.PrivKey, value path.Path) error
+
+	// PublishWithEOL is like Publish, but the mapping stays valid until
+	// eol, rather than for DefaultRecordLifetime.
+	PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error
 }
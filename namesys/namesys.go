@@ -1,8 +1,13 @@
 package namesys
 
 import (
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	path "github.com/ipfs/go-ipfs/path"
 	routing "github.com/ipfs/go-ipfs/routing"
 )
@@ -16,28 +21,96 @@ import (
 //
 // It can only publish to: (a) ipfs routing naming.
 //
+// The names it resolves and publishes are cached.
+//
 type ipns struct {
 	resolvers []Resolver
 	publisher Publisher
+	cache     *resolveCache
 }
 
-// NewNameSystem will construct the IPFS naming system based on Routing
-func NewNameSystem(r routing.IpfsRouting) NameSystem {
+// NewNameSystem will construct the IPFS naming system based on Routing.
+// It keeps the entries it publishes in dstore, and caches up to cachesize
+// resolved names; with a cachesize of 0, it caches none.
+func NewNameSystem(r routing.IpfsRouting, dstore ds.Datastore, cachesize int) NameSystem {
 	return &ipns{
+		cache: newResolveCache(cachesize),
 		resolvers: []Resolver{
 			new(DNSResolver),
 			new(ProquintResolver),
 			NewRoutingResolver(r),
 		},
-		publisher: NewRoutingPublisher(r),
+		publisher: NewRoutingPublisher(r, dstore),
 	}
 }
 
-// Resolve implements Resolver
+// Resolve implements Resolver. It follows the name through at most
+// DefaultDepthLimit names.
 func (ns *ipns) Resolve(ctx context.Context, name string) (path.Path, error) {
+	_, p, err := ns.ResolveN(ctx, name, DefaultDepthLimit, false)
+	return p, err
+}
+
+// ResolveN implements NameSystem
+func (ns *ipns) ResolveN(ctx context.Context, name string, depth int, nocache bool) ([]Hop, path.Path, error) {
+	name = strings.TrimPrefix(name, "/ipns/")
+
+	var hops []Hop
+	var rest []string // the segments trailing the names resolved
+	seen := make(map[string]bool)
+	for {
+		if seen[name] {
+			return hops, "", ErrResolveCycle
+		}
+		seen[name] = true
+
+		p, err := ns.resolveOnce(ctx, name, nocache)
+		if err != nil {
+			return hops, "", err
+		}
+		hops = append(hops, Hop{Name: name, Value: p})
+
+		seg := p.Segments()
+		if len(seg) < 2 || seg[0] != "ipns" {
+			if len(rest) == 0 {
+				return hops, p, nil
+			}
+			p, err = path.FromSegments(append(seg, rest...)...)
+			return hops, p, err
+		}
+		name, rest = seg[1], append(seg[2:], rest...)
+
+		if depth == 1 {
+			p, err = path.FromSegments(append([]string{"ipns", name}, rest...)...)
+			if err != nil {
+				return hops, "", err
+			}
+			return hops, p, ErrResolveRecursion
+		}
+		if depth > 1 {
+			depth--
+		}
+	}
+}
+
+// resolveOnce resolves name with the first resolver that can, unless it
+// is cached. With nocache, the name is resolved anew, and the cache
+// updated.
+func (ns *ipns) resolveOnce(ctx context.Context, name string, nocache bool) (path.Path, error) {
+	if !nocache {
+		if p, ok := ns.cache.get(name); ok {
+			return p, nil
+		}
+	}
+
 	for _, r := range ns.resolvers {
 		if r.CanResolve(name) {
-			return r.Resolve(ctx, name)
+			p, ttl, err := resolveTTL(ctx, r, name)
+			if err != nil {
+				return "", err
+			}
+			ns.cache.set(name, p, ttl)
+			return p, nil
 		}
 	}
 	return "", ErrResolveFailed
@@ -55,5 +128,20 @@ func (ns *ipns) CanResolve(name string) bool {
 
 // Publish implements Publisher
 func (ns *ipns) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
-	return ns.publisher.Publish(ctx, name, value)
+	return ns.PublishWithEOL(ctx, name, value, time.Now().Add(DefaultRecordLifetime))
+}
+
+// PublishWithEOL implements Publisher. The name published resolves to
+// value from the cache right away.
+func (ns *ipns) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error {
+	if err := ns.publisher.PublishWithEOL(ctx, name, value, eol); err != nil {
+		return err
+	}
+
+	id, err := peer.IDFromPrivateKey(name)
+	if err != nil {
+		return err
+	}
+	ns.cache.set(id.Pretty(), value, recordTTL(DefaultRecordTTL, eol))
+	return nil
 }
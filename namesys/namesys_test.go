@@ -0,0 +1,66 @@
+package namesys
+
+import (
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	path "github.com/ipfs/go-ipfs/path"
+)
+
+type mockResolver map[string]path.Path
+
+func (r mockResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	p, ok := r[name]
+	if !ok {
+		return "", ErrResolveFailed
+	}
+	return p, nil
+}
+
+func (r mockResolver) CanResolve(name string) bool {
+	_, ok := r[name]
+	return ok
+}
+
+func TestResolveN(t *testing.T) {
+	ns := &ipns{resolvers: []Resolver{mockResolver{
+		"example.com":       "/ipns/QmKey/docs",
+		"QmKey":             "/ipns/other.example.com",
+		"other.example.com": "/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN/www",
+		"cycle1":            "/ipns/cycle2",
+		"cycle2":            "/ipns/cycle1/foo",
+	}}}
+	ctx := context.Background()
+
+	hops, p, err := ns.ResolveN(ctx, "/ipns/example.com", DefaultDepthLimit, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != "/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN/www/docs" {
+		t.Fatalf("resolved to the wrong path: %s", p)
+	}
+	if len(hops) != 3 || hops[1].Name != "QmKey" || hops[1].Value != "/ipns/other.example.com" {
+		t.Fatalf("wrong hops: %v", hops)
+	}
+
+	// Resolve follows the names too
+	if p2, err := ns.Resolve(ctx, "example.com"); err != nil || p2 != p {
+		t.Fatalf("Resolve returned %s, %v", p2, err)
+	}
+
+	hops, p, err = ns.ResolveN(ctx, "example.com", 2, false)
+	if err != ErrResolveRecursion {
+		t.Fatalf("expected ErrResolveRecursion, got %v", err)
+	}
+	if len(hops) != 2 || p != "/ipns/other.example.com/docs" {
+		t.Fatalf("stopped at the wrong name: %s, %v", p, hops)
+	}
+
+	if _, _, err := ns.ResolveN(ctx, "cycle1", UnlimitedDepth, false); err != ErrResolveCycle {
+		t.Fatalf("expected ErrResolveCycle, got %v", err)
+	}
+
+	if _, _, err := ns.ResolveN(ctx, "unknown", DefaultDepthLimit, false); err != ErrResolveFailed {
+		t.Fatalf("expected ErrResolveFailed, got %v", err)
+	}
+}
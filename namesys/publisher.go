@@ -4,14 +4,18 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	dag "github.com/ipfs/go-ipfs/merkledag"
 	pb "github.com/ipfs/go-ipfs/namesys/internal/pb"
 	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	path "github.com/ipfs/go-ipfs/path"
 	pin "github.com/ipfs/go-ipfs/pin"
 	routing "github.com/ipfs/go-ipfs/routing"
@@ -28,26 +32,39 @@ var ErrExpiredRecord = errors.New("expired record")
 // unknown validity type.
 var ErrUnrecognizedValidity = errors.New("unrecognized validity type")
 
+// DefaultRecordLifetime is how long the entries published stay valid,
+// unless published with another EOL.
+const DefaultRecordLifetime = time.Hour * 24
+
+// DefaultRecordTTL is how long resolvers may cache the entries published,
+// before they look for a newer one.
+const DefaultRecordTTL = time.Minute
+
 // ipnsPublisher is capable of publishing and resolving names to the IPFS
 // routing system.
 type ipnsPublisher struct {
 	routing routing.IpfsRouting
+	ds      ds.Datastore
 }
 
-// NewRoutingPublisher constructs a publisher for the IPFS Routing name system.
-func NewRoutingPublisher(route routing.IpfsRouting) Publisher {
-	return &ipnsPublisher{routing: route}
+// NewRoutingPublisher constructs a publisher for the IPFS Routing name
+// system. It keeps the entries it publishes in dstore, so that the next
+// ones supersede them even when the network lost them.
+func NewRoutingPublisher(route routing.IpfsRouting, dstore ds.Datastore) Publisher {
+	return &ipnsPublisher{routing: route, ds: dstore}
 }
 
 // Publish implements Publisher. Accepts a keypair and a value,
 // and publishes it out to the routing system
 func (p *ipnsPublisher) Publish(ctx context.Context, k ci.PrivKey, value path.Path) error {
+	return p.PublishWithEOL(ctx, k, value, time.Now().Add(DefaultRecordLifetime))
+}
+
+// PublishWithEOL implements Publisher. It publishes an entry valid until
+// eol.
+func (p *ipnsPublisher) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
 	log.Debugf("namesys: Publish %s", value)
 
-	data, err := createRoutingEntryData(k, value)
-	if err != nil {
-		return err
-	}
 	pubkey := k.GetPublic()
 	pkbytes, err := pubkey.Bytes()
 	if err != nil {
@@ -56,6 +73,26 @@ func (p *ipnsPublisher) Publish(ctx context.Context, k ci.PrivKey, value path.Pa
 
 	nameb := u.Hash(pkbytes)
 	namekey := u.Key("/pk/" + string(nameb))
+	ipnskey := u.Key("/ipns/" + string(nameb))
+
+	// the new entry supersedes the one published so far
+	seq, err := p.getPreviousSeqNo(ctx, peer.ID(nameb), ipnskey)
+	if err != nil {
+		return err
+	}
+	if seq == math.MaxUint64 {
+		return errors.New("ipns sequence number exhausted")
+	}
+	seq++
+	data, err := createRoutingEntryData(k, value, seq, eol)
+	if err != nil {
+		return err
+	}
+	// kept before it is out, so that it is superseded even if this fails
+	// half way through
+	if err := p.ds.Put(publishedKey(peer.ID(nameb)), data); err != nil {
+		return err
+	}
 
 	log.Debugf("Storing pubkey at: %s", namekey)
 	// Store associated public key
@@ -65,8 +102,6 @@ func (p *ipnsPublisher) Publish(ctx context.Context, k ci.PrivKey, value path.Pa
 		return err
 	}
 
-	ipnskey := u.Key("/ipns/" + string(nameb))
-
 	log.Debugf("Storing ipns entry at: %s", ipnskey)
 	// Store ipns entry at "/ipns/"+b58(h(pubkey))
 	timectx, _ = context.WithDeadline(ctx, time.Now().Add(time.Second*10))
@@ -78,22 +113,100 @@ func (p *ipnsPublisher) Publish(ctx context.Context, k ci.PrivKey, value path.Pa
 	return nil
 }
 
-func createRoutingEntryData(pk ci.PrivKey, val path.Path) ([]byte, error) {
+// publishedKey is the key of the last entry published for id in the
+// datastore of the publisher.
+func publishedKey(id peer.ID) ds.Key {
+	return ds.NewKey("/namesys/published/" + id.Pretty())
+}
+
+// LastPublished returns the last entry published for id with dstore, as
+// given to NewRoutingPublisher, or ds.ErrNotFound.
+func LastPublished(dstore ds.Datastore, id peer.ID) (*pb.IpnsEntry, error) {
+	v, err := dstore.Get(publishedKey(id))
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("published entry of %s is not []byte", id)
+	}
+	entry := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(b, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// getPreviousSeqNo returns the highest sequence number of the last entry
+// published here for id and the entry currently published at ipnskey, or
+// 0 if there is neither. It fails when the network can't tell, so that a
+// new entry doesn't fall behind one it didn't see.
+func (p *ipnsPublisher) getPreviousSeqNo(ctx context.Context, id peer.ID, ipnskey u.Key) (uint64, error) {
+	var seq uint64
+	local, err := LastPublished(p.ds, id)
+	switch err {
+	case nil:
+		seq = local.GetSequence()
+	case ds.ErrNotFound:
+	default:
+		return 0, err
+	}
+
+	timectx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	prev, err := p.routing.GetValue(timectx, ipnskey)
+	switch err {
+	case nil:
+	case routing.ErrNotFound, ds.ErrNotFound:
+		log.Debugf("no previous ipns entry at %s", ipnskey)
+		return seq, nil
+	default:
+		return 0, fmt.Errorf("unable to find the previous ipns entry: %s", err)
+	}
+
+	entry := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(prev, entry); err != nil {
+		// the routing validates what it returns, this should not happen
+		log.Debugf("bad previous ipns entry at %s: %s", ipnskey, err)
+		return seq, nil
+	}
+	if entry.GetSequence() > seq {
+		seq = entry.GetSequence()
+	}
+	return seq, nil
+}
+
+func createRoutingEntryData(pk ci.PrivKey, val path.Path, seq uint64, eol time.Time) ([]byte, error) {
 	entry := new(pb.IpnsEntry)
 
 	entry.Value = []byte(val)
 	typ := pb.IpnsEntry_EOL
 	entry.ValidityType = &typ
-	entry.Validity = []byte(u.FormatRFC3339(time.Now().Add(time.Hour * 24)))
+	entry.Validity = []byte(u.FormatRFC3339(eol))
+	entry.Sequence = proto.Uint64(seq)
+	entry.Ttl = proto.Uint64(uint64(DefaultRecordTTL.Nanoseconds()))
 
 	sig, err := pk.Sign(ipnsEntryDataForSig(entry))
 	if err != nil {
 		return nil, err
 	}
 	entry.Signature = sig
+	sig, err = pk.Sign(ipnsEntryDataForSigV2(entry))
+	if err != nil {
+		return nil, err
+	}
+	entry.SignatureV2 = sig
+	// resolvers need not look the key up to verify the entry
+	entry.PubKey, err = pk.GetPublic().Bytes()
+	if err != nil {
+		return nil, err
+	}
 	return proto.Marshal(entry)
 }
 
+// ipnsEntryDataForSig returns the data covered by the signature of e,
+// which resolvers predating sequence numbers and TTLs verify.
 func ipnsEntryDataForSig(e *pb.IpnsEntry) []byte {
 	return bytes.Join([][]byte{
 		e.Value,
@@ -103,33 +216,142 @@ func ipnsEntryDataForSig(e *pb.IpnsEntry) []byte {
 		[]byte{})
 }
 
-var IpnsRecordValidator = &record.ValidChecker{
-	Func: ValidateIpnsRecord,
-	Sign: true,
+// ipnsEntryDataForSigV2 returns the data covered by the second signature
+// of e, which also covers its sequence number and TTL.
+func ipnsEntryDataForSigV2(e *pb.IpnsEntry) []byte {
+	return bytes.Join([][]byte{
+		ipnsEntryDataForSig(e),
+		[]byte(fmt.Sprintf("seq:%d", e.GetSequence())),
+		[]byte(fmt.Sprintf("ttl:%d", e.GetTtl())),
+	},
+		[]byte{})
+}
+
+// verifyIpnsEntry checks that e is signed by pubkey. The second signature
+// is checked when e carries one, entries published before it existed only
+// have the first.
+func verifyIpnsEntry(pubkey ci.PubKey, e *pb.IpnsEntry) bool {
+	if ok, err := pubkey.Verify(ipnsEntryDataForSig(e), e.GetSignature()); err != nil || !ok {
+		return false
+	}
+	if e.SignatureV2 == nil {
+		return true
+	}
+	ok, err := pubkey.Verify(ipnsEntryDataForSigV2(e), e.GetSignatureV2())
+	return err == nil && ok
+}
+
+// ErrPublicKeyNotFound is returned when the public key an IpnsEntry is
+// signed with is neither in the entry nor known.
+var ErrPublicKeyNotFound = errors.New("public key of ipns entry not found")
+
+// ErrSignature is returned when an IpnsEntry is not signed by the key of
+// its name.
+var ErrSignature = errors.New("ipns entry not signed by the key of its name")
+
+// NewIpnsRecordValidator returns the validator of IPNS entries. The public
+// key entries are signed with is the one they carry, or the one kbook holds
+// for their name.
+func NewIpnsRecordValidator(kbook peer.KeyBook) *record.ValidChecker {
+	return &record.ValidChecker{
+		Func: func(k u.Key, val []byte) error {
+			_, err := validateIpnsRecord(kbook, k, val)
+			return err
+		},
+		Sign: true,
+	}
 }
 
-// ValidateIpnsRecord implements ValidatorFunc and verifies that the
-// given 'val' is an IpnsEntry and that that entry is valid.
-func ValidateIpnsRecord(k u.Key, val []byte) error {
+// validateIpnsRecord checks that val is an IpnsEntry for k, still valid and
+// signed by the key of the name, and returns it.
+func validateIpnsRecord(kbook peer.KeyBook, k u.Key, val []byte) (*pb.IpnsEntry, error) {
 	entry := new(pb.IpnsEntry)
 	err := proto.Unmarshal(val, entry)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	switch entry.GetValidityType() {
 	case pb.IpnsEntry_EOL:
 		t, err := u.ParseRFC3339(string(entry.GetValidity()))
 		if err != nil {
 			log.Debug("Failed parsing time for ipns record EOL")
-			return err
+			return nil, err
 		}
 		if time.Now().After(t) {
-			return ErrExpiredRecord
+			return nil, ErrExpiredRecord
 		}
 	default:
-		return ErrUnrecognizedValidity
+		return nil, ErrUnrecognizedValidity
+	}
+
+	pubkey, err := ipnsEntryPubKey(kbook, peer.ID(strings.TrimPrefix(string(k), "/ipns/")), entry)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyIpnsEntry(pubkey, entry) {
+		return nil, ErrSignature
+	}
+	return entry, nil
+}
+
+// ipnsEntryPubKey returns the public key of name, as carried by e or held
+// by kbook, which may be nil.
+func ipnsEntryPubKey(kbook peer.KeyBook, name peer.ID, e *pb.IpnsEntry) (ci.PubKey, error) {
+	if e.PubKey != nil {
+		pubkey, err := ci.UnmarshalPublicKey(e.PubKey)
+		if err != nil {
+			return nil, err
+		}
+		id, err := peer.IDFromPublicKey(pubkey)
+		if err != nil {
+			return nil, err
+		}
+		if id != name {
+			return nil, ErrSignature
+		}
+		return pubkey, nil
+	}
+	if kbook != nil {
+		if pubkey := kbook.PubKey(name); pubkey != nil {
+			return pubkey, nil
+		}
+	}
+	return nil, ErrPublicKeyNotFound
+}
+
+// NewIpnsSelectorFunc returns the SelectorFunc of IPNS entries. It selects
+// the valid entry with the highest sequence number, and of those the one
+// that stays valid the longest. Entries are verified as by the validator
+// NewIpnsRecordValidator returns for kbook.
+func NewIpnsSelectorFunc(kbook peer.KeyBook) record.SelectorFunc {
+	return func(k u.Key, vals [][]byte) (int, error) {
+		best := -1
+		var bestEntry *pb.IpnsEntry
+		var bestEOL time.Time
+		for i, v := range vals {
+			entry, err := validateIpnsRecord(kbook, k, v)
+			if err != nil {
+				continue
+			}
+			// validateIpnsRecord checked the EOL parses
+			eol, _ := u.ParseRFC3339(string(entry.GetValidity()))
+
+			if best >= 0 {
+				if entry.GetSequence() < bestEntry.GetSequence() {
+					continue
+				}
+				if entry.GetSequence() == bestEntry.GetSequence() && !eol.After(bestEOL) {
+					continue
+				}
+			}
+			best, bestEntry, bestEOL = i, entry, eol
+		}
+
+		if best < 0 {
+			return 0, errors.New("no valid ipns entry to select")
+		}
+		return best, nil
 	}
-	return nil
 }
 
 // InitializeKeyspace sets the ipns record for the given key to
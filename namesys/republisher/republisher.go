@@ -0,0 +1,329 @@
+// Package republisher keeps the IPNS names a node published alive, by
+// publishing them again before their records expire.
+package republisher
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dsq "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/query"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	pb "github.com/ipfs/go-ipfs/namesys/internal/pb"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	path "github.com/ipfs/go-ipfs/path"
+	config "github.com/ipfs/go-ipfs/repo/config"
+	routing "github.com/ipfs/go-ipfs/routing"
+	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var log = eventlog.Logger("ipns-repub")
+
+// publishedKey is where the names published are tracked in the datastore,
+// one entry per name.
+var publishedKey = ds.NewKey("/local/ipns/published")
+
+// InitialDelay is how long Run waits before the first republish, so that
+// a daemon started and stopped right away does not republish.
+var InitialDelay = time.Minute
+
+var errBadEntry = errors.New("invalid republisher entry")
+
+// errSuperseded is recorded for the names whose record in the network is
+// newer than the last one published here: it was published from another
+// node with the same key, which takes the name over.
+var errSuperseded = errors.New("a newer record was published from elsewhere")
+
+// Entry is a name the node published, and the state of its republishing.
+type Entry struct {
+	Name      string    // the IPNS name, the hash of the public key
+	Value     path.Path // the path published at Name
+	Published time.Time // when Value was last published
+	EOL       time.Time // when the record last published expires
+	Error     string    `json:",omitempty"` // why the last republish failed
+}
+
+// Republisher records the names published through the NameSystem it
+// returns, and publishes them again every Interval, with records valid
+// for RecordLifetime. Names are published with the identity key of the
+// node, or a key of its keystore. Names are not republished once the
+// network has a newer record than the last one published here.
+type Republisher struct {
+	ns   namesys.NameSystem
+	r    routing.IpfsRouting
+	ds   ds.Datastore
+	self ci.PrivKey
+	ks   keystore.Keystore
+
+	Interval       time.Duration
+	RecordLifetime time.Duration
+
+	// serializes the updates of the entries
+	lk sync.Mutex
+}
+
+// NewRepublisher returns a Republisher publishing through ns, which
+// tracks names in d and looks the records in the network up with r. d
+// must be the datastore ns keeps the records it publishes in. ks may be
+// nil, if the node has no keystore.
+func NewRepublisher(ns namesys.NameSystem, r routing.IpfsRouting, d ds.Datastore, self ci.PrivKey, ks keystore.Keystore) *Republisher {
+	return &Republisher{
+		ns:             ns,
+		r:              r,
+		ds:             d,
+		self:           self,
+		ks:             ks,
+		Interval:       config.DefaultRepublishPeriod,
+		RecordLifetime: config.DefaultRecordLifetime,
+	}
+}
+
+// NameSystem returns the NameSystem of the Republisher, which records the
+// names published through it to be republished.
+func (rp *Republisher) NameSystem() namesys.NameSystem {
+	return &trackingNameSystem{NameSystem: rp.ns, rp: rp}
+}
+
+type trackingNameSystem struct {
+	namesys.NameSystem
+	rp *Republisher
+}
+
+func (t *trackingNameSystem) Publish(ctx context.Context, k ci.PrivKey, value path.Path) error {
+	return t.PublishWithEOL(ctx, k, value, time.Now().Add(t.rp.RecordLifetime))
+}
+
+func (t *trackingNameSystem) PublishWithEOL(ctx context.Context, k ci.PrivKey, value path.Path, eol time.Time) error {
+	if err := t.NameSystem.PublishWithEOL(ctx, k, value, eol); err != nil {
+		return err
+	}
+
+	id, err := peer.IDFromPrivateKey(k)
+	if err != nil {
+		return err
+	}
+	t.rp.lk.Lock()
+	defer t.rp.lk.Unlock()
+	return t.rp.putEntry(&Entry{
+		Name:      id.Pretty(),
+		Value:     value,
+		Published: time.Now(),
+		EOL:       eol,
+	})
+}
+
+// Run republishes the names every Interval, until ctx is done.
+func (rp *Republisher) Run(ctx context.Context) {
+	after := time.After(InitialDelay)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-after:
+			if err := rp.Republish(ctx); err != nil {
+				log.Debug(err)
+			}
+			after = time.After(rp.Interval)
+		}
+	}
+}
+
+// Republish publishes all the names again, with fresh records. Failures
+// are recorded in the entries of the names; the error returned is the
+// last of them.
+func (rp *Republisher) Republish(ctx context.Context) error {
+	entries, err := rp.Entries()
+	if err != nil {
+		return err
+	}
+	keys, err := rp.keys()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, e := range entries {
+		if err := rp.republish(ctx, e, keys); err != nil {
+			log.Debugf("republishing %s failed: %s", e.Name, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (rp *Republisher) republish(ctx context.Context, e *Entry, keys map[string]ci.PrivKey) error {
+	eol := time.Now().Add(rp.RecordLifetime)
+	var err error
+	k, ok := keys[e.Name]
+	if !ok {
+		err = fmt.Errorf("no key for %s", e.Name)
+	}
+	if err == nil {
+		err = rp.checkLatest(ctx, e.Name)
+	}
+	if err == nil {
+		err = rp.ns.PublishWithEOL(ctx, k, e.Value, eol)
+	}
+
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	// the name may have been published anew, or forgotten, meanwhile
+	cur, gerr := rp.getEntry(e.Name)
+	if gerr != nil || cur.Value != e.Value {
+		return err
+	}
+	if err != nil {
+		cur.Error = err.Error()
+	} else {
+		cur.Published, cur.EOL, cur.Error = time.Now(), eol, ""
+	}
+	if perr := rp.putEntry(cur); perr != nil {
+		return perr
+	}
+	return err
+}
+
+// checkLatest returns errSuperseded if the record of name in the network
+// has a higher sequence number than the last one published here.
+func (rp *Republisher) checkLatest(ctx context.Context, name string) error {
+	id, err := peer.IDB58Decode(name)
+	if err != nil {
+		return err
+	}
+	local, err := namesys.LastPublished(rp.ds, id)
+	switch err {
+	case nil:
+	case ds.ErrNotFound:
+		// nothing to compare with, the publisher picks the sequence
+		return nil
+	default:
+		return err
+	}
+
+	timectx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	val, err := rp.r.GetValue(timectx, u.Key("/ipns/"+string(id)))
+	switch err {
+	case nil:
+	case routing.ErrNotFound, ds.ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+
+	remote := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(val, remote); err != nil {
+		return err
+	}
+	if remote.GetSequence() > local.GetSequence() {
+		return errSuperseded
+	}
+	return nil
+}
+
+// keys returns the keys names can be published with, by IPNS name.
+func (rp *Republisher) keys() (map[string]ci.PrivKey, error) {
+	keys := []ci.PrivKey{rp.self}
+	if rp.ks != nil {
+		names, err := rp.ks.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			k, err := rp.ks.Get(n)
+			if err == keystore.ErrNoPassphrase {
+				log.Debug("no keystore passphrase, republishing the identity key only")
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, k)
+		}
+	}
+
+	byName := make(map[string]ci.PrivKey, len(keys))
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+		id, err := peer.IDFromPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		byName[id.Pretty()] = k
+	}
+	return byName, nil
+}
+
+// Forget stops tracking name: it is no longer republished. It is not an
+// error if name is not tracked.
+func (rp *Republisher) Forget(name string) error {
+	rp.lk.Lock()
+	defer rp.lk.Unlock()
+	err := rp.ds.Delete(publishedKey.ChildString(name))
+	if err == ds.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// Entries returns all the names tracked.
+func (rp *Republisher) Entries() ([]*Entry, error) {
+	res, err := rp.ds.Query(dsq.Query{Prefix: publishedKey.String()})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var entries []*Entry
+	for r := range res.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		e, err := decodeEntry(r.Value)
+		if err != nil {
+			log.Debugf("skipping %s: %s", r.Key, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (rp *Republisher) getEntry(name string) (*Entry, error) {
+	v, err := rp.ds.Get(publishedKey.ChildString(name))
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(v)
+}
+
+func (rp *Republisher) putEntry(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return rp.ds.Put(publishedKey.ChildString(e.Name), b)
+}
+
+func decodeEntry(v interface{}) (*Entry, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errBadEntry
+	}
+	e := new(Entry)
+	if err := json.Unmarshal(b, e); err != nil {
+		return nil, errBadEntry
+	}
+	return e, nil
+}
@@ -0,0 +1,169 @@
+package republisher
+
+import (
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	keystore "github.com/ipfs/go-ipfs/keystore"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
+	path "github.com/ipfs/go-ipfs/path"
+	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
+	testutil "github.com/ipfs/go-ipfs/util/testutil"
+)
+
+func TestRepublish(t *testing.T) {
+	ctx := context.Background()
+	r := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	self, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := keystore.NewMemKeystore()
+	if err := ks.Put("other", other); err != nil {
+		t.Fatal(err)
+	}
+
+	rp := NewRepublisher(namesys.NewNameSystem(r, d, 0), r, d, self, ks)
+	rp.RecordLifetime = time.Hour
+	ns := rp.NameSystem()
+
+	p1 := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	p2 := path.FromString("/ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	if err := ns.Publish(ctx, self, p1); err != nil {
+		t.Fatal(err)
+	}
+	if err := ns.Publish(ctx, other, p1); err != nil {
+		t.Fatal(err)
+	}
+	// the last publish of a name is the one tracked
+	if err := ns.Publish(ctx, other, p2); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := rp.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 names tracked, got %d", len(entries))
+	}
+	otherID, err := peer.IDFromPrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := make(map[string]*Entry)
+	for _, e := range entries {
+		published[e.Name] = e
+	}
+	oe := published[otherID.Pretty()]
+	if oe == nil || oe.Value != p2 {
+		t.Fatalf("wrong entry tracked for the keystore key: %v", oe)
+	}
+	if oe.EOL.Sub(oe.Published) > time.Hour {
+		t.Fatal("record published with the wrong lifetime")
+	}
+
+	// republishing fails for the names whose key is gone
+	if err := ks.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Republish(ctx); err == nil {
+		t.Fatal("expected republishing without the key to fail")
+	}
+	entries, err = rp.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		switch e.Name {
+		case otherID.Pretty():
+			if e.Error == "" || !e.Published.Equal(oe.Published) {
+				t.Fatalf("failed republish not recorded: %v", e)
+			}
+		default:
+			if e.Error != "" || !e.Published.After(published[e.Name].Published) {
+				t.Fatalf("republish not recorded: %v", e)
+			}
+		}
+	}
+
+	resolved, err := namesys.NewNameSystem(r, d, 0).Resolve(ctx, otherID.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != p2 {
+		t.Fatalf("resolved to %s, expected %s", resolved, p2)
+	}
+}
+
+func TestRepublishSuperseded(t *testing.T) {
+	ctx := context.Background()
+	r := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	d := dssync.MutexWrap(ds.NewMapDatastore())
+
+	self, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(self)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rp := NewRepublisher(namesys.NewNameSystem(r, d, 0), r, d, self, nil)
+	p1 := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	p2 := path.FromString("/ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH")
+	if err := rp.NameSystem().Publish(ctx, self, p1); err != nil {
+		t.Fatal(err)
+	}
+
+	// another node with the same key publishes a newer record
+	elsewhere := namesys.NewNameSystem(r, dssync.MutexWrap(ds.NewMapDatastore()), 0)
+	if err := elsewhere.Publish(ctx, self, p2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rp.Republish(ctx); err != errSuperseded {
+		t.Fatalf("expected %s, got %v", errSuperseded, err)
+	}
+	e, err := rp.getEntry(id.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Error != errSuperseded.Error() {
+		t.Fatalf("superseded name not recorded: %v", e)
+	}
+	resolved, err := namesys.NewNameSystem(r, d, 0).Resolve(ctx, id.Pretty())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved != p2 {
+		t.Fatalf("newer record overwritten: resolved to %s", resolved)
+	}
+
+	if err := rp.Forget(id.Pretty()); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := rp.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no names tracked, got %d", len(entries))
+	}
+	// forgetting is idempotent
+	if err := rp.Forget(id.Pretty()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,21 @@
 package namesys
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	pb "github.com/ipfs/go-ipfs/namesys/internal/pb"
+	ci "github.com/ipfs/go-ipfs/p2p/crypto"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	path "github.com/ipfs/go-ipfs/path"
+	routing "github.com/ipfs/go-ipfs/routing"
 	mockrouting "github.com/ipfs/go-ipfs/routing/mock"
 	u "github.com/ipfs/go-ipfs/util"
 	testutil "github.com/ipfs/go-ipfs/util/testutil"
@@ -14,7 +25,7 @@ func TestRoutingResolve(t *testing.T) {
 	d := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
 
 	resolver := NewRoutingResolver(d)
-	publisher := NewRoutingPublisher(d)
+	publisher := NewRoutingPublisher(d, dssync.MutexWrap(ds.NewMapDatastore()))
 
 	privk, pubk, err := testutil.RandTestKeyPair(512)
 	if err != nil {
@@ -42,3 +53,260 @@ func TestRoutingResolve(t *testing.T) {
 		t.Fatal("Got back incorrect value.")
 	}
 }
+
+func TestPublishIncrementsSequence(t *testing.T) {
+	d := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+
+	resolver := NewRoutingResolver(d)
+	publisher := NewRoutingPublisher(d, dssync.MutexWrap(ds.NewMapDatastore()))
+
+	privk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubkb, err := pubk.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkhash := u.Hash(pubkb)
+
+	for i, p := range []string{
+		"/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN",
+		"/ipfs/QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+	} {
+		h := path.FromString(p)
+		if err := publisher.Publish(context.Background(), privk, h); err != nil {
+			t.Fatal(err)
+		}
+
+		val, err := d.GetValue(context.Background(), u.Key("/ipns/"+string(pkhash)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry := new(pb.IpnsEntry)
+		if err := proto.Unmarshal(val, entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetSequence() != uint64(i+1) {
+			t.Fatalf("expected sequence %d, got %d", i+1, entry.GetSequence())
+		}
+
+		res, err := resolver.Resolve(context.Background(), u.Key(pkhash).Pretty())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != h {
+			t.Fatal("Got back incorrect value.")
+		}
+	}
+}
+
+func TestIpnsSelector(t *testing.T) {
+	privk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forger, _, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pubk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := u.Key("/ipns/" + string(id))
+
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	entry := func(sk ci.PrivKey, seq uint64, eol time.Time) []byte {
+		b, err := createRoutingEntryData(sk, p, seq, eol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	// signed by the forger, carrying the key of the name
+	forged := func(seq uint64, eol time.Time) []byte {
+		e := new(pb.IpnsEntry)
+		if err := proto.Unmarshal(entry(forger, seq, eol), e); err != nil {
+			t.Fatal(err)
+		}
+		if e.PubKey, err = pubk.Bytes(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	now := time.Now()
+	vals := [][]byte{
+		entry(privk, 1, now.Add(time.Hour)),
+		entry(privk, 3, now.Add(-time.Hour)), // expired
+		entry(privk, 2, now.Add(time.Hour)),
+		entry(privk, 2, now.Add(time.Hour*2)),
+		[]byte("not an entry"),
+		entry(forger, 5, now.Add(time.Hour)), // the key of another name
+		forged(5, now.Add(time.Hour)),
+	}
+	sel := NewIpnsSelectorFunc(nil)
+	i, err := sel(k, vals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 3 {
+		t.Fatalf("expected entry 3 to be selected, got %d", i)
+	}
+
+	if _, err := sel(k, vals[1:2]); err == nil {
+		t.Fatal("selected an expired entry")
+	}
+	if _, err := sel(k, vals[5:]); err == nil {
+		t.Fatal("selected a forged entry")
+	}
+	v := NewIpnsRecordValidator(nil)
+	for _, val := range vals[5:] {
+		if err := v.Func(k, val); err == nil {
+			t.Fatal("validated a forged entry")
+		}
+	}
+	if err := v.Func(k, vals[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries without their key verify against the key book
+	e := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(vals[0], e); err != nil {
+		t.Fatal(err)
+	}
+	e.PubKey = nil
+	bare, err := proto.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Func(k, bare); err != ErrPublicKeyNotFound {
+		t.Fatalf("expected ErrPublicKeyNotFound, got %v", err)
+	}
+	ps := peer.NewPeerstore()
+	if err := ps.AddPubKey(id, pubk); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewIpnsRecordValidator(ps).Func(k, bare); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// baselineDataForSig is the data resolvers predating sequence numbers
+// verify the signature of entries over.
+func baselineDataForSig(e *pb.IpnsEntry) []byte {
+	return bytes.Join([][]byte{
+		e.Value,
+		e.Validity,
+		[]byte(fmt.Sprint(e.GetValidityType())),
+	},
+		[]byte{})
+}
+
+func TestIpnsEntrySignatures(t *testing.T) {
+	sk, pk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := u.Key("/ipns/" + string(id))
+
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+	b, err := createRoutingEntryData(sk, p, 7, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := new(pb.IpnsEntry)
+	if err := proto.Unmarshal(b, e); err != nil {
+		t.Fatal(err)
+	}
+
+	// older resolvers verify new entries
+	if ok, err := pk.Verify(baselineDataForSig(e), e.GetSignature()); err != nil || !ok {
+		t.Fatal("new entry does not verify as before sequence numbers")
+	}
+
+	v := NewIpnsRecordValidator(nil)
+	marshal := func(e *pb.IpnsEntry) []byte {
+		b, err := proto.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// the sequence number and TTL are covered by the second signature
+	seq := *e
+	seq.Sequence = proto.Uint64(8)
+	if err := v.Func(k, marshal(&seq)); err != ErrSignature {
+		t.Fatalf("expected ErrSignature for a changed sequence number, got %v", err)
+	}
+	ttl := *e
+	ttl.Ttl = proto.Uint64(1)
+	if err := v.Func(k, marshal(&ttl)); err != ErrSignature {
+		t.Fatalf("expected ErrSignature for a changed ttl, got %v", err)
+	}
+
+	// entries of older publishers only have the first signature
+	old := *e
+	old.Sequence, old.Ttl, old.SignatureV2 = nil, nil, nil
+	if err := v.Func(k, marshal(&old)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// failingRouting fails to get any value.
+type failingRouting struct {
+	routing.IpfsRouting
+}
+
+func (failingRouting) GetValue(context.Context, u.Key) ([]byte, error) {
+	return nil, errors.New("network unreachable")
+}
+
+func TestPublishSequence(t *testing.T) {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	privk, pubk, err := testutil.RandTestKeyPair(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPublicKey(pubk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := path.FromString("/ipfs/QmZULkCELmmk5XNfCgTnCyFgAVxBRBXyDHGGMVoLFLiXEN")
+
+	d := mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	for i := 0; i < 2; i++ {
+		if err := NewRoutingPublisher(d, dstore).Publish(ctx, privk, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a network which lost the entries published so far
+	d = mockrouting.NewServer().Client(testutil.RandIdentityOrFatal(t))
+	if err := NewRoutingPublisher(d, dstore).Publish(ctx, privk, p); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := LastPublished(dstore, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetSequence() != 3 {
+		t.Fatalf("expected sequence number 3, got %d", entry.GetSequence())
+	}
+
+	// nor does a network which can't tell
+	if err := NewRoutingPublisher(failingRouting{d}, dstore).Publish(ctx, privk, p); err == nil {
+		t.Fatal("published without knowing the previous entry")
+	}
+}
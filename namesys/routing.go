@@ -2,12 +2,14 @@ package namesys
 
 import (
 	"fmt"
+	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	pb "github.com/ipfs/go-ipfs/namesys/internal/pb"
+	peer "github.com/ipfs/go-ipfs/p2p/peer"
 	path "github.com/ipfs/go-ipfs/path"
 	routing "github.com/ipfs/go-ipfs/routing"
 	u "github.com/ipfs/go-ipfs/util"
@@ -39,11 +41,18 @@ func (r *routingResolver) CanResolve(name string) bool {
 // Resolve implements Resolver. Uses the IPFS routing system to resolve SFS-like
 // names.
 func (r *routingResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	p, _, err := r.resolveTTL(ctx, name)
+	return p, err
+}
+
+// resolveTTL implements ttlResolver. Entries are cached for their TTL, or
+// DefaultRecordTTL for the entries without one, but never past their EOL.
+func (r *routingResolver) resolveTTL(ctx context.Context, name string) (path.Path, time.Duration, error) {
 	log.Debugf("RoutingResolve: '%s'", name)
 	hash, err := mh.FromB58String(name)
 	if err != nil {
 		log.Warning("RoutingResolve: bad input hash: [%s]\n", name)
-		return "", err
+		return "", 0, err
 	}
 	// name should be a multihash. if it isn't, error out here.
 
@@ -55,39 +64,60 @@ func (r *routingResolver) Resolve(ctx context.Context, name string) (path.Path,
 	val, err := r.routing.GetValue(ctx, ipnsKey)
 	if err != nil {
 		log.Warning("RoutingResolve get failed.")
-		return "", err
+		return "", 0, err
 	}
 
 	entry := new(pb.IpnsEntry)
 	err = proto.Unmarshal(val, entry)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
-	// name should be a public key retrievable from ipfs
-	pubkey, err := routing.GetPublicKey(r.routing, ctx, hash)
+	// the entry carries the public key of the name, else it should be
+	// retrievable from ipfs
+	pubkey, err := ipnsEntryPubKey(nil, peer.ID(hash), entry)
+	if err == ErrPublicKeyNotFound {
+		pubkey, err = routing.GetPublicKey(r.routing, ctx, hash)
+	}
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	hsh, _ := pubkey.Hash()
 	log.Debugf("pk hash = %s", u.Key(hsh))
 
 	// check sig with pk
-	if ok, err := pubkey.Verify(ipnsEntryDataForSig(entry), entry.GetSignature()); err != nil || !ok {
-		return "", fmt.Errorf("Invalid value. Not signed by PrivateKey corresponding to %v", pubkey)
+	if !verifyIpnsEntry(pubkey, entry) {
+		return "", 0, fmt.Errorf("Invalid value. Not signed by PrivateKey corresponding to %v", pubkey)
 	}
 
 	// ok sig checks out. this is a valid name.
 
+	ttl := DefaultRecordTTL
+	if entry.Ttl != nil {
+		ttl = time.Duration(entry.GetTtl())
+	}
+	if eol, err := u.ParseRFC3339(string(entry.GetValidity())); err == nil {
+		ttl = recordTTL(ttl, eol)
+	}
+
 	// check for old style record:
 	valh, err := mh.Cast(entry.GetValue())
 	if err != nil {
 		// Not a multihash, probably a new record
-		return path.ParsePath(string(entry.GetValue()))
+		p, err := path.ParsePath(string(entry.GetValue()))
+		return p, ttl, err
 	} else {
 		// Its an old style multihash record
 		log.Warning("Detected old style multihash record")
-		return path.FromKey(u.Key(valh)), nil
+		return path.FromKey(u.Key(valh)), ttl, nil
+	}
+}
+
+// recordTTL returns ttl, or less if the record expires at eol before.
+func recordTTL(ttl time.Duration, eol time.Time) time.Duration {
+	if left := eol.Sub(time.Now()); left < ttl {
+		return left
 	}
+	return ttl
 }
@@ -68,15 +68,21 @@ func ParsePath(txt string) (Path, error) {
 		return "", ErrBadPath
 	}
 
-	if parts[1] != "ipfs" && parts[1] != "ipns" {
+	switch parts[1] {
+	case "ipfs":
+		_, err := ParseKeyToPath(parts[2])
+		if err != nil {
+			return "", err
+		}
+	case "ipns":
+		// names may be keys, but also domains or proquints
+		if parts[2] == "" {
+			return "", ErrBadPath
+		}
+	default:
 		return "", ErrBadPath
 	}
 
-	_, err := ParseKeyToPath(parts[2])
-	if err != nil {
-		return "", err
-	}
-
 	return Path(txt), nil
 }
 
@@ -3,12 +3,15 @@ package path
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	merkledag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
 	u "github.com/ipfs/go-ipfs/util"
 )
 
@@ -102,18 +105,11 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 	// for each of the path components
 	for _, name := range names {
 
-		var next u.Key
-		var nlink *merkledag.Link
-		// for each of the links in nd, the current object
-		for _, link := range nd.Links {
-			if link.Name == name {
-				next = u.Key(link.Hash)
-				nlink = link
-				break
-			}
+		nlink, err := s.findLink(ctx, nd, name)
+		if err != nil {
+			return result, err
 		}
-
-		if next == "" {
+		if nlink == nil {
 			n, _ := nd.Multihash()
 			return result, ErrNoLink{name: name, node: n}
 		}
@@ -122,8 +118,7 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 			// fetch object for link and assign to nd
 			ctx, cancel := context.WithTimeout(ctx, time.Minute)
 			defer cancel()
-			var err error
-			nd, err = s.DAG.Get(ctx, next)
+			nd, err = nlink.GetNode(ctx, s.DAG)
 			if err != nil {
 				return append(result, nd), err
 			}
@@ -136,3 +131,27 @@ func (s *Resolver) ResolveLinks(ctx context.Context, ndd *merkledag.Node, names
 	}
 	return result, nil
 }
+
+// findLink returns the link named name in nd, or nil. The entries of a
+// sharded unixfs directory are looked up in its trie, as the links of its
+// nodes are not named after them.
+func (s *Resolver) findLink(ctx context.Context, nd *merkledag.Node, name string) (*merkledag.Link, error) {
+	if pbd, err := ft.FromBytes(nd.Data); err == nil && pbd.GetType() == ft.THAMTShard {
+		shard, err := hamt.NewHamtFromDag(s.DAG, nd)
+		if err != nil {
+			return nil, err
+		}
+		lnk, err := shard.Find(ctx, name)
+		if err == os.ErrNotExist {
+			return nil, nil
+		}
+		return lnk, err
+	}
+
+	for _, link := range nd.Links {
+		if link.Name == name {
+			return link, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,192 @@
+// package gc implements a mark and sweep garbage collector for the
+// local blockstore.
+package gc
+
+import (
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	bstore "github.com/ipfs/go-ipfs/blocks/blockstore"
+	"github.com/ipfs/go-ipfs/blocks/bloom"
+	bserv "github.com/ipfs/go-ipfs/blockservice"
+	offline "github.com/ipfs/go-ipfs/exchange/offline"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	pin "github.com/ipfs/go-ipfs/pin"
+	eventlog "github.com/ipfs/go-ipfs/thirdparty/eventlog"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+var log = eventlog.Logger("gc")
+
+// bloom filter bytes allocated per marked key, roughly ten bits per key.
+const bloomBytesPerKey = 2
+
+// GC performs a mark and sweep garbage collection of the blocks in bs.
+// First it builds a marked set containing:
+//   - all recursively pinned blocks, plus all of their descendants
+//   - all directly pinned blocks
+//   - the blocks the pinner stores its own state in
+//   - the blocks bs expects, on their way to a pin
+//
+// Then it iterates over every block in the blockstore and deletes any
+// block that is not found in the marked set. The removed keys are sent on
+// the returned channel. If dryRun is set, nothing is deleted and the keys
+// that would have been removed are reported instead.
+//
+// The GC lock on bs is held for the whole run, so imports that take the
+// pin lock wait until the sweep has finished.
+func GC(ctx context.Context, bs bstore.GCBlockstore, pn pin.Pinner, dryRun bool) (<-chan u.Key, error) {
+	unlock := bs.GCLock()
+
+	// only ever look at local blocks, never ask the network
+	bsrv, err := bserv.New(bs, offline.Exchange(bs))
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	ds := dag.NewDAGService(bsrv)
+
+	marked, err := ColoredSet(ctx, pn, ds, bs.ExpectedKeys())
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	keychan, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	output := make(chan u.Key)
+	go func() {
+		defer close(output)
+		defer unlock()
+		for {
+			select {
+			case k, ok := <-keychan:
+				if !ok {
+					return
+				}
+				if marked.HasKey(k) {
+					continue
+				}
+				if !dryRun {
+					err := bs.DeleteBlock(k)
+					if err != nil {
+						log.Debugf("Error removing key from blockstore: %s", err)
+						continue
+					}
+				}
+				select {
+				case output <- k:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return output, nil
+}
+
+// ColoredSet walks every recursive pin of pn through ds and returns the
+// set of keys that must be kept: the recursive roots, all of their
+// descendants, the direct pins, the pinner's internal nodes and the keys
+// of live.
+func ColoredSet(ctx context.Context, pn pin.Pinner, ds dag.DAGService, live []u.Key) (*MarkSet, error) {
+	ms := newMarkSet()
+
+	for _, k := range live {
+		ms.add(k)
+	}
+
+	for _, k := range pn.RecursiveKeys() {
+		if err := markTree(ctx, ds, k, ms); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, k := range pn.DirectKeys() {
+		ms.add(k)
+	}
+
+	// the pin set nodes link to every pinned key, walking them would pin
+	// the children of direct pins too
+	for _, k := range pn.InternalPins() {
+		ms.add(k)
+	}
+
+	ms.buildFilter()
+	return ms, nil
+}
+
+func markTree(ctx context.Context, ds dag.DAGService, k u.Key, ms *MarkSet) error {
+	if ms.has(k) {
+		// shared subtree, already walked
+		return nil
+	}
+
+	nd, err := ds.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	ms.add(k)
+
+	for _, l := range nd.Links {
+		if l.Raw || l.Inline != nil {
+			// leaves, which need not be fetched
+			ms.add(u.Key(l.Hash))
+			continue
+		}
+		if err := markTree(ctx, ds, u.Key(l.Hash), ms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarkSet is the set of live keys built by the mark phase. A bloom filter
+// sits in front of the key map so that the sweep can rule out most dead
+// blocks without a map lookup.
+type MarkSet struct {
+	keys   map[u.Key]struct{}
+	filter bloom.Filter
+}
+
+func newMarkSet() *MarkSet {
+	return &MarkSet{keys: make(map[u.Key]struct{})}
+}
+
+func (ms *MarkSet) add(k u.Key) {
+	ms.keys[k] = struct{}{}
+}
+
+func (ms *MarkSet) has(k u.Key) bool {
+	_, ok := ms.keys[k]
+	return ok
+}
+
+func (ms *MarkSet) buildFilter() {
+	size := len(ms.keys) * bloomBytesPerKey
+	if size < 2048 {
+		size = 2048
+	}
+	ms.filter = bloom.NewFilter(size)
+	for k := range ms.keys {
+		ms.filter.Add([]byte(k))
+	}
+}
+
+// HasKey returns whether k was marked as live.
+func (ms *MarkSet) HasKey(k u.Key) bool {
+	if ms.filter != nil && !ms.filter.Find([]byte(k)) {
+		return false
+	}
+	return ms.has(k)
+}
+
+// Len returns the number of marked keys.
+func (ms *MarkSet) Len() int {
+	return len(ms.keys)
+}
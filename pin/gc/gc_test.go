@@ -0,0 +1,213 @@
+package gc
+
+import (
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	blocks "github.com/ipfs/go-ipfs/blocks"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	bs "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+// shared, so that nodes made in quick succession still differ
+var rnd = util.NewTimeSeededRand()
+
+func randNode() (*mdag.Node, util.Key) {
+	nd := new(mdag.Node)
+	nd.Data = make([]byte, 32)
+	rnd.Read(nd.Data)
+	k, _ := nd.Key()
+	return nd, k
+}
+
+type gcFixture struct {
+	bstore blockstore.GCBlockstore
+	dserv  mdag.DAGService
+	pinner pin.Pinner
+
+	root, child, shared, direct, loose util.Key
+}
+
+func setupFixture(t *testing.T) *gcFixture {
+	ctx := context.Background()
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	f := &gcFixture{bstore: blockstore.NewBlockstore(dstore)}
+	bserv, err := bs.New(f.bstore, offline.Exchange(f.bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.dserv = mdag.NewDAGService(bserv)
+	f.pinner = pin.NewPinner(dstore, f.dserv)
+
+	shared, sk := randNode()
+	child, _ := randNode()
+	if err := child.AddNodeLink("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	ck, _ := child.Key()
+	root, _ := randNode()
+	if err := root.AddNodeLink("child", child); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("shared", shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.dserv.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+	rk, _ := root.Key()
+	if err := f.pinner.Pin(ctx, root, true); err != nil {
+		t.Fatal(err)
+	}
+
+	direct, dk := randNode()
+	if _, err := f.dserv.Add(direct); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.pinner.Pin(ctx, direct, false); err != nil {
+		t.Fatal(err)
+	}
+
+	loose, lk := randNode()
+	if _, err := f.dserv.Add(loose); err != nil {
+		t.Fatal(err)
+	}
+
+	f.root, f.child, f.shared, f.direct, f.loose = rk, ck, sk, dk, lk
+	return f
+}
+
+func (f *gcFixture) mustHave(t *testing.T, k util.Key, want bool) {
+	has, err := f.bstore.Has(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has != want {
+		t.Fatalf("expected Has(%s) to be %t", k, want)
+	}
+}
+
+func collect(t *testing.T, out <-chan util.Key) []util.Key {
+	var keys []util.Key
+	for k := range out {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestGCRemovesOnlyUnpinned(t *testing.T) {
+	f := setupFixture(t)
+
+	out, err := GC(context.Background(), f.bstore, f.pinner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := collect(t, out)
+	if len(removed) != 1 || removed[0] != f.loose {
+		t.Fatalf("expected only the loose block to be removed, got %v", removed)
+	}
+
+	f.mustHave(t, f.root, true)
+	f.mustHave(t, f.child, true)
+	f.mustHave(t, f.shared, true)
+	f.mustHave(t, f.direct, true)
+	f.mustHave(t, f.loose, false)
+}
+
+func TestGCDryRun(t *testing.T) {
+	f := setupFixture(t)
+
+	out, err := GC(context.Background(), f.bstore, f.pinner, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := collect(t, out)
+	if len(removed) != 1 || removed[0] != f.loose {
+		t.Fatalf("expected the loose block to be reported, got %v", removed)
+	}
+	f.mustHave(t, f.loose, true)
+}
+
+func TestGCIgnoresIndirectCounts(t *testing.T) {
+	f := setupFixture(t)
+
+	// a broken indirect refcount must not make the child collectable
+	f.pinner.GetManual().RemovePinWithMode(f.child, pin.Indirect)
+
+	out, err := GC(context.Background(), f.bstore, f.pinner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, out)
+	f.mustHave(t, f.child, true)
+}
+
+func TestGCMissingPinnedBlock(t *testing.T) {
+	f := setupFixture(t)
+	if err := f.bstore.DeleteBlock(f.child); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := GC(context.Background(), f.bstore, f.pinner, false)
+	if err == nil {
+		t.Fatal("expected gc to refuse to run with a pinned block missing")
+	}
+	f.mustHave(t, f.loose, true)
+
+	// the gc lock must have been released on error
+	f.bstore.PinLock()()
+}
+
+func TestGCKeepsPinState(t *testing.T) {
+	f := setupFixture(t)
+	if err := f.pinner.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := GC(context.Background(), f.bstore, f.pinner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, out)
+
+	for _, k := range f.pinner.InternalPins() {
+		f.mustHave(t, k, true)
+	}
+}
+
+func TestGCKeepsSessionBlocks(t *testing.T) {
+	f := setupFixture(t)
+
+	// a block written for a pin which is yet to be made
+	sess := blockstore.NewSession(f.bstore)
+	nd, k := randNode()
+	enc, err := nd.Encoded(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Put(blocks.NewBlock(enc)); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := GC(context.Background(), f.bstore, f.pinner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, out)
+	f.mustHave(t, k, true)
+	f.mustHave(t, f.loose, false)
+
+	sess.Close()
+	out, err = GC(context.Background(), f.bstore, f.pinner, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collect(t, out)
+	f.mustHave(t, k, false)
+}
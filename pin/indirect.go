@@ -11,16 +11,29 @@ type indirectPin struct {
 	refCounts map[util.Key]int
 }
 
-func NewIndirectPin(dstore ds.Datastore) *indirectPin {
+func newIndirectPin() *indirectPin {
 	return &indirectPin{
-		blockset:  set.NewDBWrapperSet(dstore, set.NewSimpleBlockSet()),
+		blockset:  set.NewSimpleBlockSet(),
 		refCounts: make(map[util.Key]int),
 	}
 }
 
+func indirectPinFromRefcounts(refcnt map[util.Key]int) *indirectPin {
+	var keys []util.Key
+	for k, v := range refcnt {
+		if v > 0 {
+			keys = append(keys, k)
+		} else {
+			delete(refcnt, k)
+		}
+	}
+	return &indirectPin{blockset: set.SimpleSetFromKeys(keys), refCounts: refcnt}
+}
+
+// loadIndirPin reads a legacy JSON encoded refcount map
 func loadIndirPin(d ds.Datastore, k ds.Key) (*indirectPin, error) {
 	var rcStore map[string]int
-	err := loadSet(d, k, &rcStore)
+	err := loadLegacySet(d, k, &rcStore)
 	if err != nil {
 		return nil, err
 	}
@@ -39,15 +52,6 @@ func loadIndirPin(d ds.Datastore, k ds.Key) (*indirectPin, error) {
 	return &indirectPin{blockset: set.SimpleSetFromKeys(keys), refCounts: refcnt}, nil
 }
 
-func storeIndirPin(d ds.Datastore, k ds.Key, p *indirectPin) error {
-
-	rcStore := map[string]int{}
-	for k, v := range p.refCounts {
-		rcStore[util.B58KeyEncode(k)] = v
-	}
-	return storeSet(d, k, rcStore)
-}
-
 func (i *indirectPin) Increment(k util.Key) {
 	c := i.refCounts[k]
 	i.refCounts[k] = c + 1
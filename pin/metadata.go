@@ -0,0 +1,98 @@
+package pin
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+// name of the link from the pin root to the metadata set
+const linkMetadata = "metadata"
+
+// Metadata describes why a key is pinned. All fields are optional.
+type Metadata struct {
+	Name    string    `json:",omitempty"`
+	Owner   string    `json:",omitempty"`
+	Created time.Time `json:",omitempty"`
+	Tags    []string  `json:",omitempty"`
+}
+
+// HasTag returns whether tag is one of the tags of m.
+func (m *Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// annotation is the dag encoding of the metadata of a single pin. It does
+// not link to the pinned key, so the metadata set never keeps anything
+// alive by itself.
+type annotation struct {
+	Key util.Key
+	Metadata
+}
+
+func annotationNode(k util.Key, m *Metadata) (*mdag.Node, error) {
+	data, err := json.Marshal(&annotation{Key: k, Metadata: *m})
+	if err != nil {
+		return nil, err
+	}
+	return &mdag.Node{Data: data}, nil
+}
+
+// storeMetadata writes every annotation as its own dag node, and a set
+// linking to all of them.
+func storeMetadata(ctx context.Context, dag mdag.DAGService, meta map[util.Key]*Metadata, internalKeys keyObserver) (*mdag.Node, error) {
+	keys := make([]util.Key, 0, len(meta))
+	for k, m := range meta {
+		n, err := annotationNode(k, m)
+		if err != nil {
+			return nil, err
+		}
+		ak, err := dag.Add(n)
+		if err != nil {
+			return nil, err
+		}
+		internalKeys(ak)
+		keys = append(keys, ak)
+	}
+	return storeSet(ctx, dag, keys, internalKeys)
+}
+
+// loadMetadata reads the annotations linked from root. Pin roots written
+// before metadata existed have no metadata link and load as empty.
+func loadMetadata(ctx context.Context, dag mdag.DAGService, root *mdag.Node, internalKeys keyObserver) (map[util.Key]*Metadata, error) {
+	meta := make(map[util.Key]*Metadata)
+	if _, err := root.GetNodeLink(linkMetadata); err == mdag.ErrNotFound {
+		return meta, nil
+	}
+
+	keys, err := loadSet(ctx, dag, root, linkMetadata, internalKeys)
+	if err != nil {
+		return nil, err
+	}
+	for _, ak := range keys {
+		internalKeys(ak)
+		n, err := dag.Get(ctx, ak)
+		if err != nil {
+			return nil, err
+		}
+		var a annotation
+		if err := json.Unmarshal(n.Data, &a); err != nil {
+			return nil, err
+		}
+		if a.Key == "" {
+			return nil, errors.New("pin annotation without key")
+		}
+		m := a.Metadata
+		meta[a.Key] = &m
+	}
+	return meta, nil
+}
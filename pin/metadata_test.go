@@ -0,0 +1,64 @@
+package pin
+
+import (
+	"testing"
+	"time"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+)
+
+func TestMetadataPersists(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDag(t)
+	p := NewPinner(dstore, dserv)
+
+	a, ak := randNode()
+	if _, err := dserv.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2015, 7, 1, 12, 0, 0, 0, time.UTC)
+	meta := &Metadata{Name: "website", Owner: "ops", Created: created, Tags: []string{"prod", "www"}}
+	if err := p.Annotate(ak, meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	np, err := LoadPinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := np.Metadata(ak)
+	if m == nil {
+		t.Fatal("metadata was not persisted")
+	}
+	if m.Name != "website" || m.Owner != "ops" || !m.Created.Equal(created) {
+		t.Fatalf("unexpected metadata %#v", m)
+	}
+	if !m.HasTag("www") || m.HasTag("dev") {
+		t.Fatalf("unexpected tags %v", m.Tags)
+	}
+
+	// metadata goes away with the pin
+	if err := np.Unpin(ctx, ak, true); err != nil {
+		t.Fatal(err)
+	}
+	if np.Metadata(ak) != nil {
+		t.Fatal("metadata should be removed with the pin")
+	}
+}
+
+func TestAnnotateRequiresPin(t *testing.T) {
+	dstore, dserv := newTestDag(t)
+	p := NewPinner(dstore, dserv)
+
+	_, ak := randNode()
+	if err := p.Annotate(ak, &Metadata{Name: "nope"}); err == nil {
+		t.Fatal("expected annotating an unpinned key to fail")
+	}
+}
@@ -7,9 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	nsds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/namespace"
 	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 	"github.com/ipfs/go-ipfs/blocks/set"
 	mdag "github.com/ipfs/go-ipfs/merkledag"
@@ -17,10 +17,25 @@ import (
 )
 
 var log = util.Logger("pin")
+
+// pinDatastoreKey holds the key of the merkledag node rooting all pin sets
+var pinDatastoreKey = ds.NewKey("/local/pins")
+
+// keys of the JSON encoded pin sets written by older versions
 var recursePinDatastoreKey = ds.NewKey("/local/pins/recursive/keys")
 var directPinDatastoreKey = ds.NewKey("/local/pins/direct/keys")
 var indirectPinDatastoreKey = ds.NewKey("/local/pins/indirect/keys")
 
+// names of the links from the pin root to the individual sets
+const (
+	linkDirect    = "direct"
+	linkRecursive = "recursive"
+	linkIndirect  = "indirect"
+)
+
+// loadTimeout bounds how long loading the pin sets from the dag may take
+const loadTimeout = time.Minute
+
 type PinMode int
 
 const (
@@ -34,11 +49,28 @@ type Pinner interface {
 	IsPinned(util.Key) bool
 	Pin(context.Context, *mdag.Node, bool) error
 	Unpin(context.Context, util.Key, bool) error
+	// Update moves a recursive pin from one root to another. Only the
+	// parts of the two dags that differ are walked. If unpin is false the
+	// old root stays pinned.
+	Update(ctx context.Context, from, to util.Key, unpin bool) error
 	Flush() error
 	GetManual() ManualPinner
 	DirectKeys() []util.Key
 	IndirectKeys() map[util.Key]int
 	RecursiveKeys() []util.Key
+
+	// Annotate attaches metadata to a direct or recursive pin, replacing
+	// any previous metadata of that key.
+	Annotate(util.Key, *Metadata) error
+	// Metadata returns the metadata of a pin, or nil if it has none.
+	Metadata(util.Key) *Metadata
+	// Annotations returns the metadata of every annotated pin.
+	Annotations() map[util.Key]*Metadata
+
+	// InternalPins returns the keys of the merkledag nodes the pinner
+	// uses to store its own state. They must be kept by the garbage
+	// collector, but their children are not pinned through them.
+	InternalPins() []util.Key
 }
 
 // ManualPinner is for manually editing the pin structure
@@ -56,27 +88,23 @@ type pinner struct {
 	recursePin set.BlockSet
 	directPin  set.BlockSet
 	indirPin   *indirectPin
-	dserv      mdag.DAGService
-	dstore     ds.ThreadSafeDatastore
+	meta       map[util.Key]*Metadata
+	// keys of the dag nodes holding the pin sets, as of the last Flush
+	internalPin map[util.Key]struct{}
+	dserv       mdag.DAGService
+	dstore      ds.ThreadSafeDatastore
 }
 
 // NewPinner creates a new pinner using the given datastore as a backend
 func NewPinner(dstore ds.ThreadSafeDatastore, serv mdag.DAGService) Pinner {
-
-	// Load set from given datastore...
-	rcds := nsds.Wrap(dstore, recursePinDatastoreKey)
-	rcset := set.NewDBWrapperSet(rcds, set.NewSimpleBlockSet())
-
-	dirds := nsds.Wrap(dstore, directPinDatastoreKey)
-	dirset := set.NewDBWrapperSet(dirds, set.NewSimpleBlockSet())
-
-	nsdstore := nsds.Wrap(dstore, indirectPinDatastoreKey)
 	return &pinner{
-		recursePin: rcset,
-		directPin:  dirset,
-		indirPin:   NewIndirectPin(nsdstore),
-		dserv:      serv,
-		dstore:     dstore,
+		recursePin:  set.NewSimpleBlockSet(),
+		directPin:   set.NewSimpleBlockSet(),
+		indirPin:    newIndirectPin(),
+		meta:        make(map[util.Key]*Metadata),
+		internalPin: make(map[util.Key]struct{}),
+		dserv:       serv,
+		dstore:      dstore,
 	}
 }
 
@@ -125,18 +153,13 @@ func (p *pinner) Unpin(ctx context.Context, k util.Key, recursive bool) error {
 	defer p.lock.Unlock()
 	if p.recursePin.HasKey(k) {
 		if recursive {
-			p.recursePin.RemoveBlock(k)
-			node, err := p.dserv.Get(ctx, k)
-			if err != nil {
-				return err
-			}
-
-			return p.unpinLinks(ctx, node)
+			return p.unpinRecursive(ctx, k)
 		} else {
 			return fmt.Errorf("%s is pinned recursively", k)
 		}
 	} else if p.directPin.HasKey(k) {
 		p.directPin.RemoveBlock(k)
+		delete(p.meta, k)
 		return nil
 	} else if p.indirPin.HasKey(k) {
 		return fmt.Errorf("%s is pinned indirectly. indirect pins cannot be removed directly", k)
@@ -145,6 +168,156 @@ func (p *pinner) Unpin(ctx context.Context, k util.Key, recursive bool) error {
 	}
 }
 
+// Update moves a recursive pin from one root to another
+func (p *pinner) Update(ctx context.Context, from, to util.Key, unpin bool) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if !p.recursePin.HasKey(from) {
+		return fmt.Errorf("'from' key %s is not pinned recursively", from)
+	}
+	if from == to {
+		return nil
+	}
+
+	if !unpin || p.recursePin.HasKey(to) {
+		// nothing to diff against, pin the new root the usual way
+		if !p.recursePin.HasKey(to) {
+			toNode, err := p.dserv.Get(ctx, to)
+			if err != nil {
+				return err
+			}
+			if err := p.pinLinks(ctx, toNode); err != nil {
+				return err
+			}
+			p.directPin.RemoveBlock(to)
+			p.recursePin.AddBlock(to)
+		}
+		if m, ok := p.meta[from]; ok {
+			if _, ok := p.meta[to]; !ok {
+				c := *m
+				p.meta[to] = &c
+			}
+		}
+		if !unpin {
+			return nil
+		}
+		return p.unpinRecursive(ctx, from)
+	}
+
+	fromNode, err := p.dserv.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	toNode, err := p.dserv.Get(ctx, to)
+	if err != nil {
+		return err
+	}
+	if err := p.updateLinks(ctx, fromNode, toNode); err != nil {
+		return err
+	}
+
+	p.recursePin.RemoveBlock(from)
+	p.directPin.RemoveBlock(to)
+	p.recursePin.AddBlock(to)
+	if m, ok := p.meta[from]; ok {
+		p.meta[to] = m
+		delete(p.meta, from)
+	}
+	return nil
+}
+
+// updateLinks adjusts the indirect refcounts for replacing the children of
+// from with the children of to. Links present in both are skipped along
+// with their whole subtree; links that only changed their target are
+// diffed recursively.
+func (p *pinner) updateLinks(ctx context.Context, from, to *mdag.Node) error {
+	// cancel out identical links, they contribute the same refcounts
+	common := make(map[util.Key]int)
+	for _, l := range from.Links {
+		common[util.Key(l.Hash)]++
+	}
+	var added []*mdag.Link
+	for _, l := range to.Links {
+		k := util.Key(l.Hash)
+		if common[k] > 0 {
+			common[k]--
+			continue
+		}
+		added = append(added, l)
+	}
+	var removed []*mdag.Link
+	for _, l := range from.Links {
+		k := util.Key(l.Hash)
+		if common[k] > 0 {
+			common[k]--
+			removed = append(removed, l)
+		}
+	}
+
+	// pair changed links up by name, so edits deep in the tree only walk
+	// the path down to them
+	byName := make(map[string]*mdag.Link)
+	paired := make(map[*mdag.Link]bool)
+	for _, l := range removed {
+		if _, dup := byName[l.Name]; !dup {
+			byName[l.Name] = l
+		}
+	}
+
+	for _, l := range added {
+		nd, err := l.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		p.indirPin.Increment(util.Key(l.Hash))
+
+		old, ok := byName[l.Name]
+		if !ok {
+			if err := p.pinLinks(ctx, nd); err != nil {
+				return err
+			}
+			continue
+		}
+		delete(byName, l.Name)
+		paired[old] = true
+
+		oldNd, err := old.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		p.indirPin.Decrement(util.Key(old.Hash))
+		if err := p.updateLinks(ctx, oldNd, nd); err != nil {
+			return err
+		}
+	}
+
+	for _, l := range removed {
+		if paired[l] {
+			continue
+		}
+		nd, err := l.GetNode(ctx, p.dserv)
+		if err != nil {
+			return err
+		}
+		p.indirPin.Decrement(util.Key(l.Hash))
+		if err := p.unpinLinks(ctx, nd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pinner) unpinRecursive(ctx context.Context, k util.Key) error {
+	p.recursePin.RemoveBlock(k)
+	delete(p.meta, k)
+	node, err := p.dserv.Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	return p.unpinLinks(ctx, node)
+}
+
 func (p *pinner) unpinLinks(ctx context.Context, node *mdag.Node) error {
 	for _, l := range node.Links {
 		node, err := l.GetNode(ctx, p.dserv)
@@ -207,23 +380,95 @@ func (p *pinner) RemovePinWithMode(key util.Key, mode PinMode) {
 	switch mode {
 	case Direct:
 		p.directPin.RemoveBlock(key)
+		delete(p.meta, key)
 	case Indirect:
 		p.indirPin.Decrement(key)
 	case Recursive:
 		p.recursePin.RemoveBlock(key)
+		delete(p.meta, key)
 	default:
 		// programmer error, panic OK
 		panic("unrecognized pin type")
 	}
 }
 
-// LoadPinner loads a pinner and its keysets from the given datastore
+// LoadPinner loads a pinner and its keysets from the given datastore.
+// The datastore records the key of the root pin node; the sets themselves
+// are read from the dag.
 func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error) {
+	rootKeyI, err := d.Get(pinDatastoreKey)
+	if err == ds.ErrNotFound {
+		return loadLegacyPinner(d, dserv)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot load pin state: %v", err)
+	}
+	rootKeyBytes, ok := rootKeyI.([]byte)
+	if !ok {
+		return nil, errors.New("invalid pin root key in datastore")
+	}
+	rootKey := util.Key(rootKeyBytes)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), loadTimeout)
+	defer cancel()
+
+	root, err := dserv.Get(ctx, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find pinning root object: %v", err)
+	}
+
+	p := new(pinner)
+	p.internalPin = map[util.Key]struct{}{rootKey: struct{}{}}
+	recordInternal := func(k util.Key) {
+		p.internalPin[k] = struct{}{}
+	}
+
+	{ // load recursive set
+		recurseKeys, err := loadSet(ctx, dserv, root, linkRecursive, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load recursive pins: %v", err)
+		}
+		p.recursePin = set.SimpleSetFromKeys(recurseKeys)
+	}
+
+	{ // load direct set
+		directKeys, err := loadSet(ctx, dserv, root, linkDirect, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load direct pins: %v", err)
+		}
+		p.directPin = set.SimpleSetFromKeys(directKeys)
+	}
+
+	{ // load indirect set
+		refcnt, err := loadMultiset(ctx, dserv, root, linkIndirect, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load indirect pins: %v", err)
+		}
+		p.indirPin = indirectPinFromRefcounts(refcnt)
+	}
+
+	{ // load pin metadata
+		p.meta, err = loadMetadata(ctx, dserv, root, recordInternal)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load pin metadata: %v", err)
+		}
+	}
+
+	// assign services
+	p.dserv = dserv
+	p.dstore = d
+
+	return p, nil
+}
+
+// loadLegacyPinner reads the JSON encoded pin sets written by older
+// versions. The next Flush stores them as dag objects.
+func loadLegacyPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error) {
 	p := new(pinner)
 
 	{ // load recursive set
 		var recurseKeys []util.Key
-		if err := loadSet(d, recursePinDatastoreKey, &recurseKeys); err != nil {
+		if err := loadLegacySet(d, recursePinDatastoreKey, &recurseKeys); err != nil {
 			return nil, err
 		}
 		p.recursePin = set.SimpleSetFromKeys(recurseKeys)
@@ -231,7 +476,7 @@ func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error)
 
 	{ // load direct set
 		var directKeys []util.Key
-		if err := loadSet(d, directPinDatastoreKey, &directKeys); err != nil {
+		if err := loadLegacySet(d, directPinDatastoreKey, &directKeys); err != nil {
 			return nil, err
 		}
 		p.directPin = set.SimpleSetFromKeys(directKeys)
@@ -246,6 +491,8 @@ func LoadPinner(d ds.ThreadSafeDatastore, dserv mdag.DAGService) (Pinner, error)
 	}
 
 	// assign services
+	p.meta = make(map[util.Key]*Metadata)
+	p.internalPin = make(map[util.Key]struct{})
 	p.dserv = dserv
 	p.dstore = d
 
@@ -267,39 +514,135 @@ func (p *pinner) RecursiveKeys() []util.Key {
 	return p.recursePin.GetKeys()
 }
 
-// Flush encodes and writes pinner keysets to the datastore
+// Annotate attaches metadata to a direct or recursive pin
+func (p *pinner) Annotate(k util.Key, m *Metadata) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.recursePin.HasKey(k) && !p.directPin.HasKey(k) {
+		return fmt.Errorf("%s is not pinned directly or recursively", k)
+	}
+	c := *m
+	p.meta[k] = &c
+	return nil
+}
+
+// Metadata returns the metadata of a pin, or nil if it has none
+func (p *pinner) Metadata(k util.Key) *Metadata {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	m, ok := p.meta[k]
+	if !ok {
+		return nil
+	}
+	c := *m
+	return &c
+}
+
+// Annotations returns the metadata of every annotated pin
+func (p *pinner) Annotations() map[util.Key]*Metadata {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	out := make(map[util.Key]*Metadata, len(p.meta))
+	for k, m := range p.meta {
+		c := *m
+		out[k] = &c
+	}
+	return out
+}
+
+// InternalPins returns the keys of the dag nodes holding the pin sets
+func (p *pinner) InternalPins() []util.Key {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var out []util.Key
+	for k := range p.internalPin {
+		out = append(out, k)
+	}
+	return out
+}
+
+// Flush encodes and writes pinner keysets to the dag, and records the key
+// of their root in the datastore. Sets are laid out deterministically, so
+// subtrees that did not change since the last Flush are already stored.
 func (p *pinner) Flush() error {
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
-	err := storeSet(p.dstore, directPinDatastoreKey, p.directPin.GetKeys())
-	if err != nil {
-		return err
+	ctx := context.TODO()
+
+	internalPin := make(map[util.Key]struct{})
+	recordInternal := func(k util.Key) {
+		internalPin[k] = struct{}{}
 	}
 
-	err = storeSet(p.dstore, recursePinDatastoreKey, p.recursePin.GetKeys())
-	if err != nil {
+	// every fanout slot that is not in use links to the empty node
+	if _, err := p.dserv.Add(emptyNode); err != nil {
 		return err
 	}
 
-	err = storeIndirPin(p.dstore, indirectPinDatastoreKey, p.indirPin)
-	if err != nil {
-		return err
+	root := &mdag.Node{}
+	{
+		n, err := storeSet(ctx, p.dserv, p.directPin.GetKeys(), recordInternal)
+		if err != nil {
+			return err
+		}
+		if err := root.AddNodeLinkClean(linkDirect, n); err != nil {
+			return err
+		}
 	}
-	return nil
-}
 
-// helpers to marshal / unmarshal a pin set
-func storeSet(d ds.Datastore, k ds.Key, val interface{}) error {
-	buf, err := json.Marshal(val)
+	{
+		n, err := storeSet(ctx, p.dserv, p.recursePin.GetKeys(), recordInternal)
+		if err != nil {
+			return err
+		}
+		if err := root.AddNodeLinkClean(linkRecursive, n); err != nil {
+			return err
+		}
+	}
+
+	{
+		n, err := storeMultiset(ctx, p.dserv, p.indirPin.GetRefs(), recordInternal)
+		if err != nil {
+			return err
+		}
+		if err := root.AddNodeLinkClean(linkIndirect, n); err != nil {
+			return err
+		}
+	}
+
+	{
+		n, err := storeMetadata(ctx, p.dserv, p.meta, recordInternal)
+		if err != nil {
+			return err
+		}
+		if err := root.AddNodeLinkClean(linkMetadata, n); err != nil {
+			return err
+		}
+	}
+
+	k, err := p.dserv.Add(root)
 	if err != nil {
 		return err
 	}
+	internalPin[k] = struct{}{}
 
-	return d.Put(k, buf)
+	if err := p.dstore.Put(pinDatastoreKey, []byte(k)); err != nil {
+		return fmt.Errorf("cannot store pin state: %v", err)
+	}
+	p.internalPin = internalPin
+
+	// the legacy JSON sets are superseded once the root is recorded
+	for _, lk := range []ds.Key{recursePinDatastoreKey, directPinDatastoreKey, indirectPinDatastoreKey} {
+		if err := p.dstore.Delete(lk); err != nil && err != ds.ErrNotFound {
+			log.Debugf("failed to remove legacy pin set %s: %s", lk, err)
+		}
+	}
+	return nil
 }
 
-func loadSet(d ds.Datastore, k ds.Key, val interface{}) error {
+// helper to unmarshal a legacy JSON pin set
+func loadLegacySet(d ds.Datastore, k ds.Key, val interface{}) error {
 	buf, err := d.Get(k)
 	if err != nil {
 		return err
@@ -0,0 +1,341 @@
+package pin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/pin/internal/pb"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+const (
+	// number of subtree links at the start of every set node
+	defaultFanout = 256
+	// a node holding fewer items than this stores them inline instead of
+	// spreading them over subtrees
+	maxItems = 8192
+	// size of the refcount stored alongside every item of a multiset
+	refcountSize = 4
+)
+
+// emptyNode is the placeholder every unused fanout slot points to.
+var emptyNode = &mdag.Node{}
+
+var emptyKey util.Key
+
+func init() {
+	k, err := emptyNode.Key()
+	if err != nil {
+		panic(err)
+	}
+	emptyKey = k
+}
+
+// hash picks the subtree of k. The seed depends only on the depth of the
+// node, so storing the same keys always produces the same tree and
+// unchanged subtrees are shared between flushes.
+func hash(seed uint32, k util.Key) uint32 {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], seed)
+	h := fnv.New32a()
+	_, _ = h.Write(buf[:])
+	_, _ = io.WriteString(h, string(k))
+	return h.Sum32()
+}
+
+type itemIterator func() (k util.Key, data []byte, ok bool)
+
+type keyObserver func(util.Key)
+
+type sortByHash struct {
+	links []*mdag.Link
+	data  []byte
+	width int
+}
+
+func (s sortByHash) Len() int {
+	return len(s.links)
+}
+
+func (s sortByHash) Less(a, b int) bool {
+	return bytes.Compare(s.links[a].Hash, s.links[b].Hash) == -1
+}
+
+func (s sortByHash) Swap(a, b int) {
+	s.links[a], s.links[b] = s.links[b], s.links[a]
+	if s.width > 0 {
+		n := s.width
+		tmp := make([]byte, n)
+		copy(tmp, s.data[a*n:a*n+n])
+		copy(s.data[a*n:a*n+n], s.data[b*n:b*n+n])
+		copy(s.data[b*n:b*n+n], tmp)
+	}
+}
+
+// storeItems builds a set node from the items yielded by iter. Items that
+// do not fit inline are hashed into defaultFanout subtrees, which are
+// built recursively and added to dag. Every node written is reported to
+// internalKeys.
+func storeItems(ctx context.Context, dag mdag.DAGService, estimatedLen uint64, depth uint32, width int, iter itemIterator, internalKeys keyObserver) (*mdag.Node, error) {
+	n := &mdag.Node{
+		Links: make([]*mdag.Link, 0, defaultFanout+maxItems),
+	}
+	for i := 0; i < defaultFanout; i++ {
+		n.Links = append(n.Links, &mdag.Link{Hash: []byte(emptyKey)})
+	}
+	internalKeys(emptyKey)
+
+	hdr := &pb.Set{
+		Version: proto.Uint32(1),
+		Fanout:  proto.Uint32(defaultFanout),
+		Seed:    proto.Uint32(depth),
+	}
+	if err := writeHdr(n, hdr); err != nil {
+		return nil, err
+	}
+	hdrLen := len(n.Data)
+
+	if estimatedLen < maxItems {
+		// it'll probably fit
+		for i := 0; i < maxItems; i++ {
+			k, data, ok := iter()
+			if !ok {
+				// all done
+				break
+			}
+			n.Links = append(n.Links, &mdag.Link{Hash: []byte(k)})
+			n.Data = append(n.Data, data...)
+		}
+		// sort by hash, also swap item data
+		s := sortByHash{
+			links: n.Links[defaultFanout:],
+			data:  n.Data[hdrLen:],
+			width: width,
+		}
+		sort.Stable(s)
+	}
+
+	type item struct {
+		k    util.Key
+		data []byte
+	}
+	buckets := make(map[uint32][]item)
+	for {
+		k, data, ok := iter()
+		if !ok {
+			break
+		}
+		b := hash(depth, k) % defaultFanout
+		buckets[b] = append(buckets[b], item{k, data})
+	}
+
+	for b, items := range buckets {
+		items := items
+		childIter := func() (util.Key, []byte, bool) {
+			if len(items) == 0 {
+				return "", nil, false
+			}
+			first := items[0]
+			items = items[1:]
+			return first.k, first.data, true
+		}
+		child, err := storeItems(ctx, dag, uint64(len(items)), depth+1, width, childIter, internalKeys)
+		if err != nil {
+			return nil, err
+		}
+		size, err := child.Size()
+		if err != nil {
+			return nil, err
+		}
+		childKey, err := dag.Add(child)
+		if err != nil {
+			return nil, err
+		}
+		internalKeys(childKey)
+		n.Links[int(b)] = &mdag.Link{
+			Hash: []byte(childKey),
+			Size: size,
+		}
+	}
+	return n, nil
+}
+
+func readHdr(n *mdag.Node) (*pb.Set, []byte, error) {
+	hdrLenRaw, consumed := binary.Uvarint(n.Data)
+	if consumed <= 0 {
+		return nil, nil, errors.New("invalid Set header length")
+	}
+	buf := n.Data[consumed:]
+	if hdrLenRaw > uint64(len(buf)) {
+		return nil, nil, errors.New("impossibly large Set header length")
+	}
+	// as hdrLenRaw was <= an int, we now know it fits in an int
+	hdrLen := int(hdrLenRaw)
+	var hdr pb.Set
+	if err := proto.Unmarshal(buf[:hdrLen], &hdr); err != nil {
+		return nil, nil, err
+	}
+	buf = buf[hdrLen:]
+
+	if v := hdr.GetVersion(); v != 1 {
+		return nil, nil, fmt.Errorf("unsupported Set version: %d", v)
+	}
+	if uint64(hdr.GetFanout()) > uint64(len(n.Links)) {
+		return nil, nil, errors.New("impossibly large Fanout")
+	}
+	return &hdr, buf, nil
+}
+
+func writeHdr(n *mdag.Node, hdr *pb.Set) error {
+	hdrData, err := proto.Marshal(hdr)
+	if err != nil {
+		return err
+	}
+	n.Data = make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(hdrData))
+	written := binary.PutUvarint(n.Data, uint64(len(hdrData)))
+	n.Data = n.Data[:written]
+	n.Data = append(n.Data, hdrData...)
+	return nil
+}
+
+type walkerFunc func(buf []byte, idx int, link *mdag.Link) error
+
+func walkItems(ctx context.Context, dag mdag.DAGService, n *mdag.Node, fn walkerFunc, children keyObserver) error {
+	hdr, buf, err := readHdr(n)
+	if err != nil {
+		return err
+	}
+	// readHdr guarantees fanout is a safe value
+	fanout := hdr.GetFanout()
+	for i, l := range n.Links[fanout:] {
+		if err := fn(buf, i, l); err != nil {
+			return err
+		}
+	}
+	for _, l := range n.Links[:fanout] {
+		children(util.Key(l.Hash))
+		if util.Key(l.Hash) == emptyKey {
+			continue
+		}
+		subtree, err := l.GetNode(ctx, dag)
+		if err != nil {
+			return err
+		}
+		if err := walkItems(ctx, dag, subtree, fn, children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadSetNode(ctx context.Context, dag mdag.DAGService, root *mdag.Node, name string, internalKeys keyObserver) (*mdag.Node, error) {
+	l, err := root.GetNodeLink(name)
+	if err != nil {
+		return nil, fmt.Errorf("pin set %q: %s", name, err)
+	}
+	internalKeys(util.Key(l.Hash))
+	return l.GetNode(ctx, dag)
+}
+
+// loadSet returns the keys stored in the set linked from root under name.
+func loadSet(ctx context.Context, dag mdag.DAGService, root *mdag.Node, name string, internalKeys keyObserver) ([]util.Key, error) {
+	n, err := loadSetNode(ctx, dag, root, name, internalKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []util.Key
+	walk := func(buf []byte, idx int, link *mdag.Link) error {
+		res = append(res, util.Key(link.Hash))
+		return nil
+	}
+	if err := walkItems(ctx, dag, n, walk, internalKeys); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// loadMultiset returns the keys and reference counts stored in the
+// multiset linked from root under name.
+func loadMultiset(ctx context.Context, dag mdag.DAGService, root *mdag.Node, name string, internalKeys keyObserver) (map[util.Key]int, error) {
+	n, err := loadSetNode(ctx, dag, root, name, internalKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	refcounts := make(map[util.Key]int)
+	walk := func(buf []byte, idx int, link *mdag.Link) error {
+		off := idx * refcountSize
+		if off+refcountSize > len(buf) {
+			return errors.New("pin multiset is missing refcounts")
+		}
+		refcounts[util.Key(link.Hash)] += int(binary.LittleEndian.Uint32(buf[off:]))
+		return nil
+	}
+	if err := walkItems(ctx, dag, n, walk, internalKeys); err != nil {
+		return nil, err
+	}
+	return refcounts, nil
+}
+
+// storeSet writes keys as a set node into dag and returns its root.
+func storeSet(ctx context.Context, dag mdag.DAGService, keys []util.Key, internalKeys keyObserver) (*mdag.Node, error) {
+	iter := func() (k util.Key, data []byte, ok bool) {
+		if len(keys) == 0 {
+			return "", nil, false
+		}
+		first := keys[0]
+		keys = keys[1:]
+		return first, nil, true
+	}
+	n, err := storeItems(ctx, dag, uint64(len(keys)), 0, 0, iter, internalKeys)
+	if err != nil {
+		return nil, err
+	}
+	k, err := dag.Add(n)
+	if err != nil {
+		return nil, err
+	}
+	internalKeys(k)
+	return n, nil
+}
+
+// storeMultiset writes refcounts as a multiset node into dag and returns
+// its root.
+func storeMultiset(ctx context.Context, dag mdag.DAGService, refcounts map[util.Key]int, internalKeys keyObserver) (*mdag.Node, error) {
+	keys := make([]util.Key, 0, len(refcounts))
+	for k, c := range refcounts {
+		if c > 0 {
+			keys = append(keys, k)
+		}
+	}
+
+	iter := func() (k util.Key, data []byte, ok bool) {
+		if len(keys) == 0 {
+			return "", nil, false
+		}
+		first := keys[0]
+		keys = keys[1:]
+		data = make([]byte, refcountSize)
+		binary.LittleEndian.PutUint32(data, uint32(refcounts[first]))
+		return first, data, true
+	}
+	n, err := storeItems(ctx, dag, uint64(len(keys)), 0, refcountSize, iter, internalKeys)
+	if err != nil {
+		return nil, err
+	}
+	k, err := dag.Add(n)
+	if err != nil {
+		return nil, err
+	}
+	internalKeys(k)
+	return n, nil
+}
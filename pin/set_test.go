@@ -0,0 +1,214 @@
+package pin
+
+import (
+	"encoding/json"
+	"testing"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	"github.com/ipfs/go-ipfs/blocks/blockstore"
+	bs "github.com/ipfs/go-ipfs/blockservice"
+	"github.com/ipfs/go-ipfs/exchange/offline"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+func newTestDag(t *testing.T) (ds.ThreadSafeDatastore, mdag.DAGService) {
+	dstore := dssync.MutexWrap(ds.NewMapDatastore())
+	bstore := blockstore.NewBlockstore(dstore)
+	bserv, err := bs.New(bstore, offline.Exchange(bstore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dstore, mdag.NewDAGService(bserv)
+}
+
+func randKeys(n int) []util.Key {
+	rnd := util.NewTimeSeededRand()
+	keys := make([]util.Key, n)
+	for i := range keys {
+		nd := &mdag.Node{Data: make([]byte, 32)}
+		rnd.Read(nd.Data)
+		keys[i], _ = nd.Key()
+	}
+	return keys
+}
+
+func ignoreKeys(util.Key) {}
+
+func TestSetRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	_, dserv := newTestDag(t)
+
+	// enough keys to spill into subtrees
+	keys := randKeys(maxItems + 100)
+
+	root := &mdag.Node{}
+	n, err := storeSet(ctx, dserv, keys, ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLinkClean("set", n); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadSet(ctx, dserv, root, "set", ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(keys) {
+		t.Fatalf("expected %d keys, got %d", len(keys), len(loaded))
+	}
+	seen := make(map[util.Key]bool)
+	for _, k := range loaded {
+		seen[k] = true
+	}
+	for _, k := range keys {
+		if !seen[k] {
+			t.Fatalf("key %s missing from loaded set", k)
+		}
+	}
+}
+
+func TestSetIsDeterministic(t *testing.T) {
+	ctx := context.Background()
+	_, dserv := newTestDag(t)
+
+	keys := randKeys(100)
+	reversed := make([]util.Key, len(keys))
+	for i, k := range keys {
+		reversed[len(keys)-1-i] = k
+	}
+
+	a, err := storeSet(ctx, dserv, keys, ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := storeSet(ctx, dserv, reversed, ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak, _ := a.Key()
+	bk, _ := b.Key()
+	if ak != bk {
+		t.Fatal("the same keys should produce the same set node")
+	}
+}
+
+func TestMultisetRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	_, dserv := newTestDag(t)
+
+	refcounts := make(map[util.Key]int)
+	for i, k := range randKeys(50) {
+		refcounts[k] = i + 1
+	}
+
+	root := &mdag.Node{}
+	n, err := storeMultiset(ctx, dserv, refcounts, ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLinkClean("refs", n); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadMultiset(ctx, dserv, root, "refs", ignoreKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != len(refcounts) {
+		t.Fatalf("expected %d keys, got %d", len(refcounts), len(loaded))
+	}
+	for k, c := range refcounts {
+		if loaded[k] != c {
+			t.Fatalf("refcount of %s: expected %d, got %d", k, c, loaded[k])
+		}
+	}
+}
+
+func TestFlushRecordsInternalPins(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDag(t)
+	p := NewPinner(dstore, dserv)
+
+	a, ak := randNode()
+	if _, err := dserv.Add(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pin(ctx, a, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rootKey, err := dstore.Get(pinDatastoreKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal := make(map[util.Key]bool)
+	for _, k := range p.InternalPins() {
+		internal[k] = true
+	}
+	if !internal[util.Key(rootKey.([]byte))] {
+		t.Fatal("pin root should be an internal pin")
+	}
+	if internal[ak] {
+		t.Fatal("pinned content should not be an internal pin")
+	}
+
+	np, err := LoadPinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(np.InternalPins()) != len(internal) {
+		t.Fatal("loaded pinner should know the same internal pins")
+	}
+}
+
+func TestLoadLegacyPinner(t *testing.T) {
+	dstore, dserv := newTestDag(t)
+
+	a, ak := randNode()
+	if _, err := dserv.Add(a); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(k ds.Key, v interface{}) {
+		buf, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dstore.Put(k, buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(recursePinDatastoreKey, []util.Key{})
+	put(directPinDatastoreKey, []util.Key{ak})
+	put(indirectPinDatastoreKey, map[string]int{})
+
+	p, err := LoadPinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.IsPinned(ak) {
+		t.Fatal("legacy direct pin was not loaded")
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := dstore.Has(directPinDatastoreKey); has {
+		t.Fatal("legacy pin set should be removed after flush")
+	}
+
+	np, err := LoadPinner(dstore, dserv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !np.IsPinned(ak) {
+		t.Fatal("direct pin lost after migrating pin state")
+	}
+}
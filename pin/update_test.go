@@ -0,0 +1,138 @@
+package pin
+
+import (
+	"testing"
+
+	context "github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	"github.com/ipfs/go-ipfs/util"
+)
+
+func linkedNode(t *testing.T, data string, links map[string]*mdag.Node) *mdag.Node {
+	nd := &mdag.Node{Data: []byte(data)}
+	for name, child := range links {
+		if err := nd.AddNodeLink(name, child); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return nd
+}
+
+func sameRefs(t *testing.T, got, want map[util.Key]int) {
+	if len(got) != len(want) {
+		t.Fatalf("expected %d indirect keys, got %d", len(want), len(got))
+	}
+	for k, c := range want {
+		if got[k] != c {
+			t.Fatalf("refcount of %s: expected %d, got %d", k, c, got[k])
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDag(t)
+
+	x := linkedNode(t, "x", nil)
+	y := linkedNode(t, "y", nil)
+	y2 := linkedNode(t, "y2", nil)
+	deep := linkedNode(t, "deep", nil)
+	b := linkedNode(t, "b", map[string]*mdag.Node{"deep": deep})
+	c := linkedNode(t, "c", map[string]*mdag.Node{"x": x})
+	a := linkedNode(t, "a", map[string]*mdag.Node{"x": x, "y": y})
+	a2 := linkedNode(t, "a", map[string]*mdag.Node{"x": x, "y": y2})
+	r1 := linkedNode(t, "root", map[string]*mdag.Node{"a": a, "b": b})
+	r2 := linkedNode(t, "root", map[string]*mdag.Node{"a": a2, "b": b, "c": c})
+	for _, nd := range []*mdag.Node{r1, r2} {
+		if err := dserv.AddRecursive(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k1, _ := r1.Key()
+	k2, _ := r2.Key()
+
+	// what a fresh pin of the new root looks like
+	want := NewPinner(dstore, dserv)
+	if err := want.Pin(ctx, r2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPinner(dstore, dserv)
+	if err := p.Pin(ctx, r1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Annotate(k1, &Metadata{Name: "site"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the unchanged subtree must not be walked
+	if err := dserv.Remove(deep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Update(ctx, k1, k2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.IsPinned(k2) {
+		t.Fatal("new root not pinned")
+	}
+	for _, k := range p.RecursiveKeys() {
+		if k == k1 {
+			t.Fatal("old root still pinned recursively")
+		}
+	}
+	sameRefs(t, p.IndirectKeys(), want.IndirectKeys())
+
+	if m := p.Metadata(k2); m == nil || m.Name != "site" {
+		t.Fatal("metadata should follow the pin")
+	}
+}
+
+func TestUpdateKeepOld(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDag(t)
+
+	x := linkedNode(t, "x", nil)
+	y := linkedNode(t, "y", nil)
+	r1 := linkedNode(t, "root1", map[string]*mdag.Node{"x": x})
+	r2 := linkedNode(t, "root2", map[string]*mdag.Node{"x": x, "y": y})
+	for _, nd := range []*mdag.Node{r1, r2} {
+		if err := dserv.AddRecursive(nd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	k1, _ := r1.Key()
+	k2, _ := r2.Key()
+
+	want := NewPinner(dstore, dserv)
+	for _, nd := range []*mdag.Node{r1, r2} {
+		if err := want.Pin(ctx, nd, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewPinner(dstore, dserv)
+	if err := p.Pin(ctx, r1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update(ctx, k1, k2, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.RecursiveKeys()) != 2 {
+		t.Fatal("expected both roots to be pinned")
+	}
+	sameRefs(t, p.IndirectKeys(), want.IndirectKeys())
+}
+
+func TestUpdateRequiresRecursivePin(t *testing.T) {
+	ctx := context.Background()
+	dstore, dserv := newTestDag(t)
+	p := NewPinner(dstore, dserv)
+
+	_, k1 := randNode()
+	_, k2 := randNode()
+	if err := p.Update(ctx, k1, k2, true); err == nil {
+		t.Fatal("expected update of an unpinned key to fail")
+	}
+}
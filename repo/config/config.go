@@ -25,6 +25,7 @@ type Config struct {
 	Bootstrap        []string              // local nodes's bootstrap peer addresses
 	Tour             Tour                  // local node's tour position
 	Gateway          Gateway               // local node's gateway server options
+	Ipns             Ipns                  // local node's ipns publishing options
 	SupernodeRouting SupernodeClientConfig // local node's routing servers (if SupernodeRouting enabled)
 	Log              Log
 }
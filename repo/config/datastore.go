@@ -1,12 +1,106 @@
 package config
 
+import (
+	"fmt"
+
+	humanize "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/dustin/go-humanize"
+)
+
 // DefaultDataStoreDirectory is the directory to store all the local IPFS data.
 const DefaultDataStoreDirectory = "datastore"
 
+// Default garbage collection watermarks, in percent of StorageMax.
+const (
+	DefaultStorageGCWatermark    = 90
+	DefaultStorageGCLowWatermark = 70
+)
+
 // Datastore tracks the configuration of the datastore.
 type Datastore struct {
 	Type string
 	Path string
+
+	// Mounts lists the backends the repo datastore is assembled from.
+	// Every key is stored in the first mount whose prefix contains it. An
+	// empty list means the default layout, DefaultDatastoreMounts.
+	Mounts []DatastoreMount `json:",omitempty"`
+
+	// StorageMax is the most block data the repo may hold, such as
+	// "10GB". Empty means there is no limit.
+	StorageMax string
+	// StorageGCWatermark is the usage, in percent of StorageMax, at which
+	// the daemon starts a garbage collection.
+	StorageGCWatermark int64
+	// StorageGCLowWatermark is the usage, in percent of StorageMax, at
+	// which an automatic garbage collection stops removing blocks.
+	StorageGCLowWatermark int64
+}
+
+// StorageLimits returns StorageMax and the two watermarks in bytes. A max
+// of zero means the storage is unlimited. Unset watermarks take their
+// default values.
+func (d *Datastore) StorageLimits() (max, high, low uint64, err error) {
+	if d.StorageMax == "" {
+		return 0, 0, 0, nil
+	}
+	max, err = humanize.ParseBytes(d.StorageMax)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid Datastore.StorageMax: %s", err)
+	}
+
+	highPct, lowPct := d.StorageGCWatermark, d.StorageGCLowWatermark
+	if highPct == 0 {
+		highPct = DefaultStorageGCWatermark
+	}
+	if lowPct == 0 {
+		lowPct = DefaultStorageGCLowWatermark
+	}
+	if highPct < 0 || highPct > 100 {
+		return 0, 0, 0, fmt.Errorf("Datastore.StorageGCWatermark must be between 0 and 100, got %d", highPct)
+	}
+	if lowPct < 0 || lowPct > highPct {
+		return 0, 0, 0, fmt.Errorf("Datastore.StorageGCLowWatermark must be between 0 and StorageGCWatermark, got %d", lowPct)
+	}
+	return max, max * uint64(highPct) / 100, max * uint64(lowPct) / 100, nil
+}
+
+// DatastoreMount is a backend mounted at a key prefix of the repo
+// datastore.
+type DatastoreMount struct {
+	// Prefix is the key prefix the backend is mounted at, such as
+	// "/blocks".
+	Prefix string
+	// Type names the kind of backend: "leveldb", "flatfs", "mem", "redis"
+	// or "s3".
+	Type string
+	// Params are passed to the backend constructor. Relative paths are
+	// resolved against the repo directory. The s3 secret key can be read
+	// from an environment variable (secretKeyEnv) or an AWS credentials
+	// file (credentialsFile, profile) rather than kept here.
+	Params map[string]interface{} `json:",omitempty"`
+}
+
+// DefaultDatastoreMounts is the datastore layout of repos that don't
+// configure one: blocks go to a flatfs directory and everything else to
+// leveldb.
+func DefaultDatastoreMounts() []DatastoreMount {
+	return []DatastoreMount{
+		{
+			Prefix: "/blocks",
+			Type:   "flatfs",
+			Params: map[string]interface{}{
+				"path":      "blocks",
+				"prefixLen": 4,
+			},
+		},
+		{
+			Prefix: "/",
+			Type:   "leveldb",
+			Params: map[string]interface{}{
+				"path": DefaultDataStoreDirectory,
+			},
+		},
+	}
 }
 
 // DataStorePath returns the default data store path given a configuration root
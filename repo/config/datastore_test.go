@@ -0,0 +1,31 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestStorageLimits(t *testing.T) {
+	tests := []struct {
+		conf           Datastore
+		max, high, low uint64
+		err            bool
+	}{
+		{Datastore{}, 0, 0, 0, false},
+		{Datastore{StorageMax: "1000B"}, 1000, 900, 700, false},
+		{Datastore{StorageMax: "10kB", StorageGCWatermark: 80, StorageGCLowWatermark: 50}, 10000, 8000, 5000, false},
+		{Datastore{StorageMax: "1KiB", StorageGCWatermark: 100, StorageGCLowWatermark: 100}, 1024, 1024, 1024, false},
+		{Datastore{StorageMax: "lots"}, 0, 0, 0, true},
+		{Datastore{StorageMax: "1GB", StorageGCWatermark: 120}, 0, 0, 0, true},
+		{Datastore{StorageMax: "1GB", StorageGCWatermark: 50, StorageGCLowWatermark: 60}, 0, 0, 0, true},
+	}
+
+	for i, tc := range tests {
+		max, high, low, err := tc.conf.StorageLimits()
+		if (err != nil) != tc.err {
+			t.Fatalf("%d: unexpected error %v", i, err)
+		}
+		if max != tc.max || high != tc.high || low != tc.low {
+			t.Fatalf("%d: got %d/%d/%d, expected %d/%d/%d", i, max, high, low, tc.max, tc.high, tc.low)
+		}
+	}
+}
@@ -71,6 +71,13 @@ func Init(out io.Writer, nBitsForKeypair int) (*Config, error) {
 			RootRedirect: "",
 			Writable:     false,
 		},
+
+		Ipns: Ipns{
+			RepublishPeriod: "12h",
+			RecordLifetime:  "24h",
+
+			ResolveCacheSize: DefaultResolveCacheSize,
+		},
 	}
 
 	return conf, nil
@@ -82,8 +89,11 @@ func datastoreConfig() (*Datastore, error) {
 		return nil, err
 	}
 	return &Datastore{
-		Path: dspath,
-		Type: "leveldb",
+		Path:                  dspath,
+		Type:                  "leveldb",
+		StorageGCWatermark:    DefaultStorageGCWatermark,
+		StorageGCLowWatermark: DefaultStorageGCLowWatermark,
+		Mounts:                DefaultDatastoreMounts(),
 	}, nil
 }
 
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Default timings of the IPNS republisher.
+const (
+	DefaultRepublishPeriod = time.Hour * 12
+	DefaultRecordLifetime  = time.Hour * 24
+)
+
+// DefaultResolveCacheSize is how many resolved names are cached, by
+// default.
+const DefaultResolveCacheSize = 128
+
+// Ipns tracks the configuration of the names the node publishes.
+type Ipns struct {
+	// RepublishPeriod is how often the daemon publishes again the names
+	// the node published, such as "12h". Empty means
+	// DefaultRepublishPeriod.
+	RepublishPeriod string
+	// RecordLifetime is how long the records published stay valid, such
+	// as "24h". Empty means DefaultRecordLifetime.
+	RecordLifetime string
+
+	// ResolveCacheSize is how many resolved names are cached. Zero means
+	// DefaultResolveCacheSize; a negative size disables the cache.
+	ResolveCacheSize int
+}
+
+// CacheSize returns how many resolved names to cache, zero when the
+// cache is disabled.
+func (i *Ipns) CacheSize() int {
+	switch {
+	case i.ResolveCacheSize == 0:
+		return DefaultResolveCacheSize
+	case i.ResolveCacheSize < 0:
+		return 0
+	}
+	return i.ResolveCacheSize
+}
+
+// RepublisherTimes returns RepublishPeriod and RecordLifetime as
+// durations. Unset values take their default values.
+func (i *Ipns) RepublisherTimes() (period, lifetime time.Duration, err error) {
+	period, lifetime = DefaultRepublishPeriod, DefaultRecordLifetime
+	if i.RepublishPeriod != "" {
+		period, err = time.ParseDuration(i.RepublishPeriod)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Ipns.RepublishPeriod: %s", err)
+		}
+	}
+	if i.RecordLifetime != "" {
+		lifetime, err = time.ParseDuration(i.RecordLifetime)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid Ipns.RecordLifetime: %s", err)
+		}
+	}
+
+	if period <= 0 {
+		return 0, 0, fmt.Errorf("Ipns.RepublishPeriod must be positive, got %s", period)
+	}
+	// records must be republished before they expire
+	if lifetime <= period {
+		return 0, 0, fmt.Errorf("Ipns.RecordLifetime must be longer than Ipns.RepublishPeriod, got %s", lifetime)
+	}
+	return period, lifetime, nil
+}
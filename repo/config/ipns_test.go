@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepublisherTimes(t *testing.T) {
+	tests := []struct {
+		conf             Ipns
+		period, lifetime time.Duration
+		err              bool
+	}{
+		{Ipns{}, DefaultRepublishPeriod, DefaultRecordLifetime, false},
+		{Ipns{RepublishPeriod: "1h", RecordLifetime: "3h"}, time.Hour, time.Hour * 3, false},
+		{Ipns{RepublishPeriod: "4h"}, time.Hour * 4, DefaultRecordLifetime, false},
+		{Ipns{RepublishPeriod: "often"}, 0, 0, true},
+		{Ipns{RepublishPeriod: "-1h"}, 0, 0, true},
+		{Ipns{RepublishPeriod: "2h", RecordLifetime: "1h"}, 0, 0, true},
+	}
+
+	for i, tc := range tests {
+		period, lifetime, err := tc.conf.RepublisherTimes()
+		if (err != nil) != tc.err {
+			t.Fatalf("%d: unexpected error %v", i, err)
+		}
+		if period != tc.period || lifetime != tc.lifetime {
+			t.Fatalf("%d: got %s/%s, expected %s/%s", i, period, lifetime, tc.period, tc.lifetime)
+		}
+	}
+}
+
+func TestCacheSize(t *testing.T) {
+	tests := []struct {
+		conf Ipns
+		size int
+	}{
+		{Ipns{}, DefaultResolveCacheSize},
+		{Ipns{ResolveCacheSize: 16}, 16},
+		{Ipns{ResolveCacheSize: -1}, 0},
+	}
+
+	for i, tc := range tests {
+		if size := tc.conf.CacheSize(); size != tc.size {
+			t.Fatalf("%d: got %d, expected %d", i, size, tc.size)
+		}
+	}
+}
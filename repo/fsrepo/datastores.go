@@ -0,0 +1,323 @@
+package fsrepo
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/aws"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/crowdmob/goamz/s3"
+	radix "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/fzzy/radix/redis"
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
+	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
+	dssync "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/sync"
+	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
+	redis "github.com/ipfs/go-ipfs/thirdparty/redis-datastore"
+	s3datastore "github.com/ipfs/go-ipfs/thirdparty/s3-datastore"
+	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
+)
+
+// DatastoreParams are the parameters of a single datastore mount, as
+// found in the config.
+type DatastoreParams map[string]interface{}
+
+// DatastoreConstructor builds a backend from its parameters. repoPath is
+// the repo directory, against which relative paths are resolved.
+type DatastoreConstructor func(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error)
+
+var (
+	datastoresLock sync.Mutex
+	datastores     = map[string]DatastoreConstructor{
+		"leveldb": openLevelDB,
+		"flatfs":  openFlatfs,
+		"mem":     openMem,
+		"redis":   openRedis,
+		"s3":      openS3,
+	}
+)
+
+// RegisterDatastore makes a backend type available to the Datastore.Mounts
+// section of the config. Registering a type twice replaces the first
+// constructor.
+func RegisterDatastore(typ string, c DatastoreConstructor) {
+	datastoresLock.Lock()
+	defer datastoresLock.Unlock()
+	datastores[typ] = c
+}
+
+func openBackend(repoPath, typ string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	datastoresLock.Lock()
+	c, ok := datastores[typ]
+	datastoresLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown datastore type %q", typ)
+	}
+	return c(repoPath, params)
+}
+
+// String returns the string parameter name, or def if it is not set.
+func (p DatastoreParams) String(name, def string) (string, error) {
+	v, ok := p[name]
+	if !ok {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("datastore parameter %q should be a string", name)
+	}
+	return s, nil
+}
+
+// Int returns the integer parameter name, or def if it is not set.
+func (p DatastoreParams) Int(name string, def int) (int, error) {
+	v, ok := p[name]
+	if !ok {
+		return def, nil
+	}
+	// numbers read from the JSON config are float64
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("datastore parameter %q should be an integer", name)
+}
+
+// Path returns the path parameter name resolved against repoPath.
+func (p DatastoreParams) Path(repoPath, name string) (string, error) {
+	s, err := p.String(name, "")
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", fmt.Errorf("datastore parameter %q is required", name)
+	}
+	if !path.IsAbs(s) {
+		s = path.Join(repoPath, s)
+	}
+	return s, nil
+}
+
+func openLevelDB(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	p, err := params.Path(repoPath, "path")
+	if err != nil {
+		return nil, err
+	}
+	comp, err := params.String("compression", "none")
+	if err != nil {
+		return nil, err
+	}
+	opts := &levelds.Options{}
+	switch comp {
+	case "none":
+		opts.Compression = ldbopts.NoCompression
+	case "snappy":
+		opts.Compression = ldbopts.SnappyCompression
+	default:
+		return nil, fmt.Errorf("unknown leveldb compression %q", comp)
+	}
+	d, err := levelds.NewDatastore(p, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open leveldb datastore: %s", err)
+	}
+	return &leveldbDatastore{Datastore: d, path: p}, nil
+}
+
+// leveldbDatastore remembers where its files are, so the repo can measure
+// them.
+type leveldbDatastore struct {
+	levelds.Datastore
+	path string
+}
+
+func (l *leveldbDatastore) diskUsage() (uint64, error) {
+	return dirSize(l.path)
+}
+
+// flatfsDatastore remembers where its files are, so the repo can measure
+// them.
+type flatfsDatastore struct {
+	*flatfs.Datastore
+	path string
+}
+
+func (f *flatfsDatastore) Close() error {
+	return nil
+}
+
+func (f *flatfsDatastore) diskUsage() (uint64, error) {
+	return dirSize(f.path)
+}
+
+func openFlatfs(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	p, err := params.Path(repoPath, "path")
+	if err != nil {
+		return nil, err
+	}
+	// 4TB of 256kB objects ~=17M objects, splitting that 256-way
+	// leads to ~66k objects per dir, splitting 256*256-way leads to
+	// only 256.
+	//
+	// The keys seen by the block store have predictable prefixes,
+	// including "/" from datastore.Key and 2 bytes from multihash. To
+	// reach a uniform 256-way split, we need approximately 4 bytes of
+	// prefix.
+	prefixLen, err := params.Int("prefixLen", 4)
+	if err != nil {
+		return nil, err
+	}
+	d, err := flatfs.New(p, prefixLen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open flatfs datastore: %s", err)
+	}
+	return &flatfsDatastore{Datastore: d, path: p}, nil
+}
+
+func openMem(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	return ds2.CloserWrap(dssync.MutexWrap(ds.NewMapDatastore())), nil
+}
+
+// redisDatastore closes its connection along with the datastore.
+type redisDatastore struct {
+	ds.ThreadSafeDatastore
+	client *radix.Client
+}
+
+func (r *redisDatastore) Close() error {
+	return r.client.Close()
+}
+
+func openRedis(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	addr, err := params.String("addr", "localhost:6379")
+	if err != nil {
+		return nil, err
+	}
+	ttlStr, err := params.String("ttl", "")
+	if err != nil {
+		return nil, err
+	}
+	var ttl time.Duration
+	if ttlStr != "" {
+		if ttl, err = time.ParseDuration(ttlStr); err != nil {
+			return nil, fmt.Errorf("invalid redis ttl: %s", err)
+		}
+	}
+
+	client, err := radix.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to redis at %s: %s", addr, err)
+	}
+	d, err := redis.NewExpiringDatastore(client, ttl)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &redisDatastore{ThreadSafeDatastore: d, client: client}, nil
+}
+
+func openS3(repoPath string, params DatastoreParams) (ds2.ThreadSafeDatastoreCloser, error) {
+	bucket, err := params.String("bucket", "")
+	if err != nil {
+		return nil, err
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("datastore parameter %q is required", "bucket")
+	}
+	regionName, err := params.String("region", aws.USEast.Name)
+	if err != nil {
+		return nil, err
+	}
+	region, ok := aws.Regions[regionName]
+	if !ok {
+		return nil, fmt.Errorf("unknown s3 region %q", regionName)
+	}
+	auth, err := s3Auth(repoPath, params)
+	if err != nil {
+		return nil, err
+	}
+	d := &s3datastore.S3Datastore{
+		Client: s3.New(auth, region),
+		Bucket: bucket,
+	}
+	return ds2.CloserWrap(d), nil
+}
+
+// s3Auth finds the credentials of an s3 mount, so that the secret key
+// needn't be written in the config: it is read from the environment
+// variable named by secretKeyEnv, or from a profile of an AWS credentials
+// file. Without any, the AWS environment variables, the instance role and
+// the default credentials file are tried in turn.
+func s3Auth(repoPath string, params DatastoreParams) (aws.Auth, error) {
+	accessKey, err := params.String("accessKey", "")
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	secretKey, err := params.String("secretKey", "")
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	secretEnv, err := params.String("secretKeyEnv", "")
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	profile, err := params.String("profile", "")
+	if err != nil {
+		return aws.Auth{}, err
+	}
+	var credFile string
+	if _, ok := params["credentialsFile"]; ok {
+		if credFile, err = params.Path(repoPath, "credentialsFile"); err != nil {
+			return aws.Auth{}, err
+		}
+	}
+
+	switch {
+	case secretEnv != "":
+		if accessKey == "" {
+			return aws.Auth{}, fmt.Errorf("datastore parameter %q is required with %q", "accessKey", "secretKeyEnv")
+		}
+		if secretKey = os.Getenv(secretEnv); secretKey == "" {
+			return aws.Auth{}, fmt.Errorf("no s3 secret key in $%s", secretEnv)
+		}
+	case credFile != "" || profile != "":
+		auth, err := aws.CredentialFileAuth(credFile, profile, 0)
+		if err != nil {
+			return aws.Auth{}, fmt.Errorf("no s3 credentials: %s", err)
+		}
+		return auth, nil
+	}
+
+	auth, err := aws.GetAuth(accessKey, secretKey, "", time.Time{})
+	if err != nil {
+		return aws.Auth{}, fmt.Errorf("no s3 credentials: %s", err)
+	}
+	return auth, nil
+}
+
+// secretParams are the datastore parameters that hold secrets.
+var secretParams = []string{"secretKey"}
+
+// Redacted replaces the secrets RedactSecrets removes.
+const Redacted = "<redacted>"
+
+// RedactSecrets replaces the secrets in the datastore mounts of conf, a
+// config decoded as a map, so that it can be shown or exported.
+func RedactSecrets(conf map[string]interface{}) {
+	dstore, _ := conf["Datastore"].(map[string]interface{})
+	mounts, _ := dstore["Mounts"].([]interface{})
+	for _, m := range mounts {
+		mount, _ := m.(map[string]interface{})
+		params, _ := mount["Params"].(map[string]interface{})
+		for _, name := range secretParams {
+			if _, ok := params[name]; ok {
+				params[name] = Redacted
+			}
+		}
+	}
+}
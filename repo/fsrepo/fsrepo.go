@@ -6,16 +6,15 @@ import (
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
-	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/flatfs"
-	levelds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/leveldb"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/mount"
-	ldbopts "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/syndtr/goleveldb/leveldb/opt"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	repo "github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/common"
 	config "github.com/ipfs/go-ipfs/repo/config"
@@ -29,6 +28,8 @@ import (
 	ds2 "github.com/ipfs/go-ipfs/util/datastore2"
 )
 
+var log = eventlog.Logger("fsrepo")
+
 // version number that we are currently expecting to see
 var RepoVersion = "2"
 
@@ -37,8 +38,8 @@ Sorry for the inconvenience. In the future, these will run automatically.`
 
 var errIncorrectRepoFmt = `Repo has incorrect version: %s
 Program version is: %s
-Please run the ipfs migration tool before continuing.
-` + migrationInstructions
+Please run 'ipfs repo migrate' before continuing, or start the daemon with --migrate.
+`
 
 var (
 	ErrNoVersion = errors.New("no version file found, please run 0-to-1 migration tool.\n" + migrationInstructions)
@@ -56,10 +57,13 @@ func (err NoRepoError) Error() string {
 }
 
 const (
-	leveldbDirectory = "datastore"
-	flatfsDirectory  = "blocks"
+	leveldbDirectory  = "datastore"
+	keystoreDirectory = "keystore"
 )
 
+// the key prefix blocks are stored under, whose size is tracked
+var blocksPrefix = ds.NewKey("/blocks")
+
 var (
 
 	// packageLock must be held to while performing any operation that modifies an
@@ -94,9 +98,11 @@ type FSRepo struct {
 	config   *config.Config
 	ds       ds.ThreadSafeDatastore
 	// tracked separately for use in Close; do not use directly.
-	leveldbDS      levelds.Datastore
-	metricsBlocks  measure.DatastoreCloser
-	metricsLevelDB measure.DatastoreCloser
+	backends []ds2.ThreadSafeDatastoreCloser
+	metrics  []measure.DatastoreCloser
+	// counts the bytes held by the datastore blocks are mounted on
+	blocksUsage *usageDatastore
+	keystore    keystore.Keystore
 }
 
 var _ repo.Repo = (*FSRepo)(nil)
@@ -158,6 +164,10 @@ func open(repoPath string) (repo.Repo, error) {
 		return nil, err
 	}
 
+	if err := r.openKeystore(); err != nil {
+		return nil, err
+	}
+
 	if err := r.openDatastore(); err != nil {
 		return nil, err
 	}
@@ -235,8 +245,36 @@ func initConfig(path string, conf *config.Config) error {
 	return nil
 }
 
+// datastoreMounts returns the datastore layout of conf, sorted so that
+// longer prefixes are looked up first.
+func datastoreMounts(conf *config.Config) ([]config.DatastoreMount, error) {
+	mounts := conf.Datastore.Mounts
+	if len(mounts) == 0 {
+		mounts = config.DefaultDatastoreMounts()
+	}
+	mounts = append([]config.DatastoreMount(nil), mounts...)
+
+	seen := make(map[string]bool)
+	for _, m := range mounts {
+		prefix := ds.NewKey(m.Prefix).String()
+		if seen[prefix] {
+			return nil, fmt.Errorf("datastore: %s is mounted twice", prefix)
+		}
+		seen[prefix] = true
+	}
+	sort.Sort(byPrefixLength(mounts))
+	return mounts, nil
+}
+
+type byPrefixLength []config.DatastoreMount
+
+func (m byPrefixLength) Len() int      { return len(m) }
+func (m byPrefixLength) Swap(a, b int) { m[a], m[b] = m[b], m[a] }
+func (m byPrefixLength) Less(a, b int) bool {
+	return len(ds.NewKey(m[a].Prefix).String()) > len(ds.NewKey(m[b].Prefix).String())
+}
+
 // Init initializes a new FSRepo at the given path with the provided config.
-// TODO add support for custom datastores.
 func Init(repoPath string, conf *config.Config) error {
 
 	// packageLock must be held to ensure that the repo is not initialized more
@@ -253,15 +291,22 @@ func Init(repoPath string, conf *config.Config) error {
 	}
 
 	// The actual datastore contents are initialized lazily when Opened.
-	// During Init, we merely check that the directory is writeable.
-	leveldbPath := path.Join(repoPath, leveldbDirectory)
-	if err := dir.Writable(leveldbPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
+	// During Init, we merely check that the directories are writeable.
+	mounts, err := datastoreMounts(conf)
+	if err != nil {
+		return err
 	}
-
-	flatfsPath := path.Join(repoPath, flatfsDirectory)
-	if err := dir.Writable(flatfsPath); err != nil {
-		return fmt.Errorf("datastore: %s", err)
+	for _, m := range mounts {
+		if _, ok := m.Params["path"]; !ok {
+			continue
+		}
+		p, err := DatastoreParams(m.Params).Path(repoPath, "path")
+		if err != nil {
+			return fmt.Errorf("datastore %s: %s", m.Prefix, err)
+		}
+		if err := dir.Writable(p); err != nil {
+			return fmt.Errorf("datastore: %s", err)
+		}
 	}
 
 	if err := dir.Writable(path.Join(repoPath, "logs")); err != nil {
@@ -275,6 +320,33 @@ func Init(repoPath string, conf *config.Config) error {
 	return nil
 }
 
+// Migrate runs the migrations that bring the repo at repoPath to version
+// to, which is usually RepoVersion. It fails if the repo is in use.
+func Migrate(repoPath string, to string) error {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	expPath, err := u.TildeExpansion(path.Clean(repoPath))
+	if err != nil {
+		return err
+	}
+	if err := checkInitialized(expPath); err != nil {
+		return err
+	}
+	target, err := strconv.Atoi(to)
+	if err != nil {
+		return fmt.Errorf("invalid repo version %q", to)
+	}
+
+	lk, err := lockfile.Lock(expPath)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	return mfsr.RepoPath(expPath).Migrate(target)
+}
+
 // Remove recursively removes the FSRepo at |path|.
 func Remove(repoPath string) error {
 	repoPath = path.Clean(repoPath)
@@ -307,29 +379,27 @@ func (r *FSRepo) openConfig() error {
 	return nil
 }
 
-// openDatastore returns an error if the config file is not present.
-func (r *FSRepo) openDatastore() error {
-	leveldbPath := path.Join(r.path, leveldbDirectory)
-	var err error
-	// save leveldb reference so it can be neatly closed afterward
-	r.leveldbDS, err = levelds.NewDatastore(leveldbPath, &levelds.Options{
-		Compression: ldbopts.NoCompression,
-	})
+// openKeystore opens the keystore, with the passphrase from the
+// environment. Repos without an identity keep no keys.
+func (r *FSRepo) openKeystore() error {
+	if r.config.Identity.PrivKey == "" {
+		return nil
+	}
+	pass := []byte(os.Getenv(keystore.EnvPassphrase))
+	ks, err := keystore.NewFSKeystore(path.Join(r.path, keystoreDirectory), pass)
 	if err != nil {
-		return errors.New("unable to open leveldb datastore")
+		return err
 	}
+	r.keystore = ks
+	return nil
+}
 
-	// 4TB of 256kB objects ~=17M objects, splitting that 256-way
-	// leads to ~66k objects per dir, splitting 256*256-way leads to
-	// only 256.
-	//
-	// The keys seen by the block store have predictable prefixes,
-	// including "/" from datastore.Key and 2 bytes from multihash. To
-	// reach a uniform 256-way split, we need approximately 4 bytes of
-	// prefix.
-	blocksDS, err := flatfs.New(path.Join(r.path, flatfsDirectory), 4)
+// openDatastore assembles the repo datastore from the backends listed in
+// the config.
+func (r *FSRepo) openDatastore() error {
+	mounts, err := datastoreMounts(r.config)
 	if err != nil {
-		return errors.New("unable to open flatfs datastore")
+		return err
 	}
 
 	// Add our PeerID to metrics paths to keep them unique
@@ -342,29 +412,79 @@ func (r *FSRepo) openDatastore() error {
 		id = fmt.Sprintf("uninitialized_%p", r)
 	}
 	prefix := "fsrepo." + id + ".datastore."
-	r.metricsBlocks = measure.New(prefix+"blocks", blocksDS)
-	r.metricsLevelDB = measure.New(prefix+"leveldb", r.leveldbDS)
-	mountDS := mount.New([]mount.Mount{
-		{
-			Prefix:    ds.NewKey("/blocks"),
-			Datastore: r.metricsBlocks,
-		},
-		{
-			Prefix:    ds.NewKey("/"),
-			Datastore: r.metricsLevelDB,
-		},
-	})
+
+	var dsMounts []mount.Mount
+	for _, m := range mounts {
+		backend, err := openBackend(r.path, m.Type, DatastoreParams(m.Params))
+		if err != nil {
+			r.closeDatastore()
+			return fmt.Errorf("datastore %s: %s", m.Prefix, err)
+		}
+		r.backends = append(r.backends, backend)
+
+		mountKey := ds.NewKey(m.Prefix)
+		metrics := measure.New(prefix+metricsName(mountKey, m.Type), backend)
+		r.metrics = append(r.metrics, metrics)
+
+		var d ds.Datastore = metrics
+		if r.blocksUsage == nil && (mountKey.Equal(blocksPrefix) || mountKey.IsAncestorOf(blocksPrefix)) {
+			// the first mount matching a block key holds all blocks
+			used, err := initialUsage(backend)
+			if err != nil {
+				r.closeDatastore()
+				return fmt.Errorf("unable to measure datastore %s: %s", m.Prefix, err)
+			}
+			r.blocksUsage = newUsageDatastore(metrics, used)
+			r.blocksUsage.remeasure(backend)
+			d = r.blocksUsage
+		}
+		dsMounts = append(dsMounts, mount.Mount{Prefix: mountKey, Datastore: d})
+	}
+	if r.blocksUsage == nil {
+		r.closeDatastore()
+		return fmt.Errorf("datastore: no mount for %s", blocksPrefix)
+	}
+
 	// Make sure it's ok to claim the virtual datastore from mount as
 	// threadsafe. There's no clean way to make mount itself provide
 	// this information without copy-pasting the code into two
 	// variants. This is the same dilemma as the `[].byte` attempt at
-	// introducing const types to Go.
-	var _ ds.ThreadSafeDatastore = blocksDS
-	var _ ds.ThreadSafeDatastore = r.leveldbDS
-	r.ds = ds2.ClaimThreadSafe{mountDS}
+	// introducing const types to Go. Every backend is a
+	// ThreadSafeDatastore, as DatastoreConstructor requires.
+	r.ds = ds2.ClaimThreadSafe{mount.New(dsMounts)}
 	return nil
 }
 
+// metricsName names the metrics of the datastore mounted at prefix.
+func metricsName(prefix ds.Key, typ string) string {
+	name := strings.Replace(strings.Trim(prefix.String(), "/"), "/", ".", -1)
+	if name == "" {
+		return typ
+	}
+	return name
+}
+
+// closeDatastore closes every backend, and their metrics.
+func (r *FSRepo) closeDatastore() error {
+	if r.blocksUsage != nil {
+		r.blocksUsage.stopMeasuring()
+	}
+	var firstErr error
+	for _, m := range r.metrics {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, b := range r.backends {
+		if err := b.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	r.metrics = nil
+	r.backends = nil
+	return firstErr
+}
+
 func configureEventLoggerAtRepoPath(c *config.Config, repoPath string) {
 	eventlog.Configure(eventlog.LevelInfo)
 	eventlog.Configure(eventlog.LdJSONFormatter)
@@ -386,13 +506,7 @@ func (r *FSRepo) Close() error {
 		return errors.New("repo is closed")
 	}
 
-	if err := r.metricsBlocks.Close(); err != nil {
-		return err
-	}
-	if err := r.metricsLevelDB.Close(); err != nil {
-		return err
-	}
-	if err := r.leveldbDS.Close(); err != nil {
+	if err := r.closeDatastore(); err != nil {
 		return err
 	}
 
@@ -533,6 +647,24 @@ func (r *FSRepo) Datastore() ds.ThreadSafeDatastore {
 	return d
 }
 
+// Keystore returns the keystore of the repo, or nil if the repo keeps no
+// keys.
+func (r *FSRepo) Keystore() keystore.Keystore {
+	return r.keystore
+}
+
+// StorageUsage returns the number of bytes stored in the blocks
+// datastore.
+func (r *FSRepo) StorageUsage() (uint64, error) {
+	packageLock.Lock()
+	defer packageLock.Unlock()
+
+	if r.closed {
+		return 0, errors.New("repo is closed")
+	}
+	return r.blocksUsage.Used(), nil
+}
+
 var _ io.Closer = &FSRepo{}
 var _ repo.Repo = &FSRepo{}
 
@@ -554,8 +686,9 @@ func isInitializedUnsynced(repoPath string) bool {
 	if !configIsInitialized(repoPath) {
 		return false
 	}
-	if !util.FileExists(path.Join(repoPath, leveldbDirectory)) {
-		return false
+	// repos from before the version file always have a leveldb directory
+	if util.FileExists(mfsr.RepoPath(repoPath).VersionFile()) {
+		return true
 	}
-	return true
+	return util.FileExists(path.Join(repoPath, leveldbDirectory))
 }
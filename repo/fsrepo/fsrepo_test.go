@@ -2,12 +2,21 @@ package fsrepo
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
 	"github.com/ipfs/go-ipfs/repo/config"
+	mfsr "github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
 	"github.com/ipfs/go-ipfs/thirdparty/assert"
+	"github.com/ipfs/go-ipfs/util"
 )
 
 // swap arg order
@@ -116,3 +125,236 @@ func TestOpenMoreThanOnceInSameProcess(t *testing.T) {
 	assert.Nil(r1.Close(), t)
 	assert.Nil(r2.Close(), t)
 }
+
+func TestStorageUsageTracksBlocks(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("usage", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+
+	r1, err := Open(path)
+	assert.Nil(err, t)
+	k := datastore.NewKey("/blocks/abcdef")
+	assert.Nil(r1.Datastore().Put(k, make([]byte, 100)), t)
+	assert.Nil(r1.Datastore().Put(k, make([]byte, 100)), t, "overwrite should not count twice")
+	assert.Nil(r1.Datastore().Put(datastore.NewKey("notablock"), make([]byte, 50)), t)
+	used, err := r1.StorageUsage()
+	assert.Nil(err, t)
+	assert.True(used == 100, t, "only block data should be counted")
+	assert.Nil(r1.Close(), t)
+
+	r2, err := Open(path)
+	assert.Nil(err, t)
+	used, err = r2.StorageUsage()
+	assert.Nil(err, t)
+	assert.True(used == 100, t, "usage should be measured again on open")
+	assert.Nil(r2.Datastore().Delete(k), t)
+	used, err = r2.StorageUsage()
+	assert.Nil(err, t)
+	assert.True(used == 0, t, "deleted blocks should be subtracted")
+	assert.Nil(r2.Close(), t)
+}
+
+func TestStorageUsageRemeasured(t *testing.T) {
+	// not parallel, as it changes the interval for every repo opened
+	defer func(d time.Duration) { remeasureInterval = d }(remeasureInterval)
+	remeasureInterval = time.Millisecond * 10
+
+	path := testRepoPath("remeasure", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	defer r.Close()
+
+	// written behind the back of the repo
+	assert.Nil(ioutil.WriteFile(filepath.Join(path, "blocks", "outside"), make([]byte, 1000), 0644), t)
+	for i := 0; ; i++ {
+		used, err := r.StorageUsage()
+		assert.Nil(err, t)
+		if used >= 1000 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("usage not remeasured, still %d", used)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestDatastoreMountsFromConfig(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("mounts", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		// listed before the more specific mount on purpose
+		{Prefix: "/", Type: "leveldb", Params: map[string]interface{}{"path": "ldb"}},
+		{Prefix: "/blocks", Type: "mem"},
+	}
+	assert.Nil(Init(path, conf), t)
+	assert.True(util.FileExists(filepath.Join(path, "ldb")), t, "init should create the leveldb directory")
+
+	r, err := Open(path)
+	assert.Nil(err, t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/blocks/abc"), []byte("block")), t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("/other"), []byte("value")), t)
+	used, err := r.StorageUsage()
+	assert.Nil(err, t)
+	assert.True(used == 5, t, "blocks in memory should be counted")
+	assert.Nil(r.Close(), t)
+
+	r, err = Open(path)
+	assert.Nil(err, t)
+	has, err := r.Datastore().Has(datastore.NewKey("/blocks/abc"))
+	assert.Nil(err, t)
+	assert.False(has, t, "blocks should have been kept in memory")
+	has, err = r.Datastore().Has(datastore.NewKey("/other"))
+	assert.Nil(err, t)
+	assert.True(has, t, "other keys should have been stored in leveldb")
+	assert.Nil(r.Close(), t)
+}
+
+func TestUnknownDatastoreType(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("unknown", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{{Prefix: "/", Type: "tape"}}
+	assert.Nil(Init(path, conf), t)
+
+	_, err := Open(path)
+	assert.Err(err, t, "an unknown backend type should fail to open")
+	assert.False(LockedByOtherProcess(path), t, "a failed open should release the lock")
+}
+
+// moveLevelDB is a migration step for TestMigrate, from the version of this
+// program to the next, that moves the leveldb mount to another directory.
+var moveLevelDB = &mfsr.Migration{
+	Apply: func(rp mfsr.RepoPath) error {
+		return moveLevelDBTo(rp, config.DefaultDataStoreDirectory, "ldb")
+	},
+	Revert: func(rp mfsr.RepoPath) error {
+		return moveLevelDBTo(rp, "ldb", config.DefaultDataStoreDirectory)
+	},
+}
+
+func moveLevelDBTo(rp mfsr.RepoPath, from, to string) error {
+	filename := filepath.Join(string(rp), config.DefaultConfigFile)
+	conf, err := serialize.Load(filename)
+	if err != nil {
+		return err
+	}
+	conf.Datastore.Mounts = config.DefaultDatastoreMounts()
+	conf.Datastore.Mounts[1].Params["path"] = to
+	if err := serialize.WriteConfigFile(filename, conf); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(string(rp), from), filepath.Join(string(rp), to))
+}
+
+func init() {
+	v, err := strconv.Atoi(RepoVersion)
+	if err != nil {
+		panic(err)
+	}
+	moveLevelDB.From, moveLevelDB.To = v, v+1
+	mfsr.Register(moveLevelDB)
+	mfsr.Register(&mfsr.Migration{
+		From: v + 1,
+		To:   v + 2,
+		Apply: func(rp mfsr.RepoPath) error {
+			if err := moveLevelDBTo(rp, "ldb", "broken"); err != nil {
+				return err
+			}
+			return errors.New("step failed")
+		},
+		Revert: func(rp mfsr.RepoPath) error { return nil },
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("migrate", t)
+	assert.Nil(Init(path, &config.Config{}), t)
+	r, err := Open(path)
+	assert.Nil(err, t)
+	assert.Nil(r.Datastore().Put(datastore.NewKey("key"), []byte("value")), t)
+	assert.Nil(r.Close(), t)
+
+	v, _ := strconv.Atoi(RepoVersion)
+	next := strconv.Itoa(v + 1)
+	assert.Nil(Migrate(path, next), t, "migrating to the next version should succeed")
+	assert.Nil(mfsr.RepoPath(path).CheckVersion(next), t)
+	assert.True(util.FileExists(filepath.Join(path, "ldb")), t, "the step should have moved leveldb")
+	_, err = Open(path)
+	assert.Err(err, t, "a repo of another version should fail to open")
+
+	assert.Err(Migrate(path, strconv.Itoa(v+2)), t, "the failing step should fail the migration")
+	assert.Nil(mfsr.RepoPath(path).CheckVersion(next), t)
+	assert.True(util.FileExists(filepath.Join(path, "ldb")), t, "leveldb should have been restored")
+	assert.False(util.FileExists(filepath.Join(path, "broken")), t, "the failed step's leveldb should be removed")
+
+	assert.Nil(Migrate(path, RepoVersion), t, "migrating back should succeed")
+	r, err = Open(path)
+	assert.Nil(err, t)
+	val, err := r.Datastore().Get(datastore.NewKey("key"))
+	assert.Nil(err, t, "the datastore should survive the migrations")
+	assert.True(bytes.Equal(val.([]byte), []byte("value")), t, "data should match")
+	assert.Nil(r.Close(), t)
+}
+
+func TestDatastoreUsage(t *testing.T) {
+	t.Parallel()
+	path := testRepoPath("du", t)
+	conf := &config.Config{}
+	conf.Datastore.Mounts = []config.DatastoreMount{
+		{Prefix: "/blocks", Type: "flatfs", Params: map[string]interface{}{"path": "blk", "prefixLen": 4}},
+		{Prefix: "/", Type: "leveldb", Params: map[string]interface{}{"path": "ldb"}},
+		{Prefix: "/cache", Type: "mem"},
+	}
+	assert.Nil(Init(path, conf), t)
+	assert.Nil(ioutil.WriteFile(filepath.Join(path, "blk", "data"), []byte("12345"), 0644), t)
+
+	usage, err := DatastoreUsage(path, conf)
+	assert.Nil(err, t)
+	assert.True(len(usage) == 2, t, "mounts without a path should be left out")
+	for _, m := range usage {
+		switch m.Prefix {
+		case "/blocks":
+			assert.True(m.Size == 5 && m.Path == filepath.Join(path, "blk"), t, "flatfs mount measured wrong")
+		case "/":
+			assert.True(m.Type == "leveldb" && m.Path == filepath.Join(path, "ldb"), t, "leveldb mount reported wrong")
+		default:
+			t.Fatalf("unexpected mount %s", m.Prefix)
+		}
+	}
+}
+
+func TestS3AuthOutsideConfig(t *testing.T) {
+	os.Setenv("IPFS_TEST_S3_SECRET", "secret")
+	defer os.Unsetenv("IPFS_TEST_S3_SECRET")
+	auth, err := s3Auth("", DatastoreParams{"accessKey": "access", "secretKeyEnv": "IPFS_TEST_S3_SECRET"})
+	assert.Nil(err, t)
+	assert.True(auth.AccessKey == "access" && auth.SecretKey == "secret", t, "secret key should be read from the environment")
+	_, err = s3Auth("", DatastoreParams{"accessKey": "access", "secretKeyEnv": "IPFS_TEST_S3_UNSET"})
+	assert.Err(err, t, "an unset variable should fail")
+
+	dir := testRepoPath("s3", t)
+	creds := "[store]\naws_access_key_id = fileaccess\naws_secret_access_key = filesecret\n"
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "credentials"), []byte(creds), 0600), t)
+	auth, err = s3Auth(dir, DatastoreParams{"credentialsFile": "credentials", "profile": "store"})
+	assert.Nil(err, t)
+	assert.True(auth.AccessKey == "fileaccess" && auth.SecretKey == "filesecret", t, "keys should be read from the credentials file")
+}
+
+func TestRedactSecrets(t *testing.T) {
+	var conf map[string]interface{}
+	assert.Nil(json.Unmarshal([]byte(`{"Datastore":{"Mounts":[
+		{"Prefix":"/blocks","Type":"s3","Params":{"bucket":"b","accessKey":"a","secretKey":"s"}},
+		{"Prefix":"/","Type":"leveldb","Params":{"path":"datastore"}}]}}`), &conf), t)
+	RedactSecrets(conf)
+	out, err := json.Marshal(conf)
+	assert.Nil(err, t)
+	assert.False(bytes.Contains(out, []byte(`"s"`)), t, "secret key should be redacted")
+	assert.True(bytes.Contains(out, []byte(`"accessKey":"a"`)), t, "other parameters should be kept")
+
+	// configs without mounts are left alone
+	RedactSecrets(map[string]interface{}{"Datastore": "odd"})
+}
@@ -0,0 +1,414 @@
+package mfsr
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	config "github.com/ipfs/go-ipfs/repo/config"
+	serialize "github.com/ipfs/go-ipfs/repo/fsrepo/serialize"
+)
+
+// BackupDir is the directory, inside the repo, that holds a copy of the
+// files a migration step may touch while the step runs.
+const BackupDir = "migration-backup"
+
+// Migration transforms a repo between two consecutive versions.
+type Migration struct {
+	// From is the version Apply starts from; To must be From+1.
+	From, To int
+
+	// Apply moves the repo from version From to version To.
+	Apply func(RepoPath) error
+	// Revert moves the repo back from version To to version From.
+	Revert func(RepoPath) error
+
+	// Backup names the entries of the repo directory that the step
+	// changes besides the version file, the config and the leveldb
+	// mounts, which are always backed up. Block data, such as the blocks
+	// directory of the flatfs mount, is only backed up if listed here:
+	// copying it can take as long and as much space as the repo itself.
+	Backup []string
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[int]*Migration)
+)
+
+// Register makes a migration step available to Migrate. It is meant to be
+// called from init, and panics if the step is malformed or another step
+// already starts from the same version.
+func Register(m *Migration) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if m.To != m.From+1 {
+		panic(fmt.Sprintf("mfsr: migration from %d must go to %d, not %d", m.From, m.From+1, m.To))
+	}
+	if m.Apply == nil || m.Revert == nil {
+		panic(fmt.Sprintf("mfsr: migration %d-to-%d must be reversible", m.From, m.To))
+	}
+	for _, name := range m.Backup {
+		if strings.Contains(name, "/") {
+			panic(fmt.Sprintf("mfsr: migration %d-to-%d can only back up top level entries, not %s", m.From, m.To, name))
+		}
+	}
+	if _, dup := registry[m.From]; dup {
+		panic(fmt.Sprintf("mfsr: migration from %d registered twice", m.From))
+	}
+	registry[m.From] = m
+}
+
+// step is a migration run in one direction.
+type step struct {
+	m       *Migration
+	reverse bool
+}
+
+func (s step) from() int {
+	if s.reverse {
+		return s.m.To
+	}
+	return s.m.From
+}
+
+func (s step) to() int {
+	if s.reverse {
+		return s.m.From
+	}
+	return s.m.To
+}
+
+func (s step) run(rp RepoPath) error {
+	if s.reverse {
+		return s.m.Revert(rp)
+	}
+	return s.m.Apply(rp)
+}
+
+// plan returns the steps that lead from version from to version to.
+func plan(from, to int) ([]step, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	var steps []step
+	for v := from; v < to; v++ {
+		m, ok := registry[v]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d to %d", v, v+1)
+		}
+		steps = append(steps, step{m: m})
+	}
+	for v := from; v > to; v-- {
+		m, ok := registry[v-1]
+		if !ok {
+			return nil, fmt.Errorf("no migration from version %d back to %d", v, v-1)
+		}
+		steps = append(steps, step{m: m, reverse: true})
+	}
+	return steps, nil
+}
+
+// Migrate runs the migration steps that bring the repo to version to,
+// reverting steps if the repo is newer. Before each step, the version file,
+// the config, the directories of the leveldb mounts and the Backup entries
+// of the step are copied to BackupDir; if the step fails they are restored
+// and whatever else the step added to the repo directory is removed,
+// leaving the repo at the last version that was reached. The repo must not
+// be in use.
+func (rp RepoPath) Migrate(to int) error {
+	// a backup left behind means a step was interrupted half way
+	if err := rp.restoreBackup(); err != nil {
+		return fmt.Errorf("restoring interrupted migration: %s", err)
+	}
+
+	v, err := rp.Version()
+	if err != nil {
+		return err
+	}
+	from, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid repo version %q", v)
+	}
+
+	steps, err := plan(from, to)
+	if err != nil {
+		return err
+	}
+	for _, s := range steps {
+		if err := rp.runStep(s); err != nil {
+			return fmt.Errorf("migrating from version %d to %d: %s", s.from(), s.to(), err)
+		}
+	}
+	return nil
+}
+
+func (rp RepoPath) runStep(s step) error {
+	// the mounts are read anew for every step, as a step may move them
+	paths, err := rp.backupPaths(s.m.Backup)
+	if err != nil {
+		return fmt.Errorf("backup failed: %s", err)
+	}
+	if err := rp.backup(paths); err != nil {
+		return fmt.Errorf("backup failed: %s", err)
+	}
+
+	err = s.run(rp)
+	if err == nil {
+		err = rp.WriteVersion(strconv.Itoa(s.to()))
+	}
+	if err != nil {
+		if rerr := rp.restoreBackup(); rerr != nil {
+			return fmt.Errorf("%s; restoring backup failed too: %s", err, rerr)
+		}
+		return err
+	}
+	return os.RemoveAll(rp.backupPath())
+}
+
+func (rp RepoPath) backupPath() string {
+	return path.Join(string(rp), BackupDir)
+}
+
+// backupPaths returns the files and directories to back up before a step:
+// the version file, the config, the directories of the leveldb mounts,
+// which hold the metadata of the repo, and extra. Paths inside the repo are
+// relative to it; paths nested in another one are left out, as they are
+// backed up along with it.
+func (rp RepoPath) backupPaths(extra []string) ([]string, error) {
+	var conf struct {
+		Datastore config.Datastore
+	}
+	if err := serialize.ReadConfigFile(path.Join(string(rp), config.DefaultConfigFile), &conf); err != nil {
+		return nil, fmt.Errorf("reading the datastore mounts: %s", err)
+	}
+	mounts := conf.Datastore.Mounts
+	if len(mounts) == 0 {
+		mounts = config.DefaultDatastoreMounts()
+	}
+
+	paths := []string{VersionFile, config.DefaultConfigFile}
+	for _, m := range mounts {
+		if m.Type != "leveldb" {
+			continue
+		}
+		p, ok := m.Params["path"].(string)
+		if !ok || p == "" {
+			continue
+		}
+		if path.IsAbs(p) {
+			if rel, inside := rp.rel(p); inside {
+				p = rel
+			}
+		}
+		p = path.Clean(p)
+		if p == "." || p == BackupDir || strings.HasPrefix(p, "../") {
+			return nil, fmt.Errorf("datastore %s: cannot back up %s", m.Prefix, p)
+		}
+		paths = append(paths, p)
+	}
+	paths = append(paths, extra...)
+
+	sort.Strings(paths)
+	var out []string
+	for _, p := range paths {
+		if n := len(out); n > 0 && (p == out[n-1] || strings.HasPrefix(p, out[n-1]+"/")) {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// rel returns p relative to the repo, and whether p is inside it.
+func (rp RepoPath) rel(p string) (string, bool) {
+	rel, err := filepath.Rel(string(rp), p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// abs resolves p, as listed in a backup, against the repo.
+func (rp RepoPath) abs(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(string(rp), p)
+}
+
+// backupManifest describes a backup, in BackupDir.
+type backupManifest struct {
+	// Entries are the paths backed up. The copy of the i-th entry is
+	// named i in BackupDir, if the path existed.
+	Entries []backupEntry
+	// Top lists the repo directory when the backup was made, so that
+	// whatever a failed step added to it is removed.
+	Top []string
+}
+
+type backupEntry struct {
+	Path  string
+	Saved bool
+}
+
+const backupManifestFile = "manifest"
+
+// backup copies paths into BackupDir. It is written to a temporary
+// directory first, so that a half written backup is never restored.
+func (rp RepoPath) backup(paths []string) error {
+	tmp := rp.backupPath() + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.Mkdir(tmp, 0755); err != nil {
+		return err
+	}
+
+	top, err := readDir(string(rp))
+	if err != nil {
+		return err
+	}
+	man := backupManifest{Top: top}
+	for i, p := range paths {
+		err := copyTree(rp.abs(p), path.Join(tmp, strconv.Itoa(i)))
+		if err != nil && !os.IsNotExist(err) {
+			os.RemoveAll(tmp)
+			return err
+		}
+		// a path that doesn't exist yet is removed on restore
+		man.Entries = append(man.Entries, backupEntry{Path: p, Saved: err == nil})
+	}
+	if err := serialize.WriteConfigFile(path.Join(tmp, backupManifestFile), &man); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.Rename(tmp, rp.backupPath())
+}
+
+// restoreBackup puts the repo back the way it was when the backup in
+// BackupDir, if there is one, was made, and removes the backup. It can be
+// run again if it is interrupted.
+func (rp RepoPath) restoreBackup() error {
+	dir := rp.backupPath()
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	var man backupManifest
+	if err := serialize.ReadConfigFile(path.Join(dir, backupManifestFile), &man); err != nil {
+		return fmt.Errorf("reading the backup manifest: %s", err)
+	}
+
+	// remove what the step added to the repo directory
+	keep := map[string]bool{BackupDir: true, BackupDir + ".tmp": true}
+	for _, name := range man.Top {
+		keep[name] = true
+	}
+	top, err := readDir(string(rp))
+	if err != nil {
+		return err
+	}
+	for _, name := range top {
+		if !keep[name] {
+			if err := os.RemoveAll(path.Join(string(rp), name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, e := range man.Entries {
+		src := path.Join(dir, strconv.Itoa(i))
+		dst := rp.abs(e.Path)
+		if e.Saved {
+			if _, err := os.Lstat(src); os.IsNotExist(err) {
+				// restored already, by an interrupted restore
+				continue
+			}
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		if !e.Saved {
+			continue
+		}
+		if err := os.MkdirAll(path.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(src, dst); err != nil {
+			// the path may be on another filesystem
+			if err := copyTree(src, dst); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(src); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(dir)
+}
+func readDir(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(-1)
+}
+
+// copyTree copies the file or directory src to dst.
+func copyTree(src, dst string) error {
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fi.IsDir():
+		if err := os.Mkdir(dst, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		names, err := readDir(src)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := copyTree(path.Join(src, name), path.Join(dst, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	default:
+		return copyFile(src, dst, fi.Mode().Perm())
+	}
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
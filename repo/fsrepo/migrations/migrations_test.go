@@ -0,0 +1,224 @@
+package mfsr
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// test steps use versions far from the real ones
+func init() {
+	Register(&Migration{
+		From: 100,
+		To:   101,
+		Apply: func(rp RepoPath) error {
+			return writeConfig(rp, "101")
+		},
+		Revert: func(rp RepoPath) error {
+			return writeConfig(rp, "100")
+		},
+	})
+	Register(&Migration{
+		From: 101,
+		To:   102,
+		Apply: func(rp RepoPath) error {
+			// leave a half done change behind
+			if err := ioutil.WriteFile(path.Join(string(rp), "config"), []byte("broken"), 0644); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(path.Join(string(rp), "blocks", "CIQA")); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(string(rp), "blocks", "new"), nil, 0644); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(path.Join(string(rp), "data", "new"), 0755); err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(path.Join(string(rp), "created"), nil, 0644); err != nil {
+				return err
+			}
+			return errors.New("step failed")
+		},
+		Revert: func(rp RepoPath) error {
+			return nil
+		},
+		Backup: []string{"blocks"},
+	})
+}
+
+// testConfig mounts blocks in the repo, and the rest of the datastore
+// under data, which doesn't exist yet.
+const testConfig = `{"Step":%q,"Datastore":{"Mounts":[
+	{"Prefix":"/blocks","Type":"flatfs","Params":{"path":"blocks"}},
+	{"Prefix":"/","Type":"leveldb","Params":{"path":"data/ldb"}},
+	{"Prefix":"/cache","Type":"mem"}]}}`
+
+func writeConfig(rp RepoPath, step string) error {
+	return ioutil.WriteFile(path.Join(string(rp), "config"), []byte(fmt.Sprintf(testConfig, step)), 0644)
+}
+
+func testRepo(t *testing.T, version string) RepoPath {
+	dir, err := ioutil.TempDir("", "mfsr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := RepoPath(dir)
+	if err := rp.WriteVersion(version); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfig(rp, version); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(dir, "blocks", "CIQA"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "blocks", "CIQA", "block"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func expectState(t *testing.T, rp RepoPath, version, step string) {
+	v, err := rp.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != version {
+		t.Fatalf("expected version %s, got %s", version, v)
+	}
+	c, err := ioutil.ReadFile(path.Join(string(rp), "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf := fmt.Sprintf(testConfig, step); string(c) != conf {
+		t.Fatalf("expected config %q, got %q", conf, c)
+	}
+	if _, err := os.Stat(path.Join(string(rp), BackupDir)); !os.IsNotExist(err) {
+		t.Fatal("backup should be removed once done")
+	}
+}
+
+func TestMigrateForwardAndBack(t *testing.T) {
+	rp := testRepo(t, "100")
+	defer os.RemoveAll(string(rp))
+
+	if err := rp.Migrate(101); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, rp, "101", "101")
+
+	if err := rp.Migrate(100); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, rp, "100", "100")
+
+	if err := rp.Migrate(100); err != nil {
+		t.Fatal("migrating to the current version should do nothing:", err)
+	}
+}
+
+func TestMigrateFailureRestoresBackup(t *testing.T) {
+	rp := testRepo(t, "100")
+	defer os.RemoveAll(string(rp))
+
+	if err := rp.Migrate(102); err == nil {
+		t.Fatal("expected the failing step to fail the migration")
+	}
+	// the first step stays applied, the second is rolled back
+	expectState(t, rp, "101", "101")
+	data, err := ioutil.ReadFile(path.Join(string(rp), "blocks", "CIQA", "block"))
+	if err != nil || string(data) != "data" {
+		t.Fatal("blocks mount should have been restored")
+	}
+	for _, p := range []string{"blocks/new", "data", "created"} {
+		if _, err := os.Lstat(path.Join(string(rp), p)); !os.IsNotExist(err) {
+			t.Fatalf("%s, created by the failed step, should have been removed", p)
+		}
+	}
+}
+
+func TestBackupPaths(t *testing.T) {
+	rp := testRepo(t, "100")
+	defer os.RemoveAll(string(rp))
+
+	out := filepath.Join(string(rp), "..", "outside")
+	conf := fmt.Sprintf(`{"Datastore":{"Mounts":[
+		{"Prefix":"/blocks","Type":"flatfs","Params":{"path":%q}},
+		{"Prefix":"/a","Type":"leveldb","Params":{"path":%q}},
+		{"Prefix":"/","Type":"leveldb","Params":{"path":"data/ldb"}}]}}`, out, filepath.Join(string(rp), "data"))
+	if err := ioutil.WriteFile(path.Join(string(rp), "config"), []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths, err := rp.backupPaths([]string{"keys"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// data/ldb is backed up along with data, the blocks only on request
+	expect := []string{"config", "data", "keys", "version"}
+	if fmt.Sprint(paths) != fmt.Sprint(expect) {
+		t.Fatalf("expected %v, got %v", expect, paths)
+	}
+
+	// the default layout, without mounts
+	if err := ioutil.WriteFile(path.Join(string(rp), "config"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	paths, err = rp.backupPaths(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"config", "datastore", "version"}; fmt.Sprint(paths) != fmt.Sprint(expect) {
+		t.Fatalf("expected %v, got %v", expect, paths)
+	}
+	paths, err = rp.backupPaths([]string{"blocks"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []string{"blocks", "config", "datastore", "version"}; fmt.Sprint(paths) != fmt.Sprint(expect) {
+		t.Fatalf("expected %v, got %v", expect, paths)
+	}
+}
+
+func TestMigrateWithoutPath(t *testing.T) {
+	rp := testRepo(t, "100")
+	defer os.RemoveAll(string(rp))
+
+	if err := rp.Migrate(103); err == nil {
+		t.Fatal("expected an error without a migration to 103")
+	}
+	expectState(t, rp, "100", "100")
+}
+
+func TestMigrateRestoresInterruptedStep(t *testing.T) {
+	rp := testRepo(t, "100")
+	defer os.RemoveAll(string(rp))
+
+	paths, err := rp.backupPaths(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.backup(paths); err != nil {
+		t.Fatal(err)
+	}
+	// a crash half way through a step
+	if err := ioutil.WriteFile(path.Join(string(rp), "config"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// and half way through restoring it: the version file is back already
+	if err := os.Remove(path.Join(string(rp), VersionFile)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path.Join(string(rp), BackupDir, "2"), path.Join(string(rp), VersionFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rp.Migrate(100); err != nil {
+		t.Fatal(err)
+	}
+	expectState(t, rp, "100", "100")
+}
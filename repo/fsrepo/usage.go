@@ -0,0 +1,189 @@
+package fsrepo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore/measure"
+	config "github.com/ipfs/go-ipfs/repo/config"
+)
+
+// remeasureInterval is how often the running total of a backend that can
+// measure itself is corrected, as it drifts from the space taken up on disk
+// with compaction, compression and files changed outside of the repo.
+var remeasureInterval = time.Minute * 10
+
+// usageDatastore keeps a running total of the bytes stored in the wrapped
+// datastore, so the repo can report its size without walking the disk.
+type usageDatastore struct {
+	measure.DatastoreCloser
+	used uint64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// newUsageDatastore wraps d, which already holds used bytes.
+func newUsageDatastore(d measure.DatastoreCloser, used uint64) *usageDatastore {
+	return &usageDatastore{DatastoreCloser: d, used: used, stop: make(chan struct{})}
+}
+
+// remeasure resets the running total to the size backend measures itself
+// at, every remeasureInterval until stopMeasuring. Backends that can't measure
+// themselves are left to the running total.
+func (u *usageDatastore) remeasure(backend ds.Datastore) {
+	du, ok := backend.(diskUsager)
+	if !ok {
+		return
+	}
+	go func() {
+		t := time.NewTicker(remeasureInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				used, err := du.diskUsage()
+				if err != nil {
+					log.Debugf("unable to measure datastore: %s", err)
+					continue
+				}
+				atomic.StoreUint64(&u.used, used)
+			case <-u.stop:
+				return
+			}
+		}
+	}()
+}
+
+// diskUsager is implemented by backends that can measure the space they
+// take up on disk.
+type diskUsager interface {
+	diskUsage() (uint64, error)
+}
+
+// initialUsage returns the bytes stored in d when the repo is opened.
+// Backends that can't measure themselves, such as remote stores, start
+// counting from zero.
+func initialUsage(d ds.Datastore) (uint64, error) {
+	if du, ok := d.(diskUsager); ok {
+		return du.diskUsage()
+	}
+	log.Infof("datastore size is unknown, counting from zero")
+	return 0, nil
+}
+
+// MountUsage is the space a datastore mount takes up on disk.
+type MountUsage struct {
+	Prefix string
+	Type   string
+	Path   string
+	Size   uint64
+}
+
+// DatastoreUsage measures the directories of the datastore mounts conf
+// configures for the repo at repoPath. Mounts that keep nothing on disk,
+// such as remote stores, are left out.
+func DatastoreUsage(repoPath string, conf *config.Config) ([]MountUsage, error) {
+	mounts, err := datastoreMounts(conf)
+	if err != nil {
+		return nil, err
+	}
+	var usage []MountUsage
+	for _, m := range mounts {
+		if _, ok := m.Params["path"]; !ok {
+			continue
+		}
+		p, err := DatastoreParams(m.Params).Path(repoPath, "path")
+		if err != nil {
+			return nil, fmt.Errorf("datastore %s: %s", m.Prefix, err)
+		}
+		size, err := dirSize(p)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, MountUsage{Prefix: m.Prefix, Type: m.Type, Path: p, Size: size})
+	}
+	return usage, nil
+}
+
+// dirSize sums the sizes of the files under dir. A missing dir is empty.
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (u *usageDatastore) Put(key ds.Key, value interface{}) error {
+	b, ok := value.([]byte)
+	if !ok {
+		return u.DatastoreCloser.Put(key, value)
+	}
+	exists, err := u.DatastoreCloser.Has(key)
+	if err != nil {
+		return err
+	}
+	if err := u.DatastoreCloser.Put(key, value); err != nil {
+		return err
+	}
+	if !exists {
+		atomic.AddUint64(&u.used, uint64(len(b)))
+	}
+	return nil
+}
+
+func (u *usageDatastore) Delete(key ds.Key) error {
+	// the size is only known before the value is gone
+	value, err := u.DatastoreCloser.Get(key)
+	if err != nil {
+		return u.DatastoreCloser.Delete(key)
+	}
+	if err := u.DatastoreCloser.Delete(key); err != nil {
+		return err
+	}
+	if b, ok := value.([]byte); ok {
+		u.sub(uint64(len(b)))
+	}
+	return nil
+}
+
+func (u *usageDatastore) sub(n uint64) {
+	for {
+		old := atomic.LoadUint64(&u.used)
+		next := old - n
+		if n > old {
+			next = 0
+		}
+		if atomic.CompareAndSwapUint64(&u.used, old, next) {
+			return
+		}
+	}
+}
+
+// stopMeasuring stops the measuring started by remeasure.
+func (u *usageDatastore) stopMeasuring() {
+	u.stopOnce.Do(func() { close(u.stop) })
+}
+
+// Used returns the number of bytes currently stored.
+func (u *usageDatastore) Used() uint64 {
+	return atomic.LoadUint64(&u.used)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	ds "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	"github.com/ipfs/go-ipfs/repo/config"
 )
 
@@ -13,6 +14,7 @@ var errTODO = errors.New("TODO")
 type Mock struct {
 	C config.Config
 	D ds.ThreadSafeDatastore
+	K keystore.Keystore
 }
 
 func (m *Mock) Config() *config.Config {
@@ -34,4 +36,8 @@ func (m *Mock) GetConfigKey(key string) (interface{}, error) {
 
 func (m *Mock) Datastore() ds.ThreadSafeDatastore { return m.D }
 
+func (m *Mock) Keystore() keystore.Keystore { return m.K }
+
+func (m *Mock) StorageUsage() (uint64, error) { return 0, nil }
+
 func (m *Mock) Close() error { return errTODO }
@@ -4,6 +4,7 @@ import (
 	"io"
 
 	datastore "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-datastore"
+	keystore "github.com/ipfs/go-ipfs/keystore"
 	config "github.com/ipfs/go-ipfs/repo/config"
 )
 
@@ -16,5 +17,12 @@ type Repo interface {
 
 	Datastore() datastore.ThreadSafeDatastore
 
+	// Keystore returns the named keys of the node, or nil if the repo
+	// keeps none.
+	Keystore() keystore.Keystore
+
+	// StorageUsage returns the number of bytes taken up by blocks.
+	StorageUsage() (uint64, error)
+
 	io.Closer
 }
@@ -55,6 +55,7 @@ type IpfsDHT struct {
 	diaglock sync.Mutex // lock to make diagnostics work better
 
 	Validator record.Validator // record validator funcs
+	Selector  record.Selector  // record selector funcs
 
 	ctxgroup.ContextGroup
 }
@@ -86,6 +87,9 @@ func NewDHT(ctx context.Context, h host.Host, dstore ds.ThreadSafeDatastore) *Ip
 	dht.Validator = make(record.Validator)
 	dht.Validator["pk"] = record.PublicKeyValidator
 
+	dht.Selector = make(record.Selector)
+	dht.Selector["pk"] = record.PublicKeySelector
+
 	if doPinging {
 		dht.Children().Add(1)
 		go dht.PingRoutine(time.Second * 10)
@@ -166,18 +170,20 @@ func (dht *IpfsDHT) putProvider(ctx context.Context, p peer.ID, key string) erro
 }
 
 // getValueOrPeers queries a particular peer p for the value for
-// key. It returns either the value or a list of closer peers.
+// key. It returns the record, if p has a valid one, and a list of closer
+// peers.
 // NOTE: it will update the dht's peerstore with any new addresses
 // it finds for the given peer.
 func (dht *IpfsDHT) getValueOrPeers(ctx context.Context, p peer.ID,
-	key u.Key) ([]byte, []peer.PeerInfo, error) {
+	key u.Key) (*pb.Record, []peer.PeerInfo, error) {
 
 	pmes, err := dht.getValueSingle(ctx, p, key)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if record := pmes.GetRecord(); record != nil {
+	record := pmes.GetRecord()
+	if record != nil {
 		// Success! We were given the value
 		log.Debug("getValueOrPeers: got value")
 
@@ -185,20 +191,21 @@ func (dht *IpfsDHT) getValueOrPeers(ctx context.Context, p peer.ID,
 		err = dht.verifyRecordOnline(ctx, record)
 		if err != nil {
 			log.Info("Received invalid record! (discarded)")
-			return nil, nil, err
+			record = nil
 		}
-		return record.GetValue(), nil, nil
 	}
 
 	// Perhaps we were given closer peers
 	peers := pb.PBPeersToPeerInfos(pmes.GetCloserPeers())
-	if len(peers) > 0 {
-		log.Debug("getValueOrPeers: peers")
-		return nil, peers, nil
+	if record == nil && len(peers) == 0 {
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Warning("getValueOrPeers: routing.ErrNotFound")
+		return nil, nil, routing.ErrNotFound
 	}
 
-	log.Warning("getValueOrPeers: routing.ErrNotFound")
-	return nil, nil, routing.ErrNotFound
+	return record, peers, nil
 }
 
 // getValueSingle simply performs the get value RPC with the given parameters
@@ -212,6 +219,15 @@ func (dht *IpfsDHT) getValueSingle(ctx context.Context, p peer.ID,
 
 // getLocal attempts to retrieve the value from the datastore
 func (dht *IpfsDHT) getLocal(key u.Key) ([]byte, error) {
+	rec, err := dht.getLocalRecord(key)
+	if err != nil {
+		return nil, err
+	}
+	return rec.GetValue(), nil
+}
+
+// getLocalRecord attempts to retrieve the record from the datastore
+func (dht *IpfsDHT) getLocalRecord(key u.Key) (*pb.Record, error) {
 
 	log.Debug("getLocal %s", key)
 	v, err := dht.datastore.Get(key.DsKey())
@@ -239,7 +255,7 @@ func (dht *IpfsDHT) getLocal(key u.Key) ([]byte, error) {
 		}
 	}
 
-	return rec.GetValue(), nil
+	return rec, nil
 }
 
 // getOwnPrivateKey attempts to load the local peers private
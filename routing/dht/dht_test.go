@@ -47,6 +47,9 @@ func setupDHT(ctx context.Context, t *testing.T) *IpfsDHT {
 		},
 		Sign: false,
 	}
+	d.Selector["v"] = func(u.Key, [][]byte) (int, error) {
+		return 0, nil
+	}
 	return d
 }
 
@@ -177,6 +180,78 @@ func TestValueGetSet(t *testing.T) {
 	}
 }
 
+func TestGetValueSelectsBest(t *testing.T) {
+	ctx := context.Background()
+
+	_, _, dhts := setupDHTS(ctx, 3, t)
+	defer func() {
+		for _, d := range dhts {
+			d.Close()
+			defer d.host.Close()
+		}
+	}()
+
+	// the largest value is the best
+	for _, d := range dhts {
+		d.Selector["v"] = func(_ u.Key, vals [][]byte) (int, error) {
+			best := 0
+			for i, v := range vals {
+				if bytes.Compare(v, vals[best]) > 0 {
+					best = i
+				}
+			}
+			return best, nil
+		}
+	}
+
+	connect(t, ctx, dhts[0], dhts[1])
+	connect(t, ctx, dhts[0], dhts[2])
+
+	k := u.Key("/v/hello")
+	for i, v := range []string{"one", "two", "three"} {
+		sk := dhts[i].peerstore.PrivKey(dhts[i].self)
+		rec, err := record.MakePutRecord(sk, k, []byte(v), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dhts[i].putLocal(k, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxT, _ := context.WithTimeout(ctx, time.Second*2)
+	val, err := dhts[0].GetValue(ctxT, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(val) != "two" {
+		t.Fatalf("Expected 'two' got '%s'", string(val))
+	}
+
+	// the outdated records were replaced with the best one
+	for i := 0; i < 50; i++ {
+		v0, _ := dhts[0].getLocal(k)
+		v2, _ := dhts[2].getLocal(k)
+		if string(v0) == "two" && string(v2) == "two" {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	for _, d := range dhts {
+		if v, _ := d.getLocal(k); string(v) != "two" {
+			t.Fatalf("outdated record '%s' left in place", string(v))
+		}
+	}
+
+	// and are not replaced by worse ones
+	if err := dhts[1].PutValue(ctxT, k, []byte("four")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := dhts[0].getLocal(k); string(v) != "two" {
+		t.Fatalf("Expected 'two' got '%s'", string(v))
+	}
+}
+
 func TestProvides(t *testing.T) {
 	// t.Skip("skipping test to debug another")
 	ctx := context.Background()
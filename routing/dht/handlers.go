@@ -112,6 +112,16 @@ func (dht *IpfsDHT) handlePutValue(ctx context.Context, p peer.ID, pmes *pb.Mess
 		return nil, err
 	}
 
+	// keep the record we have if it is better than the one put
+	key := u.Key(pmes.GetKey())
+	if old, err := dht.getLocalRecord(key); err == nil {
+		vals := [][]byte{pmes.GetRecord().GetValue(), old.GetValue()}
+		if i, err := dht.Selector.BestRecord(key, vals); err == nil && i == 1 {
+			log.Debugf("%s handlePutValue kept better record for %v", dht.self, dskey)
+			return pmes, nil
+		}
+	}
+
 	data, err := proto.Marshal(pmes.GetRecord())
 	if err != nil {
 		return nil, err
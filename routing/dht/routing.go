@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"bytes"
 	"sync"
 	"time"
 
@@ -76,15 +77,78 @@ func (dht *IpfsDHT) PutValue(ctx context.Context, key u.Key, value []byte) error
 	return nil
 }
 
-// GetValue searches for the value corresponding to given Key.
-// If the search does not succeed, a multiaddr string of a closer peer is
-// returned along with util.ErrSearchIncomplete
+// GetValue searches for the value corresponding to given Key. It gathers
+// up to maxRecordResponses records for the key, along with the local one,
+// and returns the best of them as picked by the Selector. Peers found
+// holding an outdated record are sent the best one.
 func (dht *IpfsDHT) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
-	// If we have it local, dont bother doing an RPC!
-	val, err := dht.getLocal(key)
+	// If we have it local, dont bother doing an RPC! Only records that
+	// never change can't be outdated.
+	if record.Immutable(key) {
+		if rec, err := dht.getLocalRecord(key); err == nil {
+			log.Debug("have it locally")
+			return rec.GetValue(), nil
+		}
+	}
+
+	recs, err := dht.getRecords(ctx, key, maxRecordResponses)
+	if err != nil {
+		return nil, err
+	}
+
+	vals := make([][]byte, len(recs))
+	for i, r := range recs {
+		vals[i] = r.rec.GetValue()
+	}
+	i, err := dht.Selector.BestRecord(key, vals)
+	if err != nil {
+		return nil, err
+	}
+	best := recs[i].rec
+
+	for _, r := range recs {
+		if bytes.Equal(r.rec.GetValue(), best.GetValue()) {
+			continue
+		}
+		if r.from == dht.self {
+			if err := dht.putLocal(key, best); err != nil {
+				log.Debugf("failed updating local record: %s", err)
+			}
+			continue
+		}
+		go func(p peer.ID) {
+			ctx, cancel := context.WithTimeout(dht.Context(), time.Second*30)
+			defer cancel()
+			if err := dht.putValueToPeer(ctx, p, key, best); err != nil {
+				log.Debugf("failed correcting record on peer: %s", err)
+			}
+		}(r.from)
+	}
+
+	log.Debugf("GetValue %v %v", key, best.GetValue())
+	return best.GetValue(), nil
+}
+
+// maxRecordResponses is the number of records GetValue gathers from the
+// network before picking the best.
+var maxRecordResponses = 16
+
+// recordResponse is a record, and the peer it came from.
+type recordResponse struct {
+	rec  *pb.Record
+	from peer.ID
+}
+
+// getRecords gathers the valid records for key: the local one, if any,
+// and those of up to nvals peers.
+func (dht *IpfsDHT) getRecords(ctx context.Context, key u.Key, nvals int) ([]recordResponse, error) {
+	var recs []recordResponse
+	var recsLk sync.Mutex
+
+	rec, err := dht.getLocalRecord(key)
 	if err == nil {
 		log.Debug("have it locally")
-		return val, nil
+		recs = append(recs, recordResponse{rec: rec, from: dht.self})
 	} else {
 		log.Debug("failed to get value locally: %s", err)
 	}
@@ -93,6 +157,9 @@ func (dht *IpfsDHT) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
 	rtp := dht.routingTable.NearestPeers(kb.ConvertKey(key), AlphaValue)
 	log.Debugf("peers in rt: %s", len(rtp), rtp)
 	if len(rtp) == 0 {
+		if len(recs) > 0 {
+			return recs, nil
+		}
 		log.Warning("No peers from routing table!")
 		return nil, kb.ErrLookupFailure
 	}
@@ -104,14 +171,18 @@ func (dht *IpfsDHT) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
 			ID:   p,
 		})
 
-		val, peers, err := dht.getValueOrPeers(ctx, p, key)
+		rec, peers, err := dht.getValueOrPeers(ctx, p, key)
 		if err != nil {
 			return nil, err
 		}
 
-		res := &dhtQueryResult{value: val, closerPeers: peers}
-		if val != nil {
-			res.success = true
+		res := &dhtQueryResult{closerPeers: peers}
+		if rec != nil {
+			recsLk.Lock()
+			recs = append(recs, recordResponse{rec: rec, from: p})
+			// enough records, stop the query
+			res.success = len(recs) >= nvals
+			recsLk.Unlock()
 		}
 
 		notif.PublishQueryEvent(ctx, &notif.QueryEvent{
@@ -123,18 +194,19 @@ func (dht *IpfsDHT) GetValue(ctx context.Context, key u.Key) ([]byte, error) {
 		return res, nil
 	})
 
-	// run it!
-	result, err := query.Run(ctx, rtp)
-	if err != nil {
-		return nil, err
-	}
+	// run it! it only fails when it found nothing, or was cut short; the
+	// records gathered until then are still good.
+	_, err = query.Run(ctx, rtp)
 
-	log.Debugf("GetValue %v %v", key, result.value)
-	if result.value == nil {
-		return nil, routing.ErrNotFound
+	recsLk.Lock()
+	defer recsLk.Unlock()
+	if len(recs) == 0 {
+		if err == nil {
+			err = routing.ErrNotFound
+		}
+		return nil, err
 	}
-
-	return result.value, nil
+	return append([]recordResponse(nil), recs...), nil
 }
 
 // Value provider layer of indirection.
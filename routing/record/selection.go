@@ -0,0 +1,54 @@
+package record
+
+import (
+	"errors"
+	"strings"
+
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// ErrNoRecords is returned when there is no record to select from.
+var ErrNoRecords = errors.New("no records to select from")
+
+// SelectorFunc is a function that picks the best of several values
+// found for a given key, and returns its index.
+type SelectorFunc func(u.Key, [][]byte) (int, error)
+
+// Selector is an object that picks the best of several routing records
+// for a key. Like the Validator, it holds one SelectorFunc per key
+// prefix.
+type Selector map[string]SelectorFunc
+
+// BestRecord returns the index of the best of the given values for key k,
+// as chosen by the SelectorFunc for the prefix of k.
+func (s Selector) BestRecord(k u.Key, recs [][]byte) (int, error) {
+	if len(recs) == 0 {
+		return 0, ErrNoRecords
+	}
+
+	parts := strings.Split(string(k), "/")
+	if len(parts) < 3 {
+		log.Infof("Record key does not have selector: %s", k)
+		return 0, nil
+	}
+
+	sel, ok := s[parts[1]]
+	if !ok {
+		log.Infof("Unrecognized key prefix: %s", parts[1])
+		return 0, ErrInvalidRecordType
+	}
+
+	return sel(k, recs)
+}
+
+// Immutable returns whether the records of k never change, so that the
+// first one found is as good as the best. Public key records are.
+func Immutable(k u.Key) bool {
+	return strings.HasPrefix(string(k), "/pk/")
+}
+
+// PublicKeySelector implements SelectorFunc. Public key records are
+// immutable, so any of them will do.
+func PublicKeySelector(k u.Key, vals [][]byte) (int, error) {
+	return 0, nil
+}
@@ -0,0 +1,634 @@
+// package tar stores tar archives as merkledags that can be browsed like
+// the files they hold, and writes them back out byte for byte.
+//
+// Every entry of an archive is stored as a header node, holding the raw
+// header blocks of the entry, with a "data" link to its body imported as a
+// unixfs file. Header nodes are linked from a tree that follows the paths
+// of the entries, with every path element prefixed by "_" so that it does
+// not clash with the links of the header nodes. The root records the order
+// of the entries in the archive and links to the end of the archive as
+// "trailer".
+package tar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	importer "github.com/ipfs/go-ipfs/importer"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+)
+
+const blockSize = 512
+
+// extended headers are kept in memory, with the header they describe
+const maxExtendedSize = 1 << 20
+
+// the data of the root and of the directories of an archive
+var marker = []byte("ipfs/tar")
+
+var ErrNotTar = errors.New("tar: node is not an imported tar archive")
+
+var errCorruptOrder = errors.New("tar: corrupt order of the entries")
+
+// treeNode is a path of an archive being imported.
+type treeNode struct {
+	// the header node of the entry at this path, nil for directories that
+	// have no entry of their own
+	header   *dag.Node
+	children []*treeChild
+}
+
+type treeChild struct {
+	name string
+	node *treeNode
+}
+
+// child returns the first child by the given name, or nil.
+func (t *treeNode) child(name string) *treeNode {
+	for _, c := range t.children {
+		if c.name == name {
+			return c.node
+		}
+	}
+	return nil
+}
+
+// insert adds header to the tree at the path elems. A second entry at the
+// same path gets a link of its own with the same name.
+func (t *treeNode) insert(elems []string, header *dag.Node) *treeNode {
+	cur := t
+	for _, e := range elems[:len(elems)-1] {
+		next := cur.child(e)
+		if next == nil {
+			next = new(treeNode)
+			cur.children = append(cur.children, &treeChild{name: e, node: next})
+		}
+		cur = next
+	}
+
+	name := elems[len(elems)-1]
+	if c := cur.child(name); c != nil && c.header == nil {
+		c.header = header
+		return c
+	}
+	n := &treeNode{header: header}
+	cur.children = append(cur.children, &treeChild{name: name, node: n})
+	return n
+}
+
+// sortChildren orders the children as their links will be.
+func (t *treeNode) sortChildren() {
+	sort.Stable(childSlice(t.children))
+	for _, c := range t.children {
+		c.node.sortChildren()
+	}
+}
+
+// walk calls f on the entries under t in the order ExportTar finds them.
+func (t *treeNode) walk(f func(*treeNode)) {
+	for _, c := range t.children {
+		if c.node.header != nil {
+			f(c.node)
+		}
+		c.node.walk(f)
+	}
+}
+
+// store adds the nodes of the tree under t, and t itself, to ds.
+func (t *treeNode) store(ds dag.DAGService) (*dag.Node, error) {
+	nd := t.header
+	if nd == nil {
+		nd = &dag.Node{Data: marker}
+	}
+	for _, c := range t.children {
+		cnd, err := c.node.store(ds)
+		if err != nil {
+			return nil, err
+		}
+		if err := nd.AddNodeLinkClean("_"+c.name, cnd); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := ds.Add(nd); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+type childSlice []*treeChild
+
+func (cs childSlice) Len() int           { return len(cs) }
+func (cs childSlice) Swap(a, b int)      { cs[a], cs[b] = cs[b], cs[a] }
+func (cs childSlice) Less(a, b int) bool { return cs[a].name < cs[b].name }
+
+// ImportTar reads a tar archive from r, stores it in ds and returns the
+// root of the archive.
+func ImportTar(r io.Reader, ds dag.DAGService) (*dag.Node, error) {
+	root := new(treeNode)
+	hr := &headerReader{r: r}
+	var order []*treeNode
+	var trailer []byte
+	for {
+		hdr, size, err := hr.next()
+		if err != nil {
+			return nil, err
+		}
+		if hdr == nil {
+			// the end of the archive, keep whatever follows
+			rest, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			trailer = append(make([]byte, size), rest...)
+			break
+		}
+
+		header, err := importEntry(r, hdr.raw, hdr.size, ds)
+		if err != nil {
+			return nil, err
+		}
+		order = append(order, root.insert(splitPath(hdr.name), header))
+	}
+
+	// record the order of the entries as their indices in the order
+	// ExportTar walks the tree
+	root.sortChildren()
+	index := make(map[*treeNode]uint64)
+	root.walk(func(t *treeNode) {
+		index[t] = uint64(len(index))
+	})
+	data := append([]byte(nil), marker...)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, t := range order {
+		n := binary.PutUvarint(buf, index[t])
+		data = append(data, buf[:n]...)
+	}
+
+	nd := &dag.Node{Data: data}
+	tnd := &dag.Node{Data: trailer}
+	if _, err := ds.Add(tnd); err != nil {
+		return nil, err
+	}
+	if err := nd.AddNodeLinkClean("trailer", tnd); err != nil {
+		return nil, err
+	}
+	// store the tree through the root, now carrying the order
+	root.header = nd
+	return root.store(ds)
+}
+
+// importEntry reads the body of an entry from r, and returns the header
+// node of the entry.
+func importEntry(r io.Reader, hdr []byte, size int64, ds dag.DAGService) (*dag.Node, error) {
+	header := &dag.Node{Data: hdr}
+	if size > 0 {
+		cr := &countReader{r: io.LimitReader(r, size)}
+		body, err := importer.BuildDagFromReader(cr, ds, nil, chunk.DefaultSplitter)
+		if err != nil {
+			return nil, err
+		}
+		if cr.n != size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err := header.AddNodeLinkClean("data", body); err != nil {
+			return nil, err
+		}
+	}
+
+	// the padding of the body is only stored if it is not zeros
+	pad := make([]byte, padding(size))
+	if _, err := io.ReadFull(r, pad); err != nil {
+		return nil, unexpected(err)
+	}
+	if !isZero(pad) {
+		pnd := &dag.Node{Data: pad}
+		if _, err := ds.Add(pnd); err != nil {
+			return nil, err
+		}
+		if err := header.AddNodeLinkClean("padding", pnd); err != nil {
+			return nil, err
+		}
+	}
+	return header, nil
+}
+
+// entryHeader is the header of an entry, with the extended headers that
+// describe it applied.
+type entryHeader struct {
+	// the raw header blocks, along with the extended headers before them
+	raw      []byte
+	name     string
+	linkname string
+	size     int64
+	mtime    time.Time
+}
+
+// headerReader reads the headers of the entries of an archive.
+type headerReader struct {
+	r io.Reader
+	// the records of the pax global headers, which apply to every entry
+	// that follows them
+	global map[string]string
+}
+
+// next reads the header blocks of the next entry, along with any extended
+// headers before them, and returns the header of the entry. At the end of
+// the archive, it returns no header and the size of the zero block that
+// ended it.
+func (hr *headerReader) next() (*entryHeader, int64, error) {
+	var raw []byte
+	var longName, longLink string
+	local := make(map[string]string)
+	for {
+		block := make([]byte, blockSize)
+		if _, err := io.ReadFull(hr.r, block); err != nil {
+			if err == io.EOF && raw == nil {
+				// an archive without an end marker
+				return nil, 0, nil
+			}
+			return nil, 0, unexpected(err)
+		}
+		if isZero(block) && raw == nil {
+			return nil, blockSize, nil
+		}
+		raw = append(raw, block...)
+
+		size, err := parseNumeric(block[124:136])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		switch flag := block[156]; flag {
+		case 'L', 'K', 'x', 'g':
+			// an extended header, describing the next entry
+			if size > maxExtendedSize {
+				return nil, 0, errors.New("tar: extended header too large")
+			}
+			body := make([]byte, size+padding(size))
+			if _, err := io.ReadFull(hr.r, body); err != nil {
+				return nil, 0, unexpected(err)
+			}
+			raw = append(raw, body...)
+			switch flag {
+			case 'L':
+				longName = cString(body[:size])
+			case 'K':
+				longLink = cString(body[:size])
+			case 'x':
+				parsePax(body[:size], local)
+			case 'g':
+				if hr.global == nil {
+					hr.global = make(map[string]string)
+				}
+				parsePax(body[:size], hr.global)
+			}
+		default:
+			mtime, err := parseNumeric(block[136:148])
+			if err != nil {
+				return nil, 0, err
+			}
+			hdr := &entryHeader{
+				raw:      raw,
+				name:     cString(block[0:100]),
+				linkname: cString(block[157:257]),
+				size:     size,
+				mtime:    time.Unix(mtime, 0),
+			}
+			if string(block[257:262]) == "ustar" {
+				if prefix := cString(block[345:500]); prefix != "" {
+					hdr.name = prefix + "/" + hdr.name
+				}
+			}
+			if longName != "" {
+				hdr.name = longName
+			}
+			if longLink != "" {
+				hdr.linkname = longLink
+			}
+			// local records override global ones
+			for _, recs := range []map[string]string{hr.global, local} {
+				if err := hdr.applyPax(recs); err != nil {
+					return nil, 0, err
+				}
+			}
+			if headerOnly(flag) {
+				hdr.size = 0
+			}
+			return hdr, 0, nil
+		}
+	}
+}
+
+// applyPax overrides the fields of hdr with the pax records recs.
+func (hdr *entryHeader) applyPax(recs map[string]string) error {
+	for k, v := range recs {
+		switch k {
+		case "path":
+			hdr.name = v
+		case "linkpath":
+			hdr.linkname = v
+		case "size":
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || size < 0 {
+				return errors.New("tar: invalid pax size")
+			}
+			hdr.size = size
+		case "mtime":
+			mtime, err := parsePaxTime(v)
+			if err != nil {
+				return err
+			}
+			hdr.mtime = mtime
+		}
+	}
+	return nil
+}
+
+// headerOnly returns whether entries of the given type have no body,
+// whatever their size field says.
+func headerOnly(flag byte) bool {
+	switch flag {
+	case '1', '2', '3', '4', '5', '6':
+		return true
+	}
+	return false
+}
+
+// parseNumeric parses a number field of a header, in octal or in the
+// base-256 encoding of large values.
+func parseNumeric(b []byte) (int64, error) {
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		var n int64
+		for i, c := range b {
+			if i == 0 {
+				c &= 0x7f
+			}
+			if n > (1<<55)-1 {
+				return 0, errors.New("tar: size out of range")
+			}
+			n = n<<8 | int64(c)
+		}
+		return n, nil
+	}
+
+	s := strings.Trim(string(b), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 8, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("tar: invalid header")
+	}
+	return n, nil
+}
+
+// parsePax adds the records of a pax extended header to recs. A record
+// with an empty value deletes the key. Parsing stops at the first malformed
+// record.
+func parsePax(b []byte, recs map[string]string) {
+	for len(b) > 0 {
+		// records are "<length> <key>=<value>\n"
+		sp := bytes.IndexByte(b, ' ')
+		if sp < 0 {
+			return
+		}
+		n, err := strconv.Atoi(string(b[:sp]))
+		if err != nil || n <= sp || n > len(b) {
+			return
+		}
+		rec := strings.TrimSuffix(string(b[sp+1:n]), "\n")
+		if kv := strings.SplitN(rec, "=", 2); len(kv) == 2 {
+			if kv[1] == "" {
+				delete(recs, kv[0])
+			} else {
+				recs[kv[0]] = kv[1]
+			}
+		}
+		b = b[n:]
+	}
+}
+
+// parsePaxTime parses a pax time record, in seconds with an optional
+// decimal fraction.
+func parsePaxTime(s string) (time.Time, error) {
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("tar: invalid pax time")
+	}
+	var nsec int64
+	if frac != "" {
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		frac += strings.Repeat("0", 9-len(frac))
+		if nsec, err = strconv.ParseInt(frac, 10, 64); err != nil || nsec < 0 {
+			return time.Time{}, errors.New("tar: invalid pax time")
+		}
+		if strings.HasPrefix(secs, "-") {
+			nsec = -nsec
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+func splitPath(name string) []string {
+	var elems []string
+	for _, e := range strings.Split(name, "/") {
+		if e != "" {
+			elems = append(elems, e)
+		}
+	}
+	if len(elems) == 0 {
+		elems = []string{""}
+	}
+	return elems
+}
+
+func padding(size int64) int64 {
+	return (blockSize - size%blockSize) % blockSize
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ExportTar returns a reader of the archive stored under root by
+// ImportTar.
+func ExportTar(ctx context.Context, root *dag.Node, ds dag.DAGService) (io.Reader, error) {
+	if !bytes.HasPrefix(root.Data, marker) {
+		return nil, ErrNotTar
+	}
+
+	var entries []*dag.Node
+	if err := walkEntries(ctx, root, ds, &entries); err != nil {
+		return nil, err
+	}
+
+	var order []*dag.Node
+	data := root.Data[len(marker):]
+	for len(data) > 0 {
+		i, n := binary.Uvarint(data)
+		if n <= 0 || i >= uint64(len(entries)) {
+			return nil, errCorruptOrder
+		}
+		order = append(order, entries[i])
+		data = data[n:]
+	}
+	if len(order) != len(entries) {
+		return nil, errCorruptOrder
+	}
+
+	lnk, err := root.GetNodeLink("trailer")
+	if err != nil {
+		return nil, ErrNotTar
+	}
+	trailer, err := lnk.GetNode(ctx, ds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarReader{
+		ctx:     ctx,
+		ds:      ds,
+		entries: order,
+		trailer: trailer.Data,
+	}, nil
+}
+
+// walkEntries appends the header nodes under nd to entries, in link order.
+func walkEntries(ctx context.Context, nd *dag.Node, ds dag.DAGService, entries *[]*dag.Node) error {
+	for _, lnk := range nd.Links {
+		if !strings.HasPrefix(lnk.Name, "_") {
+			continue
+		}
+		child, err := lnk.GetNode(ctx, ds)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(child.Data, marker) {
+			*entries = append(*entries, child)
+		}
+		if err := walkEntries(ctx, child, ds, entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tarReader reads the entries of an archive one after the other, fetching
+// the body of each one as it gets to it.
+type tarReader struct {
+	ctx     context.Context
+	ds      dag.DAGService
+	entries []*dag.Node
+	trailer []byte
+
+	cur io.Reader
+}
+
+func (tr *tarReader) Read(b []byte) (int, error) {
+	for {
+		if tr.cur != nil {
+			n, err := tr.cur.Read(b)
+			if err != io.EOF {
+				return n, err
+			}
+			tr.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+		}
+
+		if len(tr.entries) == 0 {
+			if tr.trailer == nil {
+				return 0, io.EOF
+			}
+			tr.cur = bytes.NewReader(tr.trailer)
+			tr.trailer = nil
+			continue
+		}
+
+		r, err := tr.entryReader(tr.entries[0])
+		if err != nil {
+			return 0, err
+		}
+		tr.entries = tr.entries[1:]
+		tr.cur = r
+	}
+}
+
+// entryReader returns a reader of the header blocks, body and padding of
+// the entry of header.
+func (tr *tarReader) entryReader(header *dag.Node) (io.Reader, error) {
+	readers := []io.Reader{bytes.NewReader(header.Data)}
+
+	var size int64
+	if lnk, err := header.GetNodeLink("data"); err == nil {
+		body, err := lnk.GetNode(tr.ctx, tr.ds)
+		if err != nil {
+			return nil, err
+		}
+		dr, err := uio.NewDagReader(tr.ctx, body, tr.ds)
+		if err != nil {
+			return nil, err
+		}
+		size = dr.Size()
+		readers = append(readers, dr)
+	}
+
+	if lnk, err := header.GetNodeLink("padding"); err == nil {
+		pad, err := lnk.GetNode(tr.ctx, tr.ds)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, bytes.NewReader(pad.Data))
+	} else {
+		readers = append(readers, bytes.NewReader(make([]byte, padding(size))))
+	}
+	return io.MultiReader(readers...), nil
+}
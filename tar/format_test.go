@@ -0,0 +1,255 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	importer "github.com/ipfs/go-ipfs/importer"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	path "github.com/ipfs/go-ipfs/path"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+type testEntry struct {
+	hdr  tar.Header
+	body []byte
+}
+
+func buildArchive(t *testing.T, entries []testEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.body))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testEntries() []testEntry {
+	large := make([]byte, 700*1024)
+	u.NewTimeSeededRand().Read(large)
+	long := strings.Repeat("long/", 60) + "name"
+
+	return []testEntry{
+		{hdr: tar.Header{Name: "dir/", Mode: 0755, Typeflag: tar.TypeDir}},
+		{hdr: tar.Header{Name: "dir/b", Mode: 0644, Typeflag: tar.TypeReg}, body: []byte("second")},
+		{hdr: tar.Header{Name: "dir/a", Mode: 0644, Typeflag: tar.TypeReg}, body: large},
+		{hdr: tar.Header{Name: "empty", Mode: 0644, Typeflag: tar.TypeReg}},
+		{hdr: tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "dir/a"}},
+		{hdr: tar.Header{Name: long, Mode: 0644, Typeflag: tar.TypeReg}, body: []byte("deep")},
+		{hdr: tar.Header{Name: "dir/b", Mode: 0600, Typeflag: tar.TypeReg}, body: []byte("again")},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ds := mdtest.Mock(t)
+	archive := buildArchive(t, testEntries())
+	// some writers pad archives to a whole record
+	archive = append(archive, make([]byte, 3*blockSize)...)
+
+	root, err := ImportTar(bytes.NewReader(archive), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := ExportTar(context.Background(), root, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, archive) {
+		t.Fatal("exported archive differs from the imported one")
+	}
+}
+
+func TestBrowseEntries(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+	entries := testEntries()
+	root, err := ImportTar(bytes.NewReader(buildArchive(t, entries)), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootk, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &path.Resolver{DAG: ds}
+	readBody := func(p string) []byte {
+		nd, err := resolver.ResolvePath(ctx, path.Path(rootk.B58String()+"/"+p))
+		if err != nil {
+			t.Fatalf("resolving %s: %s", p, err)
+		}
+		dr, err := uio.NewDagReader(ctx, nd, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(dr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	if !bytes.Equal(readBody("_dir/_a/data"), entries[2].body) {
+		t.Fatal("wrong body for dir/a")
+	}
+	// the first entry at a path is the one found by name
+	if string(readBody("_dir/_b/data")) != "second" {
+		t.Fatal("wrong body for dir/b")
+	}
+	if string(readBody(strings.Repeat("_long/", 60)+"_name/data")) != "deep" {
+		t.Fatal("wrong body for an entry with a long name")
+	}
+
+	// bodies are stored as ipfs add stores files
+	lnk, err := findLink(ctx, ds, root, "_dir", "_a", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := importer.BuildDagFromReader(bytes.NewReader(entries[2].body), mdtest.Mock(t), nil, chunk.DefaultSplitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaink, err := plain.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lnk.Hash, []byte(plaink)) {
+		t.Fatal("the body of an entry differs from the file added alone")
+	}
+}
+
+func findLink(ctx context.Context, ds dag.DAGService, nd *dag.Node, names ...string) (*dag.Link, error) {
+	for {
+		lnk, err := nd.GetNodeLink(names[0])
+		if err != nil || len(names) == 1 {
+			return lnk, err
+		}
+		if nd, err = lnk.GetNode(ctx, ds); err != nil {
+			return nil, err
+		}
+		names = names[1:]
+	}
+}
+
+func TestNotTar(t *testing.T) {
+	ds := mdtest.Mock(t)
+	if _, err := ExportTar(context.Background(), &dag.Node{Data: []byte("other")}, ds); err != ErrNotTar {
+		t.Fatalf("expected ErrNotTar, got %v", err)
+	}
+	if _, err := ImportTar(bytes.NewReader([]byte("not an archive")), ds); err == nil {
+		t.Fatal("imported a truncated archive")
+	}
+}
+
+// rawHeader builds a ustar header block.
+func rawHeader(name string, flag byte, size int64, linkname string) []byte {
+	b := make([]byte, blockSize)
+	copy(b, name)
+	copy(b[124:], fmt.Sprintf("%011o", size))
+	copy(b[136:], fmt.Sprintf("%011o", 0))
+	b[156] = flag
+	copy(b[157:], linkname)
+	copy(b[257:], "ustar\x0000")
+	return b
+}
+
+// paxHeader builds a pax extended header of the given records.
+func paxHeader(flag byte, recs ...string) []byte {
+	var body []byte
+	for _, rec := range recs {
+		// the length of a record counts its own digits
+		n := len(rec) + 3
+		for len(fmt.Sprintf("%d %s\n", n, rec)) != n {
+			n++
+		}
+		body = append(body, fmt.Sprintf("%d %s\n", n, rec)...)
+	}
+	b := append(rawHeader("PaxHeader", flag, int64(len(body)), ""), body...)
+	return append(b, make([]byte, padding(int64(len(body))))...)
+}
+
+func TestPaxRecords(t *testing.T) {
+	long := strings.Repeat("long/", 30) + "name"
+	var archive []byte
+	archive = append(archive, paxHeader('g', "mtime=100")...)
+	// the size field of the entry is zero, its pax record is right
+	archive = append(archive, paxHeader('x', "path="+long, "size=5")...)
+	archive = append(archive, rawHeader("short", '0', 0, "")...)
+	archive = append(archive, "hello"...)
+	archive = append(archive, make([]byte, padding(5))...)
+	archive = append(archive, paxHeader('x', "linkpath="+long, "mtime=1234.5")...)
+	archive = append(archive, rawHeader("link", '2', 0, "short")...)
+	archive = append(archive, make([]byte, 2*blockSize)...)
+
+	hr := &headerReader{r: bytes.NewReader(archive)}
+	hdr, _, err := hr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.name != long || hdr.size != 5 || !hdr.mtime.Equal(time.Unix(100, 0)) {
+		t.Fatalf("pax records not applied: %q, size %d, mtime %s", hdr.name, hdr.size, hdr.mtime)
+	}
+	io.CopyN(ioutil.Discard, hr.r, blockSize)
+	hdr, _, err = hr.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.name != "link" || hdr.linkname != long || !hdr.mtime.Equal(time.Unix(1234, 5e8)) {
+		t.Fatalf("pax records not applied: %q -> %q, mtime %s", hdr.name, hdr.linkname, hdr.mtime)
+	}
+
+	ds := mdtest.Mock(t)
+	root, err := ImportTar(bytes.NewReader(archive), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnk, err := findLink(context.Background(), ds, root, append(strings.Split("_"+strings.Replace(long, "/", "/_", -1), "/"), "data")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := lnk.GetNode(context.Background(), ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := uio.NewDagReader(context.Background(), body, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(dr); string(b) != "hello" {
+		t.Fatalf("wrong body %q for an entry sized by pax", b)
+	}
+
+	r, err := ExportTar(context.Background(), root, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadAll(r); !bytes.Equal(out, archive) {
+		t.Fatal("exported archive differs from the imported one")
+	}
+}
@@ -226,6 +226,35 @@ test_expect_success "ipfs add -w output looks good" '
 	test_cmp expected actual
 '
 
+test_expect_success "ipfs add --inline succeeds" '
+	mkdir inlinedir &&
+	echo "tiny" >inlinedir/small &&
+	ipfs add -r -q --inline inlinedir >inline_out &&
+	SMALL=$(head -n 1 inline_out) &&
+	INLINEDIR=$(tail -n 1 inline_out)
+'
+
+test_expect_success "inlined files are not stored in blocks of their own" '
+	ipfs refs local >local_refs &&
+	test_must_fail grep "$SMALL" local_refs &&
+	ipfs cat "$INLINEDIR/small" >actual &&
+	test_cmp inlinedir/small actual
+'
+
+test_expect_success "ipfs object data reads raw leaves" '
+	printf "0123456789abcdefghij" >rawfile &&
+	RAWHASH=$(ipfs add -q --raw-leaves --chunker=size-10 rawfile) &&
+	LEAF=$(ipfs refs "$RAWHASH" | head -n 1) &&
+	ipfs object data "$LEAF" >actual &&
+	printf "0123456789" >expected &&
+	test_cmp expected actual
+'
+
+test_expect_success "ipfs object get reads raw leaves" '
+	ipfs object get --encoding=protobuf "$LEAF" >actual &&
+	test_cmp expected actual
+'
+
 test_kill_ipfs_daemon
 
 test_done
@@ -149,4 +149,15 @@ test_expect_success "'ipfs pin ls --type=all --quiet' is correct" '
 
 test_kill_ipfs_daemon
 
+test_expect_success "'ipfs repo migrate' leaves a current repo alone" '
+	ipfs repo migrate >migrate_out &&
+	grep "repo is already at version" migrate_out
+'
+
+test_expect_success "'ipfs repo migrate --to' fails without a migration" '
+	test_must_fail ipfs repo migrate --to=1000 &&
+	ipfs pin ls --type=recursive >actual_after &&
+	test_sort_cmp rp_actual actual_after
+'
+
 test_done
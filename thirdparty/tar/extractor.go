@@ -2,14 +2,27 @@ package tar
 
 import (
 	"archive/tar"
+	"fmt"
 	"io"
 	"os"
 	fp "path/filepath"
 	"strings"
+	"time"
 )
 
+// Extractor writes the entries of a tar archive to the filesystem. The
+// permissions in the archive are applied subject to the umask, and the
+// modification times are restored.
 type Extractor struct {
 	Path string
+
+	// directories whose times are set once their contents are written
+	dirTimes []dirTime
+}
+
+type dirTime struct {
+	path    string
+	modTime time.Time
 }
 
 func (te *Extractor) Extract(reader io.Reader) error {
@@ -38,19 +51,26 @@ func (te *Extractor) Extract(reader io.Reader) error {
 			break
 		}
 
-		if header.Typeflag == tar.TypeDir {
+		switch header.Typeflag {
+		case tar.TypeDir:
 			err = te.extractDir(header, i, exists)
-			if err != nil {
-				return err
-			}
-			continue
+		case tar.TypeSymlink:
+			err = te.extractSymlink(header, i, exists, pathIsDir)
+		default:
+			err = te.extractFile(header, tarReader, i, exists, pathIsDir)
 		}
-
-		err = te.extractFile(header, tarReader, i, exists, pathIsDir)
 		if err != nil {
 			return err
 		}
 	}
+
+	// writing into a directory changes its time, so directory times are
+	// only set once everything is extracted
+	for _, dt := range te.dirTimes {
+		if err := os.Chtimes(dt.path, dt.modTime, dt.modTime); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -64,45 +84,100 @@ func (te *Extractor) extractDir(h *tar.Header, depth int, exists bool) error {
 	if depth == 0 {
 		// if this is the root root directory, use it as the output path for remaining files
 		te.Path = path
+	} else if err := te.checkPath(path); err != nil {
+		return err
 	}
 
-	err := os.MkdirAll(path, 0755)
+	// the owner must be able to write the contents, whatever the mode
+	err := os.MkdirAll(path, os.FileMode(h.Mode).Perm()|0700)
 	if err != nil {
 		return err
 	}
 
+	te.dirTimes = append(te.dirTimes, dirTime{path, h.ModTime})
 	return nil
 }
 
-func (te *Extractor) extractFile(h *tar.Header, r *tar.Reader, depth int, exists bool, pathIsDir bool) error {
-	var path string
+// outputPath returns where the non-directory entry h is extracted to.
+func (te *Extractor) outputPath(h *tar.Header, depth int, exists bool, pathIsDir bool) (string, error) {
 	if depth == 0 {
 		// if depth is 0, this is the only file (we aren't 'ipfs get'ing a directory)
 		switch {
 		case exists && !pathIsDir:
-			return os.ErrExist
+			return "", os.ErrExist
 		case exists && pathIsDir:
-			path = fp.Join(te.Path, h.Name)
-		case !exists:
-			path = te.Path
+			return fp.Join(te.Path, h.Name), nil
+		default:
+			return te.Path, nil
+		}
+	}
+
+	// we are outputting a directory, this file is inside of it
+	pathElements := strings.Split(h.Name, "/")[1:]
+	path := fp.Join(te.Path, fp.Join(pathElements...))
+	return path, te.checkPath(path)
+}
+
+// checkPath refuses to write to path outside of te.Path, or through a
+// symlink extracted earlier, which could point anywhere: every component
+// of path below te.Path must be a real directory, or not exist yet.
+func (te *Extractor) checkPath(path string) error {
+	rel, err := fp.Rel(te.Path, path)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(fp.Separator)) {
+		return fmt.Errorf("refusing to extract %s outside of %s", path, te.Path)
+	}
+
+	cur := te.Path
+	for _, elem := range strings.Split(rel, string(fp.Separator)) {
+		cur = fp.Join(cur, elem)
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			// nothing below can be a symlink
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through a symlink", path)
 		}
-	} else {
-		// we are outputting a directory, this file is inside of it
-		pathElements := strings.Split(h.Name, "/")[1:]
-		path = fp.Join(pathElements...)
-		path = fp.Join(te.Path, path)
+	}
+	return nil
+}
+
+func (te *Extractor) extractFile(h *tar.Header, r *tar.Reader, depth int, exists bool, pathIsDir bool) error {
+	path, err := te.outputPath(h, depth, exists, pathIsDir)
+	if err != nil {
+		return err
 	}
 
-	file, err := os.Create(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(h.Mode).Perm())
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
 	_, err = io.Copy(file, r)
 	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
 		return err
 	}
 
-	return nil
+	return os.Chtimes(path, h.ModTime, h.ModTime)
+}
+
+func (te *Extractor) extractSymlink(h *tar.Header, depth int, exists bool, pathIsDir bool) error {
+	path, err := te.outputPath(h, depth, exists, pathIsDir)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(h.Linkname, path)
 }
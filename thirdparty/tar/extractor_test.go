@@ -0,0 +1,121 @@
+package tar
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	utar "github.com/ipfs/go-ipfs/unixfs/tar"
+)
+
+func withAttrs(t *testing.T, data []byte, attrs ft.Attrs) []byte {
+	b, err := ft.SetAttrs(data, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestExtractAttrsAndLinks(t *testing.T) {
+	ds := mdtest.Mock(t)
+	mtime := time.Unix(1234567890, 0)
+
+	script := &dag.Node{Data: withAttrs(t, ft.FilePBData([]byte("#!/bin/sh\n"), 10), ft.Attrs{Mode: 0750, ModTime: mtime})}
+	link := &dag.Node{Data: ft.SymlinkData("script")}
+	root := &dag.Node{Data: withAttrs(t, ft.FolderPBData(), ft.Attrs{Mode: 0700, ModTime: mtime})}
+	if err := root.AddNodeLink("script", script); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.AddNodeLink("link", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddRecursive(root); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := root.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := utar.NewReader(path.Path("/ipfs/"+k.B58String()), ds, root, gzip.NoCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	if err := (&Extractor{Path: out}).Extract(r); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(filepath.Join(out, "script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm()&0100 == 0 || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("script was extracted with mode %s and time %s", fi.Mode(), fi.ModTime())
+	}
+	fi, err = os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0700 || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("directory was extracted with mode %s and time %s", fi.Mode(), fi.ModTime())
+	}
+	target, err := os.Readlink(filepath.Join(out, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "script" {
+		t.Fatalf("link points to %q", target)
+	}
+}
+
+func TestExtractThroughNestedSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside := filepath.Join(dir, "outside")
+	if err := os.MkdirAll(filepath.Join(outside, "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// root/a points outside, and root/a/b/c is written below it
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	entries := []*tar.Header{
+		{Name: "root", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "root/a", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "root/a/b/c", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	for _, h := range entries {
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&Extractor{Path: filepath.Join(dir, "out")}).Extract(&buf); err == nil {
+		t.Fatal("extracted through a symlink")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "b", "c")); !os.IsNotExist(err) {
+		t.Fatal("file written outside of the output directory")
+	}
+}
@@ -4,6 +4,8 @@ package unixfs
 
 import (
 	"errors"
+	"os"
+	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 	pb "github.com/ipfs/go-ipfs/unixfs/pb"
@@ -14,6 +16,8 @@ const (
 	TFile      = pb.Data_File
 	TDirectory = pb.Data_Directory
 	TMetadata  = pb.Data_Metadata
+	TSymlink   = pb.Data_Symlink
+	THAMTShard = pb.Data_HAMTShard
 )
 
 var ErrMalformedFileFormat = errors.New("malformed data in file format")
@@ -62,6 +66,58 @@ func FolderPBData() []byte {
 	return data
 }
 
+// SymlinkData returns the bytes of a symlink pointing to target.
+func SymlinkData(target string) []byte {
+	pbdata := new(pb.Data)
+	typ := pb.Data_Symlink
+	pbdata.Type = &typ
+	pbdata.Data = []byte(target)
+
+	out, err := proto.Marshal(pbdata)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Attrs are the POSIX attributes a unixfs node may record. A zero Mode or
+// ModTime means the attribute is not recorded.
+type Attrs struct {
+	// Mode holds the permission bits.
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// AttrsOf returns the attributes recorded in pbdata.
+func AttrsOf(pbdata *pb.Data) Attrs {
+	var a Attrs
+	if pbdata.Mode != nil {
+		a.Mode = os.FileMode(pbdata.GetMode()) & os.ModePerm
+	}
+	if pbdata.Mtime != nil {
+		a.ModTime = time.Unix(pbdata.GetMtime(), 0)
+	}
+	return a
+}
+
+// SetAttrs returns data, the bytes of a unixfs node, with attrs recorded in
+// it. Attributes that are not set in attrs are removed.
+func SetAttrs(data []byte, attrs Attrs) ([]byte, error) {
+	pbdata := new(pb.Data)
+	if err := proto.Unmarshal(data, pbdata); err != nil {
+		return nil, err
+	}
+	pbdata.Mode = nil
+	pbdata.Mtime = nil
+	if attrs.Mode != 0 {
+		pbdata.Mode = proto.Uint32(uint32(attrs.Mode & os.ModePerm))
+	}
+	if !attrs.ModTime.IsZero() {
+		pbdata.Mtime = proto.Int64(attrs.ModTime.Unix())
+	}
+	return proto.Marshal(pbdata)
+}
+
 func WrapData(b []byte) []byte {
 	pbdata := new(pb.Data)
 	typ := pb.Data_Raw
@@ -94,11 +150,11 @@ func DataSize(data []byte) (uint64, error) {
 	}
 
 	switch pbdata.GetType() {
-	case pb.Data_Directory:
+	case pb.Data_Directory, pb.Data_HAMTShard:
 		return 0, errors.New("Cant get data size of directory!")
 	case pb.Data_File:
 		return pbdata.GetFilesize(), nil
-	case pb.Data_Raw:
+	case pb.Data_Raw, pb.Data_Symlink:
 		return uint64(len(pbdata.GetData())), nil
 	default:
 		return 0, errors.New("Unrecognized node data type!")
@@ -116,6 +172,10 @@ type FSNode struct {
 
 	// node type of this node
 	Type pb.Data_DataType
+
+	// attributes, kept as they were read
+	mode  *uint32
+	mtime *int64
 }
 
 func FSNodeFromBytes(b []byte) (*FSNode, error) {
@@ -130,6 +190,8 @@ func FSNodeFromBytes(b []byte) (*FSNode, error) {
 	n.blocksizes = pbn.Blocksizes
 	n.subtotal = pbn.GetFilesize() - uint64(len(n.Data))
 	n.Type = pbn.GetType()
+	n.mode = pbn.Mode
+	n.mtime = pbn.Mtime
 	return n, nil
 }
 
@@ -150,6 +212,8 @@ func (n *FSNode) GetBytes() ([]byte, error) {
 	pbn.Filesize = proto.Uint64(uint64(len(n.Data)) + n.subtotal)
 	pbn.Blocksizes = n.blocksizes
 	pbn.Data = n.Data
+	pbn.Mode = n.mode
+	pbn.Mtime = n.mtime
 	return proto.Marshal(pbn)
 }
 
@@ -1,7 +1,9 @@
 package unixfs
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
 
@@ -37,3 +39,52 @@ func TestFSNode(t *testing.T) {
 		t.Fatal("Datasize calculations incorrect!")
 	}
 }
+
+func TestAttrs(t *testing.T) {
+	attrs := Attrs{Mode: 0750, ModTime: time.Unix(1234567890, 0)}
+	b, err := SetAttrs(FolderPBData(), attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbn, err := FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbn.GetType() != TDirectory {
+		t.Fatal("setting attributes changed the node type")
+	}
+	if got := AttrsOf(pbn); got.Mode != attrs.Mode || !got.ModTime.Equal(attrs.ModTime) {
+		t.Fatalf("expected %v, got %v", attrs, got)
+	}
+
+	// FSNode keeps the attributes of the nodes it edits
+	b, err = SetAttrs(FilePBData([]byte("data"), 4), attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsn, err := FSNodeFromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsn.Data = []byte("more data")
+	b, err = fsn.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbn, err = FromBytes(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := AttrsOf(pbn); got.Mode != attrs.Mode || !got.ModTime.Equal(attrs.ModTime) {
+		t.Fatalf("FSNode lost the attributes, got %v", got)
+	}
+
+	// clearing them leaves the data as it was without any
+	b, err = SetAttrs(FolderPBData(), Attrs{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, FolderPBData()) {
+		t.Fatal("empty attributes should not be recorded")
+	}
+}
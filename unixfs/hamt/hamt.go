@@ -0,0 +1,430 @@
+// Package hamt implements a hash array mapped trie of named links, which
+// unixfs uses for directories too large to fit in a single node.
+//
+// Every shard of the trie has a fixed number of slots. A name is hashed,
+// and successive groups of bits of the hash pick a slot in each shard on
+// the way down. A slot either holds a single entry or, when several names
+// share it, a shard one level deeper.
+//
+// In the merkledag, a shard is a node whose links are named with the slot
+// number in hexadecimal: the link to a sub shard is named with the slot
+// alone, and the link to an entry with the slot followed by the name of
+// the entry. A bitfield in the node data marks the slots in use.
+package hamt
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+
+	proto "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/gogo/protobuf/proto"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	upb "github.com/ipfs/go-ipfs/unixfs/pb"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+const (
+	// DefaultShardWidth is the number of slots of the shards of new tries.
+	DefaultShardWidth = 256
+
+	// HashFNV1a64 identifies the 64 bit FNV-1a hash, which places names in
+	// the trie.
+	HashFNV1a64 = 1
+
+	hashBits = 64
+)
+
+var ErrHashCollision = errors.New("hamt: two names have the same hash")
+
+// ErrEmptyName is returned when setting an entry without a name: in a
+// shard node, a link named after its slot alone points to a sub shard.
+var ErrEmptyName = errors.New("hamt: entries must have a name")
+
+// Shard is a shard of a trie, along with the sub shards loaded from it.
+type Shard struct {
+	dserv dag.DAGService
+
+	width  int
+	bits   uint // bits of the hash consumed by each level
+	padLen int  // hex digits of a slot number
+
+	children []*child // used slots, in slot order
+
+	// the multihash function of the shard nodes, the default if zero
+	hashFn int
+}
+
+// child is a used slot. It holds either an entry, or a sub shard that is
+// only loaded when it is needed.
+type child struct {
+	slot int
+
+	name string
+	link *dag.Link
+
+	shard     *Shard
+	shardLink *dag.Link
+}
+
+func (c *child) isShard() bool {
+	return c.shard != nil || c.shardLink != nil
+}
+
+// NewShard returns an empty shard with width slots. width must be a power
+// of two, and a multiple of 8.
+func NewShard(dserv dag.DAGService, width int) (*Shard, error) {
+	if width < 8 || width&(width-1) != 0 {
+		return nil, fmt.Errorf("hamt: shard width must be a power of two of at least 8, not %d", width)
+	}
+	s := &Shard{
+		dserv:  dserv,
+		width:  width,
+		padLen: len(fmt.Sprintf("%X", width-1)),
+	}
+	for w := width; w > 1; w >>= 1 {
+		s.bits++
+	}
+	return s, nil
+}
+
+// IsShard returns whether nd is a shard of a trie. Its links are named
+// after the slots of the trie, not the entries.
+func IsShard(nd *dag.Node) bool {
+	pbd, err := ft.FromBytes(nd.Data)
+	return err == nil && pbd.GetType() == upb.Data_HAMTShard
+}
+
+// NewHamtFromDag loads the shard stored in nd. Its sub shards are loaded
+// as they are needed.
+func NewHamtFromDag(dserv dag.DAGService, nd *dag.Node) (*Shard, error) {
+	pbd, err := ft.FromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+	if pbd.GetType() != upb.Data_HAMTShard {
+		return nil, errors.New("hamt: node is not a shard")
+	}
+	if pbd.GetHashType() != HashFNV1a64 {
+		return nil, fmt.Errorf("hamt: unsupported hash type %d", pbd.GetHashType())
+	}
+
+	s, err := NewShard(dserv, int(pbd.GetFanout()))
+	if err != nil {
+		return nil, err
+	}
+	s.hashFn = nd.HashFunc()
+	bitfield := pbd.GetData()
+	if len(bitfield) != s.width/8 {
+		return nil, errors.New("hamt: bitfield does not match the shard width")
+	}
+	links := nd.Links
+	for slot := 0; slot < s.width; slot++ {
+		if !bitSet(bitfield, slot) {
+			continue
+		}
+		if len(links) == 0 {
+			return nil, errors.New("hamt: fewer links than used slots")
+		}
+		lnk := links[0]
+		links = links[1:]
+
+		prefix := s.prefix(slot)
+		if len(lnk.Name) < len(prefix) || lnk.Name[:len(prefix)] != prefix {
+			return nil, fmt.Errorf("hamt: link %q is not in slot %s", lnk.Name, prefix)
+		}
+		c := &child{slot: slot}
+		if len(lnk.Name) == len(prefix) {
+			c.shardLink = lnk
+		} else {
+			c.name = lnk.Name[len(prefix):]
+			c.link = lnk
+		}
+		s.children = append(s.children, c)
+	}
+	if len(links) != 0 {
+		return nil, errors.New("hamt: more links than used slots")
+	}
+	return s, nil
+}
+
+// bitSet reports whether bit i of the big endian bitfield b is set.
+func bitSet(b []byte, i int) bool {
+	return b[len(b)-1-i/8]&(1<<uint(i%8)) != 0
+}
+
+func hashName(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+// slot returns the slot of the hash h in a shard at the given depth.
+func (s *Shard) slot(h uint64, depth int) (int, error) {
+	consumed := uint(depth+1) * s.bits
+	if consumed > hashBits {
+		return 0, ErrHashCollision
+	}
+	return int(h>>(hashBits-consumed)) & (s.width - 1), nil
+}
+
+func (s *Shard) prefix(slot int) string {
+	return fmt.Sprintf("%0*X", s.padLen, slot)
+}
+
+// find returns the position of slot in children, and the child in it if
+// the slot is used.
+func (s *Shard) find(slot int) (int, *child) {
+	for i, c := range s.children {
+		if c.slot == slot {
+			return i, c
+		}
+		if c.slot > slot {
+			return i, nil
+		}
+	}
+	return len(s.children), nil
+}
+
+func (s *Shard) loadShard(ctx context.Context, c *child) (*Shard, error) {
+	if c.shard != nil {
+		return c.shard, nil
+	}
+	nd, err := c.shardLink.GetNode(ctx, s.dserv)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := NewHamtFromDag(s.dserv, nd)
+	if err != nil {
+		return nil, err
+	}
+	if sub.width != s.width {
+		return nil, errors.New("hamt: sub shard has a different width")
+	}
+	c.shard = sub
+	c.shardLink = nil
+	return sub, nil
+}
+
+// SetHashFunc sets the multihash function the nodes of the trie are hashed
+// with, one of u.HashFuncs. Sub shards loaded from the dag keep their own.
+func (s *Shard) SetHashFunc(code int) error {
+	if !u.HashFuncs[code] {
+		return fmt.Errorf("hamt: unsupported hash function %d", code)
+	}
+	s.hashFn = code
+	return nil
+}
+
+// Set adds nd to the trie under name, replacing any entry by that name.
+func (s *Shard) Set(ctx context.Context, name string, nd *dag.Node) error {
+	lnk, err := dag.MakeLink(nd)
+	if err != nil {
+		return err
+	}
+	return s.SetLink(ctx, name, lnk)
+}
+
+// SetLink adds the node lnk points to under name, replacing any entry by
+// that name.
+func (s *Shard) SetLink(ctx context.Context, name string, lnk *dag.Link) error {
+	if name == "" {
+		return ErrEmptyName
+	}
+	lnk = &dag.Link{Size: lnk.Size, Hash: lnk.Hash, Raw: lnk.Raw, Inline: lnk.Inline}
+	return s.set(ctx, hashName(name), 0, name, lnk)
+}
+
+func (s *Shard) set(ctx context.Context, h uint64, depth int, name string, lnk *dag.Link) error {
+	slot, err := s.slot(h, depth)
+	if err != nil {
+		return err
+	}
+
+	i, c := s.find(slot)
+	switch {
+	case c == nil:
+		c = &child{slot: slot, name: name, link: lnk}
+		s.children = append(s.children, nil)
+		copy(s.children[i+1:], s.children[i:])
+		s.children[i] = c
+		return nil
+	case c.isShard():
+		sub, err := s.loadShard(ctx, c)
+		if err != nil {
+			return err
+		}
+		return sub.set(ctx, h, depth+1, name, lnk)
+	case c.name == name:
+		c.link = lnk
+		return nil
+	}
+
+	// two names in one slot, move both down to a new shard
+	sub, err := NewShard(s.dserv, s.width)
+	if err != nil {
+		return err
+	}
+	sub.hashFn = s.hashFn
+	if err := sub.set(ctx, hashName(c.name), depth+1, c.name, c.link); err != nil {
+		return err
+	}
+	if err := sub.set(ctx, h, depth+1, name, lnk); err != nil {
+		return err
+	}
+	*c = child{slot: slot, shard: sub}
+	return nil
+}
+
+// Remove removes the entry by the given name, or returns os.ErrNotExist.
+func (s *Shard) Remove(ctx context.Context, name string) error {
+	return s.remove(ctx, hashName(name), 0, name)
+}
+
+func (s *Shard) remove(ctx context.Context, h uint64, depth int, name string) error {
+	slot, err := s.slot(h, depth)
+	if err != nil {
+		return os.ErrNotExist
+	}
+
+	i, c := s.find(slot)
+	switch {
+	case c == nil:
+		return os.ErrNotExist
+	case c.isShard():
+		sub, err := s.loadShard(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := sub.remove(ctx, h, depth+1, name); err != nil {
+			return err
+		}
+
+		// keep the trie as small as if the entry had never been there: a
+		// sub shard left with a single entry is replaced by that entry
+		switch {
+		case len(sub.children) == 0:
+			s.children = append(s.children[:i], s.children[i+1:]...)
+		case len(sub.children) == 1 && !sub.children[0].isShard():
+			last := sub.children[0]
+			*c = child{slot: slot, name: last.name, link: last.link}
+		}
+		return nil
+	case c.name != name:
+		return os.ErrNotExist
+	}
+	s.children = append(s.children[:i], s.children[i+1:]...)
+	return nil
+}
+
+// Find returns the link to the entry by the given name, or os.ErrNotExist.
+func (s *Shard) Find(ctx context.Context, name string) (*dag.Link, error) {
+	h := hashName(name)
+	for depth, cur := 0, s; ; depth++ {
+		slot, err := cur.slot(h, depth)
+		if err != nil {
+			return nil, os.ErrNotExist
+		}
+		_, c := cur.find(slot)
+		switch {
+		case c == nil:
+			return nil, os.ErrNotExist
+		case c.isShard():
+			if cur, err = cur.loadShard(ctx, c); err != nil {
+				return nil, err
+			}
+		case c.name == name:
+			return c.entryLink(), nil
+		default:
+			return nil, os.ErrNotExist
+		}
+	}
+}
+
+// entryLink returns a link to the entry of c, named with the entry name.
+func (c *child) entryLink() *dag.Link {
+	return &dag.Link{
+		Name:   c.name,
+		Size:   c.link.Size,
+		Hash:   c.link.Hash,
+		Raw:    c.link.Raw,
+		Inline: c.link.Inline,
+	}
+}
+
+// Links returns the links to every entry of the trie, named with the entry
+// names. They come in trie order, not sorted by name.
+func (s *Shard) Links(ctx context.Context) ([]*dag.Link, error) {
+	var links []*dag.Link
+	err := s.walk(ctx, func(c *child) {
+		links = append(links, c.entryLink())
+	})
+	return links, err
+}
+
+func (s *Shard) walk(ctx context.Context, f func(*child)) error {
+	for _, c := range s.children {
+		if !c.isShard() {
+			f(c)
+			continue
+		}
+		sub, err := s.loadShard(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := sub.walk(ctx, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Node returns the merkledag node of the shard. The sub shards that were
+// loaded are stored in the DAGService; the returned node is not.
+func (s *Shard) Node() (*dag.Node, error) {
+	nd := new(dag.Node)
+	if s.hashFn != 0 {
+		if err := nd.SetHashFunc(s.hashFn); err != nil {
+			return nil, err
+		}
+	}
+	bitfield := make([]byte, s.width/8)
+	for _, c := range s.children {
+		bitfield[len(bitfield)-1-c.slot/8] |= 1 << uint(c.slot%8)
+
+		prefix := s.prefix(c.slot)
+		switch {
+		case c.shard != nil:
+			cnd, err := c.shard.Node()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := s.dserv.Add(cnd); err != nil {
+				return nil, err
+			}
+			if err := nd.AddNodeLinkClean(prefix, cnd); err != nil {
+				return nil, err
+			}
+		case c.shardLink != nil:
+			nd.AddRawLink(prefix, c.shardLink)
+		default:
+			nd.AddRawLink(prefix+c.name, c.link)
+		}
+	}
+
+	typ := upb.Data_HAMTShard
+	data, err := proto.Marshal(&upb.Data{
+		Type:     &typ,
+		Data:     bitfield,
+		HashType: proto.Uint64(HashFNV1a64),
+		Fanout:   proto.Uint64(uint64(s.width)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	nd.Data = data
+	return nd, nil
+}
@@ -0,0 +1,194 @@
+package hamt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+)
+
+func entryNode(t *testing.T, ds dag.DAGService, name string) *dag.Node {
+	nd := &dag.Node{Data: ft.FilePBData([]byte(name), uint64(len(name)))}
+	if _, err := ds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+	return nd
+}
+
+func buildShard(t *testing.T, ds dag.DAGService, names []string) *Shard {
+	s, err := NewShard(ds, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := s.Set(context.Background(), name, entryNode(t, ds, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func makeNames(n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("entry%d", i))
+	}
+	return names
+}
+
+func shardKey(t *testing.T, s *Shard) string {
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.B58String()
+}
+
+func checkNames(t *testing.T, s *Shard, expected []string) {
+	links, err := s.Links(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, lnk := range links {
+		names = append(names, lnk.Name)
+	}
+	sort.Strings(names)
+	expected = append([]string(nil), expected...)
+	sort.Strings(expected)
+
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected entries %v, got %v", expected, names)
+	}
+}
+
+func TestSetFind(t *testing.T) {
+	ds := mdtest.Mock(t)
+	names := makeNames(200)
+	s := buildShard(t, ds, names)
+
+	for _, name := range names {
+		lnk, err := s.Find(context.Background(), name)
+		if err != nil {
+			t.Fatalf("finding %s: %s", name, err)
+		}
+		nd, err := lnk.GetNode(context.Background(), ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pbd, err := ft.FromBytes(nd.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(pbd.GetData()) != name {
+			t.Fatalf("%s points to the entry of %s", name, pbd.GetData())
+		}
+	}
+
+	if _, err := s.Find(context.Background(), "missing"); err != os.ErrNotExist {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+	checkNames(t, s, names)
+}
+
+func TestOrderIndependence(t *testing.T) {
+	ds := mdtest.Mock(t)
+	names := makeNames(100)
+	reversed := make([]string, len(names))
+	for i, name := range names {
+		reversed[len(names)-1-i] = name
+	}
+
+	a := buildShard(t, ds, names)
+	b := buildShard(t, ds, reversed)
+	if shardKey(t, a) != shardKey(t, b) {
+		t.Fatal("insertion order changed the shard")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ds := mdtest.Mock(t)
+	names := makeNames(100)
+	s := buildShard(t, ds, names)
+
+	kept := names[:10]
+	for _, name := range names[10:] {
+		if err := s.Remove(context.Background(), name); err != nil {
+			t.Fatalf("removing %s: %s", name, err)
+		}
+	}
+	if err := s.Remove(context.Background(), names[50]); err != os.ErrNotExist {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+	checkNames(t, s, kept)
+
+	// removing entries must leave the trie as if they had never been added
+	if shardKey(t, s) != shardKey(t, buildShard(t, ds, kept)) {
+		t.Fatal("removed entries left the trie different")
+	}
+}
+
+func TestLoadFromDag(t *testing.T) {
+	ds := mdtest.Mock(t)
+	names := makeNames(150)
+	s := buildShard(t, ds, names)
+
+	nd, err := s.Node()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.Add(nd); err != nil {
+		t.Fatal(err)
+	}
+	k, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := ds.Get(context.Background(), k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := NewHamtFromDag(ds, fetched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkNames(t, loaded, names)
+
+	if err := loaded.Set(context.Background(), "new", entryNode(t, ds, "new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set(context.Background(), "new", entryNode(t, ds, "new")); err != nil {
+		t.Fatal(err)
+	}
+	if shardKey(t, loaded) != shardKey(t, s) {
+		t.Fatal("loaded shard differs after the same change")
+	}
+}
+
+func TestEmptyName(t *testing.T) {
+	ds := mdtest.Mock(t)
+	s := buildShard(t, ds, makeNames(10))
+	if err := s.Set(context.Background(), "", entryNode(t, ds, "")); err != ErrEmptyName {
+		t.Fatalf("expected ErrEmptyName, got %v", err)
+	}
+	checkNames(t, s, makeNames(10))
+}
+
+func TestNotAShard(t *testing.T) {
+	ds := mdtest.Mock(t)
+	_, err := NewHamtFromDag(ds, &dag.Node{Data: ft.FolderPBData()})
+	if err == nil {
+		t.Fatal("loaded a plain directory as a shard")
+	}
+}
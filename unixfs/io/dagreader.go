@@ -17,6 +17,10 @@ import (
 
 var ErrIsDir = errors.New("this dag node is a directory")
 
+// DefaultPrefetchWindow is the number of child blocks a DagReader fetches
+// ahead of the one it reads from.
+var DefaultPrefetchWindow = 10
+
 // DagReader provides a way to easily read the data contained in a dag.
 type DagReader struct {
 	serv mdag.DAGService
@@ -31,12 +35,27 @@ type DagReader struct {
 	// will either be a bytes.Reader or a child DagReader
 	buf ReadSeekCloser
 
-	// NodeGetters for each of 'nodes' child links
+	// NodeGetters for each of 'nodes' child links, nil for the links that
+	// have not been requested, or have been read already
 	promises []mdag.NodeGetter
 
-	// the index of the child link currently being read from
+	// the index of the child link to read from next
 	linkPosition int
 
+	// the index of the child link loaded in buf, or -1 if buf does not
+	// hold a child
+	bufLink int
+
+	// the number of child links fetched ahead of linkPosition
+	window int
+
+	// the index of the first child link not requested yet
+	fetched int
+
+	// context of the requests in flight, cancelled when seeking away
+	fetchCtx    context.Context
+	fetchCancel func()
+
 	// current offset for the read head within the 'file'
 	offset int64
 
@@ -57,6 +76,16 @@ type ReadSeekCloser interface {
 // NewDagReader creates a new reader object that reads the data represented by the given
 // node, using the passed in DAGService for data retreival
 func NewDagReader(ctx context.Context, n *mdag.Node, serv mdag.DAGService) (*DagReader, error) {
+	if n.IsRaw() {
+		// a raw block holds nothing but file data
+		pb := &ftpb.Data{
+			Type:     ftpb.Data_Raw.Enum(),
+			Data:     n.Data,
+			Filesize: proto.Uint64(uint64(len(n.Data))),
+		}
+		return newDataFileReader(ctx, n, pb, serv), nil
+	}
+
 	pb := new(ftpb.Data)
 	err := proto.Unmarshal(n.Data, pb)
 	if err != nil {
@@ -64,7 +93,7 @@ func NewDagReader(ctx context.Context, n *mdag.Node, serv mdag.DAGService) (*Dag
 	}
 
 	switch pb.GetType() {
-	case ftpb.Data_Directory:
+	case ftpb.Data_Directory, ftpb.Data_HAMTShard:
 		// Dont allow reading directories
 		return nil, ErrIsDir
 	case ftpb.Data_Raw:
@@ -87,52 +116,115 @@ func NewDagReader(ctx context.Context, n *mdag.Node, serv mdag.DAGService) (*Dag
 
 func newDataFileReader(ctx context.Context, n *mdag.Node, pb *ftpb.Data, serv mdag.DAGService) *DagReader {
 	fctx, cancel := context.WithCancel(ctx)
-	promises := serv.GetDAG(fctx, n)
-	return &DagReader{
+	dr := &DagReader{
 		node:     n,
 		serv:     serv,
 		buf:      NewRSNCFromBytes(pb.GetData()),
-		promises: promises,
+		promises: make([]mdag.NodeGetter, len(n.Links)),
+		bufLink:  -1,
+		window:   DefaultPrefetchWindow,
 		ctx:      fctx,
 		cancel:   cancel,
 		pbdata:   pb,
 	}
+	dr.fetchCtx, dr.fetchCancel = context.WithCancel(fctx)
+	return dr
+}
+
+// SetPrefetchWindow sets the number of child blocks fetched ahead of the
+// one being read. Readers of the children of the node inherit it.
+func (dr *DagReader) SetPrefetchWindow(n int) {
+	if n < 1 {
+		n = 1
+	}
+	dr.window = n
+}
+
+// preload requests the child links up to a window past linkPosition. They
+// are requested in batches, whenever less than half a window is in flight.
+func (dr *DagReader) preload() {
+	links := dr.node.Links
+	if dr.fetched >= len(links) || dr.fetched-dr.linkPosition > dr.window/2 {
+		return
+	}
+
+	end := dr.linkPosition + dr.window
+	if end > len(links) {
+		end = len(links)
+	}
+	copy(dr.promises[dr.fetched:end], dr.serv.GetLinks(dr.fetchCtx, links[dr.fetched:end]))
+	dr.fetched = end
+}
+
+// resetFetches drops the requests in flight, so that fetching starts over
+// from child link i.
+func (dr *DagReader) resetFetches(i int) {
+	dr.fetchCancel()
+	dr.fetchCtx, dr.fetchCancel = context.WithCancel(dr.ctx)
+	for j := range dr.promises {
+		dr.promises[j] = nil
+	}
+	dr.fetched = i
 }
 
 // precalcNextBuf follows the next link in line and loads it from the DAGService,
-// setting the next buffer to read from
+// setting the next buffer to read from. On failure, buf is left empty and
+// the link stays next in line.
 func (dr *DagReader) precalcNextBuf(ctx context.Context) error {
 	dr.buf.Close() // Just to make sure
+	dr.buf = NewRSNCFromBytes(nil)
+	dr.bufLink = -1
 	if dr.linkPosition >= len(dr.promises) {
 		return io.EOF
 	}
 
+	if dr.promises[dr.linkPosition] == nil {
+		dr.resetFetches(dr.linkPosition)
+	}
+	dr.preload()
 	nxt, err := dr.promises[dr.linkPosition].Get(ctx)
 	if err != nil {
 		return err
 	}
+	buf, err := dr.childReader(nxt)
+	if err != nil {
+		return err
+	}
+
+	// the node is not needed past this read, unless seeking back to it
+	dr.promises[dr.linkPosition] = nil
+	dr.buf = buf
+	dr.bufLink = dr.linkPosition
 	dr.linkPosition++
+	return nil
+}
+
+// childReader returns a reader of the data of nxt, a child of the file.
+func (dr *DagReader) childReader(nxt *mdag.Node) (ReadSeekCloser, error) {
+	if nxt.IsRaw() {
+		return NewRSNCFromBytes(nxt.Data), nil
+	}
 
 	pb := new(ftpb.Data)
-	err = proto.Unmarshal(nxt.Data, pb)
+	err := proto.Unmarshal(nxt.Data, pb)
 	if err != nil {
-		return fmt.Errorf("incorrectly formatted protobuf: %s", err)
+		return nil, fmt.Errorf("incorrectly formatted protobuf: %s", err)
 	}
 
 	switch pb.GetType() {
-	case ftpb.Data_Directory:
+	case ftpb.Data_Directory, ftpb.Data_HAMTShard:
 		// A directory should not exist within a file
-		return ft.ErrInvalidDirLocation
+		return nil, ft.ErrInvalidDirLocation
 	case ftpb.Data_File:
-		dr.buf = newDataFileReader(dr.ctx, nxt, pb, dr.serv)
-		return nil
+		child := newDataFileReader(dr.ctx, nxt, pb, dr.serv)
+		child.window = dr.window
+		return child, nil
 	case ftpb.Data_Raw:
-		dr.buf = NewRSNCFromBytes(pb.GetData())
-		return nil
+		return NewRSNCFromBytes(pb.GetData()), nil
 	case ftpb.Data_Metadata:
-		return errors.New("Shouldnt have had metadata object inside file")
+		return nil, errors.New("Shouldnt have had metadata object inside file")
 	default:
-		return ft.ErrUnrecognizedType
+		return nil, ft.ErrUnrecognizedType
 	}
 }
 
@@ -206,9 +298,9 @@ func (dr *DagReader) Close() error {
 }
 
 // Seek implements io.Seeker, and will seek to a given offset in the file
-// interface matches standard unix seek
-// TODO: check if we can do relative seeks, to reduce the amount of dagreader
-// recreations that need to happen.
+// interface matches standard unix seek. It finds the child holding offset
+// from the sizes of the children, and only loads it if it is not the one
+// being read already.
 func (dr *DagReader) Seek(offset int64, whence int) (int64, error) {
 	switch whence {
 	case os.SEEK_SET:
@@ -225,6 +317,7 @@ func (dr *DagReader) Seek(offset int64, whence int) (int64, error) {
 			// Close current buf to close potential child dagreader
 			dr.buf.Close()
 			dr.buf = NewRSNCFromBytes(pb.GetData()[offset:])
+			dr.bufLink = -1
 
 			// start reading links from the beginning
 			dr.linkPosition = 0
@@ -236,19 +329,31 @@ func (dr *DagReader) Seek(offset int64, whence int) (int64, error) {
 		}
 
 		// iterate through links and find where we need to be
-		for i := 0; i < len(pb.Blocksizes); i++ {
+		i := 0
+		for ; i < len(pb.Blocksizes) && i < len(dr.promises); i++ {
 			if pb.Blocksizes[i] > uint64(left) {
-				dr.linkPosition = i
 				break
-			} else {
-				left -= int64(pb.Blocksizes[i])
 			}
+			left -= int64(pb.Blocksizes[i])
 		}
 
-		// start sub-block request
-		err := dr.precalcNextBuf(dr.ctx)
-		if err != nil {
-			return 0, err
+		if i == len(dr.promises) {
+			// at or past the end of the file, there is nothing left to read
+			dr.buf.Close()
+			dr.buf = NewRSNCFromBytes(nil)
+			dr.bufLink = -1
+			dr.linkPosition = i
+			dr.offset = offset
+			return offset, nil
+		}
+
+		// start sub-block request, unless it is the one being read
+		if i != dr.bufLink {
+			dr.linkPosition = i
+			err := dr.precalcNextBuf(dr.ctx)
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		// set proper offset within child readseeker
@@ -0,0 +1,153 @@
+package io
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	mh "github.com/ipfs/go-ipfs/Godeps/_workspace/src/github.com/jbenet/go-multihash"
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	"github.com/ipfs/go-ipfs/importer"
+	chunk "github.com/ipfs/go-ipfs/importer/chunk"
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	u "github.com/ipfs/go-ipfs/util"
+)
+
+// countingDAG records the number of nodes requested at once from GetLinks
+type countingDAG struct {
+	mdag.DAGService
+	batches []int
+}
+
+func (c *countingDAG) GetLinks(ctx context.Context, links []*mdag.Link) []mdag.NodeGetter {
+	c.batches = append(c.batches, len(links))
+	return c.DAGService.GetLinks(ctx, links)
+}
+
+func buildTestFile(t *testing.T, size int) ([]byte, *mdag.Node, mdag.DAGService) {
+	data := make([]byte, size)
+	u.NewTimeSeededRand().Read(data)
+
+	ds := mdtest.Mock(t)
+	nd, err := importer.BuildDagFromReader(bytes.NewReader(data), ds, nil, &chunk.SizeSplitter{Size: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, nd, ds
+}
+
+func TestPrefetchWindow(t *testing.T) {
+	data, nd, ds := buildTestFile(t, 512*100)
+	cds := &countingDAG{DAGService: ds}
+
+	dr, err := NewDagReader(context.Background(), nd, cds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr.SetPrefetchWindow(8)
+
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatal("read data differs")
+	}
+
+	total := 0
+	for _, n := range cds.batches {
+		if n > 8 {
+			t.Fatalf("requested %d nodes at once with a window of 8", n)
+		}
+		total += n
+	}
+	if total != len(nd.Links) {
+		t.Fatalf("requested %d nodes for %d links", total, len(nd.Links))
+	}
+	if len(cds.batches) >= len(nd.Links) {
+		t.Fatal("nodes were requested one at a time")
+	}
+}
+
+func TestSeekAndRead(t *testing.T) {
+	// large enough for a tree of more than one level
+	data, nd, ds := buildTestFile(t, 512*400)
+
+	for _, window := range []int{1, 3, DefaultPrefetchWindow} {
+		dr, err := NewDagReader(context.Background(), nd, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dr.SetPrefetchWindow(window)
+
+		for i := 0; i < 200; i++ {
+			off := rand.Int63n(int64(len(data)))
+			if i%10 == 0 {
+				// short jumps forward stay within the current child
+				off = dr.offset + rand.Int63n(100)
+				if off >= int64(len(data)) {
+					off = 0
+				}
+			}
+			n, err := dr.Seek(off, os.SEEK_SET)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != off {
+				t.Fatalf("seeked to %d instead of %d", n, off)
+			}
+
+			buf := make([]byte, 1000)
+			read, err := io.ReadFull(dr, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:read], data[off:off+int64(read)]) {
+				t.Fatalf("wrong data at offset %d with a window of %d", off, window)
+			}
+		}
+
+		// seeking to the end leaves nothing to read
+		if _, err := dr.Seek(int64(len(data)), os.SEEK_SET); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := dr.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Fatalf("read %d bytes past the end, err %v", n, err)
+		}
+		dr.Close()
+	}
+}
+
+func TestBadChild(t *testing.T) {
+	_, nd, ds := buildTestFile(t, 512*4)
+
+	// the second child of the file is a directory
+	dir, err := ds.Add(&mdag.Node{Data: ft.FolderPBData()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := nd.Copy()
+	bad.Links[1] = &mdag.Link{Hash: mh.Multihash(dir), Size: bad.Links[1].Size}
+
+	dr, err := NewDagReader(context.Background(), bad, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(dr, make([]byte, 600)); err != ft.ErrInvalidDirLocation {
+		t.Fatalf("expected ErrInvalidDirLocation, got %v", err)
+	}
+
+	// the failed child is not taken as loaded
+	if _, err := dr.Seek(600, os.SEEK_SET); err != ft.ErrInvalidDirLocation {
+		t.Fatalf("seeking into the bad child: expected ErrInvalidDirLocation, got %v", err)
+	}
+	if _, err := dr.Read(make([]byte, 10)); err != ft.ErrInvalidDirLocation {
+		t.Fatalf("reading the bad child again: expected ErrInvalidDirLocation, got %v", err)
+	}
+}
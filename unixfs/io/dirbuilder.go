@@ -1,18 +1,45 @@
 package io
 
 import (
-	"time"
+	"errors"
+	"os"
 
 	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
 
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	format "github.com/ipfs/go-ipfs/unixfs"
-	u "github.com/ipfs/go-ipfs/util"
+	hamt "github.com/ipfs/go-ipfs/unixfs/hamt"
 )
 
-type directoryBuilder struct {
-	dserv   mdag.DAGService
+// ShardThreshold is the estimated size, in bytes, of the links of a
+// directory node above which the directory is turned into a HAMT.
+var ShardThreshold = 256 * 1024
+
+// estimated bytes taken by a link besides its name and hash
+const linkOverhead = 12
+
+var ErrNotADir = errors.New("node is not a unixfs directory")
+
+// Directory is a unixfs directory. It is stored in a single node while it
+// is small, and as a HAMT of nodes once its links outgrow ShardThreshold,
+// so that no block gets too large to transfer.
+type Directory struct {
+	dserv mdag.DAGService
+
+	// a small directory
 	dirnode *mdag.Node
+	size    int
+
+	// a sharded directory, and the attributes of its root
+	shard *hamt.Shard
+	attrs format.Attrs
+
+	// children without links whose encoding is at most this many bytes
+	// are stored inline in the directory
+	inlineLimit int
+
+	// the multihash function of the directory nodes, the default if zero
+	hashFn int
 }
 
 // NewEmptyDirectory returns an empty merkledag Node with a folder Data chunk
@@ -20,34 +47,214 @@ func NewEmptyDirectory() *mdag.Node {
 	return &mdag.Node{Data: format.FolderPBData()}
 }
 
-// NewDirectory returns a directoryBuilder. It needs a DAGService to add the Children
-func NewDirectory(dserv mdag.DAGService) *directoryBuilder {
-	db := new(directoryBuilder)
-	db.dserv = dserv
-	db.dirnode = NewEmptyDirectory()
-	return db
+// NewDirectory returns an empty Directory. It needs a DAGService to store
+// the shards of the directory if it grows large.
+func NewDirectory(dserv mdag.DAGService) *Directory {
+	return &Directory{
+		dserv:   dserv,
+		dirnode: NewEmptyDirectory(),
+	}
+}
+
+// NewDirectoryFromNode returns the Directory stored in nd, which may be a
+// plain or a sharded directory. A plain directory is modified in place.
+func NewDirectoryFromNode(dserv mdag.DAGService, nd *mdag.Node) (*Directory, error) {
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch pbd.GetType() {
+	case format.TDirectory:
+		d := &Directory{dserv: dserv, dirnode: nd}
+		for _, lnk := range nd.Links {
+			d.size += linkSize(lnk.Name, lnk)
+		}
+		return d, nil
+	case format.THAMTShard:
+		shard, err := hamt.NewHamtFromDag(dserv, nd)
+		if err != nil {
+			return nil, err
+		}
+		return &Directory{dserv: dserv, shard: shard, attrs: format.AttrsOf(pbd)}, nil
+	default:
+		return nil, ErrNotADir
+	}
+}
+
+// IsDirectory reports whether nd is a plain or a sharded unixfs directory.
+func IsDirectory(nd *mdag.Node) bool {
+	pbd, err := format.FromBytes(nd.Data)
+	if err != nil {
+		return false
+	}
+	typ := pbd.GetType()
+	return typ == format.TDirectory || typ == format.THAMTShard
+}
+
+func linkSize(name string, lnk *mdag.Link) int {
+	return len(name) + len(lnk.Hash) + len(lnk.Inline) + linkOverhead
+}
+
+// SetInlineLimit makes the directory store children of at most limit
+// encoded bytes, and without links of their own, inline in the directory
+// node instead of in blocks of their own. A limit of zero disables it.
+func (d *Directory) SetInlineLimit(limit int) {
+	d.inlineLimit = limit
+}
+
+// SetHashFunc sets the multihash function the nodes of the directory are
+// hashed with, one of u.HashFuncs.
+func (d *Directory) SetHashFunc(code int) error {
+	if d.shard != nil {
+		if err := d.shard.SetHashFunc(code); err != nil {
+			return err
+		}
+	} else if err := d.dirnode.SetHashFunc(code); err != nil {
+		return err
+	}
+	d.hashFn = code
+	return nil
+}
+
+// Inlines returns whether a directory with the given inline limit stores
+// nd inline.
+func Inlines(nd *mdag.Node, limit int) (bool, error) {
+	if limit <= 0 || len(nd.Links) > 0 {
+		return false, nil
+	}
+	enc, err := nd.Encoded(false)
+	if err != nil {
+		return false, err
+	}
+	return len(enc) <= limit, nil
+}
+
+func (d *Directory) makeLink(nd *mdag.Node) (*mdag.Link, error) {
+	inline, err := Inlines(nd, d.inlineLimit)
+	if err != nil {
+		return nil, err
+	}
+	if inline {
+		return mdag.MakeInlineLink(nd)
+	}
+	return mdag.MakeLink(nd)
 }
 
-// AddChild adds a (name, key)-pair to the root node.
-func (d *directoryBuilder) AddChild(name string, k u.Key) error {
-	// TODO(cryptix): consolidate context managment
-	ctx, cancel := context.WithTimeout(context.TODO(), time.Minute)
-	defer cancel()
+// AddChild adds nd to the directory under name, replacing any child by
+// that name.
+func (d *Directory) AddChild(ctx context.Context, name string, nd *mdag.Node) error {
+	lnk, err := d.makeLink(nd)
+	if err != nil {
+		return err
+	}
+	if d.shard != nil {
+		return d.shard.SetLink(ctx, name, lnk)
+	}
+
+	if err := d.removeLink(name); err != nil && err != os.ErrNotExist {
+		return err
+	}
+	d.dirnode.AddRawLink(name, lnk)
+	d.size += linkSize(name, lnk)
 
-	cnode, err := d.dserv.Get(ctx, k)
+	if d.size > ShardThreshold {
+		return d.switchToSharding(ctx)
+	}
+	return nil
+}
+
+func (d *Directory) removeLink(name string) error {
+	lnk, err := d.dirnode.GetNodeLink(name)
+	if err == mdag.ErrNotFound {
+		return os.ErrNotExist
+	}
 	if err != nil {
 		return err
 	}
+	d.size -= linkSize(name, lnk)
+	return d.dirnode.RemoveNodeLink(name)
+}
 
-	err = d.dirnode.AddNodeLinkClean(name, cnode)
+func (d *Directory) switchToSharding(ctx context.Context) error {
+	shard, err := hamt.NewShard(d.dserv, hamt.DefaultShardWidth)
 	if err != nil {
 		return err
 	}
+	if d.hashFn != 0 {
+		if err := shard.SetHashFunc(d.hashFn); err != nil {
+			return err
+		}
+	}
+	for _, lnk := range d.dirnode.Links {
+		if err := shard.SetLink(ctx, lnk.Name, lnk); err != nil {
+			return err
+		}
+	}
 
+	pbd, err := format.FromBytes(d.dirnode.Data)
+	if err != nil {
+		return err
+	}
+	d.attrs = format.AttrsOf(pbd)
+	d.shard = shard
+	d.dirnode = nil
 	return nil
 }
 
-// GetNode returns the root of this directoryBuilder
-func (d *directoryBuilder) GetNode() *mdag.Node {
-	return d.dirnode
+// RemoveChild removes the child by the given name, or returns
+// os.ErrNotExist.
+func (d *Directory) RemoveChild(ctx context.Context, name string) error {
+	if d.shard != nil {
+		return d.shard.Remove(ctx, name)
+	}
+	return d.removeLink(name)
+}
+
+// Find returns the child by the given name, or os.ErrNotExist.
+func (d *Directory) Find(ctx context.Context, name string) (*mdag.Node, error) {
+	if d.shard != nil {
+		lnk, err := d.shard.Find(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return lnk.GetNode(ctx, d.dserv)
+	}
+
+	lnk, err := d.dirnode.GetNodeLink(name)
+	if err == mdag.ErrNotFound {
+		return nil, os.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lnk.GetNode(ctx, d.dserv)
+}
+
+// Links returns the links to the children of the directory, named after
+// them. The links of a sharded directory are not sorted.
+func (d *Directory) Links(ctx context.Context) ([]*mdag.Link, error) {
+	if d.shard != nil {
+		return d.shard.Links(ctx)
+	}
+	return append([]*mdag.Link(nil), d.dirnode.Links...), nil
+}
+
+// GetNode returns the root node of the directory. The shards below the
+// root of a sharded directory are stored in the DAGService; the root is
+// not.
+func (d *Directory) GetNode() (*mdag.Node, error) {
+	if d.shard == nil {
+		return d.dirnode, nil
+	}
+
+	nd, err := d.shard.Node()
+	if err != nil {
+		return nil, err
+	}
+	nd.Data, err = format.SetAttrs(nd.Data, d.attrs)
+	if err != nil {
+		return nil, err
+	}
+	return nd, nil
 }
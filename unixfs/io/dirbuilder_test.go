@@ -0,0 +1,181 @@
+package io
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-ipfs/Godeps/_workspace/src/golang.org/x/net/context"
+
+	mdag "github.com/ipfs/go-ipfs/merkledag"
+	mdtest "github.com/ipfs/go-ipfs/merkledag/test"
+	"github.com/ipfs/go-ipfs/path"
+	format "github.com/ipfs/go-ipfs/unixfs"
+)
+
+func TestDirectorySharding(t *testing.T) {
+	defer func(old int) { ShardThreshold = old }(ShardThreshold)
+	ShardThreshold = 1000
+
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+	dir := NewDirectory(ds)
+
+	var names []string
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("file%d", i)
+		child := &mdag.Node{Data: format.FilePBData([]byte(name), uint64(len(name)))}
+		if _, err := ds.Add(child); err != nil {
+			t.Fatal(err)
+		}
+		if err := dir.AddChild(ctx, name, child); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := dir.RemoveChild(ctx, "file0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.RemoveChild(ctx, "file0"); err != os.ErrNotExist {
+		t.Fatalf("expected os.ErrNotExist, got %v", err)
+	}
+	names = names[1:]
+
+	dir.attrs.ModTime = time.Unix(1e9, 0)
+	root, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbd, err := format.FromBytes(root.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbd.GetType() != format.THAMTShard {
+		t.Fatalf("directory past the threshold has type %s", pbd.GetType())
+	}
+	if !format.AttrsOf(pbd).ModTime.Equal(dir.attrs.ModTime) {
+		t.Fatal("sharded directory lost its attributes")
+	}
+	rootk, err := ds.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewDirectoryFromNode(ds, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := loaded.Links(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != len(names) {
+		t.Fatalf("expected %d entries, got %d", len(names), len(links))
+	}
+
+	// the entries resolve through paths, as in a plain directory
+	resolver := &path.Resolver{DAG: ds}
+	for _, name := range names {
+		nd, err := resolver.ResolvePath(ctx, path.Path(rootk.B58String()+"/"+name))
+		if err != nil {
+			t.Fatalf("resolving %s: %s", name, err)
+		}
+		pbd, err := format.FromBytes(nd.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(pbd.GetData()) != name {
+			t.Fatalf("%s resolved to the entry of %s", name, pbd.GetData())
+		}
+	}
+	_, err = resolver.ResolvePath(ctx, path.Path(rootk.B58String()+"/file0"))
+	if _, ok := err.(path.ErrNoLink); !ok {
+		t.Fatalf("expected ErrNoLink for a removed entry, got %v", err)
+	}
+}
+
+func TestSmallDirectoryUnchanged(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+	dir := NewDirectory(ds)
+
+	plain := NewEmptyDirectory()
+	for _, name := range []string{"b", "a", "c"} {
+		child := &mdag.Node{Data: format.FilePBData([]byte(name), 1)}
+		if err := dir.AddChild(ctx, name, child); err != nil {
+			t.Fatal(err)
+		}
+		if err := plain.AddNodeLinkClean(name, child); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nd, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1, err := nd.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	k2, err := plain.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 != k2 {
+		t.Fatal("a small directory is not stored as a plain directory node")
+	}
+}
+
+func TestInlineChildren(t *testing.T) {
+	ctx := context.Background()
+	ds := mdtest.Mock(t)
+	dir := NewDirectory(ds)
+	dir.SetInlineLimit(32)
+
+	// neither child is stored, only the small one can be read back
+	small := &mdag.Node{Data: format.FilePBData([]byte("tiny"), 4)}
+	large := &mdag.Node{Data: format.FilePBData(make([]byte, 100), 100)}
+	if err := dir.AddChild(ctx, "small", small); err != nil {
+		t.Fatal(err)
+	}
+	if err := dir.AddChild(ctx, "large", large); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := dir.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootk, err := ds.Add(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &path.Resolver{DAG: ds}
+	nd, err := resolver.ResolvePath(ctx, path.Path(rootk.B58String()+"/small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := NewDagReader(ctx, nd, ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "tiny" {
+		t.Fatalf("read %q from an inlined file", out)
+	}
+
+	lnk, err := root.GetNodeLink("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lnk.Inline != nil {
+		t.Fatal("a file over the limit was inlined")
+	}
+}
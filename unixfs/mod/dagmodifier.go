@@ -226,6 +226,25 @@ func (dm *DagModifier) Sync() error {
 // returns the new key of the passed in node and whether or not all the data in the reader
 // has been consumed.
 func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader) (u.Key, bool, error) {
+	// A raw leaf holds only file data, and stays raw
+	if node.IsRaw() {
+		b := append([]byte(nil), node.Data...)
+		n, err := data.Read(b[offset:])
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+
+		nd := mdag.NewRawNode(b)
+		if err := nd.SetHashFunc(node.HashFunc()); err != nil {
+			return "", false, err
+		}
+		k, err := dm.dagserv.Add(nd)
+		if err != nil {
+			return "", false, err
+		}
+		return k, n < len(b[offset:]), nil
+	}
+
 	f, err := ft.FromBytes(node.Data)
 	if err != nil {
 		return "", false, err
@@ -245,6 +264,9 @@ func (dm *DagModifier) modifyDag(node *mdag.Node, offset uint64, data io.Reader)
 		}
 
 		nd := &mdag.Node{Data: b}
+		if err := nd.SetHashFunc(node.HashFunc()); err != nil {
+			return "", false, err
+		}
 		k, err := dm.dagserv.Add(nd)
 		if err != nil {
 			return "", false, err
@@ -309,6 +331,7 @@ func (dm *DagModifier) appendData(node *mdag.Node, blks <-chan []byte) (*mdag.No
 		Dagserv:  dm.dagserv,
 		Maxlinks: help.DefaultLinksPerBlock,
 		Pinner:   dm.mp,
+		HashFunc: node.HashFunc(),
 	}
 
 	return trickle.TrickleAppend(node, dbp.New(blks))
@@ -442,6 +465,14 @@ func (dm *DagModifier) Truncate(size int64) error {
 
 // dagTruncate truncates the given node to 'size' and returns the modified Node
 func dagTruncate(nd *mdag.Node, size uint64, ds mdag.DAGService) (*mdag.Node, error) {
+	if nd.IsRaw() {
+		nnd := mdag.NewRawNode(nd.Data[:size])
+		if err := nnd.SetHashFunc(nd.HashFunc()); err != nil {
+			return nil, err
+		}
+		return nnd, nil
+	}
+
 	if len(nd.Links) == 0 {
 		// TODO: this can likely be done without marshaling and remarshaling
 		pbn, err := ft.FromBytes(nd.Data)
@@ -466,9 +497,12 @@ func dagTruncate(nd *mdag.Node, size uint64, ds mdag.DAGService) (*mdag.Node, er
 			return nil, err
 		}
 
-		childsize, err := ft.DataSize(child.Data)
-		if err != nil {
-			return nil, err
+		childsize := uint64(len(child.Data))
+		if !child.IsRaw() {
+			childsize, err = ft.DataSize(child.Data)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		// found the child we want to cut
@@ -1,6 +1,7 @@
 package mod
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -388,6 +389,11 @@ func TestLargeWriteChunks(t *testing.T) {
 		}
 	}
 
+	_, err = dagmod.Seek(0, os.SEEK_SET)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	out, err := ioutil.ReadAll(dagmod)
 	if err != nil {
 		t.Fatal(err)
@@ -430,6 +436,57 @@ func TestDagTruncate(t *testing.T) {
 	}
 }
 
+func TestRawLeavesModify(t *testing.T) {
+	dserv, pins := getMockDagServ(t)
+	b := make([]byte, 50000)
+	u.NewTimeSeededRand().Read(b)
+
+	dbp := h.DagBuilderParams{
+		Dagserv:   dserv,
+		Maxlinks:  h.DefaultLinksPerBlock,
+		Pinner:    pins,
+		RawLeaves: true,
+	}
+	spl := &chunk.SizeSplitter{Size: 500}
+	n, err := trickle.TrickleLayout(dbp.New(spl.Split(bytes.NewReader(b))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dagmod, err := NewDagModifier(ctx, n, dserv, pins, &chunk.SizeSplitter{Size: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// overwrite across raw leaves, then past the end
+	b = testModWrite(t, 1000, 4000, b, dagmod)
+	b = testModWrite(t, 49500, 4000, b, dagmod)
+
+	nd, err := dagmod.GetNode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nd.Links[0].Raw {
+		t.Fatal("a modified raw leaf was not stored raw")
+	}
+
+	if err := dagmod.Truncate(12345); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dagmod.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(dagmod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = arrComp(out, b[:12345]); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestSparseWrite(t *testing.T) {
 	dserv, pins := getMockDagServ(t)
 	_, n := getNode(t, dserv, 0, pins)
@@ -13,6 +13,7 @@ import (
 
 	mdag "github.com/ipfs/go-ipfs/merkledag"
 	path "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 	uio "github.com/ipfs/go-ipfs/unixfs/io"
 	upb "github.com/ipfs/go-ipfs/unixfs/pb"
 )
@@ -66,13 +67,41 @@ func (r *Reader) writeToBuf(dagnode *mdag.Node, path string, depth int) {
 		defer r.close()
 	}
 
-	if pb.GetType() == upb.Data_Directory {
+	// the recorded attributes, or defaults that leave it to the umask
+	attrs := ft.AttrsOf(pb)
+	modTime := attrs.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	mode := func(def int64) int64 {
+		if attrs.Mode != 0 {
+			return int64(attrs.Mode)
+		}
+		return def
+	}
+
+	if pb.GetType() == upb.Data_Symlink {
 		err = r.writer.WriteHeader(&tar.Header{
 			Name:     path,
-			Typeflag: tar.TypeDir,
+			Linkname: string(pb.GetData()),
+			Typeflag: tar.TypeSymlink,
 			Mode:     0777,
-			ModTime:  time.Now(),
-			// TODO: set mode, dates, etc. when added to unixFS
+			ModTime:  modTime,
+		})
+		if err != nil {
+			r.emitError(err)
+			return
+		}
+		r.flush()
+		return
+	}
+
+	if pb.GetType() == upb.Data_Directory || pb.GetType() == upb.Data_HAMTShard {
+		err = r.writer.WriteHeader(&tar.Header{
+			Name:     path,
+			Typeflag: tar.TypeDir,
+			Mode:     mode(0777),
+			ModTime:  modTime,
 		})
 		if err != nil {
 			r.emitError(err)
@@ -83,13 +112,23 @@ func (r *Reader) writeToBuf(dagnode *mdag.Node, path string, depth int) {
 		ctx, cancel := context.WithTimeout(context.TODO(), time.Second*60)
 		defer cancel()
 
-		for i, ng := range r.dag.GetDAG(ctx, dagnode) {
+		dir, err := uio.NewDirectoryFromNode(r.dag, dagnode)
+		if err != nil {
+			r.emitError(err)
+			return
+		}
+		links, err := dir.Links(ctx)
+		if err != nil {
+			r.emitError(err)
+			return
+		}
+		for i, ng := range r.dag.GetLinks(ctx, links) {
 			childNode, err := ng.Get(ctx)
 			if err != nil {
 				r.emitError(err)
 				return
 			}
-			r.writeToBuf(childNode, gopath.Join(path, dagnode.Links[i].Name), depth+1)
+			r.writeToBuf(childNode, gopath.Join(path, links[i].Name), depth+1)
 		}
 		return
 	}
@@ -98,9 +137,8 @@ func (r *Reader) writeToBuf(dagnode *mdag.Node, path string, depth int) {
 		Name:     path,
 		Size:     int64(pb.GetFilesize()),
 		Typeflag: tar.TypeReg,
-		Mode:     0644,
-		ModTime:  time.Now(),
-		// TODO: set mode, dates, etc. when added to unixFS
+		Mode:     mode(0644),
+		ModTime:  modTime,
 	})
 	if err != nil {
 		r.emitError(err)
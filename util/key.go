@@ -106,6 +106,29 @@ func (b58KeyConverter) InvertKey(dsk ds.Key) ds.Key {
 	return k
 }
 
+// HashFuncs are the multihash functions that data may be hashed with, and
+// that blocks are verified with.
+var HashFuncs = map[int]bool{
+	mh.SHA2_256: true,
+	mh.SHA2_512: true,
+	mh.SHA3:     true,
+}
+
+// HashWith hashes data with the given multihash function, one of HashFuncs.
+func HashWith(data []byte, code int) (mh.Multihash, error) {
+	if !HashFuncs[code] {
+		return nil, fmt.Errorf("unsupported hash function: %s", hashName(code))
+	}
+	return mh.Sum(data, code, -1)
+}
+
+func hashName(code int) string {
+	if name, ok := mh.Codes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", code)
+}
+
 // Hash is the global IPFS hash function. uses multihash SHA2_256, 256 bits
 func Hash(data []byte) mh.Multihash {
 	h, err := mh.Sum(data, mh.SHA2_256, -1)